@@ -0,0 +1,122 @@
+package natsmicromw
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// Endpoint metadata key pointing from an alias subject to the primary subject
+	MetadataAliasOf string = "alias_of"
+)
+
+// Request received on an alias subject
+type aliasRequest struct {
+	micro.Request
+	alias string
+}
+
+// Route requests on the alias subject to the handler, marking them as aliased
+func aliasHandler(handler micro.Handler, alias string) micro.Handler {
+	return micro.HandlerFunc(func(req micro.Request) {
+		handler.Handle(&aliasRequest{req, alias})
+	})
+}
+
+type endpointAliasContextKey struct{}
+
+// Context of a request to the endpoint, including the alias it was received on
+func endpointContext(ctx context.Context, ep *endpointRef, req micro.Request) context.Context {
+	ctx = withEndpoint(ctx, ep)
+	for req != nil {
+		switch r := req.(type) {
+		case *aliasRequest:
+			ctx = context.WithValue(ctx, endpointAliasContextKey{}, r.alias)
+			req = r.Request
+		case *tierRequest:
+			ctx = context.WithValue(ctx, priorityTierContextKey{}, r.tier)
+			req = r.Request
+		case *shardRequest:
+			ctx = context.WithValue(ctx, shardContextKey{}, r.shard)
+			req = r.Request
+		case wrappedRequest:
+			req = r.unwrapRequest()
+		default:
+			return ctx
+		}
+	}
+	return ctx
+}
+
+// Request wrapping the one received by the endpoint, e.g. to change its
+// replies
+type wrappedRequest interface {
+	unwrapRequest() micro.Request
+}
+
+// EndpointAliasFromContext returns the alias subject the request was
+// received on, empty if it was received on the primary subject. Use it e.g.
+// to track the progress of a subject migration in metrics.
+func EndpointAliasFromContext(ctx context.Context) string {
+	alias, _ := ctx.Value(endpointAliasContextKey{}).(string)
+	return alias
+}
+
+// WithSubjectAliases returns a service whose endpoint of the given name is
+// also registered on the given legacy subjects, routed to the same handler.
+// Other endpoints registered through the returned service are not aliased.
+// The aliases are used as is, without any group prefix, and are advertised
+// as separate endpoints with the `alias_of` metadata:
+//
+//	srv.WithSubjectAliases("list", "old.orders.list").AddContextEndpoint("list", handler)
+func (s *Service) WithSubjectAliases(endpoint string, aliases ...string) *Service {
+	ns := s.clone()
+	ns.subjectAliases = withAliases(s.subjectAliases, endpoint, aliases)
+	return ns
+}
+
+// WithSubjectAliases returns a group whose endpoint of the given name is
+// also registered on the given legacy subjects, see
+// [Service.WithSubjectAliases].
+func (g *Group) WithSubjectAliases(endpoint string, aliases ...string) *Group {
+	svc := g.svc.clone()
+	svc.subjectAliases = withAliases(g.svc.subjectAliases, endpoint, aliases)
+	return &Group{svc, g.grp, g.prefix}
+}
+
+// Copy of the aliases by endpoint name with those of the endpoint replaced,
+// so that clones never share the map
+func withAliases(current map[string][]string, endpoint string, aliases []string) map[string][]string {
+	updated := make(map[string][]string, len(current)+1)
+	for name, a := range current {
+		updated[name] = a
+	}
+	updated[endpoint] = aliases
+	return updated
+}
+
+// Register the endpoint also on its alias subjects
+func (s *Service) registerAliases(name string, handler micro.Handler, ep micro.EndpointInfo, aliases []string) error {
+	for i, alias := range aliases {
+		metadata := copyMetadata(ep.Metadata)
+		metadata[MetadataAliasOf] = ep.Subject
+
+		err := s.svc.AddEndpoint(fmt.Sprintf("%s-alias-%d", name, i+1), aliasHandler(handler, alias),
+			micro.WithEndpointSubject(alias),
+			micro.WithEndpointMetadata(metadata))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	c := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,51 @@
+package natsmicromw
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubjectAliases(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	handler := func(req *Request) error {
+		return req.Respond([]byte(EndpointSubjectFromContext(req.Context()) + " " + EndpointAliasFromContext(req.Context())))
+	}
+	orders := nm.AddGroup("orders").WithSubjectAliases("list", "old.orders.list", "legacy_orders")
+	if err := orders.AddContextEndpoint("list", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Other endpoints of the group are not aliased
+	if err := orders.AddContextEndpoint("get", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[string]string{
+		"orders.list":     "orders.list ",
+		"old.orders.list": "orders.list old.orders.list",
+		"legacy_orders":   "orders.list legacy_orders",
+	}
+	for subject, expected := range tests {
+		msg, err := nc.Request(subject, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg.Data) != expected {
+			t.Errorf("reply does not match, expected %q, received %q", expected, string(msg.Data))
+		}
+	}
+
+	aliases := 0
+	for _, ep := range nm.Info().Endpoints {
+		if ep.Metadata[MetadataAliasOf] == "orders.list" {
+			aliases++
+		} else if ep.Metadata[MetadataAliasOf] != "" {
+			t.Errorf("unexpected alias endpoint: %+v", ep)
+		}
+	}
+	if aliases != 2 {
+		t.Errorf("alias endpoint count does not match, expected %d, received %d", 2, aliases)
+	}
+}
@@ -0,0 +1,100 @@
+package natsmicromw
+
+import (
+	"io"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/nats-io/nuid"
+)
+
+// Reply header referencing an attachment stored in the object store, as
+// "<bucket>/<object>", once per attachment
+const HeaderAttachment string = "attachment"
+
+// Attachment references a blob of a reply stored in a bucket of the NATS
+// object store, e.g. a document or image exceeding the payload limit.
+type Attachment struct {
+	Bucket string
+	// Object is the unique name of the object, "<nuid>/<name>"
+	Object string
+}
+
+// Name returns the name the attachment was stored with.
+func (a Attachment) Name() string {
+	if i := strings.IndexByte(a.Object, '/'); i >= 0 {
+		return a.Object[i+1:]
+	}
+	return a.Object
+}
+
+func (a Attachment) String() string {
+	return a.Bucket + "/" + a.Object
+}
+
+// AttachmentStore stores reply attachments in an object store bucket.
+// Attachments are not deleted once fetched, so use a bucket with a TTL.
+type AttachmentStore struct {
+	obs    nats.ObjectStore
+	bucket string
+}
+
+// NewAttachmentStore returns a store for attachments in the existing
+// object store bucket.
+func NewAttachmentStore(js nats.JetStreamContext, bucket string) (*AttachmentStore, error) {
+	obs, err := js.ObjectStore(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &AttachmentStore{obs: obs, bucket: bucket}, nil
+}
+
+// Put stores the content under a unique object name ending with the name,
+// with the content type recorded in the object headers, and returns the
+// attachment to add to the reply with [MicroReply.Attach] or
+// [WithAttachments].
+func (s *AttachmentStore) Put(name, contentType string, r io.Reader) (Attachment, error) {
+	meta := &nats.ObjectMeta{Name: nuid.Next() + "/" + name}
+	if contentType != "" {
+		meta.Headers = nats.Header{}
+		meta.Headers.Set(HeaderContentType, contentType)
+	}
+	if _, err := s.obs.Put(meta, r); err != nil {
+		return Attachment{}, err
+	}
+	return Attachment{Bucket: s.bucket, Object: meta.Name}, nil
+}
+
+// Attach adds the reference to the attachment to the reply headers.
+func (r *MicroReply) Attach(a Attachment) {
+	r.HeaderAdd(HeaderAttachment, a.String())
+}
+
+// WithAttachments adds the references to the attachments to a reply, for
+// context and raw handlers, keeping the other headers.
+func WithAttachments(attachments ...Attachment) micro.RespondOpt {
+	return func(m *nats.Msg) {
+		headers := nats.Header{}
+		for k, v := range m.Header {
+			headers[k] = v
+		}
+		for _, a := range attachments {
+			headers.Add(HeaderAttachment, a.String())
+		}
+		m.Header = headers
+	}
+}
+
+// AttachmentsFromMsg returns the attachments referenced by the reply.
+func AttachmentsFromMsg(msg *nats.Msg) []Attachment {
+	var attachments []Attachment
+	for _, value := range msg.Header.Values(HeaderAttachment) {
+		bucket, object, ok := strings.Cut(value, "/")
+		if !ok || bucket == "" || object == "" {
+			continue
+		}
+		attachments = append(attachments, Attachment{Bucket: bucket, Object: object})
+	}
+	return attachments
+}
@@ -0,0 +1,22 @@
+package natsmicromw
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestAttachmentsFromMsg(t *testing.T) {
+	msg := nats.NewMsg("reply")
+	msg.Header.Set("other", "kept")
+	WithAttachments(Attachment{Bucket: "files", Object: "abc/report.pdf"})(msg)
+	msg.Header.Add(HeaderAttachment, "invalid")
+
+	attachments := AttachmentsFromMsg(msg)
+	if len(attachments) != 1 || attachments[0].Bucket != "files" || attachments[0].Object != "abc/report.pdf" || attachments[0].Name() != "report.pdf" {
+		t.Errorf("unexpected attachments: %+v", attachments)
+	}
+	if msg.Header.Get("other") != "kept" {
+		t.Errorf("expected the other headers to be kept")
+	}
+}
@@ -0,0 +1,124 @@
+package natsmicromw
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Reply header hinting that the service is under pressure, with its load
+// between 0 and 1, e.g. "0.85", so clients slow down before requests get
+// rejected
+const HeaderBackpressure string = "x-backpressure"
+
+// Highest load of the resources used by a request
+type requestLoad struct {
+	mu   sync.Mutex
+	load float64
+}
+
+type loadContextKey struct{}
+
+func withLoad(ctx context.Context, l *requestLoad) context.Context {
+	return context.WithValue(ctx, loadContextKey{}, l)
+}
+
+// ReportLoad records the utilization of a resource used by the request,
+// between 0 and 1, e.g. the share of a database connection pool or of a
+// service-wide rate limit in use, for the backpressure header of its reply.
+// Limits of a single caller, like quotas, are not load of the service. See
+// [Service.SetBackpressure]. The highest load reported is kept. This has
+// no effect outside context and Micro handlers.
+func ReportLoad(ctx context.Context, load float64) {
+	l, _ := ctx.Value(loadContextKey{}).(*requestLoad)
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if load > l.load {
+		l.load = load
+	}
+}
+
+func (l *requestLoad) get() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.load
+}
+
+// SetBackpressure adds the [HeaderBackpressure] header to the replies of
+// requests whose load reaches the threshold, between 0 and 1. The load is
+// the highest of the share of the worker pool of the priority tier in use,
+// see [Service.WithPriorityTiers], and the loads reported by middleware
+// and handlers with [ReportLoad]. Clients using `client.WithBackpressure`
+// then slow down their request rate, for end-to-end flow control. Headers
+// set by the handler, e.g. relaying the reply of another service, are
+// kept. Zero, the default, disables the header.
+func (s *Service) SetBackpressure(threshold float64) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backpressure = threshold
+}
+
+// BackpressureFromMsg returns the load of the service hinted in the reply,
+// false if there is none.
+func BackpressureFromMsg(msg *nats.Msg) (float64, bool) {
+	if msg.Header == nil {
+		return 0, false
+	}
+	value := msg.Header.Get(HeaderBackpressure)
+	if value == "" {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(value, 64)
+	if err != nil || load < 0 {
+		return 0, false
+	}
+	if load > 1 {
+		load = 1
+	}
+	return load, true
+}
+
+// Return the load of the request, including its worker pool
+func requestBackpressure(req micro.Request, l *requestLoad) float64 {
+	load := l.get()
+	for req != nil {
+		switch r := req.(type) {
+		case *tierRequest:
+			if poolLoad := r.pool.load(); poolLoad > load {
+				load = poolLoad
+			}
+			req = r.Request
+		case wrappedRequest:
+			req = r.unwrapRequest()
+		default:
+			req = nil
+		}
+	}
+	return load
+}
+
+// Add the backpressure header to the reply if the load reaches the
+// threshold, unless the handler set it
+func backpressureOpt(load, threshold float64) micro.RespondOpt {
+	return func(m *nats.Msg) {
+		if load < threshold || (m.Header != nil && m.Header.Get(HeaderBackpressure) != "") {
+			return
+		}
+		if load > 1 {
+			load = 1
+		}
+		headers := nats.Header{}
+		for k, v := range m.Header {
+			headers[k] = v
+		}
+		headers.Set(HeaderBackpressure, strconv.FormatFloat(load, 'f', 2, 64))
+		m.Header = headers
+	}
+}
@@ -0,0 +1,90 @@
+package natsmicromw
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestBackpressure(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	nm.SetBackpressure(0.8)
+	if err := nm.AddMicroEndpoint("load", func(req *MicroRequest) (*MicroReply, error) {
+		load, _ := strconv.ParseFloat(string(req.Data), 64)
+		ReportLoad(req.Context(), load)
+		ReportLoad(req.Context(), load/2)
+		return NewMicroReply(nil), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tiered := nm.WithPriorityTiers(PriorityTier{Name: "high", Workers: 1, Queue: 1})
+	if err := tiered.AddMicroEndpoint("tiered", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply(nil), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		subject, data string
+		load          float64
+		hinted        bool
+	}{
+		{"load", "0.5", 0, false},
+		{"load", "0.9", 0.9, true},
+		{"load", "3", 1, true},
+		// Half of the workers and queue of the tier is in use by the request
+		{"high.tiered", "", 0, false},
+	}
+	for _, tt := range tests {
+		reply, err := nc.Request(tt.subject, []byte(tt.data), time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		load, ok := BackpressureFromMsg(reply)
+		if ok != tt.hinted || load != tt.load {
+			t.Errorf("%s %s: expected %v %v, received %v %v", tt.subject, tt.data, tt.load, tt.hinted, load, ok)
+		}
+	}
+
+	nm.SetBackpressure(0.5)
+	if err := nm.WithPriorityTiers(PriorityTier{Name: "low", Workers: 1}).AddMicroEndpoint("busy", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply(nil), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reply, err := nc.Request("low.busy", nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if load, ok := BackpressureFromMsg(reply); !ok || load != 1 {
+		t.Errorf("expected the load of the worker pool, received %v %v", load, ok)
+	}
+}
+
+func TestBackpressureFromMsg(t *testing.T) {
+	tests := []struct {
+		value string
+		load  float64
+		ok    bool
+	}{
+		{"0.25", 0.25, true},
+		{"2", 1, true},
+		{"", 0, false},
+		{"high", 0, false},
+		{"-1", 0, false},
+	}
+	for _, tt := range tests {
+		msg := nats.NewMsg("reply")
+		if tt.value != "" {
+			msg.Header.Set(HeaderBackpressure, tt.value)
+		}
+		if load, ok := BackpressureFromMsg(msg); load != tt.load || ok != tt.ok {
+			t.Errorf("%q: expected %v %v, received %v %v", tt.value, tt.load, tt.ok, load, ok)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package natsmicromw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// Reply header with the overall status of a batch reply
+	HeaderBatchStatus string = "batch-status"
+)
+
+// BatchStatus is the overall status of a batch reply.
+type BatchStatus string
+
+const (
+	// All items succeeded, also for an empty batch
+	BatchOK BatchStatus = "ok"
+	// Some items succeeded and some failed
+	BatchPartial BatchStatus = "partial"
+	// All items failed
+	BatchFailed BatchStatus = "failed"
+)
+
+var ErrNotBatchReply = errors.New("not a batch reply")
+
+// BatchItem is the result of one item of a batch, either data or an error.
+type BatchItem struct {
+	Index int             `json:"index"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error *HandlerError   `json:"error,omitempty"`
+}
+
+// BatchReply reports the result of each item of a batch request, for
+// endpoints processing arrays where some items may fail. Results can be set
+// concurrently.
+type BatchReply struct {
+	Status    BatchStatus `json:"status"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Items     []BatchItem `json:"items"`
+
+	mu sync.Mutex
+	// Context of the batch request, for converting item errors
+	ctx context.Context
+}
+
+// NewBatchReply creates a new batch reply for the number of items of the
+// request with the context, which are all reported as failed with a "500"
+// error until their result is set.
+func NewBatchReply(ctx context.Context, n int) *BatchReply {
+	b := &BatchReply{Items: make([]BatchItem, n), ctx: ctx}
+	for i := range b.Items {
+		b.Items[i] = BatchItem{Index: i, Error: &HandlerError{Description: "no result", Code: CodeInternal}}
+	}
+	b.update()
+	return b
+}
+
+// Derive the counts and the overall status from the items
+func (b *BatchReply) update() {
+	b.Succeeded, b.Failed = 0, 0
+	for _, item := range b.Items {
+		if item.Error != nil {
+			b.Failed++
+		} else {
+			b.Succeeded++
+		}
+	}
+	switch {
+	case b.Failed == 0:
+		b.Status = BatchOK
+	case b.Succeeded == 0:
+		b.Status = BatchFailed
+	default:
+		b.Status = BatchPartial
+	}
+}
+
+func (b *BatchReply) set(i int, item BatchItem) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	item.Index = i
+	b.Items[i] = item
+	b.update()
+}
+
+// Succeed sets the result of the item to the value encoded as JSON.
+func (b *BatchReply) Succeed(i int, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b.set(i, BatchItem{Data: data})
+	return nil
+}
+
+// SucceedRaw sets the result of the item to the JSON data.
+func (b *BatchReply) SucceedRaw(i int, data []byte) {
+	b.set(i, BatchItem{Data: data})
+}
+
+// Fail sets the error of the item. Errors are converted like errors returned
+// by the handler, with the error codes, default code and transformers of the
+// endpoint serving the request, e.g. [SanitizeErrors].
+func (b *BatchReply) Fail(i int, err error) {
+	b.set(i, BatchItem{Error: contextHandlerError(b.ctx, err)})
+}
+
+// Encode the reply as JSON, with the status and content type headers
+func (b *BatchReply) encode() ([]byte, micro.Headers, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := nats.Header{}
+	headers.Set(HeaderContentType, JSONCodec{}.ContentType())
+	headers.Set(HeaderBatchStatus, string(b.Status))
+	return data, micro.Headers(headers), nil
+}
+
+// MicroReply returns the batch as a reply of a Micro handler.
+func (b *BatchReply) MicroReply() (*MicroReply, error) {
+	data, headers, err := b.encode()
+	if err != nil {
+		return nil, err
+	}
+	return &MicroReply{Headers: headers, Data: data}, nil
+}
+
+// RespondBatch responds with the batch reply. Additional headers can be
+// passed using the `micro.WithHeaders` option.
+func (r *Request) RespondBatch(b *BatchReply, opts ...micro.RespondOpt) error {
+	data, headers, err := b.encode()
+	if err != nil {
+		return err
+	}
+	return r.Respond(data, append([]micro.RespondOpt{micro.WithHeaders(headers)}, opts...)...)
+}
+
+// BatchReplyFromMsg parses a batch reply sent by a natsmicromw service.
+func BatchReplyFromMsg(msg *nats.Msg) (*BatchReply, error) {
+	if msg.Header.Get(HeaderBatchStatus) == "" {
+		return nil, ErrNotBatchReply
+	}
+	var b BatchReply
+	if err := json.Unmarshal(msg.Data, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Decode decodes the data of a successful item into v, or returns the
+// error of a failed item.
+func (b *BatchReply) Decode(i int, v any) error {
+	if i < 0 || i >= len(b.Items) {
+		return errors.New("batch item " + strconv.Itoa(i) + " out of range")
+	}
+	item := b.Items[i]
+	if item.Error != nil {
+		return item.Error
+	}
+	return json.Unmarshal(item.Data, v)
+}
+
+// BatchOptions configures a batch endpoint created with [AsBatch].
+type BatchOptions struct {
+	// Maximum number of items handled at the same time, defaults to 8
+	Concurrency int
+	// Maximum number of items in a request, unlimited if 0
+	MaxItems int
+}
+
+// AsBatch turns a handler of single items into a handler of JSON arrays, so
+// a single-item endpoint can also be exposed as a batch endpoint. Each item
+// is passed to the handler as a request with the item as data and a copy of
+// the headers and the context of the batch request, and the replies, which
+// must be JSON or empty, are aggregated into a [BatchReply]. Item errors are
+// converted with the error codes and transformers of the endpoint, like
+// errors of single requests. Items are handled concurrently
+// up to the limit. Once the context is done, the items not started yet fail
+// with its error. Items run in their own goroutines, out of reach of
+// [Service.SetRecoverPanics], so an item whose handler panics always fails
+// with a "500" error wrapping [ErrPanic].
+func AsBatch(handler MicroHandlerFunc, opts BatchOptions) MicroHandlerFunc {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 8
+	}
+	return func(req *MicroRequest) (*MicroReply, error) {
+		data, err := req.ReadData()
+		if err != nil {
+			return nil, err
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, Errorf(CodeBadRequest, "batch request must be a JSON array: %v", err)
+		}
+		if opts.MaxItems > 0 && len(items) > opts.MaxItems {
+			return nil, Errorf(CodeBadRequest, "batch of %d items exceeds the limit of %d", len(items), opts.MaxItems)
+		}
+
+		ctx := req.Context()
+		b := NewBatchReply(ctx, len(items))
+		sem := make(chan struct{}, opts.Concurrency)
+		var wg sync.WaitGroup
+		for i, item := range items {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				b.Fail(i, ctx.Err())
+				continue
+			}
+
+			wg.Add(1)
+			go func(i int, item []byte) {
+				defer func() {
+					if recovered := recover(); recovered != nil {
+						b.Fail(i, Errorf(CodeInternal, "%w", ErrPanic))
+					}
+					<-sem
+					wg.Done()
+				}()
+
+				// Handlers may set request headers concurrently
+				headers := micro.Headers{}
+				for k, v := range req.Headers {
+					headers[k] = append([]string{}, v...)
+				}
+				itemReq := &MicroRequest{
+					Subject: req.Subject,
+					Reply:   req.Reply,
+					Headers: headers,
+					Data:    item,
+					ctx:     ctx,
+				}
+				reply, err := handler(itemReq)
+				switch {
+				case err != nil:
+					b.Fail(i, err)
+				case reply == nil:
+					b.Fail(i, ErrUnanswered)
+				case reply.errCode != "":
+					b.Fail(i, &HandlerError{
+						Description: reply.errDescription,
+						Code:        reply.errCode,
+					})
+				case len(reply.Data) == 0:
+					b.SucceedRaw(i, []byte("null"))
+				case !json.Valid(reply.Data):
+					b.Fail(i, Errorf(CodeInternal, "item reply is not JSON"))
+				default:
+					b.SucceedRaw(i, reply.Data)
+				}
+			}(i, item)
+		}
+		wg.Wait()
+		return b.MicroReply()
+	}
+}
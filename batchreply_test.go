@@ -0,0 +1,252 @@
+package natsmicromw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestBatchReply(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	// Double the positive numbers, reject the others
+	process := func(ctx context.Context, data []byte) *BatchReply {
+		var numbers []int
+		_ = json.Unmarshal(data, &numbers)
+		b := NewBatchReply(ctx, len(numbers))
+		for i, n := range numbers {
+			if n < 0 {
+				b.Fail(i, NewValidationError("negative number"))
+				continue
+			}
+			if err := b.Succeed(i, n*2); err != nil {
+				b.Fail(i, err)
+			}
+		}
+		return b
+	}
+
+	if err := nm.AddMicroEndpoint("micro", func(req *MicroRequest) (*MicroReply, error) {
+		return process(req.Context(), req.Data).MicroReply()
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddContextEndpoint("context", func(req *Request) error {
+		return req.RespondBatch(process(req.Context(), req.Data()))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		data   string
+		status BatchStatus
+	}{
+		{`[1,2]`, BatchOK},
+		{`[1,-2]`, BatchPartial},
+		{`[-1]`, BatchFailed},
+		{`[]`, BatchOK},
+	}
+	for _, subject := range []string{"micro", "context"} {
+		for _, tt := range tests {
+			msg, err := nc.Request(subject, []byte(tt.data), time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if msg.Header.Get(HeaderBatchStatus) != string(tt.status) {
+				t.Errorf("%s %s: expected status header %s, received %s", subject, tt.data, tt.status, msg.Header.Get(HeaderBatchStatus))
+			}
+			b, err := BatchReplyFromMsg(msg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if b.Status != tt.status || b.Succeeded+b.Failed != len(b.Items) {
+				t.Errorf("%s %s: unexpected reply %+v", subject, tt.data, b)
+			}
+		}
+	}
+
+	msg, err := nc.Request("micro", []byte(`[3,-1]`), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, _ := BatchReplyFromMsg(msg)
+	var n int
+	if err := b.Decode(0, &n); err != nil || n != 6 {
+		t.Errorf("expected 6, got %d: %v", n, err)
+	}
+	var handlerErr *HandlerError
+	if err := b.Decode(1, &n); !errors.As(err, &handlerErr) || handlerErr.Code != CodeBadRequest {
+		t.Errorf("expected validation error, got %v", err)
+	}
+	if err := b.Decode(2, &n); err == nil {
+		t.Errorf("expected out of range error")
+	}
+}
+
+func TestBatchReplyErrorConfig(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	err := nm.WithErrorTransformer(SanitizeErrors).AddMicroEndpoint("sanitized", func(req *MicroRequest) (*MicroReply, error) {
+		b := NewBatchReply(req.Context(), 1)
+		b.Fail(0, errors.New("connection to db-7 refused"))
+		return b.MicroReply()
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg, err := nc.Request("sanitized", nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := BatchReplyFromMsg(msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if item := b.Items[0].Error; item == nil || item.Code != CodeInternal || item.Description != "internal error" {
+		t.Errorf("item error not sanitized: %+v", item)
+	}
+}
+
+func TestNewBatchReply(t *testing.T) {
+	b := NewBatchReply(context.Background(), 2)
+	if b.Status != BatchFailed || b.Failed != 2 {
+		t.Errorf("expected items without results to fail, got %+v", b)
+	}
+	b.SucceedRaw(1, []byte(`true`))
+	if b.Status != BatchPartial || b.Succeeded != 1 || b.Items[1].Index != 1 {
+		t.Errorf("unexpected reply %+v", b)
+	}
+}
+
+func TestAsBatch(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	var running, maxRunning atomic.Int32
+	double := func(req *MicroRequest) (*MicroReply, error) {
+		if n := running.Add(1); n > maxRunning.Load() {
+			maxRunning.Store(n)
+		}
+		defer running.Add(-1)
+		time.Sleep(10 * time.Millisecond)
+
+		var n int
+		if err := json.Unmarshal(req.Data, &n); err != nil {
+			return nil, NewValidationError("not a number")
+		}
+		return NewMicroReply([]byte(strconv.Itoa(n * 2))), nil
+	}
+	if err := nm.AddMicroEndpoint("double", AsBatch(double, BatchOptions{Concurrency: 2, MaxItems: 5})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg, err := nc.Request("double", []byte(`[1,"x",3,4]`), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := BatchReplyFromMsg(msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Status != BatchPartial || b.Succeeded != 3 {
+		t.Errorf("unexpected reply %+v", b)
+	}
+	for i, expected := range map[int]int{0: 2, 2: 6, 3: 8} {
+		var n int
+		if err := b.Decode(i, &n); err != nil || n != expected {
+			t.Errorf("item %d: expected %d, got %d: %v", i, expected, n, err)
+		}
+	}
+	if max := maxRunning.Load(); max > 2 {
+		t.Errorf("expected at most 2 concurrent items, got %d", max)
+	}
+
+	for _, data := range []string{`{"not":"array"}`, `[1,2,3,4,5,6]`} {
+		msg, err := nc.Request("double", []byte(data), time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if handlerErr := HandlerErrorFromMsg(msg); handlerErr == nil || handlerErr.Code != CodeBadRequest {
+			t.Errorf("%s: expected bad request, received %v", data, handlerErr)
+		}
+	}
+}
+
+func TestAsBatchErrorConfig(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	errMissing := errors.New("row 17 missing in orders table")
+	nm.SetErrorCodes(MapError(errMissing, CodeNotFound))
+	handler := AsBatch(func(req *MicroRequest) (*MicroReply, error) {
+		if string(req.Data) == `"missing"` {
+			return nil, errMissing
+		}
+		return nil, errors.New("connection to db-7 refused")
+	}, BatchOptions{})
+	if err := nm.WithErrorTransformer(SanitizeErrors).AddMicroEndpoint("items", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg, err := nc.Request("items", []byte(`["missing","broken"]`), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := BatchReplyFromMsg(msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if item := b.Items[0].Error; item == nil || item.Code != CodeNotFound {
+		t.Errorf("item error not mapped: %+v", item)
+	}
+	if item := b.Items[1].Error; item == nil || item.Description != "internal error" {
+		t.Errorf("item error not sanitized: %+v", item)
+	}
+}
+
+func TestAsBatchIsolatesItems(t *testing.T) {
+	handler := AsBatch(func(req *MicroRequest) (*MicroReply, error) {
+		if string(req.Data) == `"panic"` {
+			panic("item failed")
+		}
+		// Each item gets its own headers
+		req.HeaderSet("item", string(req.Data))
+		if values := req.Headers.Values("item"); len(values) != 1 || values[0] != string(req.Data) {
+			return nil, Errorf(CodeInternal, "shared headers %v", values)
+		}
+		return NewMicroReply(req.Data), nil
+	}, BatchOptions{Concurrency: 4})
+
+	req := &MicroRequest{
+		Headers: micro.Headers{"batch": []string{"true"}},
+		Data:    []byte(`[1,"panic",3,4,5,6]`),
+		ctx:     context.Background(),
+	}
+	reply, err := handler(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var b BatchReply
+	if err := json.Unmarshal(reply.Data, &b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Succeeded != 5 || b.Items[1].Error == nil || b.Items[1].Error.Code != CodeInternal {
+		t.Errorf("expected only the panicking item to fail, received %s", reply.Data)
+	}
+	if req.HeaderGet("item") != "" {
+		t.Errorf("batch request headers changed: %v", req.Headers)
+	}
+}
@@ -0,0 +1,97 @@
+package natsmicromw
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Suffix of the keys of headers with binary values, which are base64 encoded
+// as in gRPC metadata. Binary values such as keys, nonces or signatures
+// cannot be put in headers as they are.
+const BinaryHeaderSuffix = "-bin"
+
+// Return the key with the binary suffix
+func binaryHeaderKey(key string) string {
+	if strings.HasSuffix(key, BinaryHeaderSuffix) {
+		return key
+	}
+	return key + BinaryHeaderSuffix
+}
+
+// Set the binary header, encoded without padding
+func setBinaryHeader(h nats.Header, key string, value []byte) nats.Header {
+	if h == nil {
+		h = nats.Header{}
+	}
+	h.Set(binaryHeaderKey(key), base64.RawStdEncoding.EncodeToString(value))
+	return h
+}
+
+// Decode the binary header, accepting values with and without padding
+func getBinaryHeader(h nats.Header, key string) ([]byte, error) {
+	value := h.Get(binaryHeaderKey(key))
+	if value == "" {
+		return nil, nil
+	}
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// SetBinaryHeader sets the binary value of the header of a message, e.g. a
+// request sent by a client, adding the "-bin" suffix to the key if missing.
+// The headers must not be nil.
+func SetBinaryHeader(h nats.Header, key string, value []byte) {
+	setBinaryHeader(h, key, value)
+}
+
+// GetBinaryHeader returns the binary value of the header of a message, e.g.
+// a reply received by a client, see [MicroRequest.HeaderGetBinary].
+func GetBinaryHeader(h nats.Header, key string) ([]byte, error) {
+	return getBinaryHeader(h, key)
+}
+
+// WithBinaryHeader is a respond option setting the binary value of the
+// header of the reply, for context and plain handlers.
+func WithBinaryHeader(key string, value []byte) micro.RespondOpt {
+	return func(m *nats.Msg) {
+		// Copy the headers passed by the handler before changing them
+		headers := nats.Header{}
+		for k, v := range m.Header {
+			headers[k] = v
+		}
+		m.Header = setBinaryHeader(headers, key, value)
+	}
+}
+
+// HeaderGetBinary returns the binary value of the request header, see
+// [MicroRequest.HeaderGetBinary].
+func (r *Request) HeaderGetBinary(key string) ([]byte, error) {
+	return getBinaryHeader(nats.Header(r.Headers()), key)
+}
+
+// HeaderSetBinary sets the binary value of the header, adding the "-bin"
+// suffix to the key if missing.
+func (r *MicroRequest) HeaderSetBinary(key string, value []byte) {
+	r.Headers = micro.Headers(setBinaryHeader(nats.Header(r.Headers), key, value))
+}
+
+// HeaderGetBinary returns the binary value of the header, adding the "-bin"
+// suffix to the key if missing. It returns nil if the header is not set, and
+// an error if the value is not valid base64.
+func (r *MicroRequest) HeaderGetBinary(key string) ([]byte, error) {
+	return getBinaryHeader(nats.Header(r.Headers), key)
+}
+
+// HeaderSetBinary sets the binary value of the header, see
+// [MicroRequest.HeaderSetBinary].
+func (r *MicroReply) HeaderSetBinary(key string, value []byte) {
+	r.Headers = micro.Headers(setBinaryHeader(nats.Header(r.Headers), key, value))
+}
+
+// HeaderGetBinary returns the binary value of the header, see
+// [MicroRequest.HeaderGetBinary].
+func (r *MicroReply) HeaderGetBinary(key string) ([]byte, error) {
+	return getBinaryHeader(nats.Header(r.Headers), key)
+}
@@ -0,0 +1,74 @@
+package natsmicromw
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestBinaryHeaders(t *testing.T) {
+	value := []byte{0x00, 0xff, 0x10, 0x80}
+
+	req := &MicroRequest{}
+	req.HeaderSetBinary("nonce", value)
+	if got := req.HeaderGet("nonce-bin"); got != "AP8QgA" {
+		t.Errorf("expected unpadded base64, received %q", got)
+	}
+	got, err := req.HeaderGetBinary("nonce-bin")
+	if err != nil || !bytes.Equal(got, value) {
+		t.Errorf("expected %v, received %v: %v", value, got, err)
+	}
+
+	reply := NewMicroReply(nil)
+	reply.Headers = micro.Headers{"signature-bin": []string{"AP8QgA=="}}
+	got, err = reply.HeaderGetBinary("signature")
+	if err != nil || !bytes.Equal(got, value) {
+		t.Errorf("expected padded value to decode, received %v: %v", got, err)
+	}
+
+	if got, err := reply.HeaderGetBinary("missing"); got != nil || err != nil {
+		t.Errorf("expected no value, received %v: %v", got, err)
+	}
+	reply.HeaderSet("key-bin", "not base64!")
+	if _, err := reply.HeaderGetBinary("key"); err == nil {
+		t.Errorf("expected decoding error")
+	}
+}
+
+func TestBinaryHeadersContext(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	// The nonce is signed by reversing it
+	if err := nm.AddContextEndpoint("sign", func(req *Request) error {
+		nonce, err := req.HeaderGetBinary("nonce")
+		if err != nil {
+			return Errorf(CodeBadRequest, "invalid nonce: %v", err)
+		}
+		signature := make([]byte, len(nonce))
+		for i, b := range nonce {
+			signature[len(nonce)-1-i] = b
+		}
+		return req.Respond(nil, micro.WithHeaders(micro.Headers{"custom": []string{"value"}}), WithBinaryHeader("signature", signature))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg := nats.NewMsg("sign")
+	SetBinaryHeader(msg.Header, "nonce", []byte{0x00, 0xff, 0x10})
+	reply, err := nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signature, err := GetBinaryHeader(reply.Header, "signature-bin")
+	if err != nil || !bytes.Equal(signature, []byte{0x10, 0xff, 0x00}) {
+		t.Errorf("unexpected signature: %v, %v", signature, err)
+	}
+	if reply.Header.Get("custom") != "value" {
+		t.Errorf("headers of the handler lost: %v", reply.Header)
+	}
+}
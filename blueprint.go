@@ -0,0 +1,385 @@
+package natsmicromw
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+var (
+	ErrBlueprintHandler    = errors.New("natsmicromw: handler not registered")
+	ErrBlueprintMiddleware = errors.New("natsmicromw: middleware not registered")
+	ErrBlueprintEndpoint   = errors.New("natsmicromw: endpoint kind not supported by blueprints")
+	ErrBlueprintDuplicate  = errors.New("natsmicromw: duplicate registration")
+)
+
+// Blueprint is a serializable definition of a service, as returned by
+// [Service.Blueprint], from which identical services are created on other
+// connections with [FromBlueprint]. Handlers and middleware are referred to
+// by their registered names, see [HandlerRegistry].
+type Blueprint struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// Middleware of the service, outermost first
+	Middleware        []string            `json:"middleware,omitempty"`
+	ContextMiddleware []string            `json:"context_middleware,omitempty"`
+	MicroMiddleware   []string            `json:"micro_middleware,omitempty"`
+	Endpoints         []EndpointBlueprint `json:"endpoints,omitempty"`
+}
+
+// EndpointBlueprint is the definition of an endpoint in a [Blueprint].
+type EndpointBlueprint struct {
+	Name string `json:"name"`
+	// Subject relative to the group and version prefixes
+	Subject    string       `json:"subject"`
+	Group      string       `json:"group,omitempty"`
+	QueueGroup string       `json:"queue_group,omitempty"`
+	Kind       EndpointKind `json:"kind"`
+	Handler    string       `json:"handler"`
+	// Middleware applied to the handler, outermost first
+	Middleware []string          `json:"middleware,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Options    EndpointOptions   `json:"options"`
+}
+
+// Blueprint returns the definition of the service and the endpoints
+// registered so far, naming handlers and middleware after their
+// registration in the registry. Every handler and middleware of the service
+// must be registered, otherwise [ErrBlueprintHandler] or
+// [ErrBlueprintMiddleware] is returned. Settings holding functions, e.g.
+// the codec, error encoder, error transformers and hooks, are not part of
+// it and have to be set again on the created services. Group default
+// contexts, priority tiers and shards are not captured either: endpoints
+// registered through them are recorded as plain endpoints.
+func (s *Service) Blueprint(registry *HandlerRegistry) (Blueprint, error) {
+	cfg := s.snapshot()
+	info := s.svc.Info()
+
+	s.described.mu.Lock()
+	endpoints := append([]EndpointDescription{}, s.described.endpoints...)
+	wiring := append([]endpointWiring{}, s.described.wiring...)
+	s.described.mu.Unlock()
+
+	bp := Blueprint{
+		Name:        info.Name,
+		Version:     info.Version,
+		Description: info.Description,
+		Metadata:    info.Metadata,
+	}
+	var err error
+	if bp.Middleware, err = registry.names(registry.mw, anySlice(cfg.mw), ErrBlueprintMiddleware); err != nil {
+		return Blueprint{}, err
+	}
+	if bp.ContextMiddleware, err = registry.names(registry.cmw, anySlice(cfg.cmw), ErrBlueprintMiddleware); err != nil {
+		return Blueprint{}, err
+	}
+	if bp.MicroMiddleware, err = registry.names(registry.mmw, anySlice(cfg.mmw), ErrBlueprintMiddleware); err != nil {
+		return Blueprint{}, err
+	}
+
+	for i, ep := range endpoints {
+		subject := ep.Subject
+		if ep.Group != "" {
+			subject = strings.TrimPrefix(subject, ep.Group+".")
+		}
+		if ep.Options.Version != "" {
+			subject = strings.TrimPrefix(subject, ep.Options.Version+".")
+		}
+		epBp := EndpointBlueprint{
+			Name:       ep.Name,
+			Subject:    subject,
+			Group:      ep.Group,
+			QueueGroup: ep.QueueGroup,
+			Kind:       ep.Kind,
+			Metadata:   ep.Metadata,
+			Options:    ep.Options,
+		}
+		// Cron and watch endpoints are recorded, but cannot be created
+		if handlers, mws := registry.kind(ep.Kind); handlers != nil {
+			names, err := registry.names(handlers, []any{wiring[i].handler}, ErrBlueprintHandler)
+			if err != nil {
+				return Blueprint{}, fmt.Errorf("endpoint %q: %w", ep.Name, err)
+			}
+			epBp.Handler = names[0]
+			if epBp.Middleware, err = registry.names(mws, wiring[i].middleware, ErrBlueprintMiddleware); err != nil {
+				return Blueprint{}, fmt.Errorf("endpoint %q: %w", ep.Name, err)
+			}
+		}
+		bp.Endpoints = append(bp.Endpoints, epBp)
+	}
+	return bp, nil
+}
+
+// HandlerRegistry maps the names used in blueprints to handlers and
+// middleware, registered under explicit names, e.g. "orders.list" or
+// "quota-gold". The process taking a blueprint and the ones creating
+// services from it register the same values under the same names. Each
+// name and each value can only be registered once per kind, so that two
+// middleware created by the same factory with different options, e.g.
+// `QuotaMiddleware(gold)` and `QuotaMiddleware(basic)`, keep their own
+// names.
+type HandlerRegistry struct {
+	mu              sync.RWMutex
+	handlers        *registryEntries
+	contextHandlers *registryEntries
+	microHandlers   *registryEntries
+	mw              *registryEntries
+	cmw             *registryEntries
+	mmw             *registryEntries
+}
+
+// Values of one kind by name, and names by value identity
+type registryEntries struct {
+	values map[string]any
+	names  map[uintptr]string
+}
+
+func newRegistryEntries() *registryEntries {
+	return &registryEntries{values: make(map[string]any), names: make(map[uintptr]string)}
+}
+
+// NewHandlerRegistry creates an empty registry.
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{
+		handlers:        newRegistryEntries(),
+		contextHandlers: newRegistryEntries(),
+		microHandlers:   newRegistryEntries(),
+		mw:              newRegistryEntries(),
+		cmw:             newRegistryEntries(),
+		mmw:             newRegistryEntries(),
+	}
+}
+
+// Identity of a handler or middleware value. For functions it is the
+// closure the value points to, so that closures created by the same factory,
+// which share their code and function name, are told apart.
+func valueIdentity(v any) uintptr {
+	return (*[2]uintptr)(unsafe.Pointer(&v))[1]
+}
+
+// RegisterHandler registers a handler of raw endpoints under the name.
+func (r *HandlerRegistry) RegisterHandler(name string, handler micro.Handler) error {
+	return r.register(r.handlers, name, handler)
+}
+
+// RegisterContextHandler registers a handler of context endpoints under the name.
+func (r *HandlerRegistry) RegisterContextHandler(name string, handler ContextHandlerFunc) error {
+	return r.register(r.contextHandlers, name, handler)
+}
+
+// RegisterMicroHandler registers a handler of Micro endpoints under the name.
+func (r *HandlerRegistry) RegisterMicroHandler(name string, handler MicroHandlerFunc) error {
+	return r.register(r.microHandlers, name, handler)
+}
+
+// RegisterMiddleware registers middleware of raw endpoints under the name.
+func (r *HandlerRegistry) RegisterMiddleware(name string, fn MiddlewareFunc) error {
+	return r.register(r.mw, name, fn)
+}
+
+// RegisterContextMiddleware registers middleware of context endpoints under the name.
+func (r *HandlerRegistry) RegisterContextMiddleware(name string, fn ContextMiddlewareFunc) error {
+	return r.register(r.cmw, name, fn)
+}
+
+// RegisterMicroMiddleware registers middleware of Micro endpoints under the name.
+func (r *HandlerRegistry) RegisterMicroMiddleware(name string, fn MicroMiddlewareFunc) error {
+	return r.register(r.mmw, name, fn)
+}
+
+func (r *HandlerRegistry) register(entries *registryEntries, name string, v any) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrBlueprintDuplicate)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := entries.values[name]; ok {
+		return fmt.Errorf("%w: %q", ErrBlueprintDuplicate, name)
+	}
+	id := valueIdentity(v)
+	if other, ok := entries.names[id]; ok {
+		return fmt.Errorf("%w: %q is already registered as %q", ErrBlueprintDuplicate, name, other)
+	}
+	entries.values[name] = v
+	entries.names[id] = name
+	return nil
+}
+
+// Handlers and middleware registered for the endpoint kind, nil for kinds
+// blueprints cannot create
+func (r *HandlerRegistry) kind(kind EndpointKind) (handlers, mws *registryEntries) {
+	switch kind {
+	case EndpointRaw:
+		return r.handlers, r.mw
+	case EndpointContext:
+		return r.contextHandlers, r.cmw
+	case EndpointMicro:
+		return r.microHandlers, r.mmw
+	}
+	return nil, nil
+}
+
+// Names the values are registered under
+func (r *HandlerRegistry) names(entries *registryEntries, values []any, errNotFound error) ([]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, len(values))
+	for i, v := range values {
+		name, ok := entries.names[valueIdentity(v)]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errNotFound, handlerName(v))
+		}
+		names[i] = name
+	}
+	return names, nil
+}
+
+func lookupNamed[T any](r *HandlerRegistry, entries *registryEntries, names []string, errNotFound error) ([]T, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	values := make([]T, len(names))
+	for i, name := range names {
+		v, ok := entries.values[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", errNotFound, name)
+		}
+		values[i] = v.(T)
+	}
+	return values, nil
+}
+
+// Name of a handler, the function name for functions and the type name for
+// other handlers
+func handlerName(handler any) string {
+	if reflect.ValueOf(handler).Kind() == reflect.Func {
+		return middlewareName(handler)
+	}
+	name := reflect.TypeOf(handler).String()
+	return strings.TrimPrefix(name, "*")
+}
+
+// FromBlueprint creates a service on the connection from the blueprint,
+// e.g. to run identical services in several regions from one definition,
+// and registers its endpoints with the handlers and middleware of the
+// registry. Cron and watch endpoints are not supported, and an error is
+// returned if the blueprint has any, or refers to names missing from the
+// registry.
+func FromBlueprint(nc *nats.Conn, bp Blueprint, registry *HandlerRegistry) (*Service, error) {
+	mw, err := lookupNamed[MiddlewareFunc](registry, registry.mw, bp.Middleware, ErrBlueprintMiddleware)
+	if err != nil {
+		return nil, err
+	}
+	cmw, err := lookupNamed[ContextMiddlewareFunc](registry, registry.cmw, bp.ContextMiddleware, ErrBlueprintMiddleware)
+	if err != nil {
+		return nil, err
+	}
+	mmw, err := lookupNamed[MicroMiddlewareFunc](registry, registry.mmw, bp.MicroMiddleware, ErrBlueprintMiddleware)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := AddService(nc, micro.Config{
+		Name:        bp.Name,
+		Version:     bp.Version,
+		Description: bp.Description,
+		Metadata:    bp.Metadata,
+	}, mw...)
+	if err != nil {
+		return nil, err
+	}
+	s.cmw = cmw
+	s.mmw = mmw
+
+	groups := make(map[string]*Group)
+	for _, ep := range bp.Endpoints {
+		if err := s.addBlueprintEndpoint(ep, registry, groups); err != nil {
+			_ = s.Stop()
+			return nil, fmt.Errorf("endpoint %q: %w", ep.Name, err)
+		}
+	}
+	return s, nil
+}
+
+// Register an endpoint of a blueprint, with the options recorded for it
+func (s *Service) addBlueprintEndpoint(ep EndpointBlueprint, registry *HandlerRegistry, groups map[string]*Group) error {
+	ns := s.clone()
+	ns.handlerTimeout = ep.Options.HandlerTimeout
+	ns.version = ep.Options.Version
+	ns.versionPrecedence = ep.Options.VersionPrecedence
+	ns.subjectAliases = map[string][]string{ep.Name: ep.Options.Aliases}
+	ns.echoHeaders = ep.Options.EchoHeaders
+	ns.defaultErrorCode = ep.Options.DefaultErrorCode
+	ns.instanceSubjects = ep.Options.InstanceSubjects
+	ns.recoverPanics = ep.Options.RecoverPanics
+
+	opts := []micro.EndpointOpt{micro.WithEndpointSubject(ep.Subject)}
+	if ep.QueueGroup != "" {
+		opts = append(opts, micro.WithEndpointQueueGroup(ep.QueueGroup))
+	}
+	if ep.Metadata != nil {
+		opts = append(opts, micro.WithEndpointMetadata(copyMetadata(ep.Metadata)))
+	}
+
+	var parent endpointParent = s.svc
+	if ep.Group != "" {
+		grp, ok := groups[ep.Group]
+		if !ok {
+			grp = s.AddGroup(ep.Group)
+			groups[ep.Group] = grp
+		}
+		parent = grp.grp
+	}
+	ref := &endpointRef{name: ep.Name, kind: ep.Kind, group: ep.Group}
+
+	switch ep.Kind {
+	case EndpointRaw:
+		mw, err := lookupNamed[MiddlewareFunc](registry, registry.mw, ep.Middleware, ErrBlueprintMiddleware)
+		if err != nil {
+			return err
+		}
+		handler, err := lookupNamed[micro.Handler](registry, registry.handlers, []string{ep.Handler}, ErrBlueprintHandler)
+		if err != nil {
+			return err
+		}
+		ns.mw = mw
+		ref.handler, ref.fn = handlerName(handler[0]), handler[0]
+		return ns.addEndpoint(ns.endpointAdder(parent), ref, ns.snapshot().unanswered.track(wrapHandler(handler[0], mw...)), opts...)
+	case EndpointContext:
+		cmw, err := lookupNamed[ContextMiddlewareFunc](registry, registry.cmw, ep.Middleware, ErrBlueprintMiddleware)
+		if err != nil {
+			return err
+		}
+		handler, err := lookupNamed[ContextHandlerFunc](registry, registry.contextHandlers, []string{ep.Handler}, ErrBlueprintHandler)
+		if err != nil {
+			return err
+		}
+		ns.cmw = cmw
+		ref.handler, ref.fn = handlerName(handler[0]), handler[0]
+		return ns.addEndpoint(ns.endpointAdder(parent), ref, wrapContextHandler(ns, ref, handler[0]), opts...)
+	case EndpointMicro:
+		mmw, err := lookupNamed[MicroMiddlewareFunc](registry, registry.mmw, ep.Middleware, ErrBlueprintMiddleware)
+		if err != nil {
+			return err
+		}
+		handler, err := lookupNamed[MicroHandlerFunc](registry, registry.microHandlers, []string{ep.Handler}, ErrBlueprintHandler)
+		if err != nil {
+			return err
+		}
+		ns.mmw = mmw
+		ref.handler, ref.fn = handlerName(handler[0]), handler[0]
+		return ns.addEndpoint(ns.endpointAdder(parent), ref, wrapMicroHandler(ns, ref, handler[0]), opts...)
+	default:
+		return fmt.Errorf("%w: %q", ErrBlueprintEndpoint, ep.Kind)
+	}
+}
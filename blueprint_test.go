@@ -0,0 +1,158 @@
+package natsmicromw
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func blueprintEcho(req *MicroRequest) (*MicroReply, error) {
+	return NewMicroReply(req.Data), nil
+}
+
+// Handler factory, whose handlers share one function name
+func blueprintReply(reply string) MicroHandlerFunc {
+	return func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply([]byte(reply)), nil
+	}
+}
+
+func TestBlueprint(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	hello, bye := blueprintReply("hello"), blueprintReply("bye")
+	if err := nm.UseMicro(rejectingMiddleware).AddMicroEndpoint("echo", blueprintEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddMicroEndpoint("hello", hello); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddMicroEndpoint("bye", bye); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	orders := nm.AddGroup("orders").WithVersionPrefix("v2").WithHandlerTimeout(time.Second)
+	if err := orders.AddContextEndpoint("list", emptyRequestHandler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	newRegistry := func(t *testing.T) *HandlerRegistry {
+		registry := NewHandlerRegistry()
+		for _, err := range []error{
+			registry.RegisterMicroHandler("echo", blueprintEcho),
+			registry.RegisterMicroHandler("hello", hello),
+			registry.RegisterMicroHandler("bye", bye),
+			registry.RegisterContextHandler("orders.list", emptyRequestHandler),
+			registry.RegisterMicroMiddleware("reject", rejectingMiddleware),
+		} {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return registry
+	}
+
+	t.Run("unregistered", func(t *testing.T) {
+		registry := NewHandlerRegistry()
+		if err := registry.RegisterMicroHandler("echo", blueprintEcho); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := nm.Blueprint(registry); !errors.Is(err, ErrBlueprintMiddleware) {
+			t.Errorf("expected ErrBlueprintMiddleware, received %v", err)
+		}
+	})
+
+	t.Run("duplicates", func(t *testing.T) {
+		registry := newRegistry(t)
+		if err := registry.RegisterMicroHandler("echo", blueprintReply("other")); !errors.Is(err, ErrBlueprintDuplicate) {
+			t.Errorf("expected ErrBlueprintDuplicate for a duplicate name, received %v", err)
+		}
+		if err := registry.RegisterMicroHandler("echo2", blueprintEcho); !errors.Is(err, ErrBlueprintDuplicate) {
+			t.Errorf("expected ErrBlueprintDuplicate for a duplicate handler, received %v", err)
+		}
+	})
+
+	bp, err := nm.Blueprint(newRegistry(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Round trip through JSON as when distributing it
+	data, err := json.Marshal(bp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bp = Blueprint{}
+	if err := json.Unmarshal(data, &bp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bp.Name != "TestService" || len(bp.Endpoints) != 4 {
+		t.Fatalf("unexpected blueprint: %+v", bp)
+	}
+	echo := bp.Endpoints[0]
+	if echo.Handler != "echo" || !reflect.DeepEqual(echo.Middleware, []string{"reject"}) {
+		t.Errorf("unexpected endpoint blueprint: %+v", echo)
+	}
+	// Handlers of the same factory keep their own names
+	if bp.Endpoints[1].Handler != "hello" || bp.Endpoints[2].Handler != "bye" {
+		t.Errorf("unexpected handler names: %q, %q", bp.Endpoints[1].Handler, bp.Endpoints[2].Handler)
+	}
+	list := bp.Endpoints[3]
+	if list.Handler != "orders.list" || list.Subject != "list" || list.Group != "orders" || list.Options.Version != "v2" {
+		t.Errorf("unexpected endpoint blueprint: %+v", list)
+	}
+
+	t.Run("instantiated", func(t *testing.T) {
+		nc2, err := nats.Connect(s.Addr().String())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer nc2.Close()
+
+		registry := newRegistry(t)
+		clone, err := FromBlueprint(nc2, bp, registry)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer clone.Stop()
+
+		desc, cloneDesc := nm.Describe(), clone.Describe()
+		if len(cloneDesc.Endpoints) != len(desc.Endpoints) {
+			t.Fatalf("endpoints do not match, received %+v", cloneDesc.Endpoints)
+		}
+		for i, ep := range cloneDesc.Endpoints {
+			if !reflect.DeepEqual(ep, desc.Endpoints[i]) {
+				t.Errorf("endpoint does not match:\n%+v\n%+v", ep, desc.Endpoints[i])
+			}
+		}
+		cloneBp, err := clone.Blueprint(registry)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cloneData, _ := json.Marshal(cloneBp); string(cloneData) != string(data) {
+			t.Errorf("blueprint of the created service does not match:\n%s\n%s", cloneData, data)
+		}
+
+		// The created service serves the same subjects
+		nm.Stop()
+		if err := nc2.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msg := nats.NewMsg("echo")
+		msg.Header.Set("reject", "1")
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != CodeForbidden {
+			t.Errorf("expected the middleware to reject the request, received %v", handlerErr)
+		}
+		if reply, err := nc.Request("bye", nil, time.Second); err != nil || string(reply.Data) != "bye" {
+			t.Errorf("expected the bye handler, received %v %v", reply, err)
+		}
+	})
+}
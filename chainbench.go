@@ -0,0 +1,195 @@
+package natsmicromw
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	chainBenchSubjectPrefix = "_CHAINBENCH"
+
+	// Iterations of a chain benchmark when the request does not set them
+	DefaultChainBenchmarkIterations = 1000
+	// Upper bound of the iterations of a chain benchmark
+	MaxChainBenchmarkIterations = 100000
+)
+
+// ChainBenchmarkRequest is the request of the chain benchmark endpoint.
+type ChainBenchmarkRequest struct {
+	// Number of runs of the chain, DefaultChainBenchmarkIterations if zero
+	Iterations int `json:"iterations,omitempty"`
+	// Pipeline to run, "context" or "micro", both if empty
+	Pipeline EndpointKind `json:"pipeline,omitempty"`
+	// Subject, headers and payload of the synthesized requests, e.g. to pass
+	// authentication middleware
+	Subject string              `json:"subject,omitempty"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Data    []byte              `json:"data,omitempty"`
+}
+
+// ChainLayerStats is the latency of a layer of the chain, excluding the
+// layers it calls.
+type ChainLayerStats struct {
+	Name   string        `json:"name"`
+	Calls  int           `json:"calls"`
+	Errors int           `json:"errors"`
+	Mean   time.Duration `json:"mean"`
+	P50    time.Duration `json:"p50"`
+	P99    time.Duration `json:"p99"`
+	Max    time.Duration `json:"max"`
+}
+
+// ChainBenchmarkResult is the result of benchmarking a pipeline.
+type ChainBenchmarkResult struct {
+	Pipeline   EndpointKind      `json:"pipeline"`
+	Iterations int               `json:"iterations"`
+	Duration   time.Duration     `json:"duration"`
+	Layers     []ChainLayerStats `json:"layers"`
+}
+
+type chainBenchmarkContextKey struct{}
+
+// IsChainBenchmark reports whether the request is synthesized by the chain
+// benchmark, see [Service.EnableChainBenchmark]. Middleware with side
+// effects, e.g. consuming quota, publishing audit records, forwarding or
+// recording metrics, skip them for such requests and call the next handler.
+func IsChainBenchmark(ctx context.Context) bool {
+	bench, _ := ctx.Value(chainBenchmarkContextKey{}).(bool)
+	return bench
+}
+
+// EnableChainBenchmark subscribes a hidden endpoint that runs a no-op handler
+// N times through the context and Micro middleware chains of the service, or
+// of the scope it is called on, and replies with the latency of each layer as
+// a JSON list of [ChainBenchmarkResult], so operators can measure the
+// middleware overhead of the deployed binary and configuration. The endpoint
+// is not listed in the service info and only serves requests passing the
+// authorizer, others get a "403" error. It listens on
+// "_CHAINBENCH.<service name>.<instance ID>", which is returned, and is removed
+// when the service stops. Requests take a JSON [ChainBenchmarkRequest] and
+// block the endpoint while running.
+//
+// The chains are built once, when the benchmark is enabled, so the
+// middleware share their state with each other across benchmark requests,
+// but not with the endpoints. The synthesized requests are marked, see
+// [IsChainBenchmark], and the middleware of this module skip their side
+// effects for them, so their measured latency excludes e.g. the quota
+// store or publishing audit records. Third party middleware may not.
+func (s *Service) EnableChainBenchmark(authorize DebugChainAuthorizer) (string, error) {
+	cfg := s.snapshot()
+	chains := cfg.benchmarkChains()
+	return s.subscribeOps(chainBenchSubjectPrefix, authorize, func(req *msgRequest) {
+		var benchReq ChainBenchmarkRequest
+		if len(req.Data()) > 0 {
+			if err := json.Unmarshal(req.Data(), &benchReq); err != nil {
+				_ = req.Error(CodeBadRequest, "invalid request: "+err.Error(), nil)
+				return
+			}
+		}
+		_ = req.RespondJSON(cfg.runBenchmark(chains, benchReq))
+	})
+}
+
+// Traced chains of the benchmark, with no-op handlers
+type chainBenchmarks struct {
+	context ContextHandlerFunc
+	micro   MicroHandlerFunc
+}
+
+// Build the chains of the benchmark, called on a snapshot
+func (s *Service) benchmarkChains() chainBenchmarks {
+	return chainBenchmarks{
+		context: tracedContextChain(s.cmw, func(*Request) error { return nil }),
+		micro: tracedMicroChain(s.mmw, func(*MicroRequest) (*MicroReply, error) {
+			return NewMicroReply(nil), nil
+		}),
+	}
+}
+
+// Run the requested pipelines, called on a snapshot
+func (s *Service) runBenchmark(chains chainBenchmarks, req ChainBenchmarkRequest) []ChainBenchmarkResult {
+	if req.Iterations <= 0 {
+		req.Iterations = DefaultChainBenchmarkIterations
+	}
+	if req.Iterations > MaxChainBenchmarkIterations {
+		req.Iterations = MaxChainBenchmarkIterations
+	}
+	if req.Subject == "" {
+		req.Subject = chainBenchSubjectPrefix
+	}
+
+	var results []ChainBenchmarkResult
+	if req.Pipeline == "" || req.Pipeline == EndpointContext {
+		results = append(results, s.benchmarkChain(EndpointContext, req, chains.context))
+	}
+	if req.Pipeline == "" || req.Pipeline == EndpointMicro {
+		results = append(results, s.benchmarkChain(EndpointMicro, req, func(r *Request) error {
+			_, err := chains.micro(newMicroRequest(r.Request, r.Context()))
+			return err
+		}))
+	}
+	return results
+}
+
+// Run the chain for the iterations and aggregate the traces per layer
+func (s *Service) benchmarkChain(pipeline EndpointKind, req ChainBenchmarkRequest, run func(*Request) error) ChainBenchmarkResult {
+	baseCtx := context.WithValue(withErrorConverter(withConn(s.baseContext(), s.nc), s.errorConverter()), chainBenchmarkContextKey{}, true)
+	var layers []ChainLayerStats
+	var durations [][]time.Duration
+
+	start := time.Now()
+	for n := 0; n < req.Iterations; n++ {
+		trace := &chainTrace{start: time.Now()}
+		ctx := withChainTrace(withErrorMeta(baseCtx, new(errorMeta)), trace)
+		fake := &benchRequest{cronRequest{req.Subject, micro.Headers(req.Headers)}, req.Data}
+		_ = run(&Request{fake, ctx})
+
+		for i, span := range trace.spans {
+			if i == len(layers) {
+				layers = append(layers, ChainLayerStats{Name: span.name})
+				durations = append(durations, nil)
+			}
+			// Exclude the time spent in the next layer, if it was entered
+			self := span.duration
+			if i+1 < len(trace.spans) {
+				self -= trace.spans[i+1].duration
+			}
+			layers[i].Calls++
+			if span.code != "" {
+				layers[i].Errors++
+			}
+			durations[i] = append(durations[i], self)
+		}
+	}
+
+	for i := range layers {
+		d := durations[i]
+		sort.Slice(d, func(a, b int) bool { return d[a] < d[b] })
+		var total time.Duration
+		for _, v := range d {
+			total += v
+		}
+		layers[i].Mean = total / time.Duration(len(d))
+		layers[i].P50 = d[len(d)/2]
+		layers[i].P99 = d[len(d)*99/100]
+		layers[i].Max = d[len(d)-1]
+	}
+	return ChainBenchmarkResult{
+		Pipeline:   pipeline,
+		Iterations: req.Iterations,
+		Duration:   time.Since(start),
+		Layers:     layers,
+	}
+}
+
+// Synthesized request of a chain benchmark, replies are discarded
+type benchRequest struct {
+	cronRequest
+	data []byte
+}
+
+func (r *benchRequest) Data() []byte { return r.data }
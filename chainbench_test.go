@@ -0,0 +1,110 @@
+package natsmicromw
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestChainBenchmark(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	if _, err := nm.EnableChainBenchmark(nil); err != ErrOpsAuthorizer {
+		t.Fatalf("expected ErrOpsAuthorizer, received %v", err)
+	}
+
+	// Count the chains built and the requests marked as benchmark requests
+	var built, marked atomic.Int32
+	counting := func(next MicroHandlerFunc) MicroHandlerFunc {
+		built.Add(1)
+		return func(req *MicroRequest) (*MicroReply, error) {
+			if IsChainBenchmark(req.Context()) {
+				marked.Add(1)
+			}
+			return next(req)
+		}
+	}
+	subject, err := nm.UseMicro(rejectingMiddleware, counting).EnableChainBenchmark(func(req micro.Request) bool {
+		return req.Headers().Get("debug-token") == "secret"
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(subject, "_CHAINBENCH.") {
+		t.Errorf("unexpected subject %q", subject)
+	}
+
+	request := func(token string, req ChainBenchmarkRequest) *nats.Msg {
+		msg := nats.NewMsg(subject)
+		msg.Header.Set("debug-token", token)
+		msg.Data, _ = json.Marshal(req)
+		reply, err := nc.RequestMsg(msg, 5*time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return reply
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		reply := request("wrong", ChainBenchmarkRequest{})
+		if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != CodeForbidden {
+			t.Errorf("expected a 403 error, received %v", handlerErr)
+		}
+	})
+
+	t.Run("micro", func(t *testing.T) {
+		reply := request("secret", ChainBenchmarkRequest{Iterations: 10, Pipeline: EndpointMicro})
+		var results []ChainBenchmarkResult
+		if err := json.Unmarshal(reply.Data, &results); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(results) != 1 || results[0].Pipeline != EndpointMicro || results[0].Iterations != 10 {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+		layers := results[0].Layers
+		if len(layers) != 3 || layers[0].Name != "natsmicromw.rejectingMiddleware" || layers[2].Name != "handler" {
+			t.Fatalf("unexpected layers: %+v", layers)
+		}
+		for _, layer := range layers {
+			if layer.Calls != 10 || layer.Errors != 0 || layer.Max < layer.P50 {
+				t.Errorf("unexpected layer stats: %+v", layer)
+			}
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		reply := request("secret", ChainBenchmarkRequest{Iterations: 5, Headers: map[string][]string{"reject": {"1"}}})
+		var results []ChainBenchmarkResult
+		if err := json.Unmarshal(reply.Data, &results); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("expected both pipelines, received %+v", results)
+		}
+		micro := results[1]
+		if len(micro.Layers) != 1 || micro.Layers[0].Errors != 5 {
+			t.Errorf("expected the middleware to reject all requests, received %+v", micro.Layers)
+		}
+	})
+
+	if n := built.Load(); n != 1 {
+		t.Errorf("expected the chain to be built once, built %d", n)
+	}
+	if n := marked.Load(); n != 10 {
+		t.Errorf("expected 10 marked requests, received %d", n)
+	}
+
+	info := nm.svc.Info()
+	for _, ep := range info.Endpoints {
+		if strings.HasPrefix(ep.Subject, "_CHAINBENCH") {
+			t.Errorf("expected the benchmark endpoint to be hidden, found %q", ep.Subject)
+		}
+	}
+}
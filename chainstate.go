@@ -0,0 +1,104 @@
+package natsmicromw
+
+import (
+	"context"
+	"sync"
+)
+
+// StateKey identifies a fact of type T in the [ChainState] of a request.
+// Keys are compared by identity, so middleware define their keys once with
+// [NewStateKey] as exported variables, documenting when they are set,
+// instead of inventing private context keys.
+type StateKey[T any] struct {
+	name string
+}
+
+// NewStateKey creates a key for facts of type T. The name is used when
+// listing the facts, e.g. for logging, and should be unique.
+func NewStateKey[T any](name string) *StateKey[T] {
+	return &StateKey[T]{name: name}
+}
+
+// Name returns the name of the key.
+func (k *StateKey[T]) Name() string {
+	return k.name
+}
+
+// Set publishes the fact in the chain state of the request, replacing the
+// value set before. This has no effect outside context and Micro handlers.
+func (k *StateKey[T]) Set(ctx context.Context, value T) {
+	s := ChainStateFromContext(ctx)
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.facts == nil {
+		s.facts = make(map[any]any)
+	}
+	if _, ok := s.facts[k]; !ok {
+		s.keys = append(s.keys, k)
+	}
+	s.facts[k] = value
+}
+
+// Get returns the fact published in the chain state of the request, false
+// if it was not set.
+func (k *StateKey[T]) Get(ctx context.Context) (T, bool) {
+	var zero T
+	s := ChainStateFromContext(ctx)
+	if s == nil {
+		return zero, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.facts[k].(T)
+	if !ok {
+		return zero, false
+	}
+	return value, true
+}
+
+// Key of the chain state, whatever the type of its facts
+type namedStateKey interface {
+	Name() string
+}
+
+// ChainState carries the facts middleware publish about a request, e.g.
+// its decompressed size, authenticated identity or cache decision, for
+// the middleware running after them, e.g. metrics labels and logging. The
+// facts are read and written with typed [StateKey] values. Every request to
+// a context or Micro handler has its own chain state.
+type ChainState struct {
+	mu    sync.Mutex
+	keys  []namedStateKey
+	facts map[any]any
+}
+
+type chainStateContextKey struct{}
+
+func withChainState(ctx context.Context, s *ChainState) context.Context {
+	return context.WithValue(ctx, chainStateContextKey{}, s)
+}
+
+// ChainStateFromContext returns the chain state of the request, nil outside
+// context and Micro handlers.
+func ChainStateFromContext(ctx context.Context) *ChainState {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(chainStateContextKey{}).(*ChainState)
+	return s
+}
+
+// Facts returns the facts published so far by the name of their key, e.g.
+// to add them to log entries.
+func (s *ChainState) Facts() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	facts := make(map[string]any, len(s.keys))
+	for _, key := range s.keys {
+		facts[key.Name()] = s.facts[key]
+	}
+	return facts
+}
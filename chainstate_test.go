@@ -0,0 +1,75 @@
+package natsmicromw
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChainState(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	size := NewStateKey[int]("size")
+	decision := NewStateKey[string]("decision")
+	// Another key with the same name is a different key
+	other := NewStateKey[int]("size")
+
+	var facts map[string]any
+	var otherSet bool
+	outer := func(next MicroHandlerFunc) MicroHandlerFunc {
+		return func(req *MicroRequest) (*MicroReply, error) {
+			reply, err := next(req)
+			facts = ChainStateFromContext(req.Context()).Facts()
+			_, otherSet = other.Get(req.Context())
+			return reply, err
+		}
+	}
+	inner := func(next MicroHandlerFunc) MicroHandlerFunc {
+		return func(req *MicroRequest) (*MicroReply, error) {
+			size.Set(req.Context(), len(req.Data))
+			decision.Set(req.Context(), "miss")
+			return next(req)
+		}
+	}
+	err := nm.UseMicro(outer, inner).AddMicroEndpoint("state", func(req *MicroRequest) (*MicroReply, error) {
+		n, ok := size.Get(req.Context())
+		if !ok || n != 5 {
+			t.Errorf("expected the size set by the middleware, received %v %v", n, ok)
+		}
+		decision.Set(req.Context(), "hit")
+		return NewMicroReply(nil), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := nc.Request("state", []byte("hello"), time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(facts) != 2 || facts["size"] != 5 || facts["decision"] != "hit" {
+		t.Errorf("unexpected facts: %v", facts)
+	}
+	if otherSet {
+		t.Errorf("expected keys with the same name to be distinct")
+	}
+}
+
+func TestChainStateOutsideHandler(t *testing.T) {
+	key := NewStateKey[string]("key")
+	ctx := context.Background()
+	key.Set(ctx, "value")
+	if _, ok := key.Get(ctx); ok {
+		t.Errorf("expected no chain state outside handlers")
+	}
+	if ChainStateFromContext(ctx) != nil {
+		t.Errorf("expected no chain state outside handlers")
+	}
+
+	// Micro requests built without a context
+	key.Set(nil, "value")
+	if _, ok := key.Get(nil); ok {
+		t.Errorf("expected no chain state without a context")
+	}
+}
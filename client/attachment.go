@@ -0,0 +1,95 @@
+// Fetching of reply attachments from the object store.
+
+package client
+
+import (
+	"context"
+	"io"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// FetchedAttachment is the content of a reply attachment.
+type FetchedAttachment struct {
+	natsmicromw.Attachment
+	ContentType string
+	Data        []byte
+}
+
+// WithInlineAttachments fetches the attachment of successful replies with
+// an empty payload and a single attachment, see
+// `natsmicromw.AttachmentStore`, and returns it as the reply payload, with
+// its content type, so callers need not know whether the service attached
+// a large result or replied with it directly.
+func WithInlineAttachments() Option {
+	return func(c *Client) {
+		c.inlineAttachments = true
+	}
+}
+
+// FetchAttachment fetches the content of the attachment from the object
+// store.
+func (c *Client) FetchAttachment(ctx context.Context, a natsmicromw.Attachment) (*FetchedAttachment, error) {
+	js, err := c.nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	obs, err := js.ObjectStore(a.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	result, err := obs.Get(a.Object, nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	data, err := io.ReadAll(result)
+	if err != nil {
+		return nil, err
+	}
+	fetched := &FetchedAttachment{Attachment: a, Data: data}
+	if info, err := result.Info(); err == nil && info.Headers != nil {
+		fetched.ContentType = info.Headers.Get(natsmicromw.HeaderContentType)
+	}
+	return fetched, nil
+}
+
+// FetchAttachments fetches the contents of all attachments of the reply.
+func (c *Client) FetchAttachments(ctx context.Context, reply *nats.Msg) ([]*FetchedAttachment, error) {
+	attachments := natsmicromw.AttachmentsFromMsg(reply)
+	fetched := make([]*FetchedAttachment, 0, len(attachments))
+	for _, a := range attachments {
+		f, err := c.FetchAttachment(ctx, a)
+		if err != nil {
+			return nil, err
+		}
+		fetched = append(fetched, f)
+	}
+	return fetched, nil
+}
+
+// Replace the empty payload of the reply with its single attachment
+func (c *Client) inlineAttachment(ctx context.Context, reply *nats.Msg) (*nats.Msg, error) {
+	attachments := natsmicromw.AttachmentsFromMsg(reply)
+	if len(reply.Data) > 0 || len(attachments) != 1 {
+		return reply, nil
+	}
+	fetched, err := c.FetchAttachment(ctx, attachments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	headers := nats.Header{}
+	for k, v := range reply.Header {
+		if k != natsmicromw.HeaderAttachment {
+			headers[k] = v
+		}
+	}
+	if fetched.ContentType != "" {
+		headers.Set(natsmicromw.HeaderContentType, fetched.ContentType)
+	}
+	return &nats.Msg{Subject: reply.Subject, Header: headers, Data: fetched.Data}, nil
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestAttachments(t *testing.T) {
+	opts := &server.Options{Host: "localhost", Port: server.RANDOM_PORT, NoSigs: true, JetStream: true, StoreDir: t.TempDir()}
+	s, err := runServer(opts)
+	if err != nil {
+		t.Fatalf("Could not start NATS server: %v", err)
+	}
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "attachments", TTL: time.Hour}); err != nil {
+		t.Fatalf("Could not create bucket: %v", err)
+	}
+	store, err := natsmicromw.NewAttachmentStore(js, "attachments")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	nm, err := natsmicromw.AddMicroService(nc, micro.Config{Name: "TestService", Version: "1.0.0"})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+	defer nm.Stop()
+
+	// Larger than the default max payload of 1MB
+	document := bytes.Repeat([]byte("pdf"), 500_000)
+	err = nm.AddMicroEndpoint("document", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		a, err := store.Put("report.pdf", "application/pdf", bytes.NewReader(document))
+		if err != nil {
+			return nil, err
+		}
+		reply := natsmicromw.NewMicroReply(nil)
+		reply.Attach(a)
+		return reply, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = nm.AddContextEndpoint("images", func(req *natsmicromw.Request) error {
+		first, err := store.Put("a.png", "image/png", bytes.NewReader([]byte("a")))
+		if err != nil {
+			return err
+		}
+		second, err := store.Put("b.png", "", bytes.NewReader([]byte("b")))
+		if err != nil {
+			return err
+		}
+		return req.Respond([]byte("two images"), natsmicromw.WithAttachments(first, second))
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("fetched", func(t *testing.T) {
+		c := New(nc, WithTimeout(time.Second))
+		reply, err := c.Request(context.Background(), "images", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fetched, err := c.FetchAttachments(context.Background(), reply)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(fetched) != 2 || fetched[0].Name() != "a.png" || string(fetched[0].Data) != "a" || fetched[0].ContentType != "image/png" ||
+			fetched[1].Name() != "b.png" || string(fetched[1].Data) != "b" || fetched[1].ContentType != "" {
+			t.Errorf("unexpected attachments: %+v", fetched)
+		}
+	})
+
+	t.Run("inlined", func(t *testing.T) {
+		c := New(nc, WithTimeout(5*time.Second), WithInlineAttachments())
+		reply, err := c.Request(context.Background(), "document", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(reply.Data, document) {
+			t.Errorf("expected the document as payload, received %d bytes", len(reply.Data))
+		}
+		if reply.Header.Get(natsmicromw.HeaderContentType) != "application/pdf" || reply.Header.Get(natsmicromw.HeaderAttachment) != "" {
+			t.Errorf("unexpected headers: %v", reply.Header)
+		}
+
+		// Replies with a payload are returned as they are
+		reply, err = c.Request(context.Background(), "images", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(reply.Data) != "two images" || len(natsmicromw.AttachmentsFromMsg(reply)) != 2 {
+			t.Errorf("unexpected reply: %q %v", reply.Data, reply.Header)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+// Cooperative flow control with backpressure hints.
+
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// WithBackpressure slows down the request rate of the client when services
+// hint that they are under pressure with the `x-backpressure` reply header,
+// see `natsmicromw.Service.SetBackpressure`. Each request waits the load
+// of the last hinted reply times the maximum delay, e.g. 80 milliseconds
+// for a load of 0.8 with a maximum of 100 milliseconds. The delay halves
+// with every reply without a hint, so the client speeds up again as the
+// pressure goes away. The delay is kept per subject, so a service under
+// pressure does not slow down requests to other services.
+func WithBackpressure(maxDelay time.Duration) Option {
+	return func(c *Client) {
+		c.pacer = &pacer{maxDelay: maxDelay, delays: make(map[string]time.Duration)}
+	}
+}
+
+// Most subjects with a delay, beyond which arbitrary ones are dropped
+const maxPacedSubjects = 10000
+
+// Delays between requests per subject following the backpressure hints.
+// Subjects without a delay are not kept.
+type pacer struct {
+	maxDelay time.Duration
+
+	mu     sync.Mutex
+	delays map[string]time.Duration
+}
+
+// Delay returns the current delay before each request to the subject, zero
+// if the client does not follow backpressure hints.
+func (c *Client) Delay(subject string) time.Duration {
+	if c.pacer == nil {
+		return 0
+	}
+	return c.pacer.delay(subject)
+}
+
+func (p *pacer) delay(subject string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.delays[subject]
+}
+
+// Wait for the current delay of the subject, until the context is done
+func (p *pacer) wait(ctx context.Context, subject string) error {
+	delay := p.delay(subject)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Update the delay of the subject from the hint of the reply
+func (p *pacer) observe(subject string, reply *nats.Msg) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if load, ok := natsmicromw.BackpressureFromMsg(reply); ok {
+		if _, ok := p.delays[subject]; !ok && len(p.delays) >= maxPacedSubjects {
+			for s := range p.delays {
+				delete(p.delays, s)
+				break
+			}
+		}
+		p.delays[subject] = time.Duration(load * float64(p.maxDelay))
+		return
+	}
+	if delay := p.delays[subject] / 2; delay > 0 {
+		p.delays[subject] = delay
+	} else {
+		delete(p.delays, subject)
+	}
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestBackpressure(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	nm.SetBackpressure(0.5)
+	if err := nm.AddMicroEndpoint("other", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return natsmicromw.NewMicroReply(nil), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := nm.AddMicroEndpoint("pressure", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		if string(req.Data) == `"busy"` {
+			natsmicromw.ReportLoad(req.Context(), 0.5)
+		}
+		return natsmicromw.NewMicroReply(nil), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := New(nc, WithTimeout(time.Second), WithBackpressure(100*time.Millisecond))
+	if _, err := c.Request(context.Background(), "pressure", "busy"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if delay := c.Delay("pressure"); delay != 50*time.Millisecond {
+		t.Fatalf("expected a delay of 50ms, received %v", delay)
+	}
+
+	// Other subjects are not slowed down
+	start := time.Now()
+	if _, err := c.Request(context.Background(), "other", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond || c.Delay("other") != 0 {
+		t.Errorf("other subject was slowed down by %v", elapsed)
+	}
+
+	// The next request waits, and its reply without a hint halves the delay
+	start = time.Now()
+	if _, err := c.Request(context.Background(), "pressure", "idle"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("client did not slow down")
+	}
+	if delay := c.Delay("pressure"); delay != 25*time.Millisecond {
+		t.Errorf("expected a delay of 25ms, received %v", delay)
+	}
+
+	// The delay is bounded by the context
+	c = New(nc, WithBackpressure(time.Minute))
+	if _, err := c.Request(context.Background(), "pressure", "busy"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Request(ctx, "pressure", "idle"); err == nil {
+		t.Errorf("expected the context to expire while waiting")
+	}
+}
@@ -0,0 +1,112 @@
+// Client-side load balancing over discovered service instances.
+
+package client
+
+import (
+	"context"
+	"hash/fnv"
+	"sync/atomic"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// Strategy selects how a Balancer picks an instance.
+type Strategy int
+
+const (
+	// Rotate through all instances
+	RoundRobin Strategy = iota
+	// Pick the instance with the lowest ping round-trip time
+	LeastLatency
+	// Always pick the same instance for the same pin key
+	Pinned
+)
+
+type pinKeyContextKey struct{}
+
+// WithPinKey sets the key used by the `Pinned` strategy for this request.
+func WithPinKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, pinKeyContextKey{}, key)
+}
+
+// PinKeyFromContext returns the pin key set with `WithPinKey`.
+func PinKeyFromContext(ctx context.Context) string {
+	key, ok := ctx.Value(pinKeyContextKey{}).(string)
+	if !ok {
+		return ""
+	}
+	return key
+}
+
+// InstanceSubject returns the instance-specific subject for the shared
+// endpoint subject, if the instance advertises one.
+func (inst Instance) InstanceSubject(subject string) (string, bool) {
+	for _, ep := range inst.Endpoints {
+		if ep.Metadata[natsmicromw.MetadataInstanceOf] == subject {
+			return ep.Subject, true
+		}
+	}
+	return "", false
+}
+
+// Balancer resolves shared endpoint subjects to instance subjects of
+// discovered instances. Services must enable `SetInstanceSubjects` for this
+// to have any effect, otherwise requests fall back to the shared subject.
+type Balancer struct {
+	watcher  *Watcher
+	strategy Strategy
+	next     uint64
+}
+
+// NewBalancer creates a new Balancer over the instances of the watcher.
+func NewBalancer(w *Watcher, strategy Strategy) *Balancer {
+	return &Balancer{
+		watcher:  w,
+		strategy: strategy,
+	}
+}
+
+// Pick selects an instance serving the subject.
+func (b *Balancer) Pick(ctx context.Context, subject string) (Instance, bool) {
+	var candidates []Instance
+	for _, inst := range b.watcher.Instances() {
+		if _, ok := inst.InstanceSubject(subject); ok {
+			candidates = append(candidates, inst)
+		}
+	}
+	if len(candidates) == 0 {
+		return Instance{}, false
+	}
+
+	switch b.strategy {
+	case LeastLatency:
+		best := candidates[0]
+		for _, inst := range candidates[1:] {
+			if inst.RTT < best.RTT {
+				best = inst
+			}
+		}
+		return best, true
+
+	case Pinned:
+		if key := PinKeyFromContext(ctx); key != "" {
+			h := fnv.New32a()
+			h.Write([]byte(key))
+			return candidates[h.Sum32()%uint32(len(candidates))], true
+		}
+	}
+
+	// Round-robin, also used for pinning without a key
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return candidates[n%uint64(len(candidates))], true
+}
+
+// Resolve returns the subject to send the request to.
+func (b *Balancer) Resolve(ctx context.Context, subject string) string {
+	inst, ok := b.Pick(ctx, subject)
+	if !ok {
+		return subject
+	}
+	instSubject, _ := inst.InstanceSubject(subject)
+	return instSubject
+}
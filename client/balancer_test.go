@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestBalancer(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	nm.SetInstanceSubjects(true)
+	err := nm.AddGroup("svc").AddMicroEndpoint("echo", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return natsmicromw.NewMicroReply([]byte(req.Subject)), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w, err := Watch(nc, "TestService", time.Hour, WithDiscoveryWait(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.Stop()
+
+	for _, strategy := range []Strategy{RoundRobin, LeastLatency, Pinned} {
+		c := New(nc, WithBalancer(NewBalancer(w, strategy)))
+		ctx := WithPinKey(context.Background(), "user-1")
+
+		res, err := Call[[]byte](ctx, c, "svc.echo", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := "_INSTANCE." + nm.Info().ID + ".svc.echo"
+		if string(res) != expected {
+			t.Errorf("request was not routed to the instance subject, expected %s, received %s", expected, string(res))
+		}
+	}
+
+	t.Run("fallback to shared subject", func(t *testing.T) {
+		b := NewBalancer(w, RoundRobin)
+		if subject := b.Resolve(context.Background(), "svc.unknown"); subject != "svc.unknown" {
+			t.Errorf("expected the shared subject, got %s", subject)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+/*
+Package `client` provides a small client wrapper for calling natsmicromw
+services. Requests and replies are encoded with a `natsmicromw.Codec` and error
+replies are decoded into `*natsmicromw.HandlerError`.
+*/
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+const (
+	// Used when the context does not define a deadline
+	DefaultTimeout = 5 * time.Second
+)
+
+// Client wraps a NATS connection for calling natsmicromw services.
+type Client struct {
+	nc       *nats.Conn
+	codec    natsmicromw.Codec
+	timeout  time.Duration
+	balancer *Balancer
+	hedger   *hedger
+	// Maximum number of retries honoring the retry-after header
+	retryAfter int
+	pacer      *pacer
+	dedup      *deduplicator
+	// Fetch single attachments of empty replies as their payload
+	inlineAttachments bool
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithCodec sets the codec used for requests and replies.
+func WithCodec(codec natsmicromw.Codec) Option {
+	return func(c *Client) {
+		c.codec = codec
+	}
+}
+
+// WithTimeout sets the request timeout used when the context has no deadline.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithBalancer routes requests to instance subjects picked by the balancer.
+func WithBalancer(b *Balancer) Option {
+	return func(c *Client) {
+		c.balancer = b
+	}
+}
+
+// New creates a new Client, using JSON as the default codec.
+func New(nc *nats.Conn, opts ...Option) *Client {
+	c := &Client{
+		nc:      nc,
+		codec:   natsmicromw.JSONCodec{},
+		timeout: DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Conn returns the underlying NATS connection.
+func (c *Client) Conn() *nats.Conn {
+	return c.nc
+}
+
+// Codec returns the codec used by the client.
+func (c *Client) Codec() natsmicromw.Codec {
+	return c.codec
+}
+
+// RequestMsg sends the message and waits for a reply. If the context has no
+// deadline, the client timeout is used. Error replies are returned as
+// `*natsmicromw.HandlerError`, after retrying if enabled with [WithRetryAfter].
+// With [WithBackpressure], the request first waits for the current delay of
+// its subject.
+// With [WithReplyDeduplication], only one reply is surfaced per call.
+// When called while handling a request with a lineage, e.g. set by the
+// correlation middleware, the correlation and causation headers are added.
+func (c *Client) RequestMsg(ctx context.Context, msg *nats.Msg) (*nats.Msg, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	msg = withLineage(ctx, msg)
+
+	var call *dedupCall
+	if c.dedup != nil {
+		var err error
+		if msg, call, err = c.dedup.start(c.nc, msg); err != nil {
+			return nil, err
+		}
+		defer call.finish()
+	}
+
+	for attempt := 0; ; attempt++ {
+		if c.pacer != nil {
+			if err := c.pacer.wait(ctx, msg.Subject); err != nil {
+				return nil, err
+			}
+		}
+		var reply *nats.Msg
+		var err error
+		if c.hedger != nil {
+			reply, err = c.hedgedRequest(ctx, msg, call)
+		} else {
+			reply, err = c.send(ctx, msg, call)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c.pacer != nil {
+			c.pacer.observe(msg.Subject, reply)
+		}
+
+		handlerErr := natsmicromw.HandlerErrorFromMsg(reply)
+		if handlerErr == nil {
+			if c.inlineAttachments {
+				return c.inlineAttachment(ctx, reply)
+			}
+			return reply, nil
+		}
+		if attempt >= c.retryAfter || !waitRetryAfter(ctx, handlerErr) {
+			return reply, handlerErr
+		}
+	}
+}
+
+// Copy the message with the lineage headers of the request being handled,
+// leaving the message of the caller unchanged
+func withLineage(ctx context.Context, msg *nats.Msg) *nats.Msg {
+	if _, ok := natsmicromw.LineageFromContext(ctx); !ok {
+		return msg
+	}
+	headers := nats.Header{}
+	for k, v := range msg.Header {
+		headers[k] = v
+	}
+	natsmicromw.InjectLineage(ctx, headers)
+	return &nats.Msg{Subject: msg.Subject, Header: headers, Data: msg.Data}
+}
+
+// Send a single request to the priority tier of the context, resolving the
+// subject with the balancer if set, as an attempt of the call if
+// deduplicating replies
+func (c *Client) send(ctx context.Context, msg *nats.Msg, call *dedupCall) (*nats.Msg, error) {
+	subject := prioritySubject(ctx, msg.Subject)
+	if c.balancer != nil {
+		subject = c.balancer.Resolve(ctx, subject)
+	}
+	if subject != msg.Subject {
+		msg = &nats.Msg{
+			Subject: subject,
+			Header:  msg.Header,
+			Data:    msg.Data,
+		}
+	}
+	if call != nil {
+		return call.request(ctx, msg)
+	}
+	return c.nc.RequestMsgWithContext(ctx, msg)
+}
+
+// Request encodes the request and sends it to the subject.
+func (c *Client) Request(ctx context.Context, subject string, req any) (*nats.Msg, error) {
+	msg := nats.NewMsg(subject)
+	if err := natsmicromw.EncodeMsg(c.codec, req, msg); err != nil {
+		return nil, err
+	}
+	return c.RequestMsg(ctx, msg)
+}
+
+// Call sends a request to the subject and decodes the reply into `T`,
+// making a service call look like a typed RPC call.
+func Call[T any](ctx context.Context, c *Client, subject string, req any) (T, error) {
+	var res T
+	reply, err := c.Request(ctx, subject, req)
+	if err != nil {
+		return res, err
+	}
+
+	if err := natsmicromw.DecodeMsg(c.codec, reply, &res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// BatchResult is the result of one item of a batch call.
+type BatchResult[T any] struct {
+	Value T
+	// Error of the item, a `*natsmicromw.HandlerError`
+	Err error
+}
+
+// CallBatch sends a request to a batch endpoint and decodes the result of
+// each item into `T`. The overall status tells whether some or all items
+// failed.
+func CallBatch[T any](ctx context.Context, c *Client, subject string, req any) ([]BatchResult[T], natsmicromw.BatchStatus, error) {
+	reply, err := c.Request(ctx, subject, req)
+	if err != nil {
+		return nil, "", err
+	}
+	batch, err := natsmicromw.BatchReplyFromMsg(reply)
+	if err != nil {
+		return nil, "", err
+	}
+
+	results := make([]BatchResult[T], len(batch.Items))
+	for i := range batch.Items {
+		results[i].Err = batch.Decode(i, &results[i].Value)
+	}
+	return results, batch.Status, nil
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func runServer(opts *server.Options) (*server.Server, error) {
+	s, err := server.NewServer(opts)
+	if err != nil || s == nil {
+		return nil, err
+	}
+
+	// Run server in Go routine.
+	go s.Start()
+
+	// Wait for accept loop(s) to be started
+	if !s.ReadyForConnections(10 * time.Second) {
+		return nil, errors.New("Unable to start NATS Server in Go Routine")
+	}
+
+	return s, nil
+}
+
+func getServerServiceAndConn(t *testing.T) (*server.Server, *natsmicromw.Service, *nats.Conn) {
+	// Create test server
+	opts := &server.Options{Host: "localhost", Port: server.RANDOM_PORT, NoSigs: true}
+	s, err := runServer(opts)
+	if err != nil {
+		t.Fatalf("Could not start NATS server: %v", err)
+	}
+
+	// Create router and connect to test server
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	nm, err := natsmicromw.AddMicroService(nc, micro.Config{
+		Name:    "TestService",
+		Version: "1.0.0",
+	})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+	return s, nm, nc
+}
+
+type greetRequest struct {
+	Name string `json:"name"`
+}
+
+type greetReply struct {
+	Greeting string `json:"greeting"`
+}
+
+func TestCall(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	err := nm.AddMicroEndpoint("greet", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		var in greetRequest
+		if err := (natsmicromw.JSONCodec{}).Decode(req.Data, &in); err != nil {
+			return nil, err
+		}
+		if in.Name == "" {
+			return nil, natsmicromw.NewValidationError("invalid request").WithField("name", "required")
+		}
+		data, _ := (natsmicromw.JSONCodec{}).Encode(greetReply{Greeting: "Hello " + in.Name})
+		return natsmicromw.NewMicroReply(data), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := New(nc, WithTimeout(time.Second))
+
+	t.Run("typed reply", func(t *testing.T) {
+		res, err := Call[greetReply](context.Background(), c, "greet", greetRequest{Name: "World"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if res.Greeting != "Hello World" {
+			t.Errorf("responses do not match, expected %s, received %s", "Hello World", res.Greeting)
+		}
+	})
+
+	t.Run("error reply", func(t *testing.T) {
+		_, err := Call[greetReply](context.Background(), c, "greet", greetRequest{})
+		var handlerErr *natsmicromw.HandlerError
+		if !errors.As(err, &handlerErr) {
+			t.Fatalf("expected a HandlerError, got %v", err)
+		}
+		if handlerErr.Code != "400" || len(handlerErr.Fields) != 1 {
+			t.Errorf("unexpected error: %+v", handlerErr)
+		}
+	})
+}
+
+func TestCallBatch(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	err := nm.AddMicroEndpoint("greet-batch", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		var reqs []greetRequest
+		if err := json.Unmarshal(req.Data, &reqs); err != nil {
+			return nil, err
+		}
+		b := natsmicromw.NewBatchReply(req.Context(), len(reqs))
+		for i, r := range reqs {
+			if r.Name == "" {
+				b.Fail(i, natsmicromw.NewValidationError("name is required"))
+				continue
+			}
+			b.Succeed(i, greetReply{Greeting: "Hello, " + r.Name + "!"})
+		}
+		return b.MicroReply()
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := New(nc)
+	results, status, err := CallBatch[greetReply](context.Background(), c, "greet-batch", []greetRequest{{Name: "world"}, {}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != natsmicromw.BatchPartial || len(results) != 2 {
+		t.Fatalf("unexpected results: %s %+v", status, results)
+	}
+	if results[0].Err != nil || results[0].Value.Greeting != "Hello, world!" {
+		t.Errorf("unexpected first result: %+v", results[0])
+	}
+	if natsmicromw.ErrorCode(results[1].Err) != natsmicromw.CodeBadRequest {
+		t.Errorf("unexpected second result: %+v", results[1])
+	}
+}
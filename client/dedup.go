@@ -0,0 +1,157 @@
+// Deduplication of the replies to retried and hedged requests.
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// Request header identifying a logical call, the same on all its retries
+	// and hedges
+	HeaderIdempotencyKey = "idempotency-key"
+)
+
+// WithReplyDeduplication makes the retries and hedges of a call share its
+// idempotency key, so services can deduplicate the work, and surfaces only
+// one reply per logical call. The idempotency key of the request is kept if
+// set, and generated otherwise. Each attempt gets its own reply subject, so
+// a late reply to an earlier attempt is never taken for the reply of a
+// later one, and the replies are dropped once the call returns.
+func WithReplyDeduplication() Option {
+	return func(c *Client) {
+		c.dedup = &deduplicator{}
+	}
+}
+
+type deduplicator struct {
+	late atomic.Uint64
+}
+
+// LateReplies returns the number of replies dropped because their attempt
+// was no longer waiting, e.g. the reply of a losing hedge received before
+// the call returned. Replies received after the call returned are not
+// delivered at all. Always zero without [WithReplyDeduplication].
+func (c *Client) LateReplies() uint64 {
+	if c.dedup == nil {
+		return 0
+	}
+	return c.dedup.late.Load()
+}
+
+// Replies of a logical call, received on its own inbox with a reply subject
+// per attempt, "<inbox>.<attempt>"
+type dedupCall struct {
+	d     *deduplicator
+	nc    *nats.Conn
+	inbox string
+	sub   *nats.Subscription
+
+	mu       sync.Mutex
+	attempts int
+	waiting  map[string]chan *nats.Msg
+}
+
+// Start a call, returning the message with its idempotency key
+func (d *deduplicator) start(nc *nats.Conn, msg *nats.Msg) (*nats.Msg, *dedupCall, error) {
+	if msg.Header.Get(HeaderIdempotencyKey) == "" {
+		key, err := newIdempotencyKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		headers := nats.Header{}
+		for k, v := range msg.Header {
+			headers[k] = v
+		}
+		headers.Set(HeaderIdempotencyKey, key)
+		msg = &nats.Msg{Subject: msg.Subject, Header: headers, Data: msg.Data}
+	}
+
+	call := &dedupCall{
+		d:       d,
+		nc:      nc,
+		inbox:   nc.NewInbox(),
+		waiting: make(map[string]chan *nats.Msg),
+	}
+	sub, err := nc.Subscribe(call.inbox+".*", call.receive)
+	if err != nil {
+		return nil, nil, err
+	}
+	call.sub = sub
+	return msg, call, nil
+}
+
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Pass the reply to its attempt, dropping it if the attempt is no longer
+// waiting or already has a reply
+func (call *dedupCall) receive(reply *nats.Msg) {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+	ch, ok := call.waiting[reply.Subject]
+	if !ok {
+		call.d.late.Add(1)
+		return
+	}
+	delete(call.waiting, reply.Subject)
+	ch <- reply
+}
+
+// Send an attempt of the call and wait for its reply
+func (call *dedupCall) request(ctx context.Context, msg *nats.Msg) (*nats.Msg, error) {
+	// Buffered, so the reply is passed without blocking
+	ch := make(chan *nats.Msg, 1)
+	call.mu.Lock()
+	call.attempts++
+	subject := call.inbox + "." + strconv.Itoa(call.attempts)
+	call.waiting[subject] = ch
+	call.mu.Unlock()
+	defer func() {
+		call.mu.Lock()
+		defer call.mu.Unlock()
+		delete(call.waiting, subject)
+		// A reply passed after the attempt stopped waiting
+		select {
+		case <-ch:
+			call.d.late.Add(1)
+		default:
+		}
+	}()
+
+	attempt := &nats.Msg{Subject: msg.Subject, Reply: subject, Header: msg.Header, Data: msg.Data}
+	if err := call.nc.PublishMsg(attempt); err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-ch:
+		if isNoResponders(reply) {
+			return nil, nats.ErrNoResponders
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Status message of the server when nobody subscribes to the subject
+func isNoResponders(msg *nats.Msg) bool {
+	return len(msg.Data) == 0 && msg.Header.Get("Status") == "503"
+}
+
+// Stop receiving the replies of the call
+func (call *dedupCall) finish() {
+	_ = call.sub.Unsubscribe()
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestReplyDeduplication(t *testing.T) {
+	s, _, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	// The first request is slow, all later ones are answered immediately
+	var count int32
+	var mu sync.Mutex
+	keys := map[string]bool{}
+	_, err := nc.Subscribe("slow", func(msg *nats.Msg) {
+		n := atomic.AddInt32(&count, 1)
+		mu.Lock()
+		keys[msg.Header.Get(HeaderIdempotencyKey)] = true
+		mu.Unlock()
+		go func() {
+			if n == 1 {
+				time.Sleep(100 * time.Millisecond)
+			}
+			msg.Respond([]byte("done"))
+		}()
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	subscriptions := nc.NumSubscriptions()
+	c := New(nc, WithReplyDeduplication(), WithHedging(HedgePolicy{
+		Percentile: 0.95,
+		Delay:      20 * time.Millisecond,
+		Budget:     1,
+	}))
+
+	res, err := Call[[]byte](context.Background(), c, "slow", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(res) != "done" {
+		t.Errorf("responses do not match, expected %s, received %s", "done", string(res))
+	}
+	if n := atomic.LoadInt32(&count); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+	mu.Lock()
+	if len(keys) != 1 || keys[""] {
+		t.Errorf("expected the hedges to share an idempotency key, received %v", keys)
+	}
+	mu.Unlock()
+
+	// The inbox of the call is unsubscribed once it returned
+	if n := nc.NumSubscriptions(); n != subscriptions {
+		t.Errorf("expected %d subscriptions, got %d", subscriptions, n)
+	}
+
+	t.Run("key kept", func(t *testing.T) {
+		msg := nats.NewMsg("slow")
+		msg.Header.Set(HeaderIdempotencyKey, "order-42")
+		if _, err := c.RequestMsg(context.Background(), msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !keys["order-42"] {
+			t.Errorf("expected the idempotency key of the request, received %v", keys)
+		}
+	})
+
+	t.Run("stale reply", func(t *testing.T) {
+		// The first attempt is rejected, then gets a stale reply while the
+		// retry is waiting for its own
+		var attempts int32
+		_, err := nc.Subscribe("retried", func(msg *nats.Msg) {
+			if atomic.AddInt32(&attempts, 1) == 1 {
+				rejected := nats.NewMsg(msg.Reply)
+				rejected.Header.Set(micro.ErrorCodeHeader, "503")
+				rejected.Header.Set(micro.ErrorHeader, "busy")
+				rejected.Header.Set(natsmicromw.HeaderRetryAfter, "10")
+				msg.RespondMsg(rejected)
+				go func() {
+					time.Sleep(30 * time.Millisecond)
+					msg.Respond([]byte("stale"))
+				}()
+				return
+			}
+			go func() {
+				time.Sleep(80 * time.Millisecond)
+				msg.Respond([]byte("fresh"))
+			}()
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		c := New(nc, WithReplyDeduplication(), WithRetryAfter(1))
+		reply, err := c.RequestMsg(context.Background(), nats.NewMsg("retried"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(reply.Data) != "fresh" {
+			t.Errorf("expected the reply of the retry, received %q", reply.Data)
+		}
+		if c.LateReplies() != 1 {
+			t.Errorf("expected the stale reply to be dropped, got %d late replies", c.LateReplies())
+		}
+	})
+
+	t.Run("no responders", func(t *testing.T) {
+		if _, err := c.Request(context.Background(), "missing", nil); err != nats.ErrNoResponders {
+			t.Errorf("expected no responders, received %v", err)
+		}
+	})
+}
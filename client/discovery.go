@@ -0,0 +1,249 @@
+// Service discovery built on the `$SRV` protocol of the `micro` package.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// How long to wait for replies from service instances
+	DefaultDiscoveryWait = 500 * time.Millisecond
+)
+
+// Instance describes a single running service instance.
+type Instance struct {
+	ID        string
+	Name      string
+	Version   string
+	Metadata  map[string]string
+	Endpoints []micro.EndpointInfo
+
+	// Round-trip time of the ping request
+	RTT time.Duration
+
+	// Only set if stats were requested
+	Stats *micro.Stats
+}
+
+type discoverOpts struct {
+	wait     time.Duration
+	stats    bool
+	onUpdate func([]Instance, error)
+}
+
+// DiscoverOpt configures discovery.
+type DiscoverOpt func(*discoverOpts)
+
+// WithDiscoveryWait sets how long to wait for replies from instances.
+func WithDiscoveryWait(wait time.Duration) DiscoverOpt {
+	return func(o *discoverOpts) {
+		o.wait = wait
+	}
+}
+
+// WithStats also fetches statistics for each instance.
+func WithStats() DiscoverOpt {
+	return func(o *discoverOpts) {
+		o.stats = true
+	}
+}
+
+// WithUpdateHandler sets a function called after each refresh of a Watcher.
+func WithUpdateHandler(fn func([]Instance, error)) DiscoverOpt {
+	return func(o *discoverOpts) {
+		o.onUpdate = fn
+	}
+}
+
+func getDiscoverOpts(opts []DiscoverOpt) *discoverOpts {
+	o := &discoverOpts{wait: DefaultDiscoveryWait}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Send a request to all instances and collect replies until the wait elapses
+func collect(nc *nats.Conn, subject string, wait time.Duration, fn func(msg *nats.Msg, rtt time.Duration)) error {
+	inbox := nc.NewRespInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	start := time.Now()
+	if err := nc.PublishRequest(subject, inbox, nil); err != nil {
+		return err
+	}
+
+	deadline := start.Add(wait)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		msg, err := sub.NextMsg(remaining)
+		if errors.Is(err, nats.ErrTimeout) || errors.Is(err, nats.ErrNoResponders) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fn(msg, time.Since(start))
+	}
+}
+
+// Discover finds all running instances of the named service.
+func Discover(nc *nats.Conn, name string, opts ...DiscoverOpt) ([]Instance, error) {
+	o := getDiscoverOpts(opts)
+	return discover(nc, name, o)
+}
+
+func discover(nc *nats.Conn, name string, o *discoverOpts) ([]Instance, error) {
+	instances := make(map[string]*Instance)
+	get := func(id string) *Instance {
+		inst, ok := instances[id]
+		if !ok {
+			inst = &Instance{ID: id}
+			instances[id] = inst
+		}
+		return inst
+	}
+
+	subject, err := micro.ControlSubject(micro.PingVerb, name, "")
+	if err != nil {
+		return nil, err
+	}
+	err = collect(nc, subject, o.wait, func(msg *nats.Msg, rtt time.Duration) {
+		var ping micro.Ping
+		if err := json.Unmarshal(msg.Data, &ping); err != nil || ping.ID == "" {
+			return
+		}
+		get(ping.ID).RTT = rtt
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err = micro.ControlSubject(micro.InfoVerb, name, "")
+	if err != nil {
+		return nil, err
+	}
+	err = collect(nc, subject, o.wait, func(msg *nats.Msg, rtt time.Duration) {
+		var info micro.Info
+		if err := json.Unmarshal(msg.Data, &info); err != nil || info.ID == "" {
+			return
+		}
+		inst := get(info.ID)
+		inst.Name = info.Name
+		inst.Version = info.Version
+		inst.Metadata = info.Metadata
+		inst.Endpoints = info.Endpoints
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if o.stats {
+		subject, err = micro.ControlSubject(micro.StatsVerb, name, "")
+		if err != nil {
+			return nil, err
+		}
+		err = collect(nc, subject, o.wait, func(msg *nats.Msg, rtt time.Duration) {
+			var stats micro.Stats
+			if err := json.Unmarshal(msg.Data, &stats); err != nil || stats.ID == "" {
+				return
+			}
+			get(stats.ID).Stats = &stats
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := make([]Instance, 0, len(instances))
+	for _, inst := range instances {
+		res = append(res, *inst)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+// Watcher keeps an up-to-date list of service instances.
+type Watcher struct {
+	nc   *nats.Conn
+	name string
+	opts *discoverOpts
+
+	mu        sync.RWMutex
+	instances []Instance
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// Watch discovers the named service and keeps refreshing the instance list
+// at the given interval until `Stop` is called.
+func Watch(nc *nats.Conn, name string, interval time.Duration, opts ...DiscoverOpt) (*Watcher, error) {
+	w := &Watcher{
+		nc:   nc,
+		name: name,
+		opts: getDiscoverOpts(opts),
+		stop: make(chan struct{}),
+	}
+	if err := w.refresh(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.refresh()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+func (w *Watcher) refresh() error {
+	instances, err := discover(w.nc, w.name, w.opts)
+	if err == nil {
+		w.mu.Lock()
+		w.instances = instances
+		w.mu.Unlock()
+	}
+	if w.opts.onUpdate != nil {
+		w.opts.onUpdate(instances, err)
+	}
+	return err
+}
+
+// Instances returns the latest known instances.
+func (w *Watcher) Instances() []Instance {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.instances
+}
+
+// Stop stops refreshing the instance list.
+func (w *Watcher) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestDiscover(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	err := nm.AddMicroEndpoint("echo", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return natsmicromw.NewMicroReply(req.Data), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("discover instances", func(t *testing.T) {
+		instances, err := Discover(nc, "TestService", WithDiscoveryWait(100*time.Millisecond), WithStats())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(instances) != 1 {
+			t.Fatalf("expected 1 instance, got %d", len(instances))
+		}
+		inst := instances[0]
+		if inst.ID != nm.Info().ID || inst.Version != "1.0.0" {
+			t.Errorf("unexpected instance: %+v", inst)
+		}
+		if len(inst.Endpoints) != 1 || inst.Endpoints[0].Subject != "echo" {
+			t.Errorf("unexpected endpoints: %+v", inst.Endpoints)
+		}
+		if inst.Stats == nil {
+			t.Errorf("expected stats to be fetched")
+		}
+	})
+
+	t.Run("unknown service", func(t *testing.T) {
+		instances, err := Discover(nc, "Unknown", WithDiscoveryWait(50*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(instances) != 0 {
+			t.Errorf("expected no instances, got %d", len(instances))
+		}
+	})
+
+	t.Run("watch instances", func(t *testing.T) {
+		w, err := Watch(nc, "TestService", time.Hour, WithDiscoveryWait(50*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer w.Stop()
+		if len(w.Instances()) != 1 {
+			t.Errorf("expected 1 instance, got %d", len(w.Instances()))
+		}
+	})
+}
@@ -0,0 +1,144 @@
+// Hedged requests for cutting tail latency.
+
+package client
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// Number of recent latencies used for the hedging delay
+	hedgeWindowSize = 100
+	// Minimum number of samples before the percentile is used
+	hedgeMinSamples = 10
+)
+
+// HedgePolicy configures hedged requests. If no reply arrives within the
+// given latency percentile, another request is sent and the first reply wins.
+type HedgePolicy struct {
+	// Latency percentile used as the hedging delay, e.g. 0.95
+	Percentile float64
+	// Delay used until enough latency samples are collected
+	Delay time.Duration
+	// Maximum number of extra requests per call
+	MaxHedges int
+	// Maximum fraction of calls that may be hedged, e.g. 0.1
+	Budget float64
+}
+
+type hedger struct {
+	policy HedgePolicy
+
+	mu      sync.Mutex
+	samples []time.Duration
+	pos     int
+
+	calls  uint64
+	hedges uint64
+}
+
+// WithHedging enables hedged requests with the given policy.
+func WithHedging(policy HedgePolicy) Option {
+	return func(c *Client) {
+		if policy.MaxHedges <= 0 {
+			policy.MaxHedges = 1
+		}
+		c.hedger = &hedger{policy: policy}
+	}
+}
+
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < hedgeWindowSize {
+		h.samples = append(h.samples, d)
+		return
+	}
+	h.samples[h.pos] = d
+	h.pos = (h.pos + 1) % hedgeWindowSize
+}
+
+// Current hedging delay based on recent latencies
+func (h *hedger) delay() time.Duration {
+	h.mu.Lock()
+	if len(h.samples) < hedgeMinSamples {
+		h.mu.Unlock()
+		return h.policy.Delay
+	}
+	sorted := append([]time.Duration(nil), h.samples...)
+	h.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	idx := int(float64(len(sorted)-1) * h.policy.Percentile)
+	return sorted[idx]
+}
+
+// Check whether another hedge fits into the budget
+func (h *hedger) allow() bool {
+	calls := atomic.LoadUint64(&h.calls)
+	hedges := atomic.LoadUint64(&h.hedges)
+	if float64(hedges+1) > h.policy.Budget*float64(calls) {
+		return false
+	}
+	atomic.AddUint64(&h.hedges, 1)
+	return true
+}
+
+// Send the request and hedge it if no reply arrives in time
+func (c *Client) hedgedRequest(ctx context.Context, msg *nats.Msg, call *dedupCall) (*nats.Msg, error) {
+	h := c.hedger
+	atomic.AddUint64(&h.calls, 1)
+
+	// Cancel the remaining requests once a reply is received
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		reply *nats.Msg
+		err   error
+	}
+	results := make(chan result, h.policy.MaxHedges+1)
+	send := func() {
+		go func() {
+			reply, err := c.send(ctx, msg, call)
+			results <- result{reply, err}
+		}()
+	}
+
+	start := time.Now()
+	send()
+	inFlight, hedges := 1, 0
+
+	timer := time.NewTimer(h.delay())
+	defer timer.Stop()
+
+	for {
+		select {
+		case res := <-results:
+			inFlight--
+			if res.err == nil {
+				h.observe(time.Since(start))
+				return res.reply, nil
+			}
+			if inFlight == 0 {
+				return nil, res.err
+			}
+
+		case <-timer.C:
+			if hedges < h.policy.MaxHedges && h.allow() {
+				hedges++
+				inFlight++
+				send()
+				timer.Reset(h.delay())
+			}
+		}
+	}
+}
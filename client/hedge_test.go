@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestHedgedRequest(t *testing.T) {
+	s, _, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	// The first request is slow, all later ones are answered immediately
+	var count int32
+	_, err := nc.Subscribe("slow", func(msg *nats.Msg) {
+		n := atomic.AddInt32(&count, 1)
+		go func() {
+			if n == 1 {
+				time.Sleep(500 * time.Millisecond)
+			}
+			msg.Respond([]byte("done"))
+		}()
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := New(nc, WithHedging(HedgePolicy{
+		Percentile: 0.95,
+		Delay:      20 * time.Millisecond,
+		Budget:     1,
+	}))
+
+	start := time.Now()
+	res, err := Call[[]byte](context.Background(), c, "slow", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(res) != "done" {
+		t.Errorf("responses do not match, expected %s, received %s", "done", string(res))
+	}
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("request was not hedged, took %s", elapsed)
+	}
+	if n := atomic.LoadInt32(&count); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
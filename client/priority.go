@@ -0,0 +1,27 @@
+package client
+
+import (
+	"context"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+type priorityContextKey struct{}
+
+// WithPriority sends requests made with the context to the given priority
+// tier of the endpoint, e.g. "svc.orders" to "svc.high.orders", see
+// `natsmicromw.Service.WithPriorityTiers`.
+func WithPriority(ctx context.Context, tier string) context.Context {
+	return context.WithValue(ctx, priorityContextKey{}, tier)
+}
+
+// PriorityFromContext returns the priority tier set with `WithPriority`.
+func PriorityFromContext(ctx context.Context) string {
+	tier, _ := ctx.Value(priorityContextKey{}).(string)
+	return tier
+}
+
+// Return the subject of the priority tier of the request, if any
+func prioritySubject(ctx context.Context, subject string) string {
+	return natsmicromw.PrioritySubject(subject, PriorityFromContext(ctx))
+}
@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestWithPriority(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	tiered := nm.WithPriorityTiers(natsmicromw.PriorityTier{}, natsmicromw.PriorityTier{Name: "high"})
+	err := tiered.AddGroup("svc").AddMicroEndpoint("greet", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		tier := natsmicromw.PriorityTierFromContext(req.Context())
+		return natsmicromw.NewMicroReplyWithCodec(natsmicromw.JSONCodec{}, greetReply{Greeting: "Hello from " + tier + "!"})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := New(nc)
+	tests := []struct {
+		tier     string
+		expected string
+	}{
+		{"", "Hello from !"},
+		{"high", "Hello from high!"},
+	}
+	for _, tt := range tests {
+		reply, err := Call[greetReply](WithPriority(context.Background(), tt.tier), c, "svc.greet", greetRequest{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if reply.Greeting != tt.expected {
+			t.Errorf("expected %q, received %q", tt.expected, reply.Greeting)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Cooperative retries of rejected requests.
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// WithRetryAfter retries requests rejected with a `retry-after-ms` header,
+// e.g. by a limiter, up to the given number of times. The client waits for
+// the requested time before retrying, so that clients back off together
+// instead of hammering an overloaded service. If the wait would exceed the
+// deadline of the context, the error is returned right away.
+func WithRetryAfter(maxRetries int) Option {
+	return func(c *Client) {
+		c.retryAfter = maxRetries
+	}
+}
+
+// Wait for the time requested by the error, returns false if the request
+// should not be retried
+func waitRetryAfter(ctx context.Context, handlerErr *natsmicromw.HandlerError) bool {
+	delay, ok := handlerErr.RetryAfter()
+	if !ok {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestRetryAfter(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	// Reject the first request
+	var calls atomic.Int32
+	err := nm.AddMicroEndpoint("limited", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		if calls.Add(1) == 1 {
+			return nil, natsmicromw.Errorf(natsmicromw.CodeTooManyRequests, "slow down").WithRetryAfter(20 * time.Millisecond)
+		}
+		return natsmicromw.NewMicroReply([]byte("ok")), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("without retries", func(t *testing.T) {
+		c := New(nc, WithTimeout(time.Second))
+		_, err := c.Request(context.Background(), "limited", nil)
+		var handlerErr *natsmicromw.HandlerError
+		if !errors.As(err, &handlerErr) {
+			t.Fatalf("expected a HandlerError, got %v", err)
+		}
+		if delay, ok := handlerErr.RetryAfter(); !ok || delay != 20*time.Millisecond {
+			t.Errorf("retry-after does not match, expected %v, received %v", 20*time.Millisecond, delay)
+		}
+	})
+
+	t.Run("with retries", func(t *testing.T) {
+		calls.Store(0)
+		c := New(nc, WithTimeout(time.Second), WithRetryAfter(1))
+		start := time.Now()
+		reply, err := c.Request(context.Background(), "limited", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(reply.Data) != "ok" || calls.Load() != 2 {
+			t.Errorf("request was not retried, calls: %d", calls.Load())
+		}
+		if time.Since(start) < 20*time.Millisecond {
+			t.Errorf("client did not wait before retrying")
+		}
+	})
+}
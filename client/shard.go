@@ -0,0 +1,21 @@
+package client
+
+import (
+	"github.com/Karimerto/natsmicromw"
+)
+
+// Shards computes the subjects of a sharded group, see
+// `natsmicromw.Service.ShardedGroup`. Clients and services must agree on
+// the number of shards.
+//
+//	orders := client.Shards{Group: "orders", Count: 16}
+//	res, err := client.Call[Order](ctx, c, orders.Subject(userID, "update"), req)
+type Shards struct {
+	Group string
+	Count int
+}
+
+// Subject returns the subject of the endpoint subject in the shard of the key.
+func (s Shards) Subject(key, subject string) string {
+	return natsmicromw.ShardSubject(s.Group, natsmicromw.Shard(key, s.Count), subject)
+}
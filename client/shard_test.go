@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Karimerto/natsmicromw"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestShards(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	key := func(req micro.Request) string { return req.Headers().Get("user") }
+	err := nm.ShardedGroup("users", 4, key).AddMicroEndpoint("greet", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		shard, _ := natsmicromw.ShardFromContext(req.Context())
+		return natsmicromw.NewMicroReplyWithCodec(natsmicromw.JSONCodec{}, greetReply{Greeting: fmt.Sprintf("Hello from %d!", shard)})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := New(nc)
+	users := Shards{Group: "users", Count: 4}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		msg := nats.NewMsg(users.Subject(user, "greet"))
+		msg.Header.Set("user", user)
+		if err := natsmicromw.EncodeMsg(c.Codec(), greetRequest{}, msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		reply, err := c.RequestMsg(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var res greetReply
+		if err := natsmicromw.DecodeMsg(c.Codec(), reply, &res); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := fmt.Sprintf("Hello from %d!", natsmicromw.Shard(user, 4)); res.Greeting != expected {
+			t.Errorf("expected %q, received %q", expected, res.Greeting)
+		}
+	}
+}
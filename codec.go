@@ -0,0 +1,126 @@
+// The package introduces a `Codec` type for encoding and decoding request and
+// reply payloads, shared by services and clients.
+
+package natsmicromw
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// Header describing the encoding of the payload
+	HeaderContentType string = "content-type"
+)
+
+// Codec encodes and decodes message payloads.
+type Codec interface {
+	Encode(v any) ([]byte, error)
+	Decode(data []byte, v any) error
+	ContentType() string
+}
+
+// HeaderCodec is implemented by codecs that also use message headers, e.g. to
+// carry a schema identifier next to the payload.
+type HeaderCodec interface {
+	Codec
+	EncodeWithHeaders(v any, headers nats.Header) ([]byte, error)
+	DecodeWithHeaders(data []byte, headers nats.Header, v any) error
+}
+
+// StreamCodec is implemented by codecs that can decode incrementally from a
+// reader, used for streamed request payloads.
+type StreamCodec interface {
+	Codec
+	DecodeReader(r io.Reader, v any) error
+}
+
+// JSONCodec is the default codec, using `encoding/json`.
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Decode(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (JSONCodec) DecodeReader(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+func (JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+// EncodeWith encodes a value with the given codec. Raw `[]byte` values are
+// passed through without encoding.
+func EncodeWith(codec Codec, v any) ([]byte, error) {
+	if data, ok := v.([]byte); ok {
+		return data, nil
+	}
+	return codec.Encode(v)
+}
+
+// DecodeWith decodes data into the value with the given codec. A `*[]byte`
+// target receives the raw data without decoding.
+func DecodeWith(codec Codec, data []byte, v any) error {
+	if b, ok := v.(*[]byte); ok {
+		*b = data
+		return nil
+	}
+	return codec.Decode(data, v)
+}
+
+// EncodeMsg encodes a value into the message data, also setting the content
+// type and any codec-specific headers.
+func EncodeMsg(codec Codec, v any, msg *nats.Msg) error {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	var data []byte
+	var err error
+	hc, ok := codec.(HeaderCodec)
+	if _, raw := v.([]byte); ok && !raw {
+		data, err = hc.EncodeWithHeaders(v, msg.Header)
+	} else {
+		data, err = EncodeWith(codec, v)
+	}
+	if err != nil {
+		return err
+	}
+
+	msg.Data = data
+	msg.Header.Set(HeaderContentType, codec.ContentType())
+	return nil
+}
+
+// DecodeMsg decodes the message data into the value, passing the headers to
+// codecs that use them.
+func DecodeMsg(codec Codec, msg *nats.Msg, v any) error {
+	hc, ok := codec.(HeaderCodec)
+	if _, raw := v.(*[]byte); ok && !raw {
+		return hc.DecodeWithHeaders(msg.Data, msg.Header, v)
+	}
+	return DecodeWith(codec, msg.Data, v)
+}
+
+type codecContextKey struct{}
+
+func withCodec(ctx context.Context, codec Codec) context.Context {
+	return context.WithValue(ctx, codecContextKey{}, codec)
+}
+
+// CodecFromContext returns the default codec of the service handling the
+// request, `JSONCodec` unless set with `SetDefaultCodec`.
+func CodecFromContext(ctx context.Context) Codec {
+	if codec, ok := ctx.Value(codecContextKey{}).(Codec); ok {
+		return codec
+	}
+	return JSONCodec{}
+}
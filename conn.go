@@ -0,0 +1,106 @@
+package natsmicromw
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Timeout of requests made through [Conn] when the context has no deadline
+const DefaultRequestTimeout = 5 * time.Second
+
+type connContextKey struct{}
+
+func withConn(ctx context.Context, nc *nats.Conn) context.Context {
+	if nc == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, connContextKey{}, nc)
+}
+
+type outgoingHeadersContextKey struct{}
+
+// WithOutgoingHeaders returns a context whose [Conn] calls the function to
+// add headers to the messages it sends, e.g. the request ID or the trace
+// context set by middleware. Functions run in the order they were added,
+// after the lineage headers are set.
+func WithOutgoingHeaders(ctx context.Context, inject func(headers nats.Header)) context.Context {
+	parent, _ := ctx.Value(outgoingHeadersContextKey{}).([]func(nats.Header))
+	// Copy, so that sibling contexts do not share the slice
+	injectors := append(parent[:len(parent):len(parent)], inject)
+	return context.WithValue(ctx, outgoingHeadersContextKey{}, injectors)
+}
+
+// Conn is the connection of the service, scoped to the context of a request
+// being handled. Requests made through it are bounded by the remaining
+// deadline of the context, so handlers do not need their own timeouts, and
+// carry the lineage headers of the request, see [InjectLineage], and the
+// headers added with [WithOutgoingHeaders], e.g. by the request ID and
+// tracing middleware.
+type Conn struct {
+	nc  *nats.Conn
+	ctx context.Context
+}
+
+// ConnFromContext returns the connection of the service handling the
+// request of the context, scoped to the context, or nil outside of context
+// and Micro handlers and their middleware.
+func ConnFromContext(ctx context.Context) *Conn {
+	nc, ok := ctx.Value(connContextKey{}).(*nats.Conn)
+	if !ok {
+		return nil
+	}
+	return &Conn{nc, ctx}
+}
+
+// NatsConn returns the underlying connection.
+func (c *Conn) NatsConn() *nats.Conn {
+	return c.nc
+}
+
+// Copy the message with the lineage and outgoing headers, leaving the
+// message of the caller unchanged
+func (c *Conn) withHeaders(msg *nats.Msg) *nats.Msg {
+	headers := nats.Header{}
+	for k, v := range msg.Header {
+		headers[k] = v
+	}
+	InjectLineage(c.ctx, headers)
+	injectors, _ := c.ctx.Value(outgoingHeadersContextKey{}).([]func(nats.Header))
+	for _, inject := range injectors {
+		inject(headers)
+	}
+	if len(headers) == 0 {
+		headers = nil
+	}
+	return &nats.Msg{Subject: msg.Subject, Reply: msg.Reply, Header: headers, Data: msg.Data}
+}
+
+// RequestMsg sends the message and waits for a reply until the deadline of
+// the context, or [DefaultRequestTimeout] if it has none.
+func (c *Conn) RequestMsg(msg *nats.Msg) (*nats.Msg, error) {
+	ctx := c.ctx
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+	return c.nc.RequestMsgWithContext(ctx, c.withHeaders(msg))
+}
+
+// Request sends the data to the subject and waits for a reply, see
+// [Conn.RequestMsg].
+func (c *Conn) Request(subject string, data []byte) (*nats.Msg, error) {
+	return c.RequestMsg(&nats.Msg{Subject: subject, Data: data})
+}
+
+// PublishMsg publishes the message with the lineage headers.
+func (c *Conn) PublishMsg(msg *nats.Msg) error {
+	return c.nc.PublishMsg(c.withHeaders(msg))
+}
+
+// Publish publishes the data to the subject with the lineage headers.
+func (c *Conn) Publish(subject string, data []byte) error {
+	return c.PublishMsg(&nats.Msg{Subject: subject, Data: data})
+}
@@ -0,0 +1,80 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestConnFromContext(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	if ConnFromContext(context.Background()) != nil {
+		t.Errorf("expected no connection outside of handlers")
+	}
+
+	if err := nm.AddMicroEndpoint("back", func(req *MicroRequest) (*MicroReply, error) {
+		if string(req.Data) == "slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return NewMicroReply([]byte(req.HeaderGet(HeaderCausationID) + req.HeaderGet("outgoing"))), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddContextEndpoint("front", func(req *Request) error {
+		ctx := WithLineage(req.Context(), Lineage{CorrelationID: "corr-1", MessageID: "msg-1"})
+		ctx = WithOutgoingHeaders(ctx, func(headers nats.Header) {
+			headers.Set("outgoing", "-first")
+		})
+		ctx = WithOutgoingHeaders(ctx, func(headers nats.Header) {
+			headers.Add("outgoing", "ignored")
+		})
+		conn := ConnFromContext(ctx)
+		if conn == nil {
+			return errors.New("no connection")
+		}
+		if string(req.Data()) == "slow" {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := ConnFromContext(ctx).Request("back", []byte("slow"))
+			if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 150*time.Millisecond {
+				return Errorf(CodeInternal, "expected the context deadline, got %v", err)
+			}
+			return req.Respond([]byte("deadline"))
+		}
+		reply, err := conn.Request("back", nil)
+		if err != nil {
+			return err
+		}
+		return req.Respond(reply.Data)
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"", "msg-1-first"},
+		{"slow", "deadline"},
+	}
+	for _, tt := range tests {
+		msg, err := nc.Request("front", []byte(tt.data), time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if handlerErr := HandlerErrorFromMsg(msg); handlerErr != nil {
+			t.Fatalf("unexpected error: %v", handlerErr)
+		}
+		if string(msg.Data) != tt.expected {
+			t.Errorf("expected %q, received %q", tt.expected, msg.Data)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package natsmicromw
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Control group files limiting the CPU time of the process, as seen inside
+// a container
+const (
+	cgroupV2CPUMax    = "/sys/fs/cgroup/cpu.max"
+	cgroupV1CPUQuota  = "/sys/fs/cgroup/cpu/cpu.cfs_quota_us"
+	cgroupV1CPUPeriod = "/sys/fs/cgroup/cpu/cpu.cfs_period_us"
+)
+
+// AvailableCPUs returns the number of CPUs the process can use, the lower of
+// GOMAXPROCS and the CPU quota of its container, rounded down and at least
+// one. The quota is read from the cgroup v2 or v1 CPU controller mounted in
+// /sys/fs/cgroup, as in a container with its own cgroup namespace, and
+// ignored elsewhere.
+func AvailableCPUs() int {
+	cpus := runtime.GOMAXPROCS(0)
+	if quota, ok := cpuQuota(); ok && quota < cpus {
+		cpus = quota
+	}
+	if cpus < 1 {
+		cpus = 1
+	}
+	return cpus
+}
+
+// Return the CPU quota of the cgroup as a number of CPUs, false if it is
+// not limited
+func cpuQuota() (int, bool) {
+	if data, err := os.ReadFile(cgroupV2CPUMax); err == nil {
+		// "<quota> <period>", the quota being "max" if unlimited
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		return quotaCPUs(fields[0], fields[1])
+	}
+
+	quota, err := os.ReadFile(cgroupV1CPUQuota)
+	if err != nil {
+		return 0, false
+	}
+	period, err := os.ReadFile(cgroupV1CPUPeriod)
+	if err != nil {
+		return 0, false
+	}
+	return quotaCPUs(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func quotaCPUs(quota, period string) (int, bool) {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	cpus := int(q / p)
+	if cpus < 1 {
+		cpus = 1
+	}
+	return cpus, true
+}
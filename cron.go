@@ -0,0 +1,342 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// Header carrying the schedule of a cron task request
+	HeaderCronSpec string = "cron-spec"
+
+	cronSubjectPrefix = "$CRON"
+)
+
+var ErrInvalidCronSpec = errors.New("invalid cron spec")
+
+// ErrCronRunning is reported for activations of a cron task skipped because
+// a run that timed out has not returned yet.
+var ErrCronRunning = errors.New("previous cron run still running")
+
+// Timeout of a cron task run, unless set with `WithCronTimeout` or the
+// handler timeout of the service
+const defaultCronTimeout = 10 * time.Minute
+
+// Schedule of a cron task, returns the next activation after the given time
+// or the zero time if there is none.
+type cronSchedule interface {
+	next(t time.Time) time.Time
+}
+
+type everySchedule time.Duration
+
+func (e everySchedule) next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// Standard five field schedule, each field is a bitmask of allowed values
+type fieldSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Both day fields restricted, a day matching either one is accepted
+	dayOr bool
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = []cronField{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week, both 0 and 7 are Sunday
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse a cron spec, either five fields ("*/5 * * * *"), a descriptor like
+// "@daily" or a fixed interval like "@every 30s".
+func parseCronSpec(spec string) (cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCronSpec, spec)
+		}
+		return everySchedule(d), nil
+	}
+	if fields, ok := cronDescriptors[spec]; ok {
+		spec = fields
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidCronSpec, spec)
+	}
+
+	var masks [5]uint64
+	for i, field := range fields {
+		mask, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCronSpec, spec)
+		}
+		masks[i] = mask
+	}
+
+	// Sunday may be given as either 0 or 7
+	if masks[4]&(1<<7) != 0 {
+		masks[4] |= 1
+	}
+	return &fieldSchedule{
+		minute: masks[0],
+		hour:   masks[1],
+		dom:    masks[2],
+		month:  masks[3],
+		dow:    masks[4],
+		dayOr:  fields[2] != "*" && fields[4] != "*",
+	}, nil
+}
+
+// Parse a comma separated list of values, ranges and steps
+func parseCronField(field string, f cronField) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, ErrInvalidCronSpec
+			}
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, ErrInvalidCronSpec
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, ErrInvalidCronSpec
+				}
+			} else if hasStep {
+				// "5/15" means starting from 5
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, ErrInvalidCronSpec
+		}
+
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+func (s *fieldSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.dayOr {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (s *fieldSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Jump to the start of the next non-matching unit until all fields
+	// match, giving up if the schedule never matches (e.g. February 30)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Synthesized request passed through the middleware chain on every run of a
+// cron task. Replies are discarded.
+type cronRequest struct {
+	subject string
+	headers micro.Headers
+}
+
+func (r *cronRequest) Respond([]byte, ...micro.RespondOpt) error { return nil }
+
+func (r *cronRequest) RespondJSON(any, ...micro.RespondOpt) error { return nil }
+
+func (r *cronRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	return nil
+}
+
+func (r *cronRequest) Data() []byte { return nil }
+
+func (r *cronRequest) Headers() micro.Headers { return r.headers }
+
+func (r *cronRequest) Subject() string { return r.subject }
+
+func (r *cronRequest) Reply() string { return "" }
+
+type cronOpts struct {
+	name    string
+	timeout time.Duration
+}
+
+// CronOpt configures a cron task.
+type CronOpt func(*cronOpts)
+
+// WithCronName names the cron task, the requests of the task have the
+// subject "$CRON.<name>". Defaults to "cron-<n>".
+func WithCronName(name string) CronOpt {
+	return func(o *cronOpts) {
+		o.name = name
+	}
+}
+
+// WithCronTimeout sets the timeout of each run of the cron task. Defaults to
+// the handler timeout of the service, or 10 minutes if there is none.
+func WithCronTimeout(timeout time.Duration) CronOpt {
+	return func(o *cronOpts) {
+		o.timeout = timeout
+	}
+}
+
+// AddCronTask runs the handler on a cron schedule, either five fields
+// ("*/5 * * * *"), a descriptor like "@hourly" or an interval like
+// "@every 30s". Every run passes a synthesized request through the context
+// middleware of the service, the same way as for endpoints, with the default
+// context and connection of the service. The context of the request is
+// cancelled when the service is stopped or the run times out, see
+// [WithCronTimeout]. Returned errors and panics, which are always recovered,
+// are reported to the `ErrorHandler` of the service config. A run that is
+// still in progress when the next activation is due delays it instead of
+// overlapping. A run that timed out but has not returned yet skips the
+// activations due meanwhile, reporting `ErrCronRunning` for each.
+func (s *Service) AddCronTask(spec string, handler ContextHandlerFunc, opts ...CronOpt) error {
+	schedule, err := parseCronSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	cfg := s.snapshot()
+	o := cronOpts{timeout: cfg.handlerTimeout}
+	if o.timeout <= 0 {
+		o.timeout = defaultCronTimeout
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.name == "" {
+		o.name = fmt.Sprintf("cron-%d", s.tasks.cronSeq.Add(1))
+	}
+
+	s.guard.registered.Store(true)
+	subject := cronSubjectPrefix + "." + o.name
+	ep := &endpointRef{name: o.name, kind: EndpointCron}
+	ep.subject.Store(&subject)
+	s.described.add(cfg.describeEndpoint(ep, micro.EndpointInfo{Subject: subject}), cfg.endpointWiring(ep))
+
+	// Wrap handler in middleware calls
+	wrapped := handler
+	for i := len(cfg.cmw) - 1; i >= 0; i-- {
+		wrapped = cfg.cmw[i](wrapped)
+	}
+	baseCtx := withErrorConverter(withConn(cfg.baseContext(), cfg.nc), cfg.errorConverter())
+	onPanic := cfg.onPanic
+
+	s.Go(func(ctx context.Context) error {
+		// Closed once the last run has returned, even after timing out
+		var running <-chan struct{}
+		for {
+			next := schedule.next(time.Now())
+			if next.IsZero() {
+				return nil
+			}
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+
+			if running != nil {
+				select {
+				case <-running:
+				default:
+					s.tasks.report(subject, ErrCronRunning)
+					continue
+				}
+			}
+
+			req := &cronRequest{subject: subject, headers: micro.Headers{HeaderCronSpec: []string{spec}}}
+			var err error
+			running, err = runCronTask(ctx, withEndpoint(baseCtx, ep), o.timeout, onPanic, req, wrapped)
+			if err != nil && !(errors.Is(err, context.Canceled) && ctx.Err() != nil) {
+				s.tasks.report(subject, err)
+			}
+		}
+	})
+	return nil
+}
+
+// Run a cron task with the base context of the service, cancelled when the
+// lifetime context is done or the timeout expires, recovering panics. The
+// returned channel is closed once the handler has returned.
+func runCronTask(lifetime, baseCtx context.Context, timeout time.Duration, onPanic PanicHandler, req *cronRequest, handler ContextHandlerFunc) (<-chan struct{}, error) {
+	ctx, cancel := context.WithCancel(baseCtx)
+	defer cancel()
+	go func() {
+		select {
+		case <-lifetime.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	finished := make(chan struct{})
+	_, err := callWithTimeout(ctx, timeout, func(ctx context.Context) (struct{}, error) {
+		defer close(finished)
+		return struct{}{}, callRecovered(true, onPanic, req, func() error {
+			return handler(&Request{req, ctx})
+		})
+	})
+	return finished, err
+}
@@ -0,0 +1,243 @@
+package natsmicromw
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestParseCronSpec(t *testing.T) {
+	base := time.Date(2024, time.January, 31, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 31, 10, 15, 0, 0, time.UTC)},
+		{"5 9-11 * * *", time.Date(2024, time.January, 31, 11, 5, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2024, time.February, 4, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 1", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", base.Add(90 * time.Second)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		schedule, err := parseCronSpec(test.spec)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.spec, err)
+		}
+		if next := schedule.next(base); !next.Equal(test.expected) {
+			t.Errorf("next activation of %s does not match, expected %v, received %v", test.spec, test.expected, next)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "@every -1s"} {
+		if _, err := parseCronSpec(spec); !errors.Is(err, ErrInvalidCronSpec) {
+			t.Errorf("expected invalid spec error for %q, received %v", spec, err)
+		}
+	}
+}
+
+func TestCronTask(t *testing.T) {
+	s := getServer(t)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	errs := make(chan *micro.NATSError, 10)
+	nm, err := AddContextService(nc, micro.Config{
+		Name:    "TestService",
+		Version: "1.0.0",
+		ErrorHandler: func(svc micro.Service, natsErr *micro.NATSError) {
+			select {
+			case errs <- natsErr:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+
+	subjects := make(chan string, 10)
+	nm = nm.UseContext(func(next ContextHandlerFunc) ContextHandlerFunc {
+		return func(req *Request) error {
+			select {
+			case subjects <- EndpointSubjectFromContext(req.Context()):
+			default:
+			}
+			return next(req)
+		}
+	})
+
+	err = nm.AddCronTask("@every 10ms", func(req *Request) error {
+		if spec := req.Headers().Get(HeaderCronSpec); spec != "@every 10ms" {
+			t.Errorf("spec does not match, expected %s, received %s", "@every 10ms", spec)
+		}
+		return errors.New("job failed")
+	}, WithCronName("cleanup"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case subject := <-subjects:
+		if subject != "$CRON.cleanup" {
+			t.Errorf("subject does not match, expected %s, received %s", "$CRON.cleanup", subject)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cron task did not run")
+	}
+	select {
+	case natsErr := <-errs:
+		if natsErr.Subject != "$CRON.cleanup" || natsErr.Description != "job failed" {
+			t.Errorf("unexpected error reported: %v", natsErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cron task error was not reported")
+	}
+
+	if err := nm.AddCronTask("not a spec", emptyRequestHandler); !errors.Is(err, ErrInvalidCronSpec) {
+		t.Errorf("expected invalid spec error, received %v", err)
+	}
+
+	nm.Stop()
+	nm.Wait()
+}
+
+func TestCronTaskIsolation(t *testing.T) {
+	s := getServer(t)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	errs := make(chan *micro.NATSError, 10)
+	nm, err := AddContextService(nc, micro.Config{
+		Name:    "TestService",
+		Version: "1.0.0",
+		ErrorHandler: func(svc micro.Service, natsErr *micro.NATSError) {
+			select {
+			case errs <- natsErr:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+
+	// Panics are recovered and reported
+	err = nm.AddCronTask("@every 10ms", func(req *Request) error {
+		panic("task failed")
+	}, WithCronName("panicking"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Runs get the connection and are cancelled when they time out
+	conns := make(chan *Conn, 10)
+	err = nm.AddCronTask("@every 10ms", func(req *Request) error {
+		select {
+		case conns <- ConnFromContext(req.Context()):
+		default:
+		}
+		<-req.Context().Done()
+		return req.Context().Err()
+	}, WithCronName("slow"), WithCronTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reported := make(map[string]bool)
+	for len(reported) < 2 {
+		select {
+		case natsErr := <-errs:
+			reported[natsErr.Subject] = true
+		case <-time.After(time.Second):
+			t.Fatalf("cron task errors were not reported: %v", reported)
+		}
+	}
+	if !reported["$CRON.panicking"] || !reported["$CRON.slow"] {
+		t.Errorf("unexpected errors reported: %v", reported)
+	}
+	if conn := <-conns; conn == nil {
+		t.Errorf("cron task has no connection")
+	}
+
+	nm.Stop()
+	nm.Wait()
+}
+
+func TestCronTaskOverlap(t *testing.T) {
+	s := getServer(t)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	errs := make(chan *micro.NATSError, 100)
+	nm, err := AddContextService(nc, micro.Config{
+		Name:    "TestService",
+		Version: "1.0.0",
+		ErrorHandler: func(svc micro.Service, natsErr *micro.NATSError) {
+			select {
+			case errs <- natsErr:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+
+	// The first run ignores its timeout and keeps running
+	var running, overlaps, runs atomic.Int32
+	err = nm.AddCronTask("@every 10ms", func(req *Request) error {
+		if running.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		defer running.Add(-1)
+		if runs.Add(1) == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return nil
+	}, WithCronName("stubborn"), WithCronTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; runs.Load() < 3; i++ {
+		if i > 100 {
+			t.Fatalf("cron task did not run again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	nm.Stop()
+	nm.Wait()
+	close(errs)
+	var skipped bool
+	for natsErr := range errs {
+		skipped = skipped || natsErr.Description == ErrCronRunning.Error()
+	}
+	if !skipped {
+		t.Errorf("expected skipped activations to be reported")
+	}
+	if n := overlaps.Load(); n != 0 {
+		t.Errorf("expected no overlapping runs, received %d", n)
+	}
+}
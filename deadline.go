@@ -0,0 +1,92 @@
+package natsmicromw
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ErrReplyAbandoned is returned when replying after the reply deadline, in
+// which case the reply is not sent.
+var ErrReplyAbandoned = errors.New("reply abandoned after deadline")
+
+// WithReplyDeadline returns a service whose endpoints stop replying once the
+// deadline has elapsed since the request was received, as the client has
+// certainly timed out by then. Replies sent later are dropped without
+// publishing them, `Respond` returns [ErrReplyAbandoned], and they are
+// counted in [Service.AbandonedReplies] and reported to the `ErrorHandler`
+// of the service config. Unlike [Service.WithHandlerTimeout], the handler is
+// not interrupted.
+func (s *Service) WithReplyDeadline(deadline time.Duration) *Service {
+	ns := s.clone()
+	ns.replyDeadline = deadline
+	return ns
+}
+
+// WithReplyDeadline returns a group whose endpoints stop replying after the
+// deadline, see [Service.WithReplyDeadline].
+func (g *Group) WithReplyDeadline(deadline time.Duration) *Group {
+	svc := g.svc.clone()
+	svc.replyDeadline = deadline
+	return &Group{svc, g.grp, g.prefix}
+}
+
+// AbandonedReplies returns the number of replies of the service, its clones
+// and groups that were dropped because of the reply deadline.
+func (s *Service) AbandonedReplies() uint64 {
+	return s.abandoned.Load()
+}
+
+// Wrap the handler to drop replies after the deadline, if any
+func (s *Service) deadlineHandler(handler micro.Handler) micro.Handler {
+	deadline, abandoned, tasks := s.replyDeadline, s.abandoned, s.tasks
+	if deadline <= 0 {
+		return handler
+	}
+	return micro.HandlerFunc(func(req micro.Request) {
+		handler.Handle(&deadlineRequest{req, time.Now().Add(deadline), abandoned, tasks})
+	})
+}
+
+// Request dropping replies after its deadline
+type deadlineRequest struct {
+	micro.Request
+	deadline  time.Time
+	abandoned *atomic.Uint64
+	tasks     *tasks
+}
+
+// Check whether the reply may still be sent
+func (r *deadlineRequest) allowed() bool {
+	if time.Now().Before(r.deadline) {
+		return true
+	}
+	r.abandoned.Add(1)
+	r.tasks.report(r.Subject(), ErrReplyAbandoned)
+	return false
+}
+
+func (r *deadlineRequest) unwrapRequest() micro.Request { return r.Request }
+
+func (r *deadlineRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	if !r.allowed() {
+		return ErrReplyAbandoned
+	}
+	return r.Request.Respond(data, opts...)
+}
+
+func (r *deadlineRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	if !r.allowed() {
+		return ErrReplyAbandoned
+	}
+	return r.Request.RespondJSON(v, opts...)
+}
+
+func (r *deadlineRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	if !r.allowed() {
+		return ErrReplyAbandoned
+	}
+	return r.Request.Error(code, description, data, opts...)
+}
@@ -0,0 +1,58 @@
+package natsmicromw
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestReplyDeadline(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	responded := make(chan error, 1)
+	bounded := nm.WithReplyDeadline(50 * time.Millisecond)
+	if err := bounded.AddContextEndpoint("slow", func(req *Request) error {
+		if string(req.Data()) == "slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		err := req.Respond([]byte("done"))
+		responded <- err
+		return err
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddEndpoint("unbounded", micro.HandlerFunc(func(req micro.Request) {
+		time.Sleep(100 * time.Millisecond)
+		req.Respond([]byte("done"))
+	})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if msg, err := nc.Request("slow", nil, time.Second); err != nil || string(msg.Data) != "done" {
+		t.Fatalf("unexpected reply: %v", err)
+	}
+	<-responded
+
+	if _, err := nc.Request("slow", []byte("slow"), 30*time.Millisecond); !errors.Is(err, nats.ErrTimeout) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if err := <-responded; !errors.Is(err, ErrReplyAbandoned) {
+		t.Errorf("expected abandoned reply, got %v", err)
+	}
+
+	if msg, err := nc.Request("unbounded", nil, time.Second); err != nil || string(msg.Data) != "done" {
+		t.Errorf("expected endpoints without deadline to reply: %v", err)
+	}
+
+	if abandoned := nm.AbandonedReplies(); abandoned != 1 {
+		t.Errorf("expected 1 abandoned reply, got %d", abandoned)
+	}
+	if respondErrors := nm.RespondErrors(); respondErrors != 0 {
+		t.Errorf("expected no respond errors, got %d", respondErrors)
+	}
+}
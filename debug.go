@@ -0,0 +1,198 @@
+package natsmicromw
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// Request header asking for a trace of the middleware chain, "true" to enable
+	HeaderDebugChain string = "x-debug-chain"
+	// Reply header carrying the trace of the middleware chain
+	HeaderDebugChainTrace string = "x-debug-chain-trace"
+)
+
+// DebugChainAuthorizer decides whether the request may receive a trace of
+// the middleware chain, e.g. by checking a debug token header. It runs
+// before any middleware, so it cannot rely on authentication middleware.
+type DebugChainAuthorizer func(req micro.Request) bool
+
+// SetDebugChain enables tracing the middleware chain of context and Micro
+// endpoints for requests with the `x-debug-chain: true` header that pass
+// the authorizer. Each middleware entered is recorded with its offset from
+// the start of the request, its duration and the code of a returned error,
+// and the trace is sent in the `x-debug-chain-trace` reply header, e.g.
+//
+//	middleware.QuotaMiddleware.func1@3us+41us!429
+//
+// Context handlers reply before the outer middleware returns, so those are
+// listed without a duration. The trace is recorded by the same middleware
+// chain that serves other requests, so it must be enabled before adding the
+// endpoints. Nil disables tracing, which is the default.
+func (s *Service) SetDebugChain(authorize DebugChainAuthorizer) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.debugChain = authorize
+}
+
+// Start tracing the request if it asks for it and is authorized
+func (s *Service) debugTrace(req micro.Request) *chainTrace {
+	if s.debugChain == nil || req.Headers().Get(HeaderDebugChain) != "true" || !s.debugChain(req) {
+		return nil
+	}
+	return &chainTrace{start: time.Now()}
+}
+
+type chainSpan struct {
+	name     string
+	enter    time.Duration
+	duration time.Duration
+	code     string
+	done     bool
+}
+
+// Middleware entered and exited while handling a request
+type chainTrace struct {
+	start time.Time
+
+	mu    sync.Mutex
+	spans []chainSpan
+}
+
+type chainTraceContextKey struct{}
+
+func withChainTrace(ctx context.Context, t *chainTrace) context.Context {
+	return context.WithValue(ctx, chainTraceContextKey{}, t)
+}
+
+func chainTraceFromContext(ctx context.Context) *chainTrace {
+	t, _ := ctx.Value(chainTraceContextKey{}).(*chainTrace)
+	return t
+}
+
+func (t *chainTrace) enter(name string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, chainSpan{name: name, enter: time.Since(t.start)})
+	return len(t.spans) - 1
+}
+
+func (t *chainTrace) exit(i int, code string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &t.spans[i]
+	span.duration = time.Since(t.start) - span.enter
+	span.code = code
+	span.done = true
+}
+
+func (t *chainTrace) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, 0, len(t.spans))
+	for _, span := range t.spans {
+		part := fmt.Sprintf("%s@%dus", span.name, span.enter.Microseconds())
+		if span.done {
+			part += fmt.Sprintf("+%dus", span.duration.Microseconds())
+		}
+		if span.code != "" {
+			part += "!" + span.code
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ";")
+}
+
+// Add the trace header to the reply, without changing the headers passed
+// by the handler
+func (t *chainTrace) respondOpt() micro.RespondOpt {
+	return func(m *nats.Msg) {
+		headers := nats.Header{}
+		for k, v := range m.Header {
+			headers[k] = v
+		}
+		headers.Set(HeaderDebugChainTrace, t.String())
+		m.Header = headers
+	}
+}
+
+// Request adding the trace to the replies of context handlers
+type debugRequest struct {
+	micro.Request
+	trace *chainTrace
+}
+
+func (r *debugRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Respond(data, append(opts, r.trace.respondOpt())...)
+}
+
+func (r *debugRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	return r.Request.RespondJSON(v, append(opts, r.trace.respondOpt())...)
+}
+
+func (r *debugRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Error(code, description, data, append(opts, r.trace.respondOpt())...)
+}
+
+// Name of a middleware function for the trace, without the package path
+func middlewareName(fn any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// Record the layer in the trace of the request, if any
+func traceContextLayer(name string, next ContextHandlerFunc) ContextHandlerFunc {
+	return func(req *Request) error {
+		t := chainTraceFromContext(req.Context())
+		if t == nil {
+			return next(req)
+		}
+		i := t.enter(name)
+		err := next(req)
+		t.exit(i, ContextErrorCode(req.Context(), err))
+		return err
+	}
+}
+
+func traceMicroLayer(name string, next MicroHandlerFunc) MicroHandlerFunc {
+	return func(req *MicroRequest) (*MicroReply, error) {
+		t := chainTraceFromContext(req.Context())
+		if t == nil {
+			return next(req)
+		}
+		i := t.enter(name)
+		reply, err := next(req)
+		t.exit(i, ContextErrorCode(req.Context(), err))
+		return reply, err
+	}
+}
+
+// Chain of context middleware recording each layer in the trace of the
+// request, if any
+func tracedContextChain(mws []ContextMiddlewareFunc, handler ContextHandlerFunc) ContextHandlerFunc {
+	wrapped := traceContextLayer("handler", handler)
+	for i := len(mws) - 1; i >= 0; i-- {
+		wrapped = traceContextLayer(middlewareName(mws[i]), mws[i](wrapped))
+	}
+	return wrapped
+}
+
+func tracedMicroChain(mws []MicroMiddlewareFunc, handler MicroHandlerFunc) MicroHandlerFunc {
+	wrapped := traceMicroLayer("handler", handler)
+	for i := len(mws) - 1; i >= 0; i-- {
+		wrapped = traceMicroLayer(middlewareName(mws[i]), mws[i](wrapped))
+	}
+	return wrapped
+}
@@ -0,0 +1,110 @@
+package natsmicromw
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func rejectingMiddleware(next MicroHandlerFunc) MicroHandlerFunc {
+	return func(req *MicroRequest) (*MicroReply, error) {
+		if req.HeaderGet("reject") != "" {
+			return nil, Errorf(CodeForbidden, "rejected")
+		}
+		return next(req)
+	}
+}
+
+func TestDebugChain(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	nm.SetDebugChain(func(req micro.Request) bool {
+		return req.Headers().Get("debug-token") == "secret"
+	})
+	err := nm.UseMicro(rejectingMiddleware).AddMicroEndpoint("echo", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply(req.Data), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := func(headers map[string]string) *nats.Msg {
+		msg := nats.NewMsg("echo")
+		for k, v := range headers {
+			msg.Header.Set(k, v)
+		}
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return reply
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		reply := request(map[string]string{HeaderDebugChain: "true"})
+		if trace := reply.Header.Get(HeaderDebugChainTrace); trace != "" {
+			t.Errorf("expected no trace, received %q", trace)
+		}
+	})
+
+	t.Run("handled", func(t *testing.T) {
+		reply := request(map[string]string{HeaderDebugChain: "true", "debug-token": "secret"})
+		trace := reply.Header.Get(HeaderDebugChainTrace)
+		parts := strings.Split(trace, ";")
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "natsmicromw.rejectingMiddleware@") || !strings.HasPrefix(parts[1], "handler@") {
+			t.Errorf("unexpected trace: %q", trace)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		reply := request(map[string]string{HeaderDebugChain: "true", "debug-token": "secret", "reject": "1"})
+		trace := reply.Header.Get(HeaderDebugChainTrace)
+		if strings.Contains(trace, "handler") || !strings.HasSuffix(trace, "!403") {
+			t.Errorf("unexpected trace: %q", trace)
+		}
+		if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != CodeForbidden {
+			t.Errorf("expected a 403 error, received %v", handlerErr)
+		}
+	})
+}
+
+func TestDebugChainSharesMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	nm.SetDebugChain(func(micro.Request) bool { return true })
+	var built, called int
+	counting := func(next MicroHandlerFunc) MicroHandlerFunc {
+		built++
+		return func(req *MicroRequest) (*MicroReply, error) {
+			called++
+			return next(req)
+		}
+	}
+	err := nm.UseMicro(counting).AddMicroEndpoint("echo", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply(req.Data), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, debug := range []string{"", "true"} {
+		msg := nats.NewMsg("echo")
+		msg.Header.Set(HeaderDebugChain, debug)
+		if _, err := nc.RequestMsg(msg, time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if built != 1 {
+		t.Errorf("expected the middleware to be built once, received %d", built)
+	}
+	if called != 2 {
+		t.Errorf("expected the middleware to be called twice, received %d", called)
+	}
+}
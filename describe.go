@@ -0,0 +1,181 @@
+package natsmicromw
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// EndpointKind is the type of handler an endpoint was registered with.
+type EndpointKind string
+
+const (
+	EndpointRaw     EndpointKind = "raw"
+	EndpointContext EndpointKind = "context"
+	EndpointMicro   EndpointKind = "micro"
+	EndpointCron    EndpointKind = "cron"
+	EndpointWatch   EndpointKind = "watch"
+)
+
+// ServiceDescription is the wiring of a service as returned by [Service.Describe].
+type ServiceDescription struct {
+	Name    string
+	ID      string
+	Version string
+	// Middleware of the described service, outermost first
+	Middleware        []string
+	ContextMiddleware []string
+	MicroMiddleware   []string
+	// Prefixes of the groups with endpoints, in registration order
+	Groups    []string
+	Endpoints []EndpointDescription
+}
+
+// EndpointDescription is the wiring of an endpoint, as snapshotted when it
+// was registered.
+type EndpointDescription struct {
+	Name       string
+	Subject    string
+	Group      string
+	QueueGroup string
+	Kind       EndpointKind
+	// Name of the handler function
+	Handler string
+	// Middleware applied to the handler, outermost first
+	Middleware []string
+	Metadata   map[string]string
+	Options    EndpointOptions
+}
+
+// EndpointOptions are the settings in effect for an endpoint.
+type EndpointOptions struct {
+	HandlerTimeout    time.Duration
+	Version           string
+	VersionPrecedence VersionPrecedence
+	Aliases           []string
+	EchoHeaders       []string
+	DefaultErrorCode  string
+	ErrorTransformers int
+	InstanceSubjects  bool
+	RecoverPanics     bool
+}
+
+// Endpoints registered by a service and its clones
+type descriptions struct {
+	mu        sync.Mutex
+	endpoints []EndpointDescription
+	wiring    []endpointWiring
+}
+
+// Handler and middleware values of a described endpoint, which blueprints
+// name with a [HandlerRegistry]
+type endpointWiring struct {
+	handler    any
+	middleware []any
+}
+
+func (d *descriptions) add(desc EndpointDescription, wiring endpointWiring) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.endpoints = append(d.endpoints, desc)
+	d.wiring = append(d.wiring, wiring)
+}
+
+// Describe returns the wiring of the service: its middleware, groups and
+// the endpoints registered so far with their subjects, middleware order and
+// options. Use it in tests to verify the intended wiring, or in tooling to
+// render it. Middleware is named after its function, e.g.
+// "middleware.CompressionMiddleware".
+func (s *Service) Describe() ServiceDescription {
+	cfg := s.snapshot()
+	info := s.svc.Info()
+
+	s.described.mu.Lock()
+	endpoints := append([]EndpointDescription{}, s.described.endpoints...)
+	s.described.mu.Unlock()
+
+	var groups []string
+	seen := make(map[string]bool)
+	for _, ep := range endpoints {
+		if ep.Group != "" && !seen[ep.Group] {
+			seen[ep.Group] = true
+			groups = append(groups, ep.Group)
+		}
+	}
+
+	return ServiceDescription{
+		Name:              info.Name,
+		ID:                info.ID,
+		Version:           info.Version,
+		Middleware:        middlewareNames(cfg.mw),
+		ContextMiddleware: middlewareNames(cfg.cmw),
+		MicroMiddleware:   middlewareNames(cfg.mmw),
+		Groups:            groups,
+		Endpoints:         endpoints,
+	}
+}
+
+func middlewareNames[T any](mws []T) []string {
+	names := make([]string, len(mws))
+	for i, mw := range mws {
+		names[i] = middlewareName(mw)
+	}
+	return names
+}
+
+func anySlice[T any](values []T) []any {
+	s := make([]any, len(values))
+	for i, v := range values {
+		s[i] = v
+	}
+	return s
+}
+
+// Handler and middleware of the endpoint registered with the settings of
+// the service
+func (s *Service) endpointWiring(ref *endpointRef) endpointWiring {
+	wiring := endpointWiring{handler: ref.fn}
+	switch ref.kind {
+	case EndpointRaw:
+		wiring.middleware = anySlice(s.mw)
+	case EndpointContext, EndpointCron, EndpointWatch:
+		wiring.middleware = anySlice(s.cmw)
+	case EndpointMicro:
+		wiring.middleware = anySlice(s.mmw)
+	}
+	return wiring
+}
+
+// Describe the endpoint registered with the settings of the service
+func (s *Service) describeEndpoint(ref *endpointRef, ep micro.EndpointInfo) EndpointDescription {
+	desc := EndpointDescription{
+		Name:       ref.name,
+		Subject:    ep.Subject,
+		Group:      ref.group,
+		QueueGroup: ep.QueueGroup,
+		Kind:       ref.kind,
+		Handler:    ref.handler,
+		Metadata:   ep.Metadata,
+		Options: EndpointOptions{
+			HandlerTimeout:    s.handlerTimeout,
+			Version:           s.version,
+			VersionPrecedence: s.versionPrecedence,
+			Aliases:           s.subjectAliases[ref.name],
+			EchoHeaders:       s.echoHeaders,
+			DefaultErrorCode:  s.defaultErrorCode,
+			ErrorTransformers: len(s.errorTransforms),
+			InstanceSubjects:  s.instanceSubjects,
+			RecoverPanics:     s.recoverPanics,
+		},
+	}
+	switch ref.kind {
+	case EndpointRaw:
+		desc.Middleware = middlewareNames(s.mw)
+	case EndpointContext, EndpointCron, EndpointWatch:
+		desc.Middleware = middlewareNames(s.cmw)
+	case EndpointMicro:
+		desc.Middleware = middlewareNames(s.mmw)
+	}
+	return desc
+}
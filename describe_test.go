@@ -0,0 +1,44 @@
+package natsmicromw
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDescribe(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	nm = nm.UseMicro(rejectingMiddleware)
+	if err := nm.AddMicroEndpoint("echo", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply(req.Data), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	orders := nm.AddGroup("orders").AddGroup("v1").WithHandlerTimeout(time.Second)
+	if err := orders.AddContextEndpoint("list", emptyRequestHandler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	desc := nm.Describe()
+	if desc.Name != "TestService" || len(desc.Endpoints) != 2 {
+		t.Fatalf("unexpected description: %+v", desc)
+	}
+	if !reflect.DeepEqual(desc.MicroMiddleware, []string{"natsmicromw.rejectingMiddleware"}) {
+		t.Errorf("middleware does not match, received %v", desc.MicroMiddleware)
+	}
+	if !reflect.DeepEqual(desc.Groups, []string{"orders.v1"}) {
+		t.Errorf("groups do not match, received %v", desc.Groups)
+	}
+
+	echo := desc.Endpoints[0]
+	if echo.Subject != "echo" || echo.Kind != EndpointMicro || len(echo.Middleware) != 1 {
+		t.Errorf("unexpected endpoint description: %+v", echo)
+	}
+	list := desc.Endpoints[1]
+	if list.Subject != "orders.v1.list" || list.Group != "orders.v1" || list.Kind != EndpointContext || list.Options.HandlerTimeout != time.Second {
+		t.Errorf("unexpected endpoint description: %+v", list)
+	}
+}
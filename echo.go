@@ -0,0 +1,65 @@
+package natsmicromw
+
+import (
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// SetEchoHeaders sets the request headers copied to every reply of the
+// service, e.g. the request ID, tenant or API version, so that handlers and
+// middleware do not need to copy them. This applies to all endpoints,
+// including raw ones, and to error replies. Headers set by the handler are
+// kept.
+func (s *Service) SetEchoHeaders(headers ...string) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.echoHeaders = headers
+}
+
+// Wrap the handler to echo the headers in its replies, if any
+func echoHandler(headers []string, handler micro.Handler) micro.Handler {
+	if len(headers) == 0 {
+		return handler
+	}
+	return micro.HandlerFunc(func(req micro.Request) {
+		handler.Handle(&echoRequest{req, headers})
+	})
+}
+
+// Request copying the echoed headers into its replies
+type echoRequest struct {
+	micro.Request
+	headers []string
+}
+
+// Add the echoed headers to the reply, without changing the headers passed
+// by the handler
+func (r *echoRequest) respondOpt() micro.RespondOpt {
+	return func(m *nats.Msg) {
+		headers := nats.Header{}
+		for k, v := range m.Header {
+			headers[k] = v
+		}
+		for _, key := range r.headers {
+			if values := r.Request.Headers().Values(key); len(values) > 0 && len(headers.Values(key)) == 0 {
+				headers[key] = values
+			}
+		}
+		m.Header = headers
+	}
+}
+
+func (r *echoRequest) unwrapRequest() micro.Request { return r.Request }
+
+func (r *echoRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Respond(data, append(opts, r.respondOpt())...)
+}
+
+func (r *echoRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	return r.Request.RespondJSON(v, append(opts, r.respondOpt())...)
+}
+
+func (r *echoRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Error(code, description, data, append(opts, r.respondOpt())...)
+}
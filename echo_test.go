@@ -0,0 +1,68 @@
+package natsmicromw
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestEchoHeaders(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	nm.SetEchoHeaders("request_id", "tenant")
+
+	if err := nm.AddEndpoint("raw", micro.HandlerFunc(func(req micro.Request) {
+		req.Respond(nil)
+	})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddContextEndpoint("context", func(req *Request) error {
+		return req.Respond(nil, micro.WithHeaders(micro.Headers{"tenant": []string{"own"}}))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddMicroEndpoint("micro", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply(nil), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddGroup("grp").AddMicroEndpoint("error", func(req *MicroRequest) (*MicroReply, error) {
+		return nil, errors.New("failed")
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		subject string
+		tenant  string
+	}{
+		{"raw", "acme"},
+		{"context", "own"},
+		{"micro", "acme"},
+		{"grp.error", "acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			msg := nats.NewMsg(tt.subject)
+			msg.Header.Set("request_id", "req-1")
+			msg.Header.Set("tenant", "acme")
+			msg.Header.Set("other", "value")
+
+			reply, err := nc.RequestMsg(msg, time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if reply.Header.Get("request_id") != "req-1" || reply.Header.Get("tenant") != tt.tenant {
+				t.Errorf("headers not echoed: %v", reply.Header)
+			}
+			if reply.Header.Get("other") != "" {
+				t.Errorf("unexpected header echoed: %v", reply.Header)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package natsmicromw
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Endpoint a handler was registered as. The subject is only known after
+// the endpoint has been added, so it is stored atomically.
+type endpointRef struct {
+	name    string
+	kind    EndpointKind
+	group   string
+	handler string
+	// Handler value, named by the registry in blueprints
+	fn      any
+	subject atomic.Pointer[string]
+}
+
+type endpointContextKey struct{}
+
+func withEndpoint(ctx context.Context, ep *endpointRef) context.Context {
+	if ep == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, endpointContextKey{}, ep)
+}
+
+// EndpointNameFromContext returns the name of the endpoint handling the request.
+func EndpointNameFromContext(ctx context.Context) string {
+	ep, ok := ctx.Value(endpointContextKey{}).(*endpointRef)
+	if !ok {
+		return ""
+	}
+	return ep.name
+}
+
+// EndpointSubjectFromContext returns the subject the endpoint handling the
+// request was registered on, which may contain wildcards unlike the subject
+// of the request itself. Use it e.g. as a low cardinality metrics label.
+func EndpointSubjectFromContext(ctx context.Context) string {
+	ep, ok := ctx.Value(endpointContextKey{}).(*endpointRef)
+	if !ok {
+		return ""
+	}
+	if subject := ep.subject.Load(); subject != nil {
+		return *subject
+	}
+	return ""
+}
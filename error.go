@@ -1,10 +1,329 @@
 package natsmicromw
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Common error codes, following the HTTP status codes
+const (
+	CodeBadRequest      string = "400"
+	CodeUnauthorized    string = "401"
+	CodeForbidden       string = "403"
+	CodeNotFound        string = "404"
+	CodeConflict        string = "409"
+	CodeTooManyRequests string = "429"
+	CodeInternal        string = "500"
+	CodeUnavailable     string = "503"
+	CodeTimeout         string = "504"
+)
+
+// FieldError describes a single invalid field in a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
 type HandlerError struct {
-	Description string `json:"description"`
-	Code        string `json:"code"`
+	Description string            `json:"description"`
+	Code        string            `json:"code"`
+	Fields      []FieldError      `json:"fields,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	// Headers are added to the error reply, they are not part of the body
+	Headers micro.Headers `json:"-"`
+
+	cause error
 }
 
 func (e *HandlerError) Error() string {
 	return e.Description
 }
+
+// Unwrap returns the cause of the error, if any: the error given to
+// [WrapError], or the formatted error of [Errorf] if it wraps errors with %w.
+func (e *HandlerError) Unwrap() error {
+	return e.cause
+}
+
+// Errorf creates a new error with the given code and formatted description.
+// Errors wrapped with %w, including several of them, can still be matched
+// with `errors.Is` and `errors.As`.
+func Errorf(code string, format string, args ...any) *HandlerError {
+	err := fmt.Errorf(format, args...)
+	handlerErr := &HandlerError{
+		Description: err.Error(),
+		Code:        code,
+	}
+	switch err.(type) {
+	case interface{ Unwrap() error }, interface{ Unwrap() []error }:
+		handlerErr.cause = err
+	}
+	return handlerErr
+}
+
+// WrapError creates a new error with the given code and description,
+// wrapping the cause. The cause is not sent to the client, but can be
+// matched with `errors.Is` and `errors.As`, e.g. by an [ErrorTransformer]
+// logging it.
+func WrapError(code, description string, cause error) *HandlerError {
+	return &HandlerError{
+		Description: description,
+		Code:        code,
+		cause:       cause,
+	}
+}
+
+// ErrorCodeMapping assigns a code to plain errors returned by handlers.
+type ErrorCodeMapping struct {
+	Match func(err error) bool
+	Code  string
+}
+
+// MapError maps errors matching the target with `errors.Is` to the code.
+func MapError(target error, code string) ErrorCodeMapping {
+	return ErrorCodeMapping{
+		Match: func(err error) bool { return errors.Is(err, target) },
+		Code:  code,
+	}
+}
+
+// MapErrorType maps errors of the type T, as found by `errors.As`, to the
+// code, e.g. `MapErrorType[validator.ValidationErrors](CodeBadRequest)`.
+func MapErrorType[T error](code string) ErrorCodeMapping {
+	return ErrorCodeMapping{
+		Match: func(err error) bool {
+			var target T
+			return errors.As(err, &target)
+		},
+		Code: code,
+	}
+}
+
+// Default mapping of services, used unless changed with `SetErrorCodes`
+var DefaultErrorCodes = []ErrorCodeMapping{
+	MapError(context.DeadlineExceeded, CodeTimeout),
+}
+
+// ErrorCode returns the code a handler error is sent with using the default
+// mapping, e.g. for middleware reporting errors. Empty if err is nil.
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	return toHandlerError(err, DefaultErrorCodes, CodeInternal).Code
+}
+
+// ContextErrorCode returns the code a handler error is sent with by the
+// service serving the request, using its error codes, default code and
+// transformers. Outside a handler it is the same as `ErrorCode`.
+func ContextErrorCode(ctx context.Context, err error) string {
+	if err == nil {
+		return ""
+	}
+	return contextHandlerError(ctx, err).Code
+}
+
+type errorConverterContextKey struct{}
+
+// Conversion of handler errors with the error codes, default code and
+// transformers of the service serving the request
+func withErrorConverter(ctx context.Context, convert func(error) *HandlerError) context.Context {
+	return context.WithValue(ctx, errorConverterContextKey{}, convert)
+}
+
+// Convert a handler error like the service serving the request does before
+// encoding it, or with the default mapping outside a handler
+func contextHandlerError(ctx context.Context, err error) *HandlerError {
+	if ctx != nil {
+		if convert, ok := ctx.Value(errorConverterContextKey{}).(func(error) *HandlerError); ok {
+			return convert(err)
+		}
+	}
+	return toHandlerError(err, DefaultErrorCodes, CodeInternal)
+}
+
+// Convert a handler error into a HandlerError, using the first matching
+// mapping for plain errors and falling back to the default code
+func toHandlerError(err error, mappings []ErrorCodeMapping, defaultCode string) *HandlerError {
+	var handlerErr *HandlerError
+	if errors.As(err, &handlerErr) {
+		return handlerErr
+	}
+
+	code := defaultCode
+	for _, m := range mappings {
+		if m.Match(err) {
+			code = m.Code
+			break
+		}
+	}
+	return &HandlerError{
+		Description: err.Error(),
+		Code:        code,
+		cause:       err,
+	}
+}
+
+// NewValidationError creates a new "400" error with the given field errors.
+func NewValidationError(description string, fields ...FieldError) *HandlerError {
+	return &HandlerError{
+		Description: description,
+		Code:        CodeBadRequest,
+		Fields:      fields,
+	}
+}
+
+// WithField appends a field error and returns the same error for chaining.
+func (e *HandlerError) WithField(field, message string) *HandlerError {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+	return e
+}
+
+// WithMeta sets a metadata value and returns the same error for chaining.
+func (e *HandlerError) WithMeta(key, value string) *HandlerError {
+	if e.Meta == nil {
+		e.Meta = make(map[string]string)
+	}
+	e.Meta[key] = value
+	return e
+}
+
+// WithHeader sets a reply header and returns the same error for chaining.
+func (e *HandlerError) WithHeader(key, value string) *HandlerError {
+	if e.Headers == nil {
+		e.Headers = make(micro.Headers)
+	}
+	nats.Header(e.Headers).Set(key, value)
+	return e
+}
+
+// Header of error replies telling the client how many milliseconds to wait
+// before retrying, e.g. when a limiter rejects the request
+const HeaderRetryAfter string = "retry-after-ms"
+
+// WithRetryAfter sets the retry-after header, rounded up to milliseconds,
+// and returns the same error for chaining.
+func (e *HandlerError) WithRetryAfter(d time.Duration) *HandlerError {
+	ms := (d + time.Millisecond - 1) / time.Millisecond
+	if ms < 0 {
+		ms = 0
+	}
+	return e.WithHeader(HeaderRetryAfter, strconv.FormatInt(int64(ms), 10))
+}
+
+// RetryAfter returns how long the service asked the client to wait before
+// retrying, if it did.
+func (e *HandlerError) RetryAfter() (time.Duration, bool) {
+	value := nats.Header(e.Headers).Get(HeaderRetryAfter)
+	if value == "" {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// ErrorTransformer changes a handler error before it is encoded. It should
+// return a new error instead of modifying the given one.
+type ErrorTransformer func(err *HandlerError) *HandlerError
+
+// SanitizeErrors hides the details of server errors (codes "500" and
+// above), e.g. for public endpoints. Only the code and a generic
+// description are kept.
+func SanitizeErrors(err *HandlerError) *HandlerError {
+	if code, convErr := strconv.Atoi(err.Code); convErr == nil && code < 500 {
+		return err
+	}
+	return &HandlerError{
+		Description: "internal error",
+		Code:        err.Code,
+		Headers:     err.Headers,
+		cause:       err,
+	}
+}
+
+// ErrorEncoder serializes a handler error into the code, description, body
+// and headers of the error reply. Errors returned by handlers are converted
+// into a `*HandlerError` first, and the original error can still be found
+// with `errors.Unwrap`.
+type ErrorEncoder func(err *HandlerError) (code, description string, data []byte, headers micro.Headers)
+
+// JSONErrorEncoder is the default encoder, sending the entire error in the
+// body as JSON.
+func JSONErrorEncoder(err *HandlerError) (string, string, []byte, micro.Headers) {
+	data, _ := json.Marshal(err)
+	return err.Code, err.Description, data, err.Headers
+}
+
+// EmptyErrorEncoder only sends the code and description headers, without a body.
+func EmptyErrorEncoder(err *HandlerError) (string, string, []byte, micro.Headers) {
+	return err.Code, err.Description, nil, err.Headers
+}
+
+// Problem details body sent by ProblemJSONErrorEncoder
+type problemDetails struct {
+	Type   string            `json:"type"`
+	Title  string            `json:"title"`
+	Status int               `json:"status,omitempty"`
+	Detail string            `json:"detail,omitempty"`
+	Fields []FieldError      `json:"fields,omitempty"`
+	Meta   map[string]string `json:"meta,omitempty"`
+}
+
+// ProblemJSONErrorEncoder sends the error as RFC 9457 problem details, with
+// the content type "application/problem+json". Field errors and metadata are
+// added as extension members.
+func ProblemJSONErrorEncoder(err *HandlerError) (string, string, []byte, micro.Headers) {
+	status, _ := strconv.Atoi(err.Code)
+	title := http.StatusText(status)
+	if title == "" {
+		title = "Error " + err.Code
+	}
+	data, _ := json.Marshal(problemDetails{
+		Type:   "about:blank",
+		Title:  title,
+		Status: status,
+		Detail: err.Description,
+		Fields: err.Fields,
+		Meta:   err.Meta,
+	})
+
+	headers := nats.Header{}
+	for k, v := range err.Headers {
+		headers[k] = v
+	}
+	headers.Set(HeaderContentType, "application/problem+json")
+	return err.Code, err.Description, data, micro.Headers(headers)
+}
+
+// HandlerErrorFromMsg parses an error reply sent by a natsmicromw service.
+// Returns nil if the message is not an error reply.
+func HandlerErrorFromMsg(msg *nats.Msg) *HandlerError {
+	code := msg.Header.Get(micro.ErrorCodeHeader)
+	if code == "" {
+		return nil
+	}
+
+	// Prefer the full error from the body, fall back to the headers only
+	var handlerErr HandlerError
+	if err := json.Unmarshal(msg.Data, &handlerErr); err != nil || handlerErr.Code == "" {
+		return &HandlerError{
+			Description: msg.Header.Get(micro.ErrorHeader),
+			Code:        code,
+			Headers:     micro.Headers(msg.Header),
+		}
+	}
+	handlerErr.Headers = micro.Headers(msg.Header)
+	return &handlerErr
+}
@@ -0,0 +1,81 @@
+package natsmicromw
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// Error metadata keys of the IDs set by the request ID and tracing
+	// middleware, also used as reply headers
+	ErrorMetaRequestID string = "request_id"
+	ErrorMetaTraceID   string = "trace_id"
+)
+
+// Values added to the error reply of a request, set by middleware
+type errorMeta struct {
+	mu     sync.Mutex
+	keys   []string
+	values map[string]string
+}
+
+type errorMetaContextKey struct{}
+
+func withErrorMeta(ctx context.Context, m *errorMeta) context.Context {
+	return context.WithValue(ctx, errorMetaContextKey{}, m)
+}
+
+// SetErrorMeta records a value identifying the request, e.g. its request
+// or trace ID, which is included in the metadata and the headers of the
+// error reply if the request fails, including when the handler panics.
+// Clients can then quote the ID in bug reports. Values set by inner
+// middleware replace those set before. This has no effect outside context
+// and Micro handlers.
+func SetErrorMeta(ctx context.Context, key, value string) {
+	m, _ := ctx.Value(errorMetaContextKey{}).(*errorMeta)
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values == nil {
+		m.values = make(map[string]string)
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Return a copy of the error with the recorded values, keeping the values
+// already set by the handler
+func (m *errorMeta) annotate(err *HandlerError) *HandlerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.keys) == 0 {
+		return err
+	}
+
+	annotated := *err
+	annotated.Meta = make(map[string]string, len(err.Meta)+len(m.keys))
+	headers := nats.Header{}
+	for k, v := range err.Meta {
+		annotated.Meta[k] = v
+	}
+	for k, v := range err.Headers {
+		headers[k] = v
+	}
+	for _, key := range m.keys {
+		if _, ok := annotated.Meta[key]; !ok {
+			annotated.Meta[key] = m.values[key]
+		}
+		if headers.Get(key) == "" {
+			headers.Set(key, m.values[key])
+		}
+	}
+	annotated.Headers = micro.Headers(headers)
+	return &annotated
+}
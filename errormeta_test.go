@@ -0,0 +1,99 @@
+package natsmicromw
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestErrorMeta(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	nm.SetRecoverPanics(true)
+	srv := nm.WithContextMiddleware(func(next ContextHandlerFunc) ContextHandlerFunc {
+		return func(req *Request) error {
+			SetErrorMeta(req.Context(), ErrorMetaRequestID, "req-1")
+			return next(req)
+		}
+	}).WithMicroMiddleware(func(next MicroHandlerFunc) MicroHandlerFunc {
+		return func(req *MicroRequest) (*MicroReply, error) {
+			SetErrorMeta(req.Context(), ErrorMetaRequestID, "req-1")
+			SetErrorMeta(req.Context(), ErrorMetaTraceID, "trace-1")
+			return next(req)
+		}
+	})
+
+	if err := srv.AddContextEndpoint("error", func(req *Request) error {
+		return errors.New("failed")
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := srv.AddContextEndpoint("own", func(req *Request) error {
+		return Errorf(CodeNotFound, "not found").WithMeta(ErrorMetaRequestID, "own")
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := srv.AddContextEndpoint("ok", func(req *Request) error {
+		return req.Respond(nil)
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := srv.WithErrorTransformer(SanitizeErrors).AddMicroEndpoint("panic", func(req *MicroRequest) (*MicroReply, error) {
+		panic("micro handler")
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("error", func(t *testing.T) {
+		msg, err := nc.Request("error", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		handlerErr := HandlerErrorFromMsg(msg)
+		if handlerErr == nil || handlerErr.Meta[ErrorMetaRequestID] != "req-1" {
+			t.Errorf("request id not in error: %v", handlerErr)
+		}
+		if msg.Header.Get(ErrorMetaRequestID) != "req-1" {
+			t.Errorf("request id not in headers: %v", msg.Header)
+		}
+	})
+
+	t.Run("metadata set by handler", func(t *testing.T) {
+		msg, err := nc.Request("own", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if handlerErr := HandlerErrorFromMsg(msg); handlerErr == nil || handlerErr.Meta[ErrorMetaRequestID] != "own" {
+			t.Errorf("metadata of the handler replaced: %v", handlerErr)
+		}
+	})
+
+	t.Run("sanitized panic", func(t *testing.T) {
+		msg, err := nc.Request("panic", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		handlerErr := HandlerErrorFromMsg(msg)
+		if handlerErr == nil || handlerErr.Description != "internal error" {
+			t.Fatalf("unexpected reply: %v", handlerErr)
+		}
+		if handlerErr.Meta[ErrorMetaRequestID] != "req-1" || handlerErr.Meta[ErrorMetaTraceID] != "trace-1" {
+			t.Errorf("ids not in error: %v", handlerErr.Meta)
+		}
+		if msg.Header.Get(ErrorMetaTraceID) != "trace-1" {
+			t.Errorf("trace id not in headers: %v", msg.Header)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		msg, err := nc.Request("ok", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if msg.Header.Get(ErrorMetaRequestID) != "" {
+			t.Errorf("request id in successful reply: %v", msg.Header)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package natsmicromw
+
+import (
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// HeaderNormalizer returns the canonical form of a header key.
+type HeaderNormalizer func(key string) string
+
+// LowercaseHeaders normalizes header keys to lowercase, e.g. "request_id",
+// the form of the header keys used by this package and the middleware.
+func LowercaseHeaders(key string) string {
+	return strings.ToLower(key)
+}
+
+// SetHeaderNormalizer sets the normalization of header keys, which are case
+// sensitive in NATS. The keys of the request headers are normalized before
+// any middleware runs, so a lookup with the normalized key finds the header
+// regardless of how the client spelled it, and the keys of the reply headers
+// are normalized as well. Values of keys normalized to the same key are
+// merged. This applies to all endpoints, including raw ones. Headers
+// starting with "Nats-", which are reserved for NATS and carry e.g. the
+// service error, are kept as they are. Lookups of headers are exact, and the
+// headers used by the middleware are lowercase, so custom normalizers must
+// keep lowercase keys lowercase.
+func (s *Service) SetHeaderNormalizer(normalize HeaderNormalizer) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headerNormalizer = normalize
+}
+
+// Return a copy of the headers with the keys normalized
+func normalizeHeaders(normalize HeaderNormalizer, headers nats.Header) nats.Header {
+	if headers == nil {
+		return nil
+	}
+	normalized := make(nats.Header, len(headers))
+	for key, values := range headers {
+		if !strings.HasPrefix(key, "Nats-") {
+			key = normalize(key)
+		}
+		normalized[key] = append(normalized[key], values...)
+	}
+	return normalized
+}
+
+// Wrap the handler to normalize the header keys of requests and replies, if
+// enabled
+func normalizeHandler(normalize HeaderNormalizer, handler micro.Handler) micro.Handler {
+	if normalize == nil {
+		return handler
+	}
+	return micro.HandlerFunc(func(req micro.Request) {
+		headers := micro.Headers(normalizeHeaders(normalize, nats.Header(req.Headers())))
+		handler.Handle(&normalizedRequest{req, normalize, headers})
+	})
+}
+
+// Request with normalized header keys in both directions
+type normalizedRequest struct {
+	micro.Request
+	normalize HeaderNormalizer
+	headers   micro.Headers
+}
+
+// Normalize the reply headers, after any other options changed them
+func (r *normalizedRequest) respondOpt() micro.RespondOpt {
+	return func(m *nats.Msg) {
+		m.Header = normalizeHeaders(r.normalize, m.Header)
+	}
+}
+
+func (r *normalizedRequest) Headers() micro.Headers {
+	return r.headers
+}
+
+func (r *normalizedRequest) unwrapRequest() micro.Request { return r.Request }
+
+func (r *normalizedRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Respond(data, append(opts, r.respondOpt())...)
+}
+
+func (r *normalizedRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	return r.Request.RespondJSON(v, append(opts, r.respondOpt())...)
+}
+
+func (r *normalizedRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Error(code, description, data, append(opts, r.respondOpt())...)
+}
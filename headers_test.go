@@ -0,0 +1,78 @@
+package natsmicromw
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestHeaderNormalizer(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	nm.SetHeaderNormalizer(LowercaseHeaders)
+	nm.SetEchoHeaders("tenant")
+
+	if err := nm.AddEndpoint("raw", micro.HandlerFunc(func(req micro.Request) {
+		ids := strings.Join(req.Headers().Values("request_id"), ",")
+		req.Respond([]byte(ids), micro.WithHeaders(micro.Headers{"Reply-Key": []string{"value"}}))
+	})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddMicroEndpoint("micro", func(req *MicroRequest) (*MicroReply, error) {
+		return nil, NewValidationError("invalid " + req.HeaderGet("request_id"))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg := nats.NewMsg("raw")
+	msg.Header["Request_ID"] = []string{"req-1"}
+	msg.Header["Tenant"] = []string{"acme"}
+	reply, err := nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(reply.Data) != "req-1" {
+		t.Errorf("expected normalized request header, received %q", reply.Data)
+	}
+	if reply.Header.Get("reply-key") != "value" || reply.Header.Get("Reply-Key") != "" {
+		t.Errorf("expected normalized reply header, received %v", reply.Header)
+	}
+	if reply.Header.Get("tenant") != "acme" {
+		t.Errorf("expected echoed tenant, received %v", reply.Header)
+	}
+
+	msg = nats.NewMsg("micro")
+	msg.Header["REQUEST_ID"] = []string{"req-2"}
+	reply, err = nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handlerErr := HandlerErrorFromMsg(reply)
+	if handlerErr == nil || handlerErr.Code != CodeBadRequest || handlerErr.Description != "invalid req-2" {
+		t.Errorf("expected bad request error, received %v", handlerErr)
+	}
+}
+
+func TestNormalizeHeaders(t *testing.T) {
+	headers := nats.Header{
+		"Request_Id":      []string{"a"},
+		"request_id":      []string{"b"},
+		micro.ErrorHeader: []string{"failed"},
+		"X-Custom-Key":    []string{"c"},
+	}
+	normalized := normalizeHeaders(LowercaseHeaders, headers)
+	if values := normalized.Values("request_id"); len(values) != 2 {
+		t.Errorf("expected merged values, received %v", normalized)
+	}
+	if normalized.Get(micro.ErrorHeader) != "failed" || normalized.Get("x-custom-key") != "c" {
+		t.Errorf("unexpected headers %v", normalized)
+	}
+	if normalizeHeaders(LowercaseHeaders, nil) != nil {
+		t.Errorf("expected nil headers")
+	}
+}
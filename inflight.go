@@ -0,0 +1,292 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	inFlightSubjectPrefix = "_INFLIGHT"
+	cancelSubjectPrefix   = "_CANCEL"
+)
+
+// ErrRequestCanceled is the cause of the context of requests canceled with
+// [Service.CancelRequest].
+var ErrRequestCanceled = errors.New("natsmicromw: request canceled")
+
+// InFlightRequest is a request being handled, as listed by [Service.InFlight].
+type InFlightRequest struct {
+	Endpoint string    `json:"endpoint"`
+	Subject  string    `json:"subject"`
+	Start    time.Time `json:"start"`
+	// Time since the request was received
+	Age time.Duration `json:"age"`
+	// Request ID recorded with [SetErrorMeta], e.g. by the request ID
+	// middleware, or the `request_id` header of raw endpoints
+	RequestID string `json:"request_id,omitempty"`
+	// Tags recorded so far with [Tag]
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Requests being handled by a service and its clones
+type inFlight struct {
+	mu       sync.Mutex
+	next     uint64
+	requests map[uint64]*inFlightEntry
+}
+
+type inFlightEntry struct {
+	endpoint string
+	subject  string
+	start    time.Time
+	headers  micro.Headers
+	meta     *errorMeta
+	tags     *requestTags
+	load     *requestLoad
+
+	// Handlers still holding the entry, see holdInFlight
+	refs    atomic.Int32
+	release func()
+
+	// Cancels the context of context and Micro handlers
+	mu     sync.Mutex
+	cancel context.CancelCauseFunc
+}
+
+// Headers added to the replies of tracked requests
+type replyHints struct {
+	// Prefix of the tag headers, none if empty
+	tagHeaders string
+	// Load from which the backpressure header is added, none if zero
+	backpressure float64
+}
+
+// Request tracked while it is being handled, so the request ID and tags set
+// by middleware are listed and its context can be canceled
+type inFlightRequest struct {
+	micro.Request
+	entry *inFlightEntry
+	hints replyHints
+}
+
+func (r *inFlightRequest) unwrapRequest() micro.Request {
+	return r.Request
+}
+
+// Add the tags and backpressure hint to the reply if enabled
+func (r *inFlightRequest) respondOpts(opts []micro.RespondOpt) []micro.RespondOpt {
+	if r.hints.tagHeaders != "" {
+		opts = append(opts, r.entry.tags.respondOpt(r.hints.tagHeaders))
+	}
+	if r.hints.backpressure > 0 {
+		opts = append(opts, backpressureOpt(requestBackpressure(r.Request, r.entry.load), r.hints.backpressure))
+	}
+	return opts
+}
+
+func (r *inFlightRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Respond(data, r.respondOpts(opts)...)
+}
+
+func (r *inFlightRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	return r.Request.RespondJSON(v, r.respondOpts(opts)...)
+}
+
+func (r *inFlightRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Error(code, description, data, r.respondOpts(opts)...)
+}
+
+// Wrap the handler to track requests while they are being handled, adding
+// the hints to their replies
+func (f *inFlight) handler(name string, hints replyHints, handler micro.Handler) micro.Handler {
+	return micro.HandlerFunc(func(req micro.Request) {
+		entry := &inFlightEntry{
+			endpoint: name,
+			subject:  req.Subject(),
+			start:    time.Now(),
+			headers:  req.Headers(),
+			meta:     new(errorMeta),
+			tags:     new(requestTags),
+			load:     new(requestLoad),
+		}
+		f.mu.Lock()
+		id := f.next
+		f.next++
+		if f.requests == nil {
+			f.requests = make(map[uint64]*inFlightEntry)
+		}
+		f.requests[id] = entry
+		f.mu.Unlock()
+
+		entry.refs.Store(1)
+		entry.release = func() {
+			if entry.refs.Add(-1) > 0 {
+				return
+			}
+			f.mu.Lock()
+			delete(f.requests, id)
+			f.mu.Unlock()
+		}
+		defer entry.release()
+		handler.Handle(&inFlightRequest{req, entry, hints})
+	})
+}
+
+// Keep the request in flight after its handler returns, e.g. while it is
+// delayed to run later. The returned context is canceled with the request
+// until resume is called, and release must be called once it is handled.
+func holdInFlight(ctx context.Context, req micro.Request) (held context.Context, resume, release func()) {
+	for req != nil {
+		switch r := req.(type) {
+		case *inFlightRequest:
+			entry := r.entry
+			entry.refs.Add(1)
+			ctx, cancel := context.WithCancelCause(ctx)
+			entry.mu.Lock()
+			entry.cancel = cancel
+			entry.mu.Unlock()
+			resume = func() {
+				entry.mu.Lock()
+				entry.cancel = nil
+				entry.mu.Unlock()
+				cancel(nil)
+			}
+			return ctx, resume, entry.release
+		case wrappedRequest:
+			req = r.unwrapRequest()
+		default:
+			req = nil
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, cancel, func() {}
+}
+
+// Return the context of the request with its error metadata, tags, load
+// and chain state, canceled when the request is canceled by its request ID
+// if it is tracked
+func inFlightContext(ctx context.Context, req micro.Request) (context.Context, *errorMeta, context.CancelFunc) {
+	for req != nil {
+		switch r := req.(type) {
+		case *inFlightRequest:
+			ctx, cancel := context.WithCancelCause(ctx)
+			r.entry.mu.Lock()
+			r.entry.cancel = cancel
+			r.entry.mu.Unlock()
+			return requestContext(ctx, r.entry.meta, r.entry.tags, r.entry.load), r.entry.meta, func() { cancel(nil) }
+		case wrappedRequest:
+			req = r.unwrapRequest()
+		default:
+			req = nil
+		}
+	}
+	meta := new(errorMeta)
+	return requestContext(ctx, meta, new(requestTags), new(requestLoad)), meta, func() {}
+}
+
+// Add the per-request state shared by the middleware and handlers
+func requestContext(ctx context.Context, meta *errorMeta, tags *requestTags, load *requestLoad) context.Context {
+	ctx = withLoad(withTags(withErrorMeta(ctx, meta), tags), load)
+	return withChainState(ctx, new(ChainState))
+}
+
+// InFlight returns the requests being handled by the service, oldest
+// first, so operators can see what a hung instance is working on. Requests
+// waiting for a worker of a priority tier are not included.
+func (s *Service) InFlight() []InFlightRequest {
+	now := time.Now()
+	s.inFlight.mu.Lock()
+	requests := make([]InFlightRequest, 0, len(s.inFlight.requests))
+	for _, entry := range s.inFlight.requests {
+		requests = append(requests, InFlightRequest{
+			Endpoint:  entry.endpoint,
+			Subject:   entry.subject,
+			Start:     entry.start,
+			Age:       now.Sub(entry.start),
+			RequestID: entry.requestID(),
+			Tags:      entry.tags.copy(),
+		})
+	}
+	s.inFlight.mu.Unlock()
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Start.Before(requests[j].Start)
+	})
+	return requests
+}
+
+func (e *inFlightEntry) requestID() string {
+	e.meta.mu.Lock()
+	id := e.meta.values[ErrorMetaRequestID]
+	e.meta.mu.Unlock()
+	if id == "" {
+		id = e.headers.Get(ErrorMetaRequestID)
+	}
+	return id
+}
+
+// EnableInFlightEndpoint subscribes a hidden endpoint replying with the
+// requests being handled as a JSON list of [InFlightRequest], see
+// [Service.InFlight]. Like the chain benchmark endpoint, it is not listed in
+// the service info, only serves requests passing the authorizer and
+// listens on "_INFLIGHT.<service name>.<instance ID>", which is returned.
+func (s *Service) EnableInFlightEndpoint(authorize DebugChainAuthorizer) (string, error) {
+	return s.subscribeOps(inFlightSubjectPrefix, authorize, func(req *msgRequest) {
+		_ = req.RespondJSON(s.InFlight())
+	})
+}
+
+// CancelRequest cancels the context of the in-flight requests with the
+// request ID, see [Service.InFlight], so runaway handlers can be aborted
+// without restarting the instance. The cause of the context is
+// [ErrRequestCanceled]. Handlers of raw endpoints have no context and
+// cannot be canceled. Returns the number of requests canceled, none for an
+// empty request ID.
+func (s *Service) CancelRequest(requestID string) int {
+	if requestID == "" {
+		return 0
+	}
+	var canceled int
+	s.inFlight.mu.Lock()
+	defer s.inFlight.mu.Unlock()
+	for _, entry := range s.inFlight.requests {
+		if entry.requestID() != requestID {
+			continue
+		}
+		entry.mu.Lock()
+		if entry.cancel != nil {
+			entry.cancel(ErrRequestCanceled)
+			canceled++
+		}
+		entry.mu.Unlock()
+	}
+	return canceled
+}
+
+// EnableCancelEndpoint subscribes a hidden endpoint canceling the in-flight
+// requests with the request ID in the request body, see
+// [Service.CancelRequest]. It replies with the number of requests canceled
+// as JSON, e.g. `{"canceled":1}`, or a "404" error if none was. Like the
+// in-flight endpoint, it only serves requests passing the authorizer and
+// listens on "_CANCEL.<service name>.<instance ID>", which is returned.
+func (s *Service) EnableCancelEndpoint(authorize DebugChainAuthorizer) (string, error) {
+	return s.subscribeOps(cancelSubjectPrefix, authorize, func(req *msgRequest) {
+		requestID := string(req.Data())
+		if requestID == "" {
+			_ = req.Error(CodeBadRequest, "missing request ID", nil)
+			return
+		}
+		canceled := s.CancelRequest(requestID)
+		if canceled == 0 {
+			_ = req.Error(CodeNotFound, "request not in flight", nil)
+			return
+		}
+		_ = req.RespondJSON(map[string]int{"canceled": canceled})
+	})
+}
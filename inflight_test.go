@@ -0,0 +1,178 @@
+package natsmicromw
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestInFlight(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	requestID := func(next ContextHandlerFunc) ContextHandlerFunc {
+		return func(req *Request) error {
+			SetErrorMeta(req.Context(), ErrorMetaRequestID, "req-1")
+			return next(req)
+		}
+	}
+	release := make(chan struct{})
+	err := nm.UseContext(requestID).AddGroup("jobs").AddContextEndpoint("run", func(req *Request) error {
+		<-release
+		return req.Respond(nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	subject, err := nm.EnableInFlightEndpoint(func(req micro.Request) bool {
+		return req.Headers().Get("debug-token") == "secret"
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := nc.Request("jobs.run", nil, 5*time.Second)
+		done <- err
+	}()
+	for i := 0; len(nm.InFlight()) == 0; i++ {
+		if i > 100 {
+			t.Fatalf("request was not tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	inFlight := nm.InFlight()
+	if len(inFlight) != 1 || inFlight[0].Endpoint != "run" || inFlight[0].Subject != "jobs.run" || inFlight[0].RequestID != "req-1" {
+		t.Errorf("unexpected in-flight requests: %+v", inFlight)
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Header.Set("debug-token", "secret")
+	reply, err := nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var listed []InFlightRequest
+	if err := json.Unmarshal(reply.Data, &listed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(listed) != 1 || listed[0].RequestID != "req-1" || listed[0].Age <= 0 {
+		t.Errorf("unexpected listed requests: %+v", listed)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The reply is sent just before the handler returns
+	for i := 0; len(nm.InFlight()) != 0; i++ {
+		if i > 100 {
+			t.Fatalf("expected no in-flight requests, found %+v", nm.InFlight())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCancelRequest(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	err := nm.AddMicroEndpoint("runaway", func(req *MicroRequest) (*MicroReply, error) {
+		<-req.Context().Done()
+		return nil, context.Cause(req.Context())
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	release := make(chan struct{})
+	err = nm.AddMicroEndpoint("anonymous", func(req *MicroRequest) (*MicroReply, error) {
+		select {
+		case <-release:
+			return NewMicroReply(nil), nil
+		case <-req.Context().Done():
+			return nil, context.Cause(req.Context())
+		}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	subject, err := nm.EnableCancelEndpoint(func(req micro.Request) bool { return true })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan *nats.Msg, 1)
+	go func() {
+		msg := nats.NewMsg("runaway")
+		msg.Header.Set(ErrorMetaRequestID, "req-1")
+		reply, err := nc.RequestMsg(msg, 5*time.Second)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		done <- reply
+	}()
+	for i := 0; len(nm.InFlight()) == 0; i++ {
+		if i > 100 {
+			t.Fatalf("request was not tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Requests without an ID are not matched by an empty one
+	anonymous := make(chan *nats.Msg, 1)
+	go func() {
+		reply, err := nc.Request("anonymous", nil, 5*time.Second)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		anonymous <- reply
+	}()
+	for i := 0; len(nm.InFlight()) < 2; i++ {
+		if i > 100 {
+			t.Fatalf("request was not tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if canceled := nm.CancelRequest(""); canceled != 0 {
+		t.Errorf("expected no canceled requests, received %d", canceled)
+	}
+	select {
+	case reply := <-anonymous:
+		t.Errorf("unexpected reply %q", reply.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	reply, err := nc.Request(subject, []byte("unknown"), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != CodeNotFound {
+		t.Errorf("expected a 404 error, received %v", handlerErr)
+	}
+
+	reply, err = nc.Request(subject, []byte("req-1"), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(reply.Data) != `{"canceled":1}` {
+		t.Errorf("unexpected reply %q", reply.Data)
+	}
+
+	reply = <-done
+	if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Description != ErrRequestCanceled.Error() {
+		t.Errorf("expected the handler to be canceled, received %v", handlerErr)
+	}
+
+	close(release)
+	if handlerErr := HandlerErrorFromMsg(<-anonymous); handlerErr != nil {
+		t.Errorf("expected the request without ID not to be canceled, received %v", handlerErr)
+	}
+}
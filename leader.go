@@ -0,0 +1,138 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// Default KV bucket holding the leader leases
+	DefaultLeaderBucket = "natsmicromw_leaders"
+	// Default lease duration
+	DefaultLeaderTTL = 10 * time.Second
+)
+
+type leaderOpts struct {
+	bucket string
+	ttl    time.Duration
+}
+
+// LeaderOpt configures a leader election.
+type LeaderOpt func(*leaderOpts)
+
+// WithLeaderBucket sets the KV bucket holding the leases.
+func WithLeaderBucket(bucket string) LeaderOpt {
+	return func(o *leaderOpts) {
+		o.bucket = bucket
+	}
+}
+
+// WithLeaderTTL sets the lease duration used when the bucket is created. An
+// existing bucket keeps its own TTL.
+func WithLeaderTTL(ttl time.Duration) LeaderOpt {
+	return func(o *leaderOpts) {
+		o.ttl = ttl
+	}
+}
+
+// RunWhenLeader runs the function on only one instance of the service at a
+// time, e.g. for migrations or schedulers. The instances compete for a lease
+// in a KV bucket, keyed by the service and election name. The leader renews
+// the lease every third of the TTL; if it fails to do so, the context of the
+// function is cancelled and the lease expires, letting another instance take
+// over. When the function returns, the lease is released and this instance
+// stops competing. It runs as a background task of the service (see [Go]),
+// so it is also cancelled when the service is stopped.
+func (s *Service) RunWhenLeader(name string, fn func(ctx context.Context) error, opts ...LeaderOpt) error {
+	o := leaderOpts{bucket: DefaultLeaderBucket, ttl: DefaultLeaderTTL}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	js, err := s.nc.JetStream()
+	if err != nil {
+		return err
+	}
+	kv, err := js.KeyValue(o.bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: o.bucket, TTL: o.ttl})
+	}
+	if err != nil {
+		return err
+	}
+
+	ttl := o.ttl
+	if status, err := kv.Status(); err == nil && status.TTL() > 0 {
+		ttl = status.TTL()
+	}
+
+	info := s.svc.Info()
+	e := &election{
+		kv:       kv,
+		key:      info.Name + "." + name,
+		id:       []byte(info.ID),
+		interval: ttl / 3,
+	}
+	s.Go(func(ctx context.Context) error {
+		return e.run(ctx, fn)
+	})
+	return nil
+}
+
+type election struct {
+	kv       nats.KeyValue
+	key      string
+	id       []byte
+	interval time.Duration
+}
+
+// Compete for the lease until the function has run to completion as leader
+func (e *election) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if rev, err := e.kv.Create(e.key, e.id); err == nil {
+			if done, err := e.lead(ctx, ticker, rev, fn); done {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run the function while renewing the lease. Returns false if the lease was
+// lost before the function returned.
+func (e *election) lead(ctx context.Context, ticker *time.Ticker, rev uint64, fn func(ctx context.Context) error) (bool, error) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- fn(leaderCtx)
+	}()
+
+	for {
+		select {
+		case err := <-result:
+			_ = e.kv.Delete(e.key, nats.LastRevision(rev))
+			return true, err
+		case <-ticker.C:
+			var err error
+			if rev, err = e.kv.Update(e.key, e.id, rev); err != nil {
+				// Another instance may take over once the lease expires
+				cancel()
+				<-result
+				return false, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package natsmicromw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestRunWhenLeader(t *testing.T) {
+	opts := &server.Options{Host: "localhost", Port: server.RANDOM_PORT, NoSigs: true, JetStream: true, StoreDir: t.TempDir()}
+	s, err := runServer(opts)
+	if err != nil {
+		t.Fatalf("Could not start NATS server: %v", err)
+	}
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	leaders := make(chan int, 2)
+	services := make([]*Service, 2)
+	for i := range services {
+		i := i
+		nm, err := AddService(nc, micro.Config{
+			Name:    "TestService",
+			Version: "1.0.0",
+		})
+		if err != nil {
+			t.Fatalf("Could not create micro service: %v", err)
+		}
+		services[i] = nm
+
+		err = nm.RunWhenLeader("migrate", func(ctx context.Context) error {
+			leaders <- i
+			<-ctx.Done()
+			return ctx.Err()
+		}, WithLeaderTTL(time.Second))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var leader int
+	select {
+	case leader = <-leaders:
+	case <-time.After(time.Second):
+		t.Fatal("no leader was elected")
+	}
+	select {
+	case other := <-leaders:
+		t.Fatalf("both instances are leaders: %d and %d", leader, other)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Stopping the leader releases the lease to the other instance
+	services[leader].Stop()
+	select {
+	case next := <-leaders:
+		if next == leader {
+			t.Errorf("stopped instance became leader again")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("leadership did not fail over")
+	}
+	services[1-leader].Stop()
+}
@@ -0,0 +1,56 @@
+package natsmicromw
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// Header identifying the workflow a message belongs to, the same for all
+	// requests made while handling it
+	HeaderCorrelationID string = "correlation-id"
+	// Header identifying the request whose handler sent the message
+	HeaderCausationID string = "causation-id"
+)
+
+// Lineage places a request within a workflow of requests, to reconstruct
+// which request caused which.
+type Lineage struct {
+	// ID of the whole workflow, taken from the first request
+	CorrelationID string
+	// ID of the request that caused this one, empty for the first request
+	CausationID string
+	// ID of this request, the cause of the requests made while handling it
+	MessageID string
+}
+
+type lineageContextKey struct{}
+
+// WithLineage returns a context carrying the lineage of the request, set by
+// the correlation middleware.
+func WithLineage(ctx context.Context, l Lineage) context.Context {
+	return context.WithValue(ctx, lineageContextKey{}, l)
+}
+
+// LineageFromContext returns the lineage of the request, if any.
+func LineageFromContext(ctx context.Context) (Lineage, bool) {
+	l, ok := ctx.Value(lineageContextKey{}).(Lineage)
+	return l, ok
+}
+
+// InjectLineage sets the lineage headers of a message sent while handling
+// the request of the context: the same correlation ID, and the ID of the
+// request as the causation ID. Headers already set are kept.
+func InjectLineage(ctx context.Context, headers nats.Header) {
+	l, ok := LineageFromContext(ctx)
+	if !ok {
+		return
+	}
+	if headers.Get(HeaderCorrelationID) == "" && l.CorrelationID != "" {
+		headers.Set(HeaderCorrelationID, l.CorrelationID)
+	}
+	if headers.Get(HeaderCausationID) == "" && l.MessageID != "" {
+		headers.Set(HeaderCausationID, l.MessageID)
+	}
+}
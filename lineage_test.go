@@ -0,0 +1,30 @@
+package natsmicromw
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestInjectLineage(t *testing.T) {
+	headers := nats.Header{}
+	InjectLineage(context.Background(), headers)
+	if len(headers) != 0 {
+		t.Errorf("expected no headers without a lineage, got %v", headers)
+	}
+
+	ctx := WithLineage(context.Background(), Lineage{CorrelationID: "workflow", CausationID: "cause", MessageID: "req-1"})
+	InjectLineage(ctx, headers)
+	if headers.Get(HeaderCorrelationID) != "workflow" || headers.Get(HeaderCausationID) != "req-1" {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+
+	// Headers set by the caller are kept
+	headers = nats.Header{}
+	headers.Set(HeaderCausationID, "other")
+	InjectLineage(ctx, headers)
+	if headers.Get(HeaderCausationID) != "other" {
+		t.Errorf("causation id replaced: %v", headers)
+	}
+}
@@ -0,0 +1,134 @@
+package natsmicromw
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ErrMaintenance is the description of maintenance replies without a message.
+var ErrMaintenance = errors.New("service under maintenance")
+
+const maintenanceSubjectPrefix = "_MAINTENANCE"
+
+// Maintenance mode of a service, shared by all its clones. Nil when off.
+type maintenance struct {
+	state atomic.Pointer[maintenanceState]
+}
+
+type maintenanceState struct {
+	message string
+	// Names of the endpoints under maintenance, all if empty
+	endpoints map[string]bool
+}
+
+// Return the maintenance message if the endpoint is under maintenance
+func (m *maintenance) check(name string) (string, bool) {
+	state := m.state.Load()
+	if state == nil || (len(state.endpoints) > 0 && !state.endpoints[name]) {
+		return "", false
+	}
+	return state.message, true
+}
+
+// SetMaintenance switches the maintenance mode of the service on or off. In
+// maintenance, requests are immediately replied to with a "503" error with
+// the message as description, without calling the middleware or handler.
+// The endpoints stay subscribed, so deploys and migrations can quiesce the
+// traffic and resume it without clients seeing missing responders. When
+// endpoint names are given, only those are put in maintenance. Unlike the
+// other settings, this takes effect immediately on all endpoints of the
+// service and its clones, and can be changed at any time, e.g. from an
+// admin endpoint, see [Service.EnableMaintenanceEndpoint].
+func (s *Service) SetMaintenance(enabled bool, message string, endpoints ...string) {
+	if !enabled {
+		s.maintenance.state.Store(nil)
+		return
+	}
+	if message == "" {
+		message = ErrMaintenance.Error()
+	}
+	state := &maintenanceState{message: message}
+	if len(endpoints) > 0 {
+		state.endpoints = make(map[string]bool, len(endpoints))
+		for _, name := range endpoints {
+			state.endpoints[name] = true
+		}
+	}
+	s.maintenance.state.Store(state)
+}
+
+// Maintenance returns whether the service is in maintenance and its message.
+func (s *Service) Maintenance() (bool, string) {
+	state := s.maintenance.state.Load()
+	if state == nil {
+		return false, ""
+	}
+	return true, state.message
+}
+
+// MaintenanceMode is the body of requests to the maintenance endpoint
+// switching the mode, and of its replies with the current mode, see
+// [Service.EnableMaintenanceEndpoint].
+type MaintenanceMode struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+	// Names of the endpoints under maintenance, all if empty
+	Endpoints []string `json:"endpoints,omitempty"`
+}
+
+// Current maintenance mode of the service
+func (s *Service) maintenanceMode() MaintenanceMode {
+	state := s.maintenance.state.Load()
+	if state == nil {
+		return MaintenanceMode{}
+	}
+	mode := MaintenanceMode{Enabled: true, Message: state.message}
+	for name := range state.endpoints {
+		mode.Endpoints = append(mode.Endpoints, name)
+	}
+	sort.Strings(mode.Endpoints)
+	return mode
+}
+
+// EnableMaintenanceEndpoint subscribes a hidden endpoint for switching the
+// maintenance mode at runtime, e.g. from deploy tooling. Requests without a
+// body are replied with the current [MaintenanceMode], and requests with a
+// [MaintenanceMode] switch it with [Service.SetMaintenance] before replying.
+// The endpoint itself is not affected by the maintenance mode. Like the
+// worker pools endpoint, it only serves requests passing the authorizer and
+// listens on "_MAINTENANCE.<service name>.<instance ID>", which is returned.
+func (s *Service) EnableMaintenanceEndpoint(authorize DebugChainAuthorizer) (string, error) {
+	return s.subscribeOps(maintenanceSubjectPrefix, authorize, func(req *msgRequest) {
+		if len(req.Data()) > 0 {
+			var mode MaintenanceMode
+			if err := json.Unmarshal(req.Data(), &mode); err != nil {
+				_ = req.Error(CodeBadRequest, "invalid request: "+err.Error(), nil)
+				return
+			}
+			s.SetMaintenance(mode.Enabled, mode.Message, mode.Endpoints...)
+		}
+		_ = req.RespondJSON(s.maintenanceMode())
+	})
+}
+
+// Wrap the handler to reply with a maintenance error while it is enabled
+func (s *Service) maintenanceHandler(name string, handler micro.Handler) micro.Handler {
+	m, encodeError := s.maintenance, s.errorReply()
+	return micro.HandlerFunc(func(req micro.Request) {
+		message, ok := m.check(name)
+		if !ok {
+			handler.Handle(req)
+			return
+		}
+		code, description, data, headers := encodeError(&HandlerError{
+			Description: message,
+			Code:        CodeUnavailable,
+			cause:       ErrMaintenance,
+		}, new(errorMeta))
+		_ = req.Error(code, description, data, micro.WithHeaders(headers))
+	})
+}
@@ -0,0 +1,112 @@
+package natsmicromw
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestMaintenance(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	if err := nm.AddContextEndpoint("context", func(req *Request) error {
+		return req.Respond([]byte("ok"))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddGroup("grp").AddEndpoint("raw", micro.HandlerFunc(func(req micro.Request) {
+		req.Respond([]byte("ok"))
+	})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Check the reply of each subject, an empty description for success
+	expect := func(t *testing.T, expected map[string]string) {
+		t.Helper()
+		for subject, description := range expected {
+			reply, err := nc.Request(subject, nil, time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			handlerErr := HandlerErrorFromMsg(reply)
+			if description == "" {
+				if handlerErr != nil {
+					t.Errorf("unexpected error from %s: %v", subject, handlerErr)
+				}
+				continue
+			}
+			if handlerErr == nil || handlerErr.Code != CodeUnavailable || handlerErr.Description != description {
+				t.Errorf("expected maintenance error from %s, received %v", subject, handlerErr)
+			}
+		}
+	}
+
+	t.Run("all endpoints", func(t *testing.T) {
+		nm.SetMaintenance(true, "")
+		if enabled, message := nm.Maintenance(); !enabled || message != ErrMaintenance.Error() {
+			t.Errorf("unexpected maintenance state: %v %q", enabled, message)
+		}
+		expect(t, map[string]string{"context": ErrMaintenance.Error(), "grp.raw": ErrMaintenance.Error()})
+	})
+
+	t.Run("selected endpoints", func(t *testing.T) {
+		nm.SetMaintenance(true, "migrating", "raw")
+		expect(t, map[string]string{"context": "", "grp.raw": "migrating"})
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		nm.SetMaintenance(false, "")
+		if enabled, _ := nm.Maintenance(); enabled {
+			t.Errorf("maintenance still enabled")
+		}
+		expect(t, map[string]string{"context": "", "grp.raw": ""})
+	})
+
+	t.Run("endpoint", func(t *testing.T) {
+		subject, err := nm.EnableMaintenanceEndpoint(func(req micro.Request) bool {
+			return req.Headers().Get("debug-token") == "secret"
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		request := func(token, body string) *nats.Msg {
+			msg := nats.NewMsg(subject)
+			msg.Header.Set("debug-token", token)
+			msg.Data = []byte(body)
+			reply, err := nc.RequestMsg(msg, time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return reply
+		}
+
+		if handlerErr := HandlerErrorFromMsg(request("wrong", `{"enabled":true}`)); handlerErr == nil || handlerErr.Code != CodeForbidden {
+			t.Fatalf("expected a forbidden error, received %v", handlerErr)
+		}
+		if enabled, _ := nm.Maintenance(); enabled {
+			t.Errorf("unauthorized request enabled maintenance")
+		}
+
+		var mode MaintenanceMode
+		if err := json.Unmarshal(request("secret", `{"enabled":true,"message":"migrating","endpoints":["raw"]}`).Data, &mode); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !mode.Enabled || mode.Message != "migrating" || len(mode.Endpoints) != 1 || mode.Endpoints[0] != "raw" {
+			t.Errorf("unexpected maintenance mode: %+v", mode)
+		}
+		expect(t, map[string]string{"context": "", "grp.raw": "migrating"})
+
+		// Without a body, the mode is only listed
+		if err := json.Unmarshal(request("secret", "").Data, &mode); err != nil || !mode.Enabled {
+			t.Errorf("unexpected maintenance mode: %+v, %v", mode, err)
+		}
+		request("secret", `{"enabled":false}`)
+		expect(t, map[string]string{"context": "", "grp.raw": ""})
+	})
+}
@@ -3,13 +3,36 @@
 package natsmicromw
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
 )
 
+const (
+	HeaderContentLength string = "content-length"
+	HeaderContentSHA256 string = "content-sha256"
+)
+
+// ReplyStamping selects headers that are automatically added to replies.
+type ReplyStamping struct {
+	// Content type for replies that do not define one
+	ContentType string
+	// Add the payload size
+	ContentLength bool
+	// Add a hex-encoded SHA-256 checksum of the payload
+	Checksum bool
+}
+
 type MicroReply struct {
 	Headers micro.Headers
 	Data    []byte
+
+	// Set for replies sent as errors, see NewMicroError
+	errCode        string
+	errDescription string
 }
 
 // Create a new MicroReply
@@ -20,6 +43,61 @@ func NewMicroReply(data []byte) *MicroReply {
 	}
 }
 
+// NewMicroReplyWithCodec encodes the value with the codec into a new
+// MicroReply, setting the content type header.
+func NewMicroReplyWithCodec(codec Codec, v any) (*MicroReply, error) {
+	msg := nats.NewMsg("")
+	if err := EncodeMsg(codec, v, msg); err != nil {
+		return nil, err
+	}
+	return &MicroReply{
+		Headers: micro.Headers(msg.Header),
+		Data:    msg.Data,
+	}, nil
+}
+
+// NewMicroError creates a reply that is sent as an error reply, with the
+// code and description in the `Nats-Service-Error-Code` and
+// `Nats-Service-Error` headers. Unlike a returned error, the data and headers
+// are sent as they are instead of being encoded by the error encoder, so Micro
+// handlers have full control over error replies. Middleware sees it as a
+// reply, check [MicroReply.ServiceError] to tell it apart. An empty
+// description, which micro does not allow, is replaced by the code.
+func NewMicroError(code, description string, data []byte, headers micro.Headers) *MicroReply {
+	if description == "" {
+		description = code
+	}
+	if headers == nil {
+		headers = micro.Headers{}
+	}
+	return &MicroReply{
+		Headers:        headers,
+		Data:           data,
+		errCode:        code,
+		errDescription: description,
+	}
+}
+
+// ServiceError returns the code and description of a reply created with
+// [NewMicroError], ok is false for other replies.
+func (r *MicroReply) ServiceError() (code, description string, ok bool) {
+	return r.errCode, r.errDescription, r.errCode != ""
+}
+
+// Clone returns a deep copy of the reply, e.g. for sharing a reply between
+// requests whose middleware may modify it.
+func (r *MicroReply) Clone() *MicroReply {
+	c := *r
+	if r.Headers != nil {
+		c.Headers = make(micro.Headers, len(r.Headers))
+		for k, v := range r.Headers {
+			c.Headers[k] = append([]string(nil), v...)
+		}
+	}
+	c.Data = append([]byte(nil), r.Data...)
+	return &c
+}
+
 // Create a new MicroReply and copy headers from the original request
 func NewMicroReplyFromRequest(data []byte, req *MicroRequest) *MicroReply {
 	h := nats.Header{}
@@ -66,3 +144,17 @@ func (r *MicroReply) HeaderDel(key string) {
 	delete(h, key)
 	r.Headers = micro.Headers(h)
 }
+
+// Add the selected headers to the reply
+func (r *MicroReply) stamp(stamping ReplyStamping) {
+	if stamping.ContentType != "" && r.HeaderGet(HeaderContentType) == "" {
+		r.HeaderSet(HeaderContentType, stamping.ContentType)
+	}
+	if stamping.ContentLength {
+		r.HeaderSet(HeaderContentLength, strconv.Itoa(len(r.Data)))
+	}
+	if stamping.Checksum {
+		sum := sha256.Sum256(r.Data)
+		r.HeaderSet(HeaderContentSHA256, hex.EncodeToString(sum[:]))
+	}
+}
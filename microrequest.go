@@ -4,7 +4,9 @@
 package natsmicromw
 
 import (
+	"bytes"
 	"context"
+	"io"
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
@@ -17,6 +19,8 @@ type MicroRequest struct {
 	Data    []byte
 
 	ctx context.Context
+	// Streamed payload, replacing Data until read with ReadData
+	body io.Reader
 }
 
 // Create a new MicroRequest from an incoming `micro.Request`
@@ -43,9 +47,52 @@ func (r *MicroRequest) WithContext(ctx context.Context) *MicroRequest {
 		r.Headers,
 		r.Data,
 		ctx,
+		r.body,
 	}
 }
 
+// Body returns a reader over the payload. For a streamed payload (see
+// [WithBody]) it is the stream itself, which can only be read once.
+// Otherwise it reads from Data without copying it.
+func (r *MicroRequest) Body() io.Reader {
+	if r.body != nil {
+		return r.body
+	}
+	return bytes.NewReader(r.Data)
+}
+
+// WithBody returns a new MicroRequest whose payload is streamed from the
+// reader, e.g. a decompressor, so that later middleware and codecs can
+// process it incrementally. Data is empty until the payload is read into it
+// with [ReadData], so middleware depending on the payload, such as cache
+// keys or authorization, must call [ReadData] before using Data.
+func (r *MicroRequest) WithBody(body io.Reader) *MicroRequest {
+	nr := r.WithContext(r.ctx)
+	nr.Data = nil
+	nr.body = body
+	return nr
+}
+
+// Streamed reports whether the payload is still a stream not read into Data.
+func (r *MicroRequest) Streamed() bool {
+	return r.body != nil
+}
+
+// ReadData reads a streamed payload into Data, for handlers that need the
+// whole payload. Does nothing if the payload is not streamed.
+func (r *MicroRequest) ReadData() ([]byte, error) {
+	if r.body == nil {
+		return r.Data, nil
+	}
+	data, err := io.ReadAll(r.body)
+	if err != nil {
+		return nil, err
+	}
+	r.Data = data
+	r.body = nil
+	return data, nil
+}
+
 func (r *MicroRequest) HeaderAdd(key, value string) {
 	h := nats.Header(r.Headers)
 	if h == nil {
@@ -80,3 +127,21 @@ func (r *MicroRequest) HeaderDel(key string) {
 	delete(h, key)
 	r.Headers = micro.Headers(h)
 }
+
+// Build a message view of the request, sharing the headers and data
+func (r *MicroRequest) msg() *nats.Msg {
+	return &nats.Msg{
+		Subject: r.Subject,
+		Reply:   r.Reply,
+		Header:  nats.Header(r.Headers),
+		Data:    r.Data,
+	}
+}
+
+// ToMsg returns a copy of the request as a `*nats.Msg`, including the reply
+// subject and headers, e.g. for libraries expecting one. Changes to the
+// message do not affect the request. For a streamed request, call
+// `ReadData` first, as the unread body is not included.
+func (r *MicroRequest) ToMsg() *nats.Msg {
+	return copyMsg(r.msg())
+}
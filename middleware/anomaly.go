@@ -0,0 +1,207 @@
+// Example anomaly detection middleware for natsmicromw
+
+package middleware
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+type AnomalyMetric string
+
+const (
+	AnomalyLatency   AnomalyMetric = "latency"
+	AnomalyErrorRate AnomalyMetric = "error_rate"
+)
+
+// Anomaly describes an interval whose value deviated from the baseline.
+type Anomaly struct {
+	Subject  string        `json:"subject"`
+	Metric   AnomalyMetric `json:"metric"`
+	Value    float64       `json:"value"`
+	Baseline float64       `json:"baseline"`
+	StdDev   float64       `json:"stddev"`
+	Sigma    float64       `json:"sigma"`
+	Time     time.Time     `json:"time"`
+}
+
+type AnomalyOptions struct {
+	// Requests are aggregated per interval, each interval is compared
+	// against the baseline. Defaults to 10 seconds.
+	Interval time.Duration
+	// Deviation in standard deviations that counts as an anomaly, defaults to 3
+	Sigma float64
+	// Weight of the latest interval in the rolling baseline, defaults to 0.1
+	Alpha float64
+	// Number of intervals before anomalies are reported, defaults to 30
+	MinSamples int
+	// OnAnomaly is called for each detected anomaly
+	OnAnomaly func(a Anomaly)
+	// If set, anomalies are also published as JSON to the subject
+	Conn    *nats.Conn
+	Subject string
+}
+
+// Exponentially weighted mean and variance
+type ewma struct {
+	mean, variance float64
+	samples        int
+}
+
+func (e *ewma) update(value, alpha float64) {
+	if e.samples == 0 {
+		e.mean = value
+	} else {
+		diff := value - e.mean
+		incr := alpha * diff
+		e.mean += incr
+		e.variance = (1 - alpha) * (e.variance + diff*incr)
+	}
+	e.samples++
+}
+
+type anomalyEndpoint struct {
+	start    time.Time
+	requests int64
+	errors   int64
+	latency  time.Duration
+
+	latencyBase ewma
+	errorBase   ewma
+}
+
+// AnomalyDetector keeps rolling baselines of per-endpoint latency and error
+// rate and reports intervals that deviate from them.
+type AnomalyDetector struct {
+	opts AnomalyOptions
+	now  func() time.Time
+
+	mu        sync.Mutex
+	endpoints map[string]*anomalyEndpoint
+}
+
+// NewAnomalyDetector creates a new detector, use it with `AnomalyMiddleware`
+// or `AnomalyMicroMiddleware`.
+func NewAnomalyDetector(opts AnomalyOptions) *AnomalyDetector {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.Sigma <= 0 {
+		opts.Sigma = 3
+	}
+	if opts.Alpha <= 0 || opts.Alpha > 1 {
+		opts.Alpha = 0.1
+	}
+	if opts.MinSamples <= 0 {
+		opts.MinSamples = 30
+	}
+	return &AnomalyDetector{
+		opts:      opts,
+		now:       time.Now,
+		endpoints: make(map[string]*anomalyEndpoint),
+	}
+}
+
+// Compare the value to the baseline and update it, must be called with the lock held.
+// The floor keeps a perfectly stable baseline (e.g. no errors at all) from
+// reporting every small change as an anomaly, while still detecting spikes.
+func (d *AnomalyDetector) check(subject string, metric AnomalyMetric, base *ewma, value, floor float64, now time.Time) *Anomaly {
+	var anomaly *Anomaly
+	if base.samples >= d.opts.MinSamples {
+		stddev := math.Max(math.Sqrt(base.variance), floor)
+		if stddev > 0 && math.Abs(value-base.mean) > d.opts.Sigma*stddev {
+			anomaly = &Anomaly{
+				Subject:  subject,
+				Metric:   metric,
+				Value:    value,
+				Baseline: base.mean,
+				StdDev:   stddev,
+				Sigma:    math.Abs(value-base.mean) / stddev,
+				Time:     now,
+			}
+		}
+	}
+	base.update(value, d.opts.Alpha)
+	return anomaly
+}
+
+// Record the outcome of a request. Intervals are closed lazily by the
+// first request after the interval ended.
+func (d *AnomalyDetector) Record(subject string, duration time.Duration, failed bool) {
+	now := d.now()
+	var anomalies []Anomaly
+
+	d.mu.Lock()
+	ep, ok := d.endpoints[subject]
+	if !ok {
+		ep = &anomalyEndpoint{start: now}
+		d.endpoints[subject] = ep
+	}
+
+	if now.Sub(ep.start) >= d.opts.Interval && ep.requests > 0 {
+		latency := float64(ep.latency) / float64(ep.requests)
+		errorRate := float64(ep.errors) / float64(ep.requests)
+		if a := d.check(subject, AnomalyLatency, &ep.latencyBase, latency, ep.latencyBase.mean*0.01, now); a != nil {
+			anomalies = append(anomalies, *a)
+		}
+		if a := d.check(subject, AnomalyErrorRate, &ep.errorBase, errorRate, 0.01, now); a != nil {
+			anomalies = append(anomalies, *a)
+		}
+		ep.start = now
+		ep.requests, ep.errors, ep.latency = 0, 0, 0
+	}
+
+	ep.requests++
+	ep.latency += duration
+	if failed {
+		ep.errors++
+	}
+	d.mu.Unlock()
+
+	for _, a := range anomalies {
+		if d.opts.OnAnomaly != nil {
+			d.opts.OnAnomaly(a)
+		}
+		if d.opts.Conn != nil && d.opts.Subject != "" {
+			if data, err := json.Marshal(a); err == nil {
+				_ = d.opts.Conn.Publish(d.opts.Subject, data)
+			}
+		}
+	}
+}
+
+// Middleware that records each request in the anomaly detector
+func AnomalyMiddleware(d *AnomalyDetector) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			start := time.Now()
+			err := next(req)
+			d.Record(req.Subject(), time.Since(start), isServerError(req.Context(), replyError(req.Context(), nil, err)))
+			return err
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func AnomalyMicroMiddleware(d *AnomalyDetector) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			start := time.Now()
+			reply, err := next(req)
+			d.Record(req.Subject, time.Since(start), isServerError(req.Context(), replyError(req.Context(), reply, err)))
+			return reply, err
+		}
+	}
+}
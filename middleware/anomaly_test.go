@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnomalyDetector(t *testing.T) {
+	var anomalies []Anomaly
+	d := NewAnomalyDetector(AnomalyOptions{
+		Interval:   time.Second,
+		MinSamples: 10,
+		OnAnomaly: func(a Anomaly) {
+			anomalies = append(anomalies, a)
+		},
+	})
+
+	now := time.Unix(0, 0)
+	d.now = func() time.Time { return now }
+
+	// Build a baseline of slightly varying latencies without errors
+	for i := 0; i < 20; i++ {
+		d.Record("orders", time.Duration(10+i%3)*time.Millisecond, false)
+		now = now.Add(time.Second)
+	}
+	if len(anomalies) != 0 {
+		t.Fatalf("unexpected anomalies: %+v", anomalies)
+	}
+
+	// A much slower interval, reported when the next interval starts
+	d.Record("orders", 500*time.Millisecond, false)
+	now = now.Add(time.Second)
+	d.Record("orders", 10*time.Millisecond, false)
+
+	if len(anomalies) != 1 {
+		t.Fatalf("expected a single anomaly, got %+v", anomalies)
+	}
+	if anomalies[0].Metric != AnomalyLatency || anomalies[0].Subject != "orders" {
+		t.Errorf("unexpected anomaly: %+v", anomalies[0])
+	}
+
+	// Errors on a baseline without any errors
+	anomalies = nil
+	for i := 0; i < 20; i++ {
+		d.Record("billing", 10*time.Millisecond, false)
+		now = now.Add(time.Second)
+	}
+	d.Record("billing", 10*time.Millisecond, true)
+	now = now.Add(time.Second)
+	d.Record("billing", 10*time.Millisecond, false)
+
+	if len(anomalies) != 1 || anomalies[0].Metric != AnomalyErrorRate {
+		t.Errorf("expected an error rate anomaly, got %+v", anomalies)
+	}
+}
@@ -0,0 +1,147 @@
+// Example API key authentication middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	HeaderAPIKey string = "x-api-key"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// APIKey is the metadata associated with a key.
+type APIKey struct {
+	ID       string            `json:"id"`
+	Owner    string            `json:"owner"`
+	Tier     string            `json:"tier,omitempty"`
+	Disabled bool              `json:"disabled,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// APIKeyStore looks up the metadata of a key. Implementations return
+// `ErrAPIKeyNotFound` for unknown keys, a nil key without an error is
+// treated the same.
+type APIKeyStore interface {
+	Lookup(ctx context.Context, key string) (*APIKey, error)
+}
+
+// APIKeyStoreFunc is an adapter to use ordinary functions as key stores.
+type APIKeyStoreFunc func(ctx context.Context, key string) (*APIKey, error)
+
+func (f APIKeyStoreFunc) Lookup(ctx context.Context, key string) (*APIKey, error) {
+	return f(ctx, key)
+}
+
+// StaticAPIKeys is a fixed set of keys.
+type StaticAPIKeys map[string]APIKey
+
+func (s StaticAPIKeys) Lookup(ctx context.Context, key string) (*APIKey, error) {
+	apiKey, ok := s[key]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	return &apiKey, nil
+}
+
+// KVAPIKeys looks up keys from a JetStream key-value bucket. Entries are
+// JSON encoded `APIKey` values stored under the hex SHA-256 of the key, so
+// the bucket never contains the keys themselves.
+type KVAPIKeys struct {
+	KV nats.KeyValue
+}
+
+// HashAPIKey returns the bucket key for an API key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s KVAPIKeys) Lookup(ctx context.Context, key string) (*APIKey, error) {
+	entry, err := s.KV.Get(HashAPIKey(key))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, ErrAPIKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var apiKey APIKey
+	if err := json.Unmarshal(entry.Value(), &apiKey); err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+type apiKeyContextKey struct{}
+
+// APIKeyFromContext returns the metadata of the validated key, so that
+// downstream middleware (e.g. rate limiting) can use the owner and tier.
+func APIKeyFromContext(ctx context.Context) *APIKey {
+	apiKey, _ := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return apiKey
+}
+
+// Validate the key and return a context with its metadata
+func validateAPIKey(ctx context.Context, store APIKeyStore, key string) (context.Context, error) {
+	if key == "" {
+		return nil, &natsmicromw.HandlerError{
+			Description: "missing api key",
+			Code:        natsmicromw.CodeUnauthorized,
+		}
+	}
+
+	apiKey, err := store.Lookup(ctx, key)
+	if errors.Is(err, ErrAPIKeyNotFound) || (err == nil && (apiKey == nil || apiKey.Disabled)) {
+		return nil, &natsmicromw.HandlerError{
+			Description: "invalid api key",
+			Code:        natsmicromw.CodeUnauthorized,
+		}
+	} else if err != nil {
+		// The store error is only kept as the cause, e.g. for logging
+		return nil, natsmicromw.WrapError(natsmicromw.CodeUnavailable, "api key store unavailable", err)
+	}
+
+	StateIdentity.Set(ctx, apiKey.ID)
+	return context.WithValue(ctx, apiKeyContextKey{}, apiKey), nil
+}
+
+// APIKeyMiddleware validates the `x-api-key` header against the store and
+// stores the key metadata in the request context. Unknown and disabled keys
+// are rejected with a 401 error, and store failures with a generic 503
+// error wrapping the store error.
+func APIKeyMiddleware(store APIKeyStore) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			ctx, err := validateAPIKey(req.Context(), store, req.Headers().Get(HeaderAPIKey))
+			if err != nil {
+				return err
+			}
+			return next(req.WithContext(ctx))
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func APIKeyMicroMiddleware(store APIKeyStore) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			ctx, err := validateAPIKey(req.Context(), store, req.HeaderGet(HeaderAPIKey))
+			if err != nil {
+				return nil, err
+			}
+			return next(req.WithContext(ctx))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	store := StaticAPIKeys{
+		"good":     {ID: "1", Owner: "orders", Tier: "gold"},
+		"disabled": {ID: "2", Owner: "billing", Disabled: true},
+	}
+	nm = nm.UseMicro(APIKeyMicroMiddleware(store))
+	err := nm.AddMicroEndpoint("owner", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return &natsmicromw.MicroReply{Data: []byte(APIKeyFromContext(req.Context()).Owner)}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := func(key string) *nats.Msg {
+		msg := nats.NewMsg("owner")
+		if key != "" {
+			msg.Header.Set(HeaderAPIKey, key)
+		}
+		reply, err := nc.RequestMsg(msg, 1*time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return reply
+	}
+
+	reply := request("good")
+	if herr := natsmicromw.HandlerErrorFromMsg(reply); herr != nil {
+		t.Fatalf("unexpected error: %v", herr)
+	}
+	if string(reply.Data) != "orders" {
+		t.Errorf("owner does not match, expected %s, received %s", "orders", reply.Data)
+	}
+
+	for _, key := range []string{"", "bad", "disabled"} {
+		if herr := natsmicromw.HandlerErrorFromMsg(request(key)); herr == nil || herr.Code != "401" {
+			t.Errorf("expected a 401 error for key %q, got %v", key, herr)
+		}
+	}
+}
+
+func TestAPIKeyStoreFailures(t *testing.T) {
+	errStore := errors.New("connection refused")
+	store := APIKeyStoreFunc(func(ctx context.Context, key string) (*APIKey, error) {
+		if key == "missing" {
+			return nil, nil
+		}
+		return nil, errStore
+	})
+	handler := APIKeyMicroMiddleware(store)(microEcho)
+	request := func(key string) error {
+		req := &natsmicromw.MicroRequest{Headers: micro.Headers{HeaderAPIKey: {key}}}
+		_, err := handler(req.WithContext(context.Background()))
+		return err
+	}
+
+	var handlerErr *natsmicromw.HandlerError
+	if err := request("missing"); !errors.As(err, &handlerErr) || handlerErr.Code != natsmicromw.CodeUnauthorized {
+		t.Errorf("expected a 401 error for a nil key, received %v", err)
+	}
+
+	err := request("other")
+	if !errors.As(err, &handlerErr) || handlerErr.Code != natsmicromw.CodeUnavailable {
+		t.Fatalf("expected a 503 error, received %v", err)
+	}
+	if handlerErr.Description != "api key store unavailable" {
+		t.Errorf("expected a generic description, received %s", handlerErr.Description)
+	}
+	if !errors.Is(err, errStore) {
+		t.Errorf("expected the store error as the cause")
+	}
+}
@@ -0,0 +1,273 @@
+// Example audit trail middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// Headers left out of audit envelopes unless `AuditOptions.ScrubHeaders` is
+// set
+var DefaultAuditScrubHeaders = []string{HeaderAuthorization, HeaderAPIKey, HeaderIdentityAssertion}
+
+// AuditEnvelope describes a handled request for offline analytics.
+type AuditEnvelope struct {
+	Subject         string              `json:"subject"`
+	Headers         map[string][]string `json:"headers,omitempty"`
+	ReplyHeaders    map[string][]string `json:"reply_headers,omitempty"`
+	RequestSize     int                 `json:"request_size"`
+	ResponseSize    int                 `json:"response_size,omitempty"`
+	Status          string              `json:"status,omitempty"`
+	Error           string              `json:"error,omitempty"`
+	Tags            map[string]string   `json:"tags,omitempty"`
+	State           map[string]any      `json:"state,omitempty"`
+	Latency         time.Duration       `json:"latency"`
+	Timestamp       time.Time           `json:"timestamp"`
+	RequestPayload  []byte              `json:"request_payload,omitempty"`
+	ResponsePayload []byte              `json:"response_payload,omitempty"`
+}
+
+type AuditOptions struct {
+	// JetStream is used to publish the envelopes, its `PublishAsyncMaxPending`
+	// option bounds the number of unacknowledged envelopes
+	JetStream nats.JetStreamContext
+	// Envelopes are published to `<SubjectPrefix>.<request subject>`, which
+	// must be captured by a stream. Defaults to "audit".
+	SubjectPrefix string
+	// Fraction of envelopes that include the request and response payloads,
+	// between 0 (default) and 1
+	PayloadSampleRate float64
+	// Payloads are truncated to this size, defaults to 4096 bytes
+	MaxPayloadSize int
+	// Headers left out of the envelopes, in any case, defaults to
+	// `DefaultAuditScrubHeaders`. Set an empty slice to keep all headers.
+	ScrubHeaders []string
+	// Number of envelopes queued for publishing, defaults to 1024. When the
+	// queue is full, envelopes are dropped instead of blocking requests.
+	BufferSize int
+}
+
+// Auditor publishes audit envelopes to JetStream in the background.
+type Auditor struct {
+	opts    AuditOptions
+	queue   chan AuditEnvelope
+	dropped atomic.Int64
+	failed  atomic.Int64
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewAuditor creates a new auditor and starts publishing, use it with
+// `AuditMiddleware` or `AuditMicroMiddleware`.
+func NewAuditor(opts AuditOptions) *Auditor {
+	if opts.SubjectPrefix == "" {
+		opts.SubjectPrefix = "audit"
+	}
+	if opts.MaxPayloadSize <= 0 {
+		opts.MaxPayloadSize = 4096
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 1024
+	}
+
+	a := &Auditor{
+		opts:  opts,
+		queue: make(chan AuditEnvelope, opts.BufferSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *Auditor) run() {
+	defer close(a.done)
+	for {
+		select {
+		case env := <-a.queue:
+			a.publish(env)
+		case <-a.stop:
+			// Flush what was queued before stopping
+			for {
+				select {
+				case env := <-a.queue:
+					a.publish(env)
+				default:
+					a.waitPublished()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (a *Auditor) publish(env AuditEnvelope) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		a.failed.Add(1)
+		return
+	}
+	if _, err := a.opts.JetStream.PublishAsync(a.opts.SubjectPrefix+"."+env.Subject, data); err != nil {
+		a.failed.Add(1)
+	}
+}
+
+// Wait for the pending envelopes to be acknowledged, up to a limit
+func (a *Auditor) waitPublished() {
+	timer := time.NewTimer(5 * time.Second)
+	defer timer.Stop()
+	select {
+	case <-a.opts.JetStream.PublishAsyncComplete():
+	case <-timer.C:
+	}
+}
+
+// Record queues the envelope for publishing, dropping it if the queue is full.
+func (a *Auditor) Record(env AuditEnvelope) {
+	select {
+	case a.queue <- env:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of envelopes dropped because the queue was full.
+func (a *Auditor) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// Failed returns the number of envelopes that could not be published.
+func (a *Auditor) Failed() int64 {
+	return a.failed.Load()
+}
+
+// Stop publishes the queued envelopes, waits up to five seconds for them
+// to be acknowledged and stops the auditor. Envelopes recorded after Stop
+// are never published.
+func (a *Auditor) Stop() {
+	a.once.Do(func() { close(a.stop) })
+	<-a.done
+}
+
+// Return the payload if this request is sampled, truncated to the limit
+func (a *Auditor) samplePayload(sampled bool, data []byte) []byte {
+	if !sampled || len(data) == 0 {
+		return nil
+	}
+	if len(data) > a.opts.MaxPayloadSize {
+		data = data[:a.opts.MaxPayloadSize]
+	}
+	return data
+}
+
+func (a *Auditor) sampled() bool {
+	return a.opts.PayloadSampleRate > 0 && rand.Float64() < a.opts.PayloadSampleRate
+}
+
+// Copy the headers without the scrubbed ones, the envelope is encoded by
+// the publisher while the request and reply headers may still be changed
+func (a *Auditor) copyHeaders(headers map[string][]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+	scrub := a.opts.ScrubHeaders
+	if scrub == nil {
+		scrub = DefaultAuditScrubHeaders
+	}
+	copied := make(map[string][]string, len(headers))
+headers:
+	for k, v := range headers {
+		for _, scrubbed := range scrub {
+			if strings.EqualFold(k, scrubbed) {
+				continue headers
+			}
+		}
+		copied[k] = append([]string{}, v...)
+	}
+	return copied
+}
+
+func (a *Auditor) envelope(ctx context.Context, subject string, headers, replyHeaders map[string][]string, data []byte, start time.Time, err error) AuditEnvelope {
+	env := AuditEnvelope{
+		Subject:      subject,
+		Headers:      a.copyHeaders(headers),
+		ReplyHeaders: a.copyHeaders(replyHeaders),
+		RequestSize:  len(data),
+		Status:       natsmicromw.ContextErrorCode(ctx, err),
+		Latency:      time.Since(start),
+		Timestamp:    start,
+		Tags:         natsmicromw.Tags(ctx),
+	}
+	if state := natsmicromw.ChainStateFromContext(ctx); state != nil {
+		if facts := state.Facts(); len(facts) > 0 {
+			env.State = facts
+		}
+	}
+	if err != nil {
+		env.Error = err.Error()
+	}
+	return env
+}
+
+// AuditMiddleware records an audit envelope for each request. Responses are
+// not available to context middleware, use `AuditMicroMiddleware` to include
+// their sizes and payloads.
+func AuditMiddleware(a *Auditor) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			start := time.Now()
+			err := next(req)
+
+			env := a.envelope(req.Context(), req.Subject(), req.Headers(), nil, req.Data(), start, replyError(req.Context(), nil, err))
+			env.RequestPayload = a.samplePayload(a.sampled(), req.Data())
+			a.Record(env)
+			return err
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func AuditMicroMiddleware(a *Auditor) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			data, err := req.ReadData()
+			if err != nil {
+				return nil, err
+			}
+			start := time.Now()
+			reply, err := next(req)
+
+			var replyHeaders map[string][]string
+			if reply != nil {
+				replyHeaders = reply.Headers
+			}
+			env := a.envelope(req.Context(), req.Subject, req.Headers, replyHeaders, data, start, replyError(req.Context(), reply, err))
+			sampled := a.sampled()
+			env.RequestPayload = a.samplePayload(sampled, data)
+			if reply != nil {
+				env.ResponseSize = len(reply.Data)
+				env.ResponsePayload = a.samplePayload(sampled, reply.Data)
+			}
+			a.Record(env)
+			return reply, err
+		}
+	}
+}
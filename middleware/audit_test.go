@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestAuditMicroMiddleware(t *testing.T) {
+	opts := &server.Options{Host: "localhost", Port: server.RANDOM_PORT, NoSigs: true, JetStream: true, StoreDir: t.TempDir()}
+	s, err := runServer(opts)
+	if err != nil {
+		t.Fatalf("Could not start NATS server: %v", err)
+	}
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "AUDIT", Subjects: []string{"audit.>"}}); err != nil {
+		t.Fatalf("Could not create stream: %v", err)
+	}
+
+	nm, err := natsmicromw.AddMicroService(nc, micro.Config{
+		Name:    "TestService",
+		Version: "1.0.0",
+	})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+
+	auditor := NewAuditor(AuditOptions{JetStream: js, PayloadSampleRate: 1, MaxPayloadSize: 4})
+	if err := nm.UseMicro(AuditMicroMiddleware(auditor)).AddMicroEndpoint("echo", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := nc.Request("echo", []byte("payload"), time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auditor.Stop()
+
+	sub, err := js.SubscribeSync("audit.echo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("audit envelope was not published: %v", err)
+	}
+
+	var env AuditEnvelope
+	if err := json.Unmarshal(msg.Data, &env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env.Subject != "echo" || env.RequestSize != 7 || env.ResponseSize != 7 || env.Status != "" {
+		t.Errorf("envelope does not match: %+v", env)
+	}
+	if string(env.RequestPayload) != "payl" || string(env.ResponsePayload) != "payl" {
+		t.Errorf("payloads were not sampled and truncated: %q, %q", env.RequestPayload, env.ResponsePayload)
+	}
+}
+
+func TestAuditorDropsWhenFull(t *testing.T) {
+	// Never started, so nothing is published
+	a := &Auditor{queue: make(chan AuditEnvelope, 1)}
+	a.Record(AuditEnvelope{Subject: "foo"})
+	a.Record(AuditEnvelope{Subject: "foo"})
+	if a.Dropped() != 1 {
+		t.Errorf("dropped count does not match, expected %d, received %d", 1, a.Dropped())
+	}
+}
+
+func TestAuditEnvelopeCopiesHeaders(t *testing.T) {
+	a := &Auditor{}
+	headers := nats.Header{"foo": []string{"a"}}
+	replyHeaders := nats.Header{"bar": []string{"b"}}
+	env := a.envelope(context.Background(), "foo", headers, replyHeaders, nil, time.Now(), nil)
+
+	// The reply headers are still stamped after the envelope is recorded
+	headers.Set("foo", "changed")
+	replyHeaders.Add("bar", "c")
+	replyHeaders.Set("baz", "d")
+	if len(env.Headers) != 1 || env.Headers["foo"][0] != "a" {
+		t.Errorf("request headers were not copied: %v", env.Headers)
+	}
+	if len(env.ReplyHeaders) != 1 || len(env.ReplyHeaders["bar"]) != 1 {
+		t.Errorf("reply headers were not copied: %v", env.ReplyHeaders)
+	}
+}
+
+func TestAuditEnvelopeScrubsCredentials(t *testing.T) {
+	a := &Auditor{}
+	headers := nats.Header{
+		"Authorization":         []string{"Bearer secret"},
+		HeaderAPIKey:            []string{"secret"},
+		HeaderIdentityAssertion: []string{"secret"},
+		"foo":                   []string{"a"},
+	}
+	env := a.envelope(context.Background(), "foo", headers, nil, nil, time.Now(), nil)
+	if len(env.Headers) != 1 || env.Headers["foo"][0] != "a" {
+		t.Errorf("credentials stored in the envelope: %v", env.Headers)
+	}
+
+	a = &Auditor{opts: AuditOptions{ScrubHeaders: []string{"foo"}}}
+	env = a.envelope(context.Background(), "foo", headers, nil, nil, time.Now(), nil)
+	if _, ok := env.Headers["foo"]; ok || len(env.Headers) != 3 {
+		t.Errorf("configured headers not scrubbed: %v", env.Headers)
+	}
+}
+
+func TestAuditErrorReply(t *testing.T) {
+	// Never started, the envelope stays in the queue
+	a := &Auditor{queue: make(chan AuditEnvelope, 1)}
+	handler := AuditMicroMiddleware(a)(func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return natsmicromw.NewMicroError("503", "unavailable", nil, nil), nil
+	})
+	req := (&natsmicromw.MicroRequest{Subject: "failing"}).WithContext(context.Background())
+	if _, err := handler(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	env := <-a.queue
+	if env.Status != "503" || env.Error == "" {
+		t.Errorf("expected the error reply in the envelope, received %+v", env)
+	}
+}
@@ -0,0 +1,247 @@
+// Example policy-based authorization middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// AuthzInput is the document evaluated against the authorization policy.
+type AuthzInput struct {
+	Subject  string        `json:"subject"`
+	Identity any           `json:"identity,omitempty"`
+	Headers  micro.Headers `json:"headers,omitempty"`
+	Payload  []byte        `json:"payload,omitempty"`
+}
+
+type PolicyDecision int
+
+const (
+	// The policy did not produce a decision (e.g. an undefined OPA rule)
+	PolicyUndefined PolicyDecision = iota
+	PolicyAllow
+	PolicyDeny
+)
+
+// PolicyEvaluator evaluates a request against a policy. Wrap an OPA prepared
+// query or a Casbin enforcer to implement it, e.g.:
+//
+//	middleware.PolicyFunc(func(ctx context.Context, in *middleware.AuthzInput) (middleware.PolicyDecision, error) {
+//		ok, err := enforcer.Enforce(in.Identity, in.Subject, "request")
+//		...
+//	})
+type PolicyEvaluator interface {
+	Evaluate(ctx context.Context, input *AuthzInput) (PolicyDecision, error)
+}
+
+// PolicyFunc is an adapter to use ordinary functions as policy evaluators.
+type PolicyFunc func(ctx context.Context, input *AuthzInput) (PolicyDecision, error)
+
+func (f PolicyFunc) Evaluate(ctx context.Context, input *AuthzInput) (PolicyDecision, error) {
+	return f(ctx, input)
+}
+
+type AuthzOptions struct {
+	// Evaluator is the policy to enforce
+	Evaluator PolicyEvaluator
+	// Identity extracts the identity claims from the request context.
+	// Defaults to the OAuth2 token info, the `*APIKey`, the `*Principal` of
+	// an identity assertion or the `StateIdentity` string, whichever is
+	// found first.
+	Identity func(ctx context.Context) any
+	// IncludeHeaders and IncludePayload add the request headers and data to
+	// the policy input. Decisions are not cached when either is included.
+	IncludeHeaders bool
+	IncludePayload bool
+	// CacheTTL caches decisions per subject and identity, zero disables caching
+	CacheTTL time.Duration
+	// Maximum number of cached decisions, defaults to 10000
+	MaxCacheEntries int
+	// DenyByDefault rejects requests when the policy gives no decision.
+	// Otherwise only explicit denials are rejected. Requests are always
+	// rejected when the policy fails to evaluate.
+	DenyByDefault bool
+}
+
+type decisionCacheEntry struct {
+	decision PolicyDecision
+	expires  time.Time
+}
+
+type authorizer struct {
+	opts  AuthzOptions
+	mu    sync.Mutex
+	cache map[string]decisionCacheEntry
+}
+
+// Identity of the request from the authentication middleware, if any: the
+// OAuth2 token info, the API key, the asserted principal or the identity
+// set in the chain state, in that order
+func defaultIdentity(ctx context.Context) any {
+	if info := TokenInfoFromContext(ctx); info != nil {
+		return info
+	}
+	if apiKey := APIKeyFromContext(ctx); apiKey != nil {
+		return apiKey
+	}
+	if p := PrincipalFromContext(ctx); p != nil {
+		return p
+	}
+	if identity, ok := StateIdentity.Get(ctx); ok {
+		return identity
+	}
+	return nil
+}
+
+func newAuthorizer(opts AuthzOptions) *authorizer {
+	if opts.Identity == nil {
+		opts.Identity = defaultIdentity
+	}
+	if opts.MaxCacheEntries <= 0 {
+		opts.MaxCacheEntries = 10000
+	}
+	return &authorizer{
+		opts:  opts,
+		cache: make(map[string]decisionCacheEntry),
+	}
+}
+
+// Decision caching is only possible when the input depends on subject and identity
+func (a *authorizer) cacheable() bool {
+	return a.opts.CacheTTL > 0 && !a.opts.IncludeHeaders && !a.opts.IncludePayload
+}
+
+// Key of the cached decision, the subject and a hash of the JSON encoded
+// identity, false if the identity cannot be encoded
+func decisionCacheKey(input *AuthzInput) (string, bool) {
+	identity, err := json.Marshal(input.Identity)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(identity)
+	return input.Subject + " " + hex.EncodeToString(sum[:]), true
+}
+
+// Make room for a new decision, dropping expired decisions first and an
+// arbitrary one if none have expired
+func (a *authorizer) evict(now time.Time) {
+	if len(a.cache) < a.opts.MaxCacheEntries {
+		return
+	}
+	for key, entry := range a.cache {
+		if now.After(entry.expires) {
+			delete(a.cache, key)
+		}
+	}
+	for key := range a.cache {
+		if len(a.cache) < a.opts.MaxCacheEntries {
+			break
+		}
+		delete(a.cache, key)
+	}
+}
+
+func (a *authorizer) evaluate(ctx context.Context, input *AuthzInput) (PolicyDecision, error) {
+	if !a.cacheable() {
+		return a.opts.Evaluator.Evaluate(ctx, input)
+	}
+	key, ok := decisionCacheKey(input)
+	if !ok {
+		return a.opts.Evaluator.Evaluate(ctx, input)
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	entry, ok := a.cache[key]
+	if ok && now.After(entry.expires) {
+		delete(a.cache, key)
+		ok = false
+	}
+	a.mu.Unlock()
+	if ok {
+		return entry.decision, nil
+	}
+
+	decision, err := a.opts.Evaluator.Evaluate(ctx, input)
+	if err != nil {
+		return decision, err
+	}
+	a.mu.Lock()
+	if _, ok := a.cache[key]; !ok {
+		a.evict(now)
+	}
+	a.cache[key] = decisionCacheEntry{decision, now.Add(a.opts.CacheTTL)}
+	a.mu.Unlock()
+	return decision, nil
+}
+
+func (a *authorizer) authorize(ctx context.Context, subject string, headers micro.Headers, data []byte) error {
+	input := &AuthzInput{
+		Subject:  subject,
+		Identity: a.opts.Identity(ctx),
+	}
+	if a.opts.IncludeHeaders {
+		input.Headers = headers
+	}
+	if a.opts.IncludePayload {
+		input.Payload = data
+	}
+
+	decision, err := a.evaluate(ctx, input)
+	if err != nil {
+		// Fail closed, whatever the default
+		return &natsmicromw.HandlerError{
+			Description: "authorization failed",
+			Code:        natsmicromw.CodeForbidden,
+		}
+	}
+
+	if decision == PolicyDeny || (decision == PolicyUndefined && a.opts.DenyByDefault) {
+		return &natsmicromw.HandlerError{
+			Description: "forbidden",
+			Code:        natsmicromw.CodeForbidden,
+		}
+	}
+	return nil
+}
+
+// AuthzMiddleware evaluates each request against the policy and rejects
+// denied requests, and requests the policy fails to evaluate, with a 403
+// error before they reach the handler.
+func AuthzMiddleware(opts AuthzOptions) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	a := newAuthorizer(opts)
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if err := a.authorize(req.Context(), req.Subject(), req.Headers(), req.Data()); err != nil {
+				return err
+			}
+			return next(req)
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func AuthzMicroMiddleware(opts AuthzOptions) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	a := newAuthorizer(opts)
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			data, err := req.ReadData()
+			if err != nil {
+				return nil, err
+			}
+			if err := a.authorize(req.Context(), req.Subject, req.Headers, data); err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	}
+}
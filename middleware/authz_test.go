@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestAuthzMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	var evaluations atomic.Int64
+	policy := PolicyFunc(func(ctx context.Context, in *AuthzInput) (PolicyDecision, error) {
+		evaluations.Add(1)
+		switch in.Subject {
+		case "allow", "cached":
+			return PolicyAllow, nil
+		case "deny":
+			return PolicyDeny, nil
+		case "failing":
+			return PolicyUndefined, errors.New("policy unavailable")
+		}
+		return PolicyUndefined, nil
+	})
+	opts := AuthzOptions{
+		Evaluator: policy,
+		Identity:  func(ctx context.Context) any { return map[string]string{"sub": "alice"} },
+		CacheTTL:  time.Minute,
+	}
+	for _, subject := range []string{"allow", "deny", "failing", "undefined", "cached"} {
+		if err := nm.UseMicro(AuthzMicroMiddleware(opts)).AddMicroEndpoint(subject, microEcho); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	request := func(t *testing.T, subject string) string {
+		reply, err := nc.Request(subject, []byte("data"), time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if herr := natsmicromw.HandlerErrorFromMsg(reply); herr != nil {
+			return herr.Code
+		}
+		return string(reply.Data)
+	}
+
+	tests := []struct {
+		subject  string
+		expected string
+	}{
+		{"allow", "data"},
+		{"deny", natsmicromw.CodeForbidden},
+		// Evaluation errors are rejected even without deny by default
+		{"failing", natsmicromw.CodeForbidden},
+		{"undefined", "data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			if reply := request(t, tt.subject); reply != tt.expected {
+				t.Errorf("expected %q, received %q", tt.expected, reply)
+			}
+		})
+	}
+
+	t.Run("cache hit", func(t *testing.T) {
+		before := evaluations.Load()
+		for i := 0; i < 3; i++ {
+			if reply := request(t, "cached"); reply != "data" {
+				t.Fatalf("unexpected reply %q", reply)
+			}
+		}
+		if n := evaluations.Load() - before; n != 1 {
+			t.Errorf("expected one evaluation, got %d", n)
+		}
+	})
+}
+
+func TestAuthzDecisionCache(t *testing.T) {
+	a := newAuthorizer(AuthzOptions{
+		Evaluator: PolicyFunc(func(ctx context.Context, in *AuthzInput) (PolicyDecision, error) {
+			return PolicyAllow, nil
+		}),
+		Identity:        func(ctx context.Context) any { return nil },
+		CacheTTL:        time.Minute,
+		MaxCacheEntries: 2,
+	})
+	for _, identity := range []any{"a", "b", "c", map[string]int{"x": 1, "y": 2}} {
+		if _, err := a.evaluate(context.Background(), &AuthzInput{Subject: "foo", Identity: identity}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(a.cache) > 2 {
+		t.Errorf("expected at most 2 cached decisions, got %d", len(a.cache))
+	}
+
+	// Equal identities share a key whatever their map order
+	k1, _ := decisionCacheKey(&AuthzInput{Subject: "foo", Identity: map[string]int{"x": 1, "y": 2}})
+	k2, _ := decisionCacheKey(&AuthzInput{Subject: "foo", Identity: map[string]int{"y": 2, "x": 1}})
+	k3, _ := decisionCacheKey(&AuthzInput{Subject: "bar", Identity: map[string]int{"x": 1, "y": 2}})
+	if k1 != k2 || k1 == k3 {
+		t.Errorf("unexpected keys %q %q %q", k1, k2, k3)
+	}
+}
+
+func TestAuthzDefaultIdentity(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	// Only callers with an identity are allowed
+	opts := AuthzOptions{
+		Evaluator: PolicyFunc(func(ctx context.Context, in *AuthzInput) (PolicyDecision, error) {
+			switch identity := in.Identity.(type) {
+			case *APIKey:
+				if identity.ID == "1" {
+					return PolicyAllow, nil
+				}
+			case string:
+				if identity == "service" {
+					return PolicyAllow, nil
+				}
+			}
+			return PolicyDeny, nil
+		}),
+	}
+	store := StaticAPIKeys{"secret": {ID: "1"}}
+	if err := nm.UseMicro(APIKeyMicroMiddleware(store), AuthzMicroMiddleware(opts)).AddMicroEndpoint("apikey", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	trusted := func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			StateIdentity.Set(req.Context(), "service")
+			return next(req)
+		}
+	}
+	if err := nm.UseMicro(trusted, AuthzMicroMiddleware(opts)).AddMicroEndpoint("state", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, subject := range []string{"apikey", "state"} {
+		msg := nats.NewMsg(subject)
+		msg.Data = []byte("data")
+		msg.Header.Set(HeaderAPIKey, "secret")
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if herr := natsmicromw.HandlerErrorFromMsg(reply); herr != nil {
+			t.Errorf("%s: unexpected error: %v", subject, herr)
+		}
+	}
+}
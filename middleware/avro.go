@@ -0,0 +1,120 @@
+//go:build !natsmicromw_noavro
+
+// Example Avro codec for natsmicromw
+
+package middleware
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+
+	// For Avro encoding and schema resolution
+	"github.com/hamba/avro/v2"
+)
+
+const (
+	// Header carrying the ID of the schema the payload was written with
+	HeaderAvroSchemaID string = "avro-schema-id"
+)
+
+var (
+	ErrUnknownAvroSchema = errors.New("unknown avro schema")
+)
+
+// AvroSchemaRegistry looks up writer schemas by their ID.
+type AvroSchemaRegistry interface {
+	Schema(id string) (avro.Schema, error)
+}
+
+// AvroSchemaMap is a static schema registry.
+type AvroSchemaMap map[string]avro.Schema
+
+func (m AvroSchemaMap) Schema(id string) (avro.Schema, error) {
+	schema, ok := m[id]
+	if !ok {
+		return nil, ErrUnknownAvroSchema
+	}
+	return schema, nil
+}
+
+// AvroCodec encodes payloads with its own schema and stamps the schema ID in
+// the headers. When decoding a payload written with a different schema, the
+// writer schema is looked up from the registry and resolved against the
+// codec's schema, so older and newer clients keep working as the schema evolves.
+type AvroCodec struct {
+	id       string
+	schema   avro.Schema
+	registry AvroSchemaRegistry
+
+	// Resolved schemas by writer schema ID
+	resolved sync.Map
+}
+
+// NewAvroCodec creates a new Avro codec. The registry may be nil if only the
+// codec's own schema is ever used.
+func NewAvroCodec(id string, schema avro.Schema, registry AvroSchemaRegistry) *AvroCodec {
+	return &AvroCodec{
+		id:       id,
+		schema:   schema,
+		registry: registry,
+	}
+}
+
+func (c *AvroCodec) Encode(v any) ([]byte, error) {
+	return avro.Marshal(c.schema, v)
+}
+
+// Decode assumes the payload was written with the codec's own schema.
+func (c *AvroCodec) Decode(data []byte, v any) error {
+	return avro.Unmarshal(c.schema, data, v)
+}
+
+func (c *AvroCodec) ContentType() string {
+	return "application/avro"
+}
+
+func (c *AvroCodec) EncodeWithHeaders(v any, headers nats.Header) ([]byte, error) {
+	data, err := c.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	headers.Set(HeaderAvroSchemaID, c.id)
+	return data, nil
+}
+
+func (c *AvroCodec) DecodeWithHeaders(data []byte, headers nats.Header, v any) error {
+	id := headers.Get(HeaderAvroSchemaID)
+	if id == "" || id == c.id {
+		return c.Decode(data, v)
+	}
+
+	schema, err := c.readerSchema(id)
+	if err != nil {
+		return err
+	}
+	return avro.Unmarshal(schema, data, v)
+}
+
+// Resolve the writer schema against the codec's schema
+func (c *AvroCodec) readerSchema(writerID string) (avro.Schema, error) {
+	if schema, ok := c.resolved.Load(writerID); ok {
+		return schema.(avro.Schema), nil
+	}
+	if c.registry == nil {
+		return nil, ErrUnknownAvroSchema
+	}
+
+	writer, err := c.registry.Schema(writerID)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := avro.NewSchemaCompatibility().Resolve(c.schema, writer)
+	if err != nil {
+		return nil, err
+	}
+
+	c.resolved.Store(writerID, schema)
+	return schema, nil
+}
@@ -0,0 +1,91 @@
+//go:build !natsmicromw_noavro
+
+package middleware
+
+import (
+	"testing"
+
+	"github.com/hamba/avro/v2"
+	"github.com/nats-io/nats.go"
+)
+
+type userV1 struct {
+	Name string `avro:"name"`
+}
+
+type userV2 struct {
+	Name  string `avro:"name"`
+	Email string `avro:"email"`
+}
+
+func TestAvroCodec(t *testing.T) {
+	schemaV1 := avro.MustParse(`{"type":"record","name":"user","fields":[
+		{"name":"name","type":"string"}]}`)
+	schemaV2 := avro.MustParse(`{"type":"record","name":"user","fields":[
+		{"name":"name","type":"string"},
+		{"name":"email","type":"string","default":"unknown"}]}`)
+	registry := AvroSchemaMap{"1": schemaV1, "2": schemaV2}
+
+	v1 := NewAvroCodec("1", schemaV1, registry)
+	v2 := NewAvroCodec("2", schemaV2, registry)
+
+	t.Run("same schema", func(t *testing.T) {
+		headers := nats.Header{}
+		data, err := v2.EncodeWithHeaders(userV2{Name: "a", Email: "a@example.com"}, headers)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if headers.Get(HeaderAvroSchemaID) != "2" {
+			t.Errorf("schema id header does not match, expected %s, received %s", "2", headers.Get(HeaderAvroSchemaID))
+		}
+
+		var user userV2
+		if err := v2.DecodeWithHeaders(data, headers, &user); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if user.Email != "a@example.com" {
+			t.Errorf("unexpected user: %+v", user)
+		}
+	})
+
+	t.Run("old writer, new reader", func(t *testing.T) {
+		headers := nats.Header{}
+		data, err := v1.EncodeWithHeaders(userV1{Name: "b"}, headers)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var user userV2
+		if err := v2.DecodeWithHeaders(data, headers, &user); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if user.Name != "b" || user.Email != "unknown" {
+			t.Errorf("unexpected user: %+v", user)
+		}
+	})
+
+	t.Run("new writer, old reader", func(t *testing.T) {
+		headers := nats.Header{}
+		data, err := v2.EncodeWithHeaders(userV2{Name: "c", Email: "c@example.com"}, headers)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var user userV1
+		if err := v1.DecodeWithHeaders(data, headers, &user); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if user.Name != "c" {
+			t.Errorf("unexpected user: %+v", user)
+		}
+	})
+
+	t.Run("unknown writer schema", func(t *testing.T) {
+		headers := nats.Header{}
+		headers.Set(HeaderAvroSchemaID, "3")
+		var user userV2
+		if err := v2.DecodeWithHeaders([]byte{}, headers, &user); err != ErrUnknownAvroSchema {
+			t.Errorf("expected ErrUnknownAvroSchema, got %v", err)
+		}
+	})
+}
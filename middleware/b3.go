@@ -0,0 +1,124 @@
+// Example Zipkin B3 propagation for the natsmicromw tracing middleware
+
+package middleware
+
+import (
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// Single header format: {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}
+	HeaderB3 string = "b3"
+
+	HeaderB3TraceID      string = "x-b3-traceid"
+	HeaderB3SpanID       string = "x-b3-spanid"
+	HeaderB3ParentSpanID string = "x-b3-parentspanid"
+	HeaderB3Sampled      string = "x-b3-sampled"
+	HeaderB3Flags        string = "x-b3-flags"
+)
+
+// B3Propagator reads and writes Zipkin B3 headers. Both the single and
+// multi header formats are extracted, `SingleHeader` selects the format
+// that is injected. Use it as `TracingOptions.Propagator` to interoperate
+// with Zipkin instrumented services.
+type B3Propagator struct {
+	SingleHeader bool
+}
+
+// Validate a hex ID and pad 64-bit trace IDs to 128 bits
+func b3ID(id string, length int) (string, bool) {
+	id = strings.ToLower(id)
+	if len(id) == 16 && length == 32 {
+		id = strings.Repeat("0", 16) + id
+	}
+	if len(id) != length || strings.Trim(id, "0123456789abcdef") != "" {
+		return "", false
+	}
+	return id, true
+}
+
+func b3Sampled(state string) *bool {
+	var sampled bool
+	switch state {
+	case "1", "d", "true":
+		sampled = true
+	case "0", "false":
+		sampled = false
+	default:
+		return nil
+	}
+	return &sampled
+}
+
+func (p B3Propagator) Extract(headers micro.Headers) (SpanContext, bool) {
+	if single := headers.Get(HeaderB3); single != "" {
+		return p.extractSingle(single)
+	}
+
+	traceID, ok := b3ID(headers.Get(HeaderB3TraceID), 32)
+	if !ok {
+		return SpanContext{}, false
+	}
+	spanID, ok := b3ID(headers.Get(HeaderB3SpanID), 16)
+	if !ok {
+		return SpanContext{}, false
+	}
+
+	sc := SpanContext{TraceID: traceID, SpanID: spanID, Sampled: b3Sampled(headers.Get(HeaderB3Sampled))}
+	// Debug implies an accept sampling decision
+	if headers.Get(HeaderB3Flags) == "1" {
+		sc.Sampled = b3Sampled("d")
+	}
+	return sc, true
+}
+
+func (B3Propagator) extractSingle(value string) (SpanContext, bool) {
+	parts := strings.Split(value, "-")
+	// A lone sampling state carries no trace
+	if len(parts) < 2 {
+		return SpanContext{}, false
+	}
+
+	traceID, ok := b3ID(parts[0], 32)
+	if !ok {
+		return SpanContext{}, false
+	}
+	spanID, ok := b3ID(parts[1], 16)
+	if !ok {
+		return SpanContext{}, false
+	}
+
+	sc := SpanContext{TraceID: traceID, SpanID: spanID}
+	if len(parts) > 2 {
+		sc.Sampled = b3Sampled(parts[2])
+	}
+	return sc, true
+}
+
+func (p B3Propagator) Inject(sc SpanContext, headers nats.Header) {
+	if p.SingleHeader {
+		value := sc.TraceID + "-" + sc.SpanID
+		if sc.Sampled != nil {
+			if *sc.Sampled {
+				value += "-1"
+			} else {
+				value += "-0"
+			}
+		}
+		headers.Set(HeaderB3, value)
+		return
+	}
+
+	headers.Set(HeaderB3TraceID, sc.TraceID)
+	headers.Set(HeaderB3SpanID, sc.SpanID)
+	if sc.Sampled != nil {
+		if *sc.Sampled {
+			headers.Set(HeaderB3Sampled, "1")
+		} else {
+			headers.Set(HeaderB3Sampled, "0")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Example batching utility and handler for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// BatchFunc resolves a set of keys with a single backend call. Keys that are
+// missing from the returned map are reported as `ErrBatchKeyNotFound`.
+type BatchFunc func(ctx context.Context, keys []string) (map[string][]byte, error)
+
+var (
+	ErrBatchKeyNotFound = errors.New("batch key not found")
+)
+
+// Batcher collects concurrent lookups within a short window and resolves them
+// with one call to the batch function.
+type Batcher struct {
+	fn      BatchFunc
+	window  time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	pending *batch
+}
+
+type batch struct {
+	keys    []string
+	seen    map[string]struct{}
+	timer   *time.Timer
+	done    chan struct{}
+	results map[string][]byte
+	err     error
+}
+
+// NewBatcher creates a new Batcher. A batch is flushed when the window
+// elapses or when it reaches `maxSize` distinct keys (0 means no limit).
+func NewBatcher(fn BatchFunc, window time.Duration, maxSize int) *Batcher {
+	return &Batcher{
+		fn:      fn,
+		window:  window,
+		maxSize: maxSize,
+	}
+}
+
+// Load adds the key to the current batch and waits for its result.
+func (b *Batcher) Load(ctx context.Context, key string) ([]byte, error) {
+	b.mu.Lock()
+	bt := b.pending
+	if bt == nil {
+		bt = &batch{
+			seen: make(map[string]struct{}),
+			done: make(chan struct{}),
+		}
+		bt.timer = time.AfterFunc(b.window, func() { b.flushPending(bt) })
+		b.pending = bt
+	}
+	if _, ok := bt.seen[key]; !ok {
+		bt.seen[key] = struct{}{}
+		bt.keys = append(bt.keys, key)
+	}
+
+	// Flush immediately if the batch is full
+	if b.maxSize > 0 && len(bt.keys) >= b.maxSize {
+		b.pending = nil
+		bt.timer.Stop()
+		go b.run(bt)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-bt.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if bt.err != nil {
+		return nil, bt.err
+	}
+	data, ok := bt.results[key]
+	if !ok {
+		return nil, ErrBatchKeyNotFound
+	}
+	return data, nil
+}
+
+// flushPending runs the batch unless it was already flushed because it was full.
+func (b *Batcher) flushPending(bt *batch) {
+	b.mu.Lock()
+	if b.pending != bt {
+		b.mu.Unlock()
+		return
+	}
+	b.pending = nil
+	b.mu.Unlock()
+
+	b.run(bt)
+}
+
+func (b *Batcher) run(bt *batch) {
+	// The batch is shared by many requests, so none of their contexts apply
+	bt.results, bt.err = b.fn(context.Background(), bt.keys)
+	close(bt.done)
+}
+
+// BatchMicroHandler returns a handler that extracts a key from each request
+// and replies with the batched result for that key.
+func BatchMicroHandler(b *Batcher, keyFn func(*natsmicromw.MicroRequest) string) natsmicromw.MicroHandlerFunc {
+	return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		data, err := b.Load(req.Context(), keyFn(req))
+		if err != nil {
+			if errors.Is(err, ErrBatchKeyNotFound) {
+				return nil, &natsmicromw.HandlerError{
+					Description: err.Error(),
+					Code:        natsmicromw.CodeNotFound,
+				}
+			}
+			return nil, err
+		}
+		return natsmicromw.NewMicroReply(data), nil
+	}
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestBatcher(t *testing.T) {
+	var calls int32
+	fn := func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		res := make(map[string][]byte)
+		for _, k := range keys {
+			if k != "missing" {
+				res[k] = []byte("value-" + k)
+			}
+		}
+		return res, nil
+	}
+
+	t.Run("concurrent loads are batched", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		b := NewBatcher(fn, 20*time.Millisecond, 0)
+
+		var wg sync.WaitGroup
+		for _, key := range []string{"a", "b", "a", "c"} {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				data, err := b.Load(context.Background(), key)
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if string(data) != "value-"+key {
+					t.Errorf("result does not match, expected %s, received %s", "value-"+key, string(data))
+				}
+			}(key)
+		}
+		wg.Wait()
+
+		if c := atomic.LoadInt32(&calls); c != 1 {
+			t.Errorf("expected a single batch call, got %d", c)
+		}
+	})
+
+	t.Run("full batch is flushed immediately", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		b := NewBatcher(fn, time.Hour, 1)
+
+		data, err := b.Load(context.Background(), "a")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if string(data) != "value-a" {
+			t.Errorf("result does not match, expected %s, received %s", "value-a", string(data))
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		b := NewBatcher(fn, time.Millisecond, 0)
+		if _, err := b.Load(context.Background(), "missing"); err != ErrBatchKeyNotFound {
+			t.Errorf("expected ErrBatchKeyNotFound, got %v", err)
+		}
+	})
+}
+
+func TestBatchMicroHandler(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	b := NewBatcher(func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		res := make(map[string][]byte)
+		for _, k := range keys {
+			res[k] = []byte("value-" + k)
+		}
+		return res, nil
+	}, time.Millisecond, 0)
+
+	keyFn := func(req *natsmicromw.MicroRequest) string {
+		return string(req.Data)
+	}
+	if err := nm.AddMicroEndpoint("batch", BatchMicroHandler(b, keyFn)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg := nats.NewMsg("batch")
+	msg.Data = []byte("key")
+	reply, err := nc.RequestMsg(msg, 1*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal([]byte("value-key"), reply.Data) {
+		t.Errorf("responses do not match, expected %s, received %s", "value-key", string(reply.Data))
+	}
+}
@@ -0,0 +1,124 @@
+// Example bridge header normalization middleware for natsmicromw
+
+package middleware
+
+import (
+	"strings"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// DefaultBridgeHeaderAliases maps header names commonly used by bridged
+// clients (MQTT, HTTP gateways, leafnode domains) to the ones used by the
+// rest of the middleware stack.
+var DefaultBridgeHeaderAliases = map[string]string{
+	"X-Request-Id": "request_id",
+	"X-Request-ID": "request_id",
+	"Request-Id":   "request_id",
+	"requestId":    "request_id",
+	"Content-Type": natsmicromw.HeaderContentType,
+	"Content-type": natsmicromw.HeaderContentType,
+	"contentType":  natsmicromw.HeaderContentType,
+}
+
+// BridgeOptions configures the bridge normalization middleware.
+type BridgeOptions struct {
+	// Header aliases, mapping from the bridged name to the canonical name.
+	// If nil, `DefaultBridgeHeaderAliases` is used.
+	HeaderAliases map[string]string
+	// Subject prefixes added by bridges (e.g. "mqtt."), stripped from the
+	// subject seen by downstream middleware and the handler
+	SubjectPrefixes []string
+}
+
+func bridgeHeaderAliases(opts BridgeOptions) map[string]string {
+	if opts.HeaderAliases == nil {
+		return DefaultBridgeHeaderAliases
+	}
+	return opts.HeaderAliases
+}
+
+// Rename the aliased headers to their canonical names in place, keeping
+// canonical headers already present
+func normalizeBridgeHeaders(headers micro.Headers, aliases map[string]string) {
+	h := nats.Header(headers)
+	for alias, canonical := range aliases {
+		values := headers.Values(alias)
+		if len(values) == 0 {
+			continue
+		}
+		if len(headers.Values(canonical)) == 0 {
+			for _, v := range values {
+				h.Add(canonical, v)
+			}
+		}
+		delete(h, alias)
+	}
+}
+
+// Strip the first matching bridge prefix from the subject
+func stripBridgePrefix(subject string, prefixes []string) string {
+	for _, prefix := range prefixes {
+		if rest, ok := strings.CutPrefix(subject, prefix); ok {
+			return rest
+		}
+	}
+	return subject
+}
+
+// Request reporting the subject without the bridge prefix
+type bridgedRequest struct {
+	micro.Request
+	subject string
+}
+
+func (r *bridgedRequest) Subject() string {
+	return r.subject
+}
+
+// BridgeRawMiddleware canonicalizes headers and subjects of requests that
+// arrive through bridges for plain handlers, see `BridgeMicroMiddleware`.
+func BridgeRawMiddleware(opts BridgeOptions) func(next micro.Handler) micro.Handler {
+	aliases := bridgeHeaderAliases(opts)
+	return func(next micro.Handler) micro.Handler {
+		return micro.HandlerFunc(func(req micro.Request) {
+			normalizeBridgeHeaders(req.Headers(), aliases)
+			if subject := stripBridgePrefix(req.Subject(), opts.SubjectPrefixes); subject != req.Subject() {
+				req = &bridgedRequest{req, subject}
+			}
+			next.Handle(req)
+		})
+	}
+}
+
+// BridgeMiddleware canonicalizes headers and subjects of requests that
+// arrive through bridges, see `BridgeMicroMiddleware`.
+func BridgeMiddleware(opts BridgeOptions) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	aliases := bridgeHeaderAliases(opts)
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			normalizeBridgeHeaders(req.Headers(), aliases)
+			if subject := stripBridgePrefix(req.Subject(), opts.SubjectPrefixes); subject != req.Subject() {
+				req = req.WithSubject(subject)
+			}
+			return next(req)
+		}
+	}
+}
+
+// BridgeMicroMiddleware canonicalizes headers and subjects of requests that
+// arrive through bridges, so bridged clients work transparently. Canonical
+// headers already present in the request are never overwritten.
+func BridgeMicroMiddleware(opts BridgeOptions) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	aliases := bridgeHeaderAliases(opts)
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			normalizeBridgeHeaders(req.Headers, aliases)
+			req.Subject = stripBridgePrefix(req.Subject, opts.SubjectPrefixes)
+			return next(req)
+		}
+	}
+}
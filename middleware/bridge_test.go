@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestBridgeMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	opts := BridgeOptions{SubjectPrefixes: []string{"mqtt."}}
+	describe := func(subject string, headers micro.Headers) []byte {
+		return []byte(subject + " " + headers.Get("request_id") + " " + headers.Get(natsmicromw.HeaderContentType))
+	}
+	err := nm.Use(BridgeRawMiddleware(opts)).AddEndpoint("raw", micro.HandlerFunc(func(req micro.Request) {
+		_ = req.Respond(describe(req.Subject(), req.Headers()))
+	}), micro.WithEndpointSubject("mqtt.raw"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = nm.UseContext(BridgeMiddleware(opts)).AddContextEndpoint("context", func(req *natsmicromw.Request) error {
+		return req.Respond(describe(req.Subject(), req.Headers()))
+	}, micro.WithEndpointSubject("mqtt.context"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = nm.UseMicro(BridgeMicroMiddleware(opts)).AddMicroEndpoint("micro", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return natsmicromw.NewMicroReply(describe(req.Subject, req.Headers)), nil
+	}, micro.WithEndpointSubject("mqtt.micro"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"raw", "context", "micro"} {
+		t.Run(name, func(t *testing.T) {
+			msg := nats.NewMsg("mqtt." + name)
+			msg.Header.Set("X-Request-Id", "abc")
+			msg.Header.Set("contentType", "application/json")
+			// Canonical headers are kept
+			msg.Header.Set(natsmicromw.HeaderContentType, "text/plain")
+			reply, err := nc.RequestMsg(msg, time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := name + " abc text/plain"
+			if string(reply.Data) != expected {
+				t.Errorf("normalized request does not match, expected %s, received %s", expected, reply.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+// Example reply cache middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// Cache decisions published in the chain state
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+var (
+	// Chain state fact with the decision of `CacheMicroMiddleware`, either
+	// `CacheHit` or `CacheMiss`
+	StateCacheDecision = natsmicromw.NewStateKey[string]("cache")
+)
+
+// Request headers whose values are part of the default cache key, so that
+// callers with different credentials or tenants do not share replies
+var DefaultCacheVaryHeaders = []string{HeaderAuthorization, HeaderAPIKey, HeaderIdentityAssertion, HeaderTenant}
+
+type CacheOptions struct {
+	// How long replies are cached, 1 minute if zero
+	TTL time.Duration
+	// Maximum number of cached replies, defaults to 10000
+	MaxEntries int
+	// Key of a request, `CacheKey` by default. Replies are shared by the
+	// requests with the same key, so it must include whatever the reply
+	// depends on besides the subject and payload, e.g. the caller.
+	Key func(ctx context.Context, subject string, headers micro.Headers, data []byte) string
+	// Namespace and constant labels of the Prometheus metrics of the cache,
+	// e.g. to tell apart several caches registered with the same registry
+	MetricsNamespace string
+	MetricsLabels    map[string]string
+}
+
+// CacheStats are the counters of a reply cache.
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Entries   int
+}
+
+// CacheEntry is a cached reply with the request it answers, e.g. in a
+// snapshot used to prime the cache of a new instance.
+type CacheEntry struct {
+	// Key of the request, computed from the subject and payload if empty
+	Key     string              `json:"key,omitempty"`
+	Subject string              `json:"subject"`
+	Payload []byte              `json:"payload,omitempty"`
+	Data    []byte              `json:"data,omitempty"`
+	Headers map[string][]string `json:"headers,omitempty"`
+}
+
+type cachedReply struct {
+	entry   CacheEntry
+	expires time.Time
+}
+
+// ReplyCache caches successful replies by request key, see `CacheKey`.
+type ReplyCache struct {
+	opts CacheOptions
+
+	mu      sync.Mutex
+	entries map[string]cachedReply
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// CacheKey is the default key of a request, its subject and the SHA-256
+// hash of its payload, the identity published in the chain state, see
+// `StateIdentity`, and the values of the `DefaultCacheVaryHeaders`.
+func CacheKey(ctx context.Context, subject string, headers micro.Headers, data []byte) string {
+	h := sha256.New()
+	if ctx != nil {
+		if identity, ok := StateIdentity.Get(ctx); ok {
+			h.Write([]byte(identity))
+		}
+	}
+	h.Write([]byte{0})
+	for _, key := range DefaultCacheVaryHeaders {
+		for _, value := range nats.Header(headers).Values(key) {
+			h.Write([]byte(value))
+			h.Write([]byte{0})
+		}
+		h.Write([]byte{0})
+	}
+	h.Write(data)
+	return subject + " " + hex.EncodeToString(h.Sum(nil))
+}
+
+// NewReplyCache creates a new reply cache, use it with `CacheMicroMiddleware`.
+func NewReplyCache(opts CacheOptions) *ReplyCache {
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 10000
+	}
+	if opts.Key == nil {
+		opts.Key = CacheKey
+	}
+	return &ReplyCache{
+		opts:    opts,
+		entries: make(map[string]cachedReply),
+	}
+}
+
+// Store the entry, copying the reply so later changes do not affect it
+func (c *ReplyCache) store(entry CacheEntry) {
+	headers := nats.Header{}
+	for k, v := range entry.Headers {
+		headers[k] = append([]string{}, v...)
+	}
+	entry.Headers = headers
+	entry.Data = append([]byte{}, entry.Data...)
+	if entry.Key == "" {
+		entry.Key = c.opts.Key(context.Background(), entry.Subject, nil, entry.Payload)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[entry.Key]; !ok {
+		c.evict()
+	}
+	c.entries[entry.Key] = cachedReply{entry, time.Now().Add(c.opts.TTL)}
+}
+
+// Make room for a new entry, dropping expired entries first and an
+// arbitrary one if none have expired
+func (c *ReplyCache) evict() {
+	if len(c.entries) < c.opts.MaxEntries {
+		return
+	}
+
+	now := time.Now()
+	for key, cached := range c.entries {
+		if now.After(cached.expires) {
+			delete(c.entries, key)
+			c.evictions.Add(1)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) < c.opts.MaxEntries {
+			break
+		}
+		delete(c.entries, key)
+		c.evictions.Add(1)
+	}
+}
+
+// Return a copy of the cached reply with the key
+func (c *ReplyCache) lookup(key string) (*natsmicromw.MicroReply, bool) {
+	c.mu.Lock()
+	cached, ok := c.entries[key]
+	if ok && time.Now().After(cached.expires) {
+		delete(c.entries, key)
+		c.evictions.Add(1)
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+
+	headers := nats.Header{}
+	for k, v := range cached.entry.Headers {
+		headers[k] = append([]string{}, v...)
+	}
+	return &natsmicromw.MicroReply{
+		Headers: micro.Headers(headers),
+		Data:    append([]byte{}, cached.entry.Data...),
+	}, true
+}
+
+// Prime caches the reply to a request with the subject and payload, e.g.
+// to warm up the cache after a deploy before the first requests arrive. The
+// reply is cached for requests without credentials, see `CacheKey`.
+func (c *ReplyCache) Prime(subject string, payload []byte, reply *natsmicromw.MicroReply) {
+	c.store(CacheEntry{Subject: subject, Payload: payload, Data: reply.Data, Headers: reply.Headers})
+}
+
+// Load primes the cache with the entries of a snapshot.
+func (c *ReplyCache) Load(entries []CacheEntry) {
+	for _, entry := range entries {
+		c.store(entry)
+	}
+}
+
+// Snapshot returns the cached replies that have not expired, e.g. to store
+// them for priming the cache of the next instance.
+func (c *ReplyCache) Snapshot() []CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entries := make([]CacheEntry, 0, len(c.entries))
+	for _, cached := range c.entries {
+		if !now.After(cached.expires) {
+			entries = append(entries, cached.entry)
+		}
+	}
+	return entries
+}
+
+// Stats returns the counters of the cache.
+func (c *ReplyCache) Stats() CacheStats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+	}
+}
+
+// Whether the reply is successful, and not an error reply created with
+// `natsmicromw.NewMicroError`
+func successReply(reply *natsmicromw.MicroReply) bool {
+	if reply == nil {
+		return false
+	}
+	_, _, isError := reply.ServiceError()
+	return !isError
+}
+
+// Return the error the request was answered with, for middleware recording
+// the outcome of requests: the timeout error if the handler timeout expired,
+// else the error of the handler, or of its reply if it is an error reply
+// created with `natsmicromw.NewMicroError`
+func replyError(ctx context.Context, reply *natsmicromw.MicroReply, err error) error {
+	if natsmicromw.HandlerTimedOut(ctx) {
+		return natsmicromw.Errorf(natsmicromw.CodeTimeout, "%w", natsmicromw.ErrHandlerTimeout)
+	}
+	if err != nil || reply == nil {
+		return err
+	}
+	if code, description, ok := reply.ServiceError(); ok {
+		return &natsmicromw.HandlerError{Description: description, Code: code}
+	}
+	return nil
+}
+
+// CacheMicroMiddleware replies from the cache to requests with the same key
+// as a cached one, by default the same subject, payload and caller, and
+// caches successful replies. Add it after the authentication middleware for
+// the key to include the authenticated identity. The
+// cache also implements `prometheus.Collector` for its statistics, unless
+// built with the `natsmicromw_noprometheus` tag.
+func CacheMicroMiddleware(c *ReplyCache) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			data, err := req.ReadData()
+			if err != nil {
+				return nil, err
+			}
+			key := c.opts.Key(req.Context(), req.Subject, req.Headers, data)
+			if reply, ok := c.lookup(key); ok {
+				StateCacheDecision.Set(req.Context(), CacheHit)
+				return reply, nil
+			}
+			StateCacheDecision.Set(req.Context(), CacheMiss)
+
+			reply, err := next(req)
+			if err == nil && successReply(reply) {
+				c.store(CacheEntry{Key: key, Subject: req.Subject, Payload: data, Data: reply.Data, Headers: reply.Headers})
+			}
+			return reply, err
+		}
+	}
+}
+
+// CacheInvalidation removes cached replies, those with a request subject
+// matching the pattern, which may contain wildcards, or with the key.
+type CacheInvalidation struct {
+	Subject string `json:"subject,omitempty"`
+	Key     string `json:"key,omitempty"`
+}
+
+// CacheInvalidationSubject returns the default subject for invalidating the
+// caches of a service, e.g. "orders.cache.invalidate".
+func CacheInvalidationSubject(service string) string {
+	return service + ".cache.invalidate"
+}
+
+// Whether the subject matches the pattern with `*` and `>` wildcards
+func matchSubject(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || (token != "*" && token != subjectTokens[i]) {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
+
+// Invalidate removes the cached replies matching the invalidation and
+// returns their number.
+func (c *ReplyCache) Invalidate(inv CacheInvalidation) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, cached := range c.entries {
+		if (inv.Key != "" && key == inv.Key) || (inv.Subject != "" && matchSubject(inv.Subject, cached.entry.Subject)) {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Subscribe removes the cached replies matching the invalidations received
+// on the subject. Every instance subscribes without a queue group, so an
+// invalidation published by one instance, e.g. after a write, applies to
+// the caches of the whole fleet.
+func (c *ReplyCache) Subscribe(nc *nats.Conn, subject string) (*nats.Subscription, error) {
+	return nc.Subscribe(subject, func(msg *nats.Msg) {
+		var inv CacheInvalidation
+		if err := json.Unmarshal(msg.Data, &inv); err != nil {
+			return
+		}
+		c.Invalidate(inv)
+	})
+}
+
+// PublishCacheInvalidation publishes the invalidation to the caches
+// subscribed to the subject.
+func PublishCacheInvalidation(nc *nats.Conn, subject string, inv CacheInvalidation) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return nc.Publish(subject, data)
+}
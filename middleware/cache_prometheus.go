@@ -0,0 +1,45 @@
+//go:build !natsmicromw_noprometheus
+
+// Prometheus collector of the reply cache
+
+package middleware
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Descriptors of the metrics of the cache, with its namespace and labels
+func (c *ReplyCache) descs() (hits, misses, evictions, entries *prometheus.Desc) {
+	name := func(name string) string {
+		return prometheus.BuildFQName(c.opts.MetricsNamespace, "", name)
+	}
+	labels := prometheus.Labels(c.opts.MetricsLabels)
+	hits = prometheus.NewDesc(name("nats_cache_hits_total"),
+		"Requests replied to from the cache.", nil, labels)
+	misses = prometheus.NewDesc(name("nats_cache_misses_total"),
+		"Requests not found in the cache.", nil, labels)
+	evictions = prometheus.NewDesc(name("nats_cache_evictions_total"),
+		"Replies removed from the cache when expired or out of room.", nil, labels)
+	entries = prometheus.NewDesc(name("nats_cache_entries"),
+		"Replies in the cache.", nil, labels)
+	return
+}
+
+// Describe implements `prometheus.Collector`.
+func (c *ReplyCache) Describe(ch chan<- *prometheus.Desc) {
+	hits, misses, evictions, entries := c.descs()
+	ch <- hits
+	ch <- misses
+	ch <- evictions
+	ch <- entries
+}
+
+// Collect implements `prometheus.Collector`.
+func (c *ReplyCache) Collect(ch chan<- prometheus.Metric) {
+	hits, misses, evictions, entries := c.descs()
+	stats := c.Stats()
+	ch <- prometheus.MustNewConstMetric(hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(evictions, prometheus.CounterValue, float64(stats.Evictions))
+	ch <- prometheus.MustNewConstMetric(entries, prometheus.GaugeValue, float64(stats.Entries))
+}
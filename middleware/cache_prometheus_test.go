@@ -0,0 +1,33 @@
+//go:build !natsmicromw_noprometheus
+
+package middleware
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReplyCacheCollector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	orders := NewReplyCache(CacheOptions{TTL: time.Minute, MetricsNamespace: "shop", MetricsLabels: map[string]string{"cache": "orders"}})
+	users := NewReplyCache(CacheOptions{TTL: time.Minute, MetricsNamespace: "shop", MetricsLabels: map[string]string{"cache": "users"}})
+	for _, c := range []prometheus.Collector{orders, users} {
+		if err := reg.Register(c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := `
+# HELP shop_nats_cache_entries Replies in the cache.
+# TYPE shop_nats_cache_entries gauge
+shop_nats_cache_entries{cache="orders"} 0
+shop_nats_cache_entries{cache="users"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "shop_nats_cache_entries"); err != nil {
+		t.Errorf("unexpected metrics: %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestCacheMicroMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	cache := NewReplyCache(CacheOptions{TTL: time.Minute})
+	calls := 0
+	err := nm.UseMicro(CacheMicroMiddleware(cache)).AddMicroEndpoint("foo", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		calls++
+		return microEcho(req)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := func(t *testing.T, data string) string {
+		reply, err := nc.Request("foo", []byte(data), time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return string(reply.Data)
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		if request(t, "a") != "a" || request(t, "a") != "a" {
+			t.Errorf("unexpected reply")
+		}
+		if calls != 1 {
+			t.Errorf("expected one handler call, got %d", calls)
+		}
+	})
+
+	t.Run("primed", func(t *testing.T) {
+		cache.Prime("foo", []byte("b"), natsmicromw.NewMicroReply([]byte("primed")))
+		if reply := request(t, "b"); reply != "primed" {
+			t.Errorf("expected primed reply, received %q", reply)
+		}
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		other := NewReplyCache(CacheOptions{TTL: time.Minute})
+		other.Load(cache.Snapshot())
+		if reply, ok := other.lookup(CacheKey(context.Background(), "foo", nil, []byte("b"))); !ok || string(reply.Data) != "primed" {
+			t.Errorf("snapshot not loaded: %v", reply)
+		}
+	})
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheSkipsErrorReplies(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	cache := NewReplyCache(CacheOptions{TTL: time.Minute})
+	err := nm.UseMicro(CacheMicroMiddleware(cache)).AddMicroEndpoint("foo", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return natsmicromw.NewMicroError(natsmicromw.CodeNotFound, "not found", nil, nil), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		reply, err := nc.Request("foo", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if herr := natsmicromw.HandlerErrorFromMsg(reply); herr == nil || herr.Code != natsmicromw.CodeNotFound {
+			t.Errorf("expected a 404 error, received %v", herr)
+		}
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Hits != 0 {
+		t.Errorf("expected error replies not to be cached, got %+v", stats)
+	}
+}
+
+func TestReplyCacheEviction(t *testing.T) {
+	cache := NewReplyCache(CacheOptions{TTL: time.Minute, MaxEntries: 2})
+	for _, data := range []string{"a", "b", "c"} {
+		cache.Prime("foo", []byte(data), natsmicromw.NewMicroReply([]byte(data)))
+	}
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	expired := NewReplyCache(CacheOptions{TTL: time.Millisecond})
+	expired.Prime("foo", nil, natsmicromw.NewMicroReply(nil))
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := expired.lookup(CacheKey(context.Background(), "foo", nil, nil)); ok {
+		t.Errorf("expired reply returned")
+	}
+	if stats := expired.Stats(); stats.Entries != 0 || stats.Evictions != 1 || stats.Misses != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	s := getServer(t)
+	defer s.Shutdown()
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	subject := CacheInvalidationSubject("TestService")
+	caches := []*ReplyCache{
+		NewReplyCache(CacheOptions{TTL: time.Minute}),
+		NewReplyCache(CacheOptions{TTL: time.Minute}),
+	}
+	for _, cache := range caches {
+		sub, err := cache.Subscribe(nc, subject)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		for _, reqSubject := range []string{"orders.get", "orders.list", "users.get"} {
+			cache.Prime(reqSubject, []byte("1"), natsmicromw.NewMicroReply(nil))
+		}
+	}
+
+	invalidate := func(inv CacheInvalidation) {
+		if err := PublishCacheInvalidation(nc, subject, inv); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := nc.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// Let the subscriptions process the invalidation
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	invalidate(CacheInvalidation{Subject: "orders.*"})
+	for i, cache := range caches {
+		if entries := cache.Stats().Entries; entries != 1 {
+			t.Errorf("cache %d: expected 1 entry, got %d", i, entries)
+		}
+	}
+
+	invalidate(CacheInvalidation{Key: CacheKey(context.Background(), "users.get", nil, []byte("1"))})
+	for i, cache := range caches {
+		if entries := cache.Stats().Entries; entries != 0 {
+			t.Errorf("cache %d: expected no entries, got %d", i, entries)
+		}
+	}
+}
+
+func TestMatchSubject(t *testing.T) {
+	tests := []struct {
+		pattern, subject string
+		match            bool
+	}{
+		{"orders.get", "orders.get", true},
+		{"orders.*", "orders.get", true},
+		{"orders.*", "orders.get.1", false},
+		{"orders.>", "orders.get.1", true},
+		{"orders.>", "orders", false},
+		{"*.get", "users.get", true},
+		{"orders.get", "orders", false},
+	}
+	for _, tt := range tests {
+		if match := matchSubject(tt.pattern, tt.subject); match != tt.match {
+			t.Errorf("matchSubject(%q, %q) = %v, expected %v", tt.pattern, tt.subject, match, tt.match)
+		}
+	}
+}
+
+func TestCacheDecisionState(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	cache := NewReplyCache(CacheOptions{TTL: time.Minute})
+	decisions := make(chan string, 2)
+	label := StateLabel(StateCacheDecision)
+	outer := func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			reply, err := next(req)
+			decisions <- label(req.Context())
+			return reply, err
+		}
+	}
+	err := nm.UseMicro(outer, CacheMicroMiddleware(cache)).AddMicroEndpoint("foo", microEcho)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, expected := range []string{CacheMiss, CacheHit} {
+		if _, err := nc.Request("foo", []byte("a"), time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if decision := <-decisions; decision != expected {
+			t.Errorf("expected %q, received %q", expected, decision)
+		}
+	}
+}
+
+func TestCacheKeyVariesByCaller(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	cache := NewReplyCache(CacheOptions{})
+	err := nm.UseMicro(CacheMicroMiddleware(cache)).AddMicroEndpoint("me", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return natsmicromw.NewMicroReply([]byte(req.HeaderGet(HeaderAPIKey))), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := func(t *testing.T, key string) string {
+		msg := nats.NewMsg("me")
+		msg.Header.Set(HeaderAPIKey, key)
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return string(reply.Data)
+	}
+	for _, key := range []string{"alice", "bob", "alice"} {
+		if reply := request(t, key); reply != key {
+			t.Errorf("expected the reply of %s, received %q", key, reply)
+		}
+	}
+	// A zero TTL uses the default instead of expiring right away
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Entries != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
@@ -28,6 +28,10 @@ var (
 	compressMin = 1000
 
 	ErrUnsupportedEncoding = errors.New("unsupported encoding")
+
+	// Chain state fact with the size of compressed requests once
+	// decompressed, set by `CompressionMiddleware`
+	StateDecompressedSize = natsmicromw.NewStateKey[int]("decompressed_size")
 )
 
 // SetCompressMin sets the global minimum size for compression.
@@ -125,15 +129,31 @@ func readCompressedData(encoding string, data []byte) ([]byte, error) {
 
 // decompressRequest decompresses the message data if it was compressed.
 func decompressRequest(req *natsmicromw.MicroRequest) error {
-	data, err := readCompressedData(req.HeaderGet(HeaderEncoding), req.Data)
+	encoding := req.HeaderGet(HeaderEncoding)
+	data, err := readCompressedData(encoding, req.Data)
 	if err != nil {
 		return err
 	}
 	req.Data = data
+	if encoding != "" {
+		StateDecompressedSize.Set(req.Context(), len(data))
+	}
 
 	return nil
 }
 
+// Open a streaming reader over possibly-compressed content
+func compressedReader(encoding string, data []byte) (io.ReadCloser, error) {
+	if encoding == "gzip" {
+		return gzip.NewReader(bytes.NewReader(data))
+	} else if encoding == "deflate" {
+		return flate.NewReader(bytes.NewReader(data)), nil
+	} else if len(encoding) > 0 {
+		return nil, ErrUnsupportedEncoding
+	}
+	return nil, nil
+}
+
 func CompressionMiddleware(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
 	return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
 		// Decompress incoming request
@@ -148,7 +168,37 @@ func CompressionMiddleware(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroH
 		}
 
 		// Finally also compress reply
-		accept := CompressionType(req.HeaderGet(HeaderAcceptEncoding))
+		accept := TenantConfigFromContext(req.Context()).Encoding(CompressionType(req.HeaderGet(HeaderAcceptEncoding)))
+		if err := compressReply(accept, res); err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+}
+
+// Same as `CompressionMiddleware`, but compressed requests are decompressed
+// as a stream (see `MicroRequest.Body`) instead of into a new buffer.
+// Payload-dependent middleware after this one, such as the cache, routing or
+// authorization, reads the stream into Data with `MicroRequest.ReadData`, so
+// streaming only pays off when nothing before the handler needs the payload.
+func StreamingCompressionMiddleware(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		body, err := compressedReader(req.HeaderGet(HeaderEncoding), req.Data)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			defer body.Close()
+			req = req.WithBody(body)
+		}
+
+		res, err := next(req)
+		if err != nil {
+			return nil, err
+		}
+
+		accept := TenantConfigFromContext(req.Context()).Encoding(CompressionType(req.HeaderGet(HeaderAcceptEncoding)))
 		if err := compressReply(accept, res); err != nil {
 			return nil, err
 		}
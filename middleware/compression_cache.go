@@ -0,0 +1,140 @@
+// Example compression negotiation cache for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+const (
+	// Optional ID distinguishing the clients of one identity
+	HeaderClientID string = "client-id"
+)
+
+type compressionCacheEntry struct {
+	encoding CompressionType
+	expires  time.Time
+}
+
+// CompressionCache remembers the encoding each client accepts, keyed by the
+// verified identity of the caller and the optional client ID header, so that
+// chatty clients can omit the accept header. The zero value is ready to use.
+type CompressionCache struct {
+	TTL time.Duration
+	// Maximum number of clients remembered, defaults to 10000
+	MaxClients int
+
+	mu      sync.Mutex
+	entries map[string]compressionCacheEntry
+}
+
+// NewCompressionCache creates a cache remembering encodings for the duration.
+func NewCompressionCache(ttl time.Duration) *CompressionCache {
+	return &CompressionCache{
+		TTL:     ttl,
+		entries: make(map[string]compressionCacheEntry),
+	}
+}
+
+// Remember the encoding accepted by the client.
+func (c *CompressionCache) Remember(clientID string, encoding CompressionType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]compressionCacheEntry)
+	}
+	if _, ok := c.entries[clientID]; !ok {
+		c.evict()
+	}
+	c.entries[clientID] = compressionCacheEntry{encoding, time.Now().Add(c.TTL)}
+}
+
+// Lookup returns the encoding remembered for the client.
+func (c *CompressionCache) Lookup(clientID string) (CompressionType, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[clientID]
+	if !ok {
+		return CompressionNone, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, clientID)
+		return CompressionNone, false
+	}
+	return entry.encoding, true
+}
+
+// Make room for a new client, dropping expired entries first and an
+// arbitrary one if none have expired
+func (c *CompressionCache) evict() {
+	max := c.MaxClients
+	if max <= 0 {
+		max = 10000
+	}
+	if len(c.entries) < max {
+		return
+	}
+
+	now := time.Now()
+	for id, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, id)
+		}
+	}
+	for id := range c.entries {
+		if len(c.entries) < max {
+			break
+		}
+		delete(c.entries, id)
+	}
+}
+
+// Cache key of the caller, empty for anonymous requests whose client ID
+// header cannot be trusted
+func compressionCacheKey(ctx context.Context, clientID string) string {
+	identity := identityKey(ctx)
+	if identity == "" {
+		return ""
+	}
+	return identity + "\x00" + clientID
+}
+
+// Same as `CompressionMiddleware`, but the accepted encoding is remembered
+// per caller and used for later replies to requests without the header. The
+// caller is the identity set by the authentication middleware, which must
+// run before this one, and the `client-id` header if several clients share
+// an identity. Encodings of anonymous requests are not remembered.
+func CompressionCacheMiddleware(cache *CompressionCache) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if err := decompressRequest(req); err != nil {
+				return nil, err
+			}
+
+			res, err := next(req)
+			if err != nil {
+				return nil, err
+			}
+
+			accept := CompressionType(req.HeaderGet(HeaderAcceptEncoding))
+			if key := compressionCacheKey(req.Context(), req.HeaderGet(HeaderClientID)); key != "" {
+				if accept != CompressionNone {
+					cache.Remember(key, accept)
+				} else if remembered, ok := cache.Lookup(key); ok {
+					accept = remembered
+				}
+			}
+			if err := compressReply(accept, res); err != nil {
+				return nil, err
+			}
+
+			return res, nil
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestCompressionCacheMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	cache := NewCompressionCache(time.Minute)
+	store := StaticAPIKeys{
+		"key-1": {ID: "first", Owner: "acme"},
+		"key-2": {ID: "second", Owner: "acme"},
+	}
+	if err := nm.UseMicro(APIKeyMicroMiddleware(store), CompressionCacheMiddleware(cache)).AddMicroEndpoint("echo", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.UseMicro(CompressionCacheMiddleware(cache)).AddMicroEndpoint("anonymous", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("data"), 1000)
+	request := func(subject, apiKey, clientID string, accept CompressionType) string {
+		msg := nats.NewMsg(subject)
+		msg.Data = data
+		msg.Header.Set(HeaderAPIKey, apiKey)
+		msg.Header.Set(HeaderClientID, clientID)
+		if accept != CompressionNone {
+			msg.Header.Set(HeaderAcceptEncoding, string(accept))
+		}
+		reply, err := nc.RequestMsg(msg, 1*time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return reply.Header.Get(HeaderEncoding)
+	}
+
+	if encoding := request("echo", "key-1", "client-1", CompressionGzip); encoding != "gzip" {
+		t.Errorf("encoding does not match, expected %s, received %s", "gzip", encoding)
+	}
+	if encoding := request("echo", "key-1", "client-1", CompressionNone); encoding != "gzip" {
+		t.Errorf("remembered encoding does not match, expected %s, received %s", "gzip", encoding)
+	}
+	if encoding := request("echo", "key-1", "client-2", CompressionNone); encoding != "" {
+		t.Errorf("unknown client reply was compressed with %s", encoding)
+	}
+	// Another identity cannot use or poison the client's entry
+	if encoding := request("echo", "key-2", "client-1", CompressionNone); encoding != "" {
+		t.Errorf("other identity reply was compressed with %s", encoding)
+	}
+	if encoding := request("echo", "key-2", "client-1", CompressionDeflate); encoding != "deflate" {
+		t.Errorf("encoding does not match, expected %s, received %s", "deflate", encoding)
+	}
+	if encoding := request("echo", "key-1", "client-1", CompressionNone); encoding != "gzip" {
+		t.Errorf("remembered encoding does not match, expected %s, received %s", "gzip", encoding)
+	}
+
+	// Anonymous requests are not remembered
+	if encoding := request("anonymous", "", "client-3", CompressionGzip); encoding != "gzip" {
+		t.Errorf("encoding does not match, expected %s, received %s", "gzip", encoding)
+	}
+	if encoding := request("anonymous", "", "client-3", CompressionNone); encoding != "" {
+		t.Errorf("anonymous reply was compressed with %s", encoding)
+	}
+}
+
+func TestCompressionCacheZeroValue(t *testing.T) {
+	var cache CompressionCache
+	cache.TTL = time.Minute
+	if _, ok := cache.Lookup("client"); ok {
+		t.Errorf("empty cache remembered a client")
+	}
+	cache.Remember("client", CompressionGzip)
+	if encoding, ok := cache.Lookup("client"); !ok || encoding != CompressionGzip {
+		t.Errorf("client was not remembered")
+	}
+}
+
+func TestCompressionCacheEviction(t *testing.T) {
+	cache := NewCompressionCache(time.Minute)
+	cache.MaxClients = 2
+	for i := 0; i < 5; i++ {
+		cache.Remember(fmt.Sprintf("client-%d", i), CompressionDeflate)
+	}
+	if len(cache.entries) != 2 {
+		t.Errorf("cache size does not match, expected %d, received %d", 2, len(cache.entries))
+	}
+	if encoding, ok := cache.Lookup("client-4"); !ok || encoding != CompressionDeflate {
+		t.Errorf("latest client was not remembered")
+	}
+
+	cache.TTL = -time.Second
+	cache.Remember("expired", CompressionGzip)
+	if _, ok := cache.Lookup("expired"); ok {
+		t.Errorf("expired client was remembered")
+	}
+}
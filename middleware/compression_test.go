@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"bytes"
+	"context"
 	"testing"
 	"time"
 
@@ -226,3 +227,77 @@ func TestCompressionMiddleware(t *testing.T) {
 		}
 	})
 }
+
+func TestStreamingCompressionMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	nm = nm.UseMicro(StreamingCompressionMiddleware)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	type order struct {
+		ID string `json:"id"`
+	}
+	handler := natsmicromw.TypedHandler(natsmicromw.JSONCodec{}, func(ctx context.Context, in order) (order, error) {
+		return in, nil
+	})
+	streamed := func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if !req.Streamed() || req.Data != nil {
+				t.Errorf("request payload is not streamed")
+			}
+			return next(req)
+		}
+	}
+	if err := nm.UseMicro(streamed).AddMicroEndpoint("orders", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	compressed, err := compressGzip([]byte(`{"id":"42"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg := nats.NewMsg("orders")
+	msg.Header.Set(HeaderEncoding, string(CompressionGzip))
+	msg.Data = compressed
+
+	reply, err := nc.RequestMsg(msg, 1*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `{"id":"42"}`; string(reply.Data) != expected {
+		t.Errorf("responses do not match, expected %s, received %s", expected, string(reply.Data))
+	}
+}
+
+func TestStreamingCompressionCacheKey(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	cache := NewReplyCache(CacheOptions{TTL: time.Minute})
+	nm = nm.UseMicro(StreamingCompressionMiddleware, CacheMicroMiddleware(cache))
+	if err := nm.AddMicroEndpoint("foo", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, data := range []string{"first", "second"} {
+		compressed, err := compressGzip([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msg := nats.NewMsg("foo")
+		msg.Header.Set(HeaderEncoding, string(CompressionGzip))
+		msg.Data = compressed
+
+		reply, err := nc.RequestMsg(msg, 1*time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(reply.Data) != data {
+			t.Errorf("responses do not match, expected %s, received %s", data, string(reply.Data))
+		}
+	}
+	if stats := cache.Stats(); stats.Misses != 2 || stats.Entries != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,52 @@
+// Example correlation and causation id middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// Lineage of the request from its headers. The request is identified by
+// its request id if `RequestIdMiddleware` runs before, otherwise by a new id.
+// The first request of a workflow starts its correlation id.
+func requestLineage(ctx context.Context, get func(string) string) natsmicromw.Lineage {
+	id := RequestIdFromContext(ctx)
+	if id == "" {
+		id = newRequestID()
+	}
+	l := natsmicromw.Lineage{
+		CorrelationID: get(natsmicromw.HeaderCorrelationID),
+		CausationID:   get(natsmicromw.HeaderCausationID),
+		MessageID:     id,
+	}
+	if l.CorrelationID == "" {
+		l.CorrelationID = id
+	}
+	return l
+}
+
+// CorrelationMiddleware maintains the `correlation-id` and `causation-id`
+// headers across a workflow of requests. The lineage of the request is
+// stored in the context, see `natsmicromw.LineageFromContext`, and the
+// headers are set on the requests made with the `client` package while
+// handling it, or with `natsmicromw.InjectLineage` for other messages.
+func CorrelationMiddleware() func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			l := requestLineage(req.Context(), req.Headers().Get)
+			return next(req.WithContext(natsmicromw.WithLineage(req.Context(), l)))
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func CorrelationMicroMiddleware() func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			l := requestLineage(req.Context(), req.HeaderGet)
+			return next(req.WithContext(natsmicromw.WithLineage(req.Context(), l)))
+		}
+	}
+}
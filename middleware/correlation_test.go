@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+	"github.com/Karimerto/natsmicromw/client"
+)
+
+func TestCorrelationMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	c := client.New(nc)
+	nm = nm.UseMicro(RequestIdMicroMiddleware(), CorrelationMicroMiddleware())
+
+	// Downstream endpoint replies with its lineage, called by the upstream one
+	err := nm.AddMicroEndpoint("downstream", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		l, _ := natsmicromw.LineageFromContext(req.Context())
+		data, err := json.Marshal(l)
+		return natsmicromw.NewMicroReply(data), err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = nm.AddMicroEndpoint("upstream", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		reply, err := c.Request(req.Context(), "downstream", nil)
+		if err != nil {
+			return nil, err
+		}
+		return natsmicromw.NewMicroReply(reply.Data), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := func(t *testing.T, headers map[string]string) natsmicromw.Lineage {
+		msg := nats.NewMsg("upstream")
+		for k, v := range headers {
+			msg.Header.Set(k, v)
+		}
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var l natsmicromw.Lineage
+		if err := json.Unmarshal(reply.Data, &l); err != nil {
+			t.Fatalf("unexpected reply %q: %v", reply.Data, err)
+		}
+		return l
+	}
+
+	t.Run("new workflow", func(t *testing.T) {
+		l := request(t, map[string]string{"request_id": "req-1"})
+		if l.CorrelationID != "req-1" || l.CausationID != "req-1" {
+			t.Errorf("expected the upstream request as correlation and cause, got %+v", l)
+		}
+		if l.MessageID == "" || l.MessageID == "req-1" {
+			t.Errorf("expected a new message id, got %+v", l)
+		}
+	})
+
+	t.Run("existing workflow", func(t *testing.T) {
+		l := request(t, map[string]string{
+			"request_id":                    "req-2",
+			natsmicromw.HeaderCorrelationID: "workflow",
+			natsmicromw.HeaderCausationID:   "cause",
+		})
+		if l.CorrelationID != "workflow" || l.CausationID != "req-2" {
+			t.Errorf("expected the workflow correlation and upstream cause, got %+v", l)
+		}
+	})
+}
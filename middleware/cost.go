@@ -0,0 +1,148 @@
+// Example request cost accounting middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+)
+
+// CostInput describes a handled request for the cost function.
+type CostInput struct {
+	Subject      string
+	RequestSize  int
+	ResponseSize int
+	Duration     time.Duration
+	Err          error
+}
+
+// CostFunc computes the cost of a request in arbitrary units.
+type CostFunc func(in CostInput) float64
+
+// UsageRecord is published for each accounted request.
+type UsageRecord struct {
+	Identity     string        `json:"identity"`
+	Subject      string        `json:"subject"`
+	Cost         float64       `json:"cost"`
+	RequestSize  int           `json:"request_size"`
+	ResponseSize int           `json:"response_size,omitempty"`
+	Duration     time.Duration `json:"duration"`
+	Error        string        `json:"error,omitempty"`
+	Timestamp    time.Time     `json:"timestamp"`
+}
+
+type CostOptions struct {
+	// Conn is used to publish the usage records
+	Conn *nats.Conn
+	// Records are published to `<SubjectPrefix>.<identity>`, capture the
+	// subjects with a JetStream stream for billing pipelines. Defaults to "usage".
+	SubjectPrefix string
+	// Cost defaults to one unit per request
+	Cost CostFunc
+	// Identity defaults to the API key ID, OAuth2 token subject or auth
+	// callout principal. Requests without an identity are accounted to "anonymous".
+	Identity func(ctx context.Context) string
+}
+
+func defaultCostOptions(opts CostOptions) CostOptions {
+	if opts.SubjectPrefix == "" {
+		opts.SubjectPrefix = "usage"
+	}
+	if opts.Cost == nil {
+		opts.Cost = func(CostInput) float64 { return 1 }
+	}
+	if opts.Identity == nil {
+		opts.Identity = identityKey
+	}
+	return opts
+}
+
+// Replace characters that are not allowed in a single subject token
+var subjectTokenReplacer = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_")
+
+func publishUsage(ctx context.Context, opts CostOptions, in CostInput) {
+	identity := opts.Identity(ctx)
+	if identity == "" {
+		identity = "anonymous"
+	}
+
+	record := UsageRecord{
+		Identity:     identity,
+		Subject:      in.Subject,
+		Cost:         opts.Cost(in),
+		RequestSize:  in.RequestSize,
+		ResponseSize: in.ResponseSize,
+		Duration:     in.Duration,
+		Timestamp:    time.Now(),
+	}
+	if in.Err != nil {
+		record.Error = in.Err.Error()
+	}
+
+	// Accounting must never fail the request itself
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	_ = opts.Conn.Publish(opts.SubjectPrefix+"."+subjectTokenReplacer.Replace(identity), data)
+}
+
+// CostMiddleware computes the cost of each request and publishes a usage
+// record per identity. Add it after the authentication middleware, whose
+// identity it reads from the request context. Response sizes are not available to context
+// middleware, use `CostMicroMiddleware` if the cost depends on them.
+func CostMiddleware(opts CostOptions) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	opts = defaultCostOptions(opts)
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			start := time.Now()
+			err := next(req)
+			publishUsage(req.Context(), opts, CostInput{
+				Subject:     req.Subject(),
+				RequestSize: len(req.Data()),
+				Duration:    time.Since(start),
+				Err:         replyError(req.Context(), nil, err),
+			})
+			return err
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func CostMicroMiddleware(opts CostOptions) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	opts = defaultCostOptions(opts)
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			data, err := req.ReadData()
+			if err != nil {
+				return nil, err
+			}
+			start := time.Now()
+			reply, err := next(req)
+
+			in := CostInput{
+				Subject:     req.Subject,
+				RequestSize: len(data),
+				Duration:    time.Since(start),
+				Err:         replyError(req.Context(), reply, err),
+			}
+			if reply != nil {
+				in.ResponseSize = len(reply.Data)
+			}
+			publishUsage(req.Context(), opts, in)
+			return reply, err
+		}
+	}
+}
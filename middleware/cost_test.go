@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestCostMicroMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	sub, err := nc.SubscribeSync("usage.>")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store := StaticAPIKeys{"secret": {ID: "team.a"}}
+	cost := func(in CostInput) float64 {
+		return float64(in.RequestSize + in.ResponseSize)
+	}
+	costMiddleware := CostMicroMiddleware(CostOptions{Conn: nc, Cost: cost})
+	err = nm.UseMicro(costMiddleware).AddMicroEndpoint("echo", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		if string(req.Data) == "fail" {
+			return natsmicromw.NewMicroError(natsmicromw.CodeUnavailable, "unavailable", nil, nil), nil
+		}
+		return natsmicromw.NewMicroReply(append(req.Data, req.Data...)), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = nm.UseMicro(APIKeyMicroMiddleware(store), costMiddleware).AddMicroEndpoint("keyed", microEcho)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	usage := func() (string, UsageRecord) {
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var record UsageRecord
+		if err := json.Unmarshal(msg.Data, &record); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return msg.Subject, record
+	}
+
+	t.Run("anonymous", func(t *testing.T) {
+		if _, err := nc.Request("echo", []byte("data"), time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		subject, record := usage()
+		if subject != "usage.anonymous" || record.Identity != "anonymous" || record.Subject != "echo" {
+			t.Errorf("unexpected usage record on %s: %+v", subject, record)
+		}
+		if record.RequestSize != 4 || record.ResponseSize != 8 || record.Cost != 12 || record.Error != "" {
+			t.Errorf("unexpected usage record: %+v", record)
+		}
+	})
+
+	t.Run("error reply", func(t *testing.T) {
+		if _, err := nc.Request("echo", []byte("fail"), time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, record := usage(); record.Error != "unavailable" {
+			t.Errorf("expected the error to be recorded, received %+v", record)
+		}
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		msg := nats.NewMsg("keyed")
+		msg.Header.Set(HeaderAPIKey, "secret")
+		if _, err := nc.RequestMsg(msg, time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		subject, record := usage()
+		if subject != "usage.team_a" || record.Identity != "team.a" {
+			t.Errorf("unexpected usage record on %s: %+v", subject, record)
+		}
+	})
+}
+
+func TestCostMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	sub, err := nc.SubscribeSync("billing.>")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var input CostInput
+	opts := CostOptions{
+		Conn:          nc,
+		SubjectPrefix: "billing",
+		Cost: func(in CostInput) float64 {
+			input = in
+			return 2.5
+		},
+		Identity: func(ctx context.Context) string { return "tenant" },
+	}
+	errFailed := errors.New("failed")
+	err = nm.UseContext(CostMiddleware(opts)).AddContextEndpoint("work", func(req *natsmicromw.Request) error {
+		time.Sleep(5 * time.Millisecond)
+		return errFailed
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := nc.Request("work", []byte("abc"), time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var record UsageRecord
+	if err := json.Unmarshal(msg.Data, &record); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.Subject != "billing.tenant" || record.Cost != 2.5 || record.RequestSize != 3 || record.Error != "failed" {
+		t.Errorf("unexpected usage record on %s: %+v", msg.Subject, record)
+	}
+	if input.Subject != "work" || input.Duration < 5*time.Millisecond || !errors.Is(input.Err, errFailed) {
+		t.Errorf("unexpected cost input: %+v", input)
+	}
+}
@@ -0,0 +1,97 @@
+// Example Datadog APM middleware for natsmicromw
+
+package middleware
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+const (
+	HeaderDatadogTraceID          string = "x-datadog-trace-id"
+	HeaderDatadogParentID         string = "x-datadog-parent-id"
+	HeaderDatadogSamplingPriority string = "x-datadog-sampling-priority"
+	HeaderDatadogTags             string = "x-datadog-tags"
+
+	// Upper 64 bits of 128-bit trace IDs are carried as a propagated tag
+	datadogTraceIDHighTag = "_dd.p.tid"
+)
+
+// DatadogPropagator reads and writes the headers used by Datadog tracers.
+// Datadog sends decimal 64-bit IDs, they are converted to hex span contexts.
+type DatadogPropagator struct{}
+
+func (DatadogPropagator) Extract(headers micro.Headers) (SpanContext, bool) {
+	traceID, err := strconv.ParseUint(headers.Get(HeaderDatadogTraceID), 10, 64)
+	if err != nil || traceID == 0 {
+		return SpanContext{}, false
+	}
+	spanID, err := strconv.ParseUint(headers.Get(HeaderDatadogParentID), 10, 64)
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	high := strings.Repeat("0", 16)
+	for _, tag := range strings.Split(headers.Get(HeaderDatadogTags), ",") {
+		if value, ok := strings.CutPrefix(tag, datadogTraceIDHighTag+"="); ok && len(value) == 16 {
+			high = strings.ToLower(value)
+		}
+	}
+
+	sc := SpanContext{
+		TraceID: high + fmt.Sprintf("%016x", traceID),
+		SpanID:  fmt.Sprintf("%016x", spanID),
+	}
+	if priority, err := strconv.Atoi(headers.Get(HeaderDatadogSamplingPriority)); err == nil {
+		sampled := priority > 0
+		sc.Sampled = &sampled
+	}
+	return sc, true
+}
+
+func (DatadogPropagator) Inject(sc SpanContext, headers nats.Header) {
+	if len(sc.TraceID) != 32 {
+		return
+	}
+	traceID, err := strconv.ParseUint(sc.TraceID[16:], 16, 64)
+	if err != nil {
+		return
+	}
+	spanID, err := strconv.ParseUint(sc.SpanID, 16, 64)
+	if err != nil {
+		return
+	}
+
+	headers.Set(HeaderDatadogTraceID, strconv.FormatUint(traceID, 10))
+	headers.Set(HeaderDatadogParentID, strconv.FormatUint(spanID, 10))
+	if high := sc.TraceID[:16]; high != strings.Repeat("0", 16) {
+		headers.Set(HeaderDatadogTags, datadogTraceIDHighTag+"="+high)
+	}
+	if sc.Sampled != nil {
+		priority := "0"
+		if *sc.Sampled {
+			priority = "1"
+		}
+		headers.Set(HeaderDatadogSamplingPriority, priority)
+	}
+}
+
+// DatadogMiddleware traces requests with Datadog header propagation. The
+// tracer adapts dd-trace-go, e.g. by starting spans with
+// `tracer.StartSpanFromContext` and `tracer.ChildOf` for the parent, using
+// the "nats.subject" tag as the resource name. Error spans are tagged with
+// the `HandlerError` code as "error.code".
+func DatadogMiddleware(tracer Tracer) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return TracingMiddleware(TracingOptions{Tracer: tracer, Propagator: DatadogPropagator{}})
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func DatadogMicroMiddleware(tracer Tracer) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return TracingMicroMiddleware(TracingOptions{Tracer: tracer, Propagator: DatadogPropagator{}})
+}
@@ -0,0 +1,282 @@
+// Example delta encoding middleware for natsmicromw
+
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+const (
+	// Request header with the entity tag of the payload the client has
+	HeaderDeltaBase string = "delta-base"
+	// Reply header telling that the payload is a delta against the base
+	HeaderDeltaEncoding string = "delta-encoding"
+
+	// Delta encoding of JSON merge patches (RFC 7396)
+	DeltaMergePatch string = "merge-patch"
+)
+
+var (
+	ErrDeltaBaseMissing = errors.New("delta base does not match the reply")
+)
+
+type DeltaOptions struct {
+	// Number of payloads kept as delta bases, 1000 if zero
+	MaxVersions int
+}
+
+// DeltaEncoder keeps the recent payloads of the replies, by entity tag, to
+// compute deltas against them.
+type DeltaEncoder struct {
+	opts DeltaOptions
+
+	mu       sync.Mutex
+	versions map[string][]byte
+	// Entity tags in the order they were stored, for evicting the oldest
+	order []string
+}
+
+// NewDeltaEncoder creates a new encoder with the given options.
+func NewDeltaEncoder(opts DeltaOptions) *DeltaEncoder {
+	if opts.MaxVersions <= 0 {
+		opts.MaxVersions = 1000
+	}
+	return &DeltaEncoder{
+		opts:     opts,
+		versions: make(map[string][]byte),
+	}
+}
+
+// Keep the payload as a delta base
+func (d *DeltaEncoder) store(etag string, data []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.versions[etag]; ok {
+		return
+	}
+	if len(d.order) >= d.opts.MaxVersions {
+		delete(d.versions, d.order[0])
+		d.order = d.order[1:]
+	}
+	d.versions[etag] = append([]byte{}, data...)
+	d.order = append(d.order, etag)
+}
+
+func (d *DeltaEncoder) base(etag string) ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	data, ok := d.versions[etag]
+	return data, ok
+}
+
+// Set the entity tag of the reply and replace its payload with a merge
+// patch against the base of the client, if it is known and the patch is
+// smaller
+func (d *DeltaEncoder) encode(baseTag string, headers nats.Header, data []byte) (nats.Header, []byte) {
+	etag := headers.Get(HeaderETag)
+	if etag == "" {
+		etag = computeETag(data)
+		headers.Set(HeaderETag, etag)
+	}
+	d.store(etag, data)
+	if baseTag == "" {
+		return headers, data
+	}
+	base, ok := d.base(baseTag)
+	if !ok {
+		return headers, data
+	}
+	patch, ok := createMergePatch(base, data)
+	if !ok || len(patch) >= len(data) {
+		return headers, data
+	}
+	headers.Set(HeaderDeltaEncoding, DeltaMergePatch)
+	return headers, patch
+}
+
+// Decode a JSON object, keeping numbers as they are
+func decodeObject(data []byte) (map[string]any, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil || obj == nil {
+		return nil, false
+	}
+	return obj, true
+}
+
+// Return the merge patch turning the original JSON object into the target,
+// false if they are not objects or the target has null values, which merge
+// patches cannot express
+func createMergePatch(original, target []byte) ([]byte, bool) {
+	from, ok := decodeObject(original)
+	if !ok {
+		return nil, false
+	}
+	to, ok := decodeObject(target)
+	if !ok {
+		return nil, false
+	}
+	patch, ok := diffObjects(from, to)
+	if !ok {
+		return nil, false
+	}
+	data, err := json.Marshal(patch)
+	return data, err == nil
+}
+
+func diffObjects(from, to map[string]any) (map[string]any, bool) {
+	patch := map[string]any{}
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	for key, value := range to {
+		if containsNull(value) {
+			return nil, false
+		}
+		old, existed := from[key]
+		if existed && reflect.DeepEqual(old, value) {
+			continue
+		}
+		oldObj, oldIsObj := old.(map[string]any)
+		newObj, newIsObj := value.(map[string]any)
+		if existed && oldIsObj && newIsObj {
+			sub, ok := diffObjects(oldObj, newObj)
+			if !ok {
+				return nil, false
+			}
+			patch[key] = sub
+			continue
+		}
+		patch[key] = value
+	}
+	return patch, true
+}
+
+func containsNull(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case map[string]any:
+		for _, item := range v {
+			if containsNull(item) {
+				return true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if containsNull(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ApplyMergePatch applies the JSON merge patch (RFC 7396) to the JSON
+// document and returns the result.
+func ApplyMergePatch(doc, patch []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var target any
+	if err := dec.Decode(&target); err != nil {
+		return nil, err
+	}
+	dec = json.NewDecoder(bytes.NewReader(patch))
+	dec.UseNumber()
+	var p any
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+		} else {
+			t[key] = mergePatch(t[key], value)
+		}
+	}
+	return t
+}
+
+// ApplyDelta returns the full payload of the reply to a request sent with
+// the `delta-base` header, applying the delta to the base payload if the
+// reply is delta encoded. Clients keep the payload and `etag` of the reply
+// as the base of their next request.
+func ApplyDelta(base []byte, reply *nats.Msg) ([]byte, error) {
+	if reply.Header.Get(HeaderDeltaEncoding) != DeltaMergePatch {
+		return reply.Data, nil
+	}
+	if len(base) == 0 {
+		return nil, ErrDeltaBaseMissing
+	}
+	return ApplyMergePatch(base, reply.Data)
+}
+
+// DeltaMiddleware adds an entity tag to the replies sent by the handler
+// and keeps their JSON payloads, so that requests stating the entity tag of
+// the payload they have in the `delta-base` header are replied with a JSON
+// merge patch against it and the `delta-encoding: merge-patch` header,
+// when the patch is smaller than the payload. This reduces the bandwidth of
+// clients frequently polling slowly changing resources, which rebuild the
+// payload with `ApplyDelta`. Unknown bases, payloads that are not JSON
+// objects and error replies are sent as they are.
+func DeltaMiddleware(d *DeltaEncoder) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			baseTag := req.Headers().Get(HeaderDeltaBase)
+			delta := func(m *nats.Msg) {
+				if m.Header.Get(micro.ErrorCodeHeader) != "" {
+					return
+				}
+				// Copy the headers passed by the handler before changing them
+				headers := nats.Header{}
+				for k, v := range m.Header {
+					headers[k] = v
+				}
+				m.Header, m.Data = d.encode(baseTag, headers, m.Data)
+			}
+			return next(req.WithRespondOpts(delta))
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func DeltaMicroMiddleware(d *DeltaEncoder) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			reply, err := next(req)
+			if err != nil || !successReply(reply) {
+				return reply, err
+			}
+			if reply.Headers == nil {
+				reply.Headers = micro.Headers{}
+			}
+			headers, data := d.encode(req.HeaderGet(HeaderDeltaBase), nats.Header(reply.Headers), reply.Data)
+			reply.Headers, reply.Data = micro.Headers(headers), data
+			return reply, nil
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestDeltaMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	// Each request changes one field of a large resource
+	var version int64
+	resource := func() []byte {
+		data, _ := json.Marshal(map[string]any{
+			"version":     atomic.AddInt64(&version, 1),
+			"description": "a resource changing slowly, polled frequently by the clients",
+			"owner":       map[string]any{"name": "owner", "team": "team"},
+		})
+		return data
+	}
+
+	if err := nm.UseMicro(DeltaMicroMiddleware(NewDeltaEncoder(DeltaOptions{}))).AddMicroEndpoint("micro", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return &natsmicromw.MicroReply{Data: resource()}, nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.UseContext(DeltaMiddleware(NewDeltaEncoder(DeltaOptions{}))).AddContextEndpoint("context", func(req *natsmicromw.Request) error {
+		return req.Respond(resource())
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := func(t *testing.T, subject, base string) *nats.Msg {
+		msg := nats.NewMsg(subject)
+		if base != "" {
+			msg.Header.Set(HeaderDeltaBase, base)
+		}
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return reply
+	}
+
+	for _, subject := range []string{"micro", "context"} {
+		t.Run(subject, func(t *testing.T) {
+			reply := request(t, subject, "")
+			etag := reply.Header.Get(HeaderETag)
+			if etag == "" || reply.Header.Get(HeaderDeltaEncoding) != "" {
+				t.Fatalf("unexpected reply: %q %v", reply.Data, reply.Header)
+			}
+			base := reply.Data
+
+			reply = request(t, subject, etag)
+			if reply.Header.Get(HeaderDeltaEncoding) != DeltaMergePatch || len(reply.Data) >= len(base) {
+				t.Fatalf("expected delta reply, received %q %v", reply.Data, reply.Header)
+			}
+			data, err := ApplyDelta(base, reply)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var doc map[string]any
+			if err := json.Unmarshal(data, &doc); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if doc["version"] != float64(atomic.LoadInt64(&version)) || doc["description"] == nil || doc["owner"] == nil {
+				t.Errorf("unexpected payload: %s", data)
+			}
+
+			reply = request(t, subject, `"unknown"`)
+			if reply.Header.Get(HeaderDeltaEncoding) != "" {
+				t.Errorf("expected full reply, received %q %v", reply.Data, reply.Header)
+			}
+			if data, err := ApplyDelta(nil, reply); err != nil || string(data) != string(reply.Data) {
+				t.Errorf("unexpected full payload: %q %v", data, err)
+			}
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		target   string
+		ok       bool
+	}{
+		{"changed", `{"a":1,"b":{"c":"x","d":[1,2]}}`, `{"a":2,"b":{"c":"x","d":[1,2,3]}}`, true},
+		{"removed", `{"a":1,"b":{"c":"x","d":"y"}}`, `{"a":1,"b":{"c":"x"}}`, true},
+		{"added", `{"a":1}`, `{"a":1,"b":{"c":true}}`, true},
+		{"replaced", `{"a":{"b":1}}`, `{"a":"b"}`, true},
+		{"null", `{"a":1}`, `{"a":null}`, false},
+		{"array", `[1]`, `[2]`, false},
+		{"invalid", `{"a":1}`, `a`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, ok := createMergePatch([]byte(tt.original), []byte(tt.target))
+			if ok != tt.ok {
+				t.Fatalf("expected %v, got %v", tt.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			data, err := ApplyMergePatch([]byte(tt.original), patch)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got, want any
+			_ = json.Unmarshal(data, &got)
+			_ = json.Unmarshal([]byte(tt.target), &want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("patch %s applied to %s gave %s, expected %s", patch, tt.original, data, tt.target)
+			}
+		})
+	}
+}
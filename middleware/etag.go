@@ -0,0 +1,101 @@
+// Example ETag middleware for natsmicromw
+
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+const (
+	// Reply header with the entity tag of the payload
+	HeaderETag string = "etag"
+	// Request header with the entity tags the client already has
+	HeaderIfNoneMatch string = "if-none-match"
+	// Reply header with the status of conditional replies
+	HeaderStatus string = "status"
+
+	// Status of replies without a body, as the client has the current payload
+	StatusNotModified string = "304"
+)
+
+// Quoted entity tag of the payload
+func computeETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Whether the if-none-match header contains the entity tag
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the entity tag of the reply, and drop its payload if the client
+// already has it. Replies that already have an entity tag keep it.
+func conditionalReply(ifNoneMatch string, headers nats.Header, data []byte) (nats.Header, []byte) {
+	etag := headers.Get(HeaderETag)
+	if etag == "" {
+		etag = computeETag(data)
+		headers.Set(HeaderETag, etag)
+	}
+	if etagMatches(ifNoneMatch, etag) {
+		headers.Set(HeaderStatus, StatusNotModified)
+		return headers, nil
+	}
+	return headers, data
+}
+
+// ETagMiddleware adds an entity tag computed from the payload to the
+// replies sent by the handler, and replies without a payload and with the
+// `status: 304` header if the `if-none-match` request header contains the
+// tag, saving bandwidth for clients polling unchanged resources. Handlers
+// can set their own `etag` header, e.g. a version number. Error replies are
+// left unchanged.
+func ETagMiddleware(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(req *natsmicromw.Request) error {
+		ifNoneMatch := req.Headers().Get(HeaderIfNoneMatch)
+		conditional := func(m *nats.Msg) {
+			if m.Header.Get(micro.ErrorCodeHeader) != "" {
+				return
+			}
+			// Copy the headers passed by the handler before changing them
+			headers := nats.Header{}
+			for k, v := range m.Header {
+				headers[k] = v
+			}
+			m.Header, m.Data = conditionalReply(ifNoneMatch, headers, m.Data)
+		}
+		return next(req.WithRespondOpts(conditional))
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func ETagMicroMiddleware(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		reply, err := next(req)
+		if err != nil || !successReply(reply) {
+			return reply, err
+		}
+		if reply.Headers == nil {
+			reply.Headers = micro.Headers{}
+		}
+		headers, data := conditionalReply(req.HeaderGet(HeaderIfNoneMatch), nats.Header(reply.Headers), reply.Data)
+		reply.Headers, reply.Data = micro.Headers(headers), data
+		return reply, nil
+	}
+}
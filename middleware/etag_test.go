@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestETagMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	if err := nm.UseMicro(ETagMicroMiddleware).AddMicroEndpoint("micro", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.UseContext(ETagMiddleware).AddContextEndpoint("context", func(req *natsmicromw.Request) error {
+		return req.Respond(req.Data())
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.UseContext(ETagMiddleware).AddContextEndpoint("error", func(req *natsmicromw.Request) error {
+		return errors.New("failed")
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := func(t *testing.T, subject, ifNoneMatch string) *nats.Msg {
+		msg := nats.NewMsg(subject)
+		msg.Data = []byte("data")
+		if ifNoneMatch != "" {
+			msg.Header.Set(HeaderIfNoneMatch, ifNoneMatch)
+		}
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return reply
+	}
+
+	for _, subject := range []string{"micro", "context"} {
+		t.Run(subject, func(t *testing.T) {
+			reply := request(t, subject, "")
+			etag := reply.Header.Get(HeaderETag)
+			if etag == "" || string(reply.Data) != "data" || reply.Header.Get(HeaderStatus) != "" {
+				t.Fatalf("unexpected reply: %q %v", reply.Data, reply.Header)
+			}
+
+			reply = request(t, subject, `"other", `+etag)
+			if len(reply.Data) != 0 || reply.Header.Get(HeaderStatus) != StatusNotModified || reply.Header.Get(HeaderETag) != etag {
+				t.Errorf("expected not modified reply, received %q %v", reply.Data, reply.Header)
+			}
+
+			reply = request(t, subject, `"other"`)
+			if string(reply.Data) != "data" {
+				t.Errorf("expected full reply, received %q", reply.Data)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		reply := request(t, "error", "*")
+		if reply.Header.Get(HeaderETag) != "" || natsmicromw.HandlerErrorFromMsg(reply) == nil {
+			t.Errorf("error reply changed: %q %v", reply.Data, reply.Header)
+		}
+	})
+}
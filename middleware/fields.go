@@ -0,0 +1,164 @@
+// Example response field filtering middleware for natsmicromw
+
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+const (
+	// Request header with the comma separated fields to keep in the reply,
+	// as dotted paths such as "id,address.city"
+	HeaderFields string = "fields"
+)
+
+// Tree of selected fields, a nil subtree selects the whole value
+type fieldTree map[string]fieldTree
+
+// Parse the comma separated paths into a tree
+func parseFields(fields string) fieldTree {
+	tree := fieldTree{}
+	for _, path := range strings.Split(fields, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		node := tree
+		parts := strings.Split(path, ".")
+		for i, part := range parts {
+			sub, ok := node[part]
+			if ok && sub == nil {
+				// A parent path is already selected whole
+				break
+			}
+			if i == len(parts)-1 {
+				node[part] = nil
+				break
+			}
+			if !ok {
+				sub = fieldTree{}
+				node[part] = sub
+			}
+			node = sub
+		}
+	}
+	return tree
+}
+
+// Keep the selected fields of the value. The selection applies to every
+// element of arrays, and values without fields are kept as they are.
+func (t fieldTree) project(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		projected := make(map[string]any, len(t))
+		for key, sub := range t {
+			value, ok := v[key]
+			if !ok {
+				continue
+			}
+			if sub == nil {
+				projected[key] = value
+			} else {
+				projected[key] = sub.project(value)
+			}
+		}
+		return projected
+	case []any:
+		projected := make([]any, len(v))
+		for i, elem := range v {
+			projected[i] = t.project(elem)
+		}
+		return projected
+	default:
+		return v
+	}
+}
+
+// Remove the selected fields from the value, the opposite of project
+func (t fieldTree) remove(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, sub := range t {
+			value, ok := v[key]
+			if !ok {
+				continue
+			}
+			if sub == nil {
+				delete(v, key)
+			} else {
+				v[key] = sub.remove(value)
+			}
+		}
+		return v
+	case []any:
+		for i, elem := range v {
+			v[i] = t.remove(elem)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// Project the JSON payload down to the fields, returning it unchanged if it
+// is not JSON
+func projectFields(fields string, headers nats.Header, data []byte) []byte {
+	if fields == "" || len(data) == 0 {
+		return data
+	}
+	if contentType := headers.Get(natsmicromw.HeaderContentType); contentType != "" && !strings.Contains(contentType, "json") {
+		return data
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// Keep numbers as they were sent
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return data
+	}
+	projected, err := json.Marshal(parseFields(fields).project(v))
+	if err != nil {
+		return data
+	}
+	return projected
+}
+
+// FieldsMiddleware projects JSON replies down to the fields listed in the
+// `fields` request header, e.g. "id,name,items.sku", cutting payload sizes
+// for constrained clients without changing the handlers. Paths select
+// nested fields, and apply to every element of arrays. Replies that are not
+// JSON and error replies are left unchanged.
+func FieldsMiddleware(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(req *natsmicromw.Request) error {
+		fields := req.Headers().Get(HeaderFields)
+		if fields == "" {
+			return next(req)
+		}
+		project := func(m *nats.Msg) {
+			if m.Header.Get(micro.ErrorCodeHeader) == "" {
+				m.Data = projectFields(fields, m.Header, m.Data)
+			}
+		}
+		return next(req.WithRespondOpts(project))
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func FieldsMicroMiddleware(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		reply, err := next(req)
+		if err != nil || !successReply(reply) {
+			return reply, err
+		}
+		reply.Data = projectFields(req.HeaderGet(HeaderFields), nats.Header(reply.Headers), reply.Data)
+		return reply, nil
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestProjectFields(t *testing.T) {
+	data := `{"id":12345678901234567890,"name":"order","address":{"city":"Oulu","zip":"90100"},"items":[{"sku":"a","qty":1},{"sku":"b","qty":2}]}`
+	tests := []struct {
+		fields   string
+		expected string
+	}{
+		{"", data},
+		{"id,name", `{"id":12345678901234567890,"name":"order"}`},
+		{"address.city", `{"address":{"city":"Oulu"}}`},
+		{"address.city,address", `{"address":{"city":"Oulu","zip":"90100"}}`},
+		{"items.sku, missing", `{"items":[{"sku":"a"},{"sku":"b"}]}`},
+		{"name.first", `{"name":"order"}`},
+	}
+	for _, tt := range tests {
+		if projected := string(projectFields(tt.fields, nats.Header{}, []byte(data))); projected != tt.expected {
+			t.Errorf("fields %q: expected %s, received %s", tt.fields, tt.expected, projected)
+		}
+	}
+
+	if projected := string(projectFields("id", nats.Header{}, []byte("not json"))); projected != "not json" {
+		t.Errorf("payload that is not JSON changed: %s", projected)
+	}
+	headers := nats.Header{natsmicromw.HeaderContentType: []string{"application/xml"}}
+	if projected := string(projectFields("id", headers, []byte(`{"id":1,"x":2}`))); projected != `{"id":1,"x":2}` {
+		t.Errorf("payload with another content type changed: %s", projected)
+	}
+}
+
+func TestFieldsMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	if err := nm.UseMicro(FieldsMicroMiddleware).AddMicroEndpoint("micro", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.UseContext(FieldsMiddleware).AddContextEndpoint("context", func(req *natsmicromw.Request) error {
+		return req.Respond(req.Data())
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, subject := range []string{"micro", "context"} {
+		msg := nats.NewMsg(subject)
+		msg.Data = []byte(`{"id":1,"name":"order","total":10}`)
+		msg.Header.Set(HeaderFields, "id,total")
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(reply.Data) != `{"id":1,"total":10}` {
+			t.Errorf("%s: unexpected reply %s", subject, reply.Data)
+		}
+	}
+}
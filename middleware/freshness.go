@@ -0,0 +1,83 @@
+// Example request freshness middleware for natsmicromw
+
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+const (
+	// Request creation time, either as Unix milliseconds or RFC 3339
+	HeaderRequestTimestamp string = "request-timestamp"
+)
+
+// Parse the timestamp header value
+func parseRequestTimestamp(value string) (time.Time, bool) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), true
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return ts, true
+	}
+	return time.Time{}, false
+}
+
+// Check that the timestamp is within the window in either direction
+func checkFreshness(value string, window time.Duration) error {
+	if value == "" {
+		return &natsmicromw.HandlerError{
+			Description: "missing request timestamp",
+			Code:        natsmicromw.CodeBadRequest,
+		}
+	}
+	ts, ok := parseRequestTimestamp(value)
+	if !ok {
+		return &natsmicromw.HandlerError{
+			Description: "invalid request timestamp",
+			Code:        natsmicromw.CodeBadRequest,
+		}
+	}
+
+	skew := time.Since(ts)
+	if skew > window {
+		return &natsmicromw.HandlerError{
+			Description: "stale request",
+			Code:        natsmicromw.CodeUnauthorized,
+		}
+	} else if skew < -window {
+		return &natsmicromw.HandlerError{
+			Description: "request timestamp is in the future",
+			Code:        natsmicromw.CodeUnauthorized,
+		}
+	}
+	return nil
+}
+
+// RequestFreshnessMiddleware rejects requests whose `request-timestamp`
+// header is missing or further than the window away from the current time.
+// Use it together with request signing to prevent replay attacks.
+func RequestFreshnessMiddleware(window time.Duration) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if err := checkFreshness(req.Headers().Get(HeaderRequestTimestamp), window); err != nil {
+				return err
+			}
+			return next(req)
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func RequestFreshnessMicroMiddleware(window time.Duration) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if err := checkFreshness(req.HeaderGet(HeaderRequestTimestamp), window); err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	}
+}
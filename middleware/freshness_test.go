@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestCheckFreshness(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		value string
+		code  string
+	}{
+		{"unix milliseconds", strconv.FormatInt(now.UnixMilli(), 10), ""},
+		{"rfc3339", now.Format(time.RFC3339Nano), ""},
+		{"missing", "", "400"},
+		{"invalid", "yesterday", "400"},
+		{"stale", strconv.FormatInt(now.Add(-time.Minute).UnixMilli(), 10), "401"},
+		{"future", now.Add(time.Minute).Format(time.RFC3339), "401"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkFreshness(tt.value, 5*time.Second)
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			handlerErr, ok := err.(*natsmicromw.HandlerError)
+			if !ok || handlerErr.Code != tt.code {
+				t.Errorf("expected error code %s, got %v", tt.code, err)
+			}
+		})
+	}
+}
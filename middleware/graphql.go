@@ -0,0 +1,61 @@
+// Example GraphQL-over-NATS handler for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// GraphQLRequest is the standard GraphQL request payload.
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQLExecuteFunc executes a GraphQL request against a schema and returns
+// a JSON-serializable result. The context is the request context, so values
+// set by earlier middleware are available in resolvers.
+//
+// With graphql-go, this is simply:
+//
+//	func(ctx context.Context, req middleware.GraphQLRequest) interface{} {
+//		return graphql.Do(graphql.Params{
+//			Schema:         schema,
+//			RequestString:  req.Query,
+//			OperationName:  req.OperationName,
+//			VariableValues: req.Variables,
+//			Context:        ctx,
+//		})
+//	}
+type GraphQLExecuteFunc func(ctx context.Context, req GraphQLRequest) interface{}
+
+// GraphQLMicroHandler returns a handler serving GraphQL requests. Register it
+// with `AddMicroEndpoint` to run it through the full middleware stack.
+func GraphQLMicroHandler(execute GraphQLExecuteFunc) natsmicromw.MicroHandlerFunc {
+	return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		payload, err := req.ReadData()
+		if err != nil {
+			return nil, err
+		}
+		var gqlReq GraphQLRequest
+		if err := json.Unmarshal(payload, &gqlReq); err != nil || gqlReq.Query == "" {
+			return nil, &natsmicromw.HandlerError{
+				Description: "invalid GraphQL request",
+				Code:        natsmicromw.CodeBadRequest,
+			}
+		}
+
+		data, err := json.Marshal(execute(req.Context(), gqlReq))
+		if err != nil {
+			return nil, err
+		}
+
+		reply := natsmicromw.NewMicroReply(data)
+		reply.HeaderSet(natsmicromw.HeaderContentType, "application/json")
+		return reply, nil
+	}
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestGraphQLMicroHandler(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	execute := func(ctx context.Context, req GraphQLRequest) interface{} {
+		switch req.Query {
+		case "{ hello }":
+			return map[string]interface{}{"data": map[string]interface{}{"hello": "world"}}
+		case "query Greet($name: String) { greet(name: $name) }":
+			return map[string]interface{}{"data": map[string]interface{}{
+				"greet":     "hello " + req.Variables["name"].(string),
+				"operation": req.OperationName,
+			}}
+		default:
+			return map[string]interface{}{"errors": []map[string]string{{"message": "unknown query"}}}
+		}
+	}
+	if err := nm.AddMicroEndpoint("graphql", GraphQLMicroHandler(execute)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		request  string
+		expected string
+		code     string
+	}{
+		{
+			"query",
+			`{"query":"{ hello }"}`,
+			`{"data":{"hello":"world"}}`,
+			"",
+		},
+		{
+			"variables",
+			`{"query":"query Greet($name: String) { greet(name: $name) }","operationName":"Greet","variables":{"name":"nats"}}`,
+			`{"data":{"greet":"hello nats","operation":"Greet"}}`,
+			"",
+		},
+		{
+			"executor errors",
+			`{"query":"{ missing }"}`,
+			`{"errors":[{"message":"unknown query"}]}`,
+			"",
+		},
+		{
+			"invalid json",
+			`{"query":`,
+			`{"description":"invalid GraphQL request","code":"400"}`,
+			natsmicromw.CodeBadRequest,
+		},
+		{
+			"missing query",
+			`{"variables":{}}`,
+			`{"description":"invalid GraphQL request","code":"400"}`,
+			natsmicromw.CodeBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := nc.Request("graphql", []byte(tt.request), 1*time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if code := reply.Header.Get(micro.ErrorCodeHeader); code != tt.code {
+				t.Errorf("error codes do not match, expected %q, received %q", tt.code, code)
+			}
+			if string(reply.Data) != tt.expected {
+				t.Errorf("responses do not match, expected %s, received %s", tt.expected, string(reply.Data))
+			}
+			if tt.code == "" && reply.Header.Get(natsmicromw.HeaderContentType) != "application/json" {
+				t.Errorf("expected application/json content type, received %q", reply.Header.Get(natsmicromw.HeaderContentType))
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// Example auth callout identity middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nkeys"
+)
+
+const (
+	// Signed identity assertion, formatted as `<base64url claims>.<base64url signature>`
+	HeaderIdentityAssertion string = "identity-assertion"
+)
+
+// Longest accepted lifetime of identity assertions by default
+const DefaultMaxAssertionLifetime = time.Hour
+
+var (
+	ErrInvalidAssertion   = errors.New("invalid identity assertion")
+	ErrUntrustedIssuer    = errors.New("untrusted identity issuer")
+	ErrAssertionExpired   = errors.New("identity assertion expired")
+	ErrAssertionNoExpiry  = errors.New("identity assertion without expiry")
+	ErrAssertionLifetime  = errors.New("identity assertion lifetime too long")
+	ErrAssertionSignature = errors.New("invalid identity assertion signature")
+)
+
+var (
+	// Chain state fact with the identity of the request, set by the API key,
+	// OAuth2 and identity assertion middleware to the API key ID, token
+	// subject or principal subject
+	StateIdentity = natsmicromw.NewStateKey[string]("identity")
+)
+
+// Principal is the identity asserted by the auth callout service, typically
+// derived from the client certificate of an mTLS connection.
+type Principal struct {
+	Subject   string   `json:"sub"`
+	Name      string   `json:"name,omitempty"`
+	Account   string   `json:"account,omitempty"`
+	Issuer    string   `json:"iss"`
+	KeyID     string   `json:"kid"`
+	Tags      []string `json:"tags,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+type IdentityOptions struct {
+	// Keys are the accepted public keys of the issuers by key ID. Keys are
+	// rotated by adding the new key, signing with it and removing the old
+	// one once its assertions have expired.
+	Keys map[string]string
+	// KeySecret, if set, replaces `Keys` with the keys of a rotating secret
+	KeySecret *SecretIdentityKeys
+	// MaxLifetime limits how long assertions are valid, from their issue
+	// time if set and in the past and from now otherwise,
+	// `DefaultMaxAssertionLifetime` if zero
+	MaxLifetime time.Duration
+}
+
+// SignIdentityAssertion creates the header value for the principal. This is
+// called by the auth callout service, the issuer is set to the public key of
+// the signing key pair. The principal must have the key ID under which the
+// services accept the key and an expiry time.
+func SignIdentityAssertion(kp nkeys.KeyPair, p Principal) (string, error) {
+	issuer, err := kp.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	p.Issuer = issuer
+
+	claims, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(claims)
+	sig, err := kp.Sign([]byte(encoded))
+	if err != nil {
+		return "", err
+	}
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VerifyIdentityAssertion parses the header value and verifies it was signed
+// by the accepted key of its key ID, expires and has not expired, and is
+// not valid for longer than the maximum lifetime.
+func VerifyIdentityAssertion(value string, opts IdentityOptions) (*Principal, error) {
+	if opts.MaxLifetime <= 0 {
+		opts.MaxLifetime = DefaultMaxAssertionLifetime
+	}
+	encoded, encodedSig, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrInvalidAssertion
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidAssertion
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return nil, ErrInvalidAssertion
+	}
+
+	var p Principal
+	if err := json.Unmarshal(claims, &p); err != nil {
+		return nil, ErrInvalidAssertion
+	}
+
+	keys := opts.Keys
+	if opts.KeySecret != nil {
+		keys = opts.KeySecret.Keys()
+	}
+	if issuer, ok := keys[p.KeyID]; !ok || p.KeyID == "" || issuer != p.Issuer {
+		return nil, ErrUntrustedIssuer
+	}
+
+	pub, err := nkeys.FromPublicKey(p.Issuer)
+	if err != nil {
+		return nil, ErrUntrustedIssuer
+	}
+	if err := pub.Verify([]byte(encoded), sig); err != nil {
+		return nil, ErrAssertionSignature
+	}
+
+	now := time.Now().Unix()
+	switch {
+	case p.ExpiresAt <= 0:
+		return nil, ErrAssertionNoExpiry
+	case now >= p.ExpiresAt:
+		return nil, ErrAssertionExpired
+	}
+	// A future issue time does not extend the lifetime
+	start := now
+	if p.IssuedAt > 0 && p.IssuedAt < now {
+		start = p.IssuedAt
+	}
+	if time.Duration(p.ExpiresAt-start)*time.Second > opts.MaxLifetime {
+		return nil, ErrAssertionLifetime
+	}
+	return &p, nil
+}
+
+type principalContextKey struct{}
+
+// PrincipalFromContext returns the verified principal of the request.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return p
+}
+
+// Verify the assertion and return a context with the principal
+func verifyIdentity(ctx context.Context, value string, opts IdentityOptions) (context.Context, error) {
+	if value == "" {
+		return nil, &natsmicromw.HandlerError{
+			Description: "missing identity assertion",
+			Code:        natsmicromw.CodeUnauthorized,
+		}
+	}
+	p, err := VerifyIdentityAssertion(value, opts)
+	if err != nil {
+		return nil, &natsmicromw.HandlerError{
+			Description: err.Error(),
+			Code:        natsmicromw.CodeUnauthorized,
+		}
+	}
+	StateIdentity.Set(ctx, p.Subject)
+	return context.WithValue(ctx, principalContextKey{}, p), nil
+}
+
+// IdentityMiddleware verifies the `identity-assertion` header forwarded by
+// the auth callout service against the accepted keys, and stores the
+// principal in the request context.
+func IdentityMiddleware(opts IdentityOptions) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			ctx, err := verifyIdentity(req.Context(), req.Headers().Get(HeaderIdentityAssertion), opts)
+			if err != nil {
+				return err
+			}
+			return next(req.WithContext(ctx))
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func IdentityMicroMiddleware(opts IdentityOptions) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			ctx, err := verifyIdentity(req.Context(), req.HeaderGet(HeaderIdentityAssertion), opts)
+			if err != nil {
+				return nil, err
+			}
+			return next(req.WithContext(ctx))
+		}
+	}
+}
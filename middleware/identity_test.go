@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nkeys"
+)
+
+func TestIdentityAssertion(t *testing.T) {
+	issuer, _ := nkeys.CreateAccount()
+	rotated, _ := nkeys.CreateAccount()
+	other, _ := nkeys.CreateAccount()
+	trusted, _ := issuer.PublicKey()
+	trustedRotated, _ := rotated.PublicKey()
+	opts := IdentityOptions{Keys: map[string]string{"2024": trusted, "2025": trustedRotated}}
+
+	principal := func(keyID string) Principal {
+		return Principal{Subject: "CN=orders", KeyID: keyID, ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	}
+
+	t.Run("valid assertion", func(t *testing.T) {
+		value, err := SignIdentityAssertion(issuer, principal("2024"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		p, err := VerifyIdentityAssertion(value, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p.Subject != "CN=orders" || p.Issuer != trusted {
+			t.Errorf("unexpected principal: %+v", p)
+		}
+	})
+
+	t.Run("rotated key", func(t *testing.T) {
+		value, _ := SignIdentityAssertion(rotated, principal("2025"))
+		if _, err := VerifyIdentityAssertion(value, opts); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("untrusted issuer", func(t *testing.T) {
+		value, _ := SignIdentityAssertion(other, principal("2024"))
+		if _, err := VerifyIdentityAssertion(value, opts); err != ErrUntrustedIssuer {
+			t.Errorf("expected ErrUntrustedIssuer, got %v", err)
+		}
+	})
+
+	t.Run("unknown key ID", func(t *testing.T) {
+		for _, keyID := range []string{"", "2023"} {
+			value, _ := SignIdentityAssertion(issuer, principal(keyID))
+			if _, err := VerifyIdentityAssertion(value, opts); err != ErrUntrustedIssuer {
+				t.Errorf("expected ErrUntrustedIssuer for key ID %q, got %v", keyID, err)
+			}
+		}
+	})
+
+	t.Run("tampered claims", func(t *testing.T) {
+		value, _ := SignIdentityAssertion(issuer, principal("2024"))
+		forged := principal("2024")
+		forged.Subject = "CN=admin"
+		forgedValue, _ := SignIdentityAssertion(issuer, forged)
+		// Forged claims with the signature of the original claims
+		claims, _, _ := strings.Cut(forgedValue, ".")
+		_, sig, _ := strings.Cut(value, ".")
+		if _, err := VerifyIdentityAssertion(claims+"."+sig, opts); err != ErrAssertionSignature {
+			t.Errorf("expected ErrAssertionSignature, got %v", err)
+		}
+	})
+
+	t.Run("expired assertion", func(t *testing.T) {
+		p := principal("2024")
+		p.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+		value, _ := SignIdentityAssertion(issuer, p)
+		if _, err := VerifyIdentityAssertion(value, opts); err != ErrAssertionExpired {
+			t.Errorf("expected ErrAssertionExpired, got %v", err)
+		}
+	})
+
+	t.Run("no expiry", func(t *testing.T) {
+		p := principal("2024")
+		p.ExpiresAt = 0
+		value, _ := SignIdentityAssertion(issuer, p)
+		if _, err := VerifyIdentityAssertion(value, opts); err != ErrAssertionNoExpiry {
+			t.Errorf("expected ErrAssertionNoExpiry, got %v", err)
+		}
+	})
+
+	t.Run("lifetime too long", func(t *testing.T) {
+		p := principal("2024")
+		p.ExpiresAt = time.Now().Add(2 * time.Hour).Unix()
+		value, _ := SignIdentityAssertion(issuer, p)
+		if _, err := VerifyIdentityAssertion(value, opts); err != ErrAssertionLifetime {
+			t.Errorf("expected ErrAssertionLifetime, got %v", err)
+		}
+
+		// Measured from the issue time
+		p = principal("2024")
+		p.IssuedAt = time.Now().Add(-2 * time.Hour).Unix()
+		value, _ = SignIdentityAssertion(issuer, p)
+		if _, err := VerifyIdentityAssertion(value, opts); err != ErrAssertionLifetime {
+			t.Errorf("expected ErrAssertionLifetime, got %v", err)
+		}
+
+		// A future issue time is measured from now
+		p = principal("2024")
+		p.IssuedAt = time.Now().Add(24 * time.Hour).Unix()
+		p.ExpiresAt = time.Now().Add(24*time.Hour + 30*time.Minute).Unix()
+		value, _ = SignIdentityAssertion(issuer, p)
+		if _, err := VerifyIdentityAssertion(value, opts); err != ErrAssertionLifetime {
+			t.Errorf("expected ErrAssertionLifetime, got %v", err)
+		}
+	})
+}
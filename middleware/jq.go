@@ -0,0 +1,113 @@
+//go:build !natsmicromw_nojq
+
+// Example jq reply transformation middleware for natsmicromw
+
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/itchyny/gojq"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+var ErrNoJQResult = errors.New("jq expression returned no result")
+
+// JQTransform is a compiled jq expression transforming JSON replies.
+type JQTransform struct {
+	code *gojq.Code
+}
+
+// NewJQTransform compiles the jq expression, e.g.
+// `{id: .orderId, total: (.items | map(.price) | add)}`.
+func NewJQTransform(expr string) (*JQTransform, error) {
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	return &JQTransform{code: code}, nil
+}
+
+// Apply transforms the JSON payload, returning the first result of the
+// expression.
+func (t *JQTransform) Apply(data []byte) ([]byte, error) {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	result, ok := t.code.Run(v).Next()
+	if !ok {
+		return nil, ErrNoJQResult
+	}
+	if err, ok := result.(error); ok {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// Transform the reply, as an internal error if it fails
+func (t *JQTransform) transformReply(data []byte) ([]byte, error) {
+	transformed, err := t.Apply(data)
+	if err != nil {
+		return nil, natsmicromw.Errorf(natsmicromw.CodeInternal, "transforming reply: %w", err)
+	}
+	return transformed, nil
+}
+
+// JQMiddleware applies the jq transformation to the replies sent by the
+// handler, e.g. to adapt an internal reply shape to an external contract
+// during a migration without changing the handler. A reply that cannot be
+// transformed is replaced by a "500" error reply. Error replies are left
+// unchanged.
+func JQMiddleware(t *JQTransform) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			transform := func(m *nats.Msg) {
+				if m.Header.Get(micro.ErrorCodeHeader) != "" {
+					return
+				}
+				data, err := t.transformReply(m.Data)
+				if err == nil {
+					m.Data = data
+					return
+				}
+				// Copy the headers passed by the handler before changing them
+				headers := nats.Header{}
+				for k, v := range m.Header {
+					headers[k] = v
+				}
+				headers.Set(micro.ErrorCodeHeader, natsmicromw.CodeInternal)
+				headers.Set(micro.ErrorHeader, err.Error())
+				m.Header, m.Data = headers, nil
+			}
+			return next(req.WithRespondOpts(transform))
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func JQMicroMiddleware(t *JQTransform) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			reply, err := next(req)
+			if err != nil || !successReply(reply) {
+				return reply, err
+			}
+			data, err := t.transformReply(reply.Data)
+			if err != nil {
+				return nil, err
+			}
+			reply.Data = data
+			return reply, nil
+		}
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !natsmicromw_nojq
+
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestJQMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	transform, err := NewJQTransform(`{id: .orderId, total: (.items | map(.price) | add)}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := NewJQTransform(`{id:`); err == nil {
+		t.Errorf("expected invalid expression to fail")
+	}
+
+	if err := nm.UseMicro(JQMicroMiddleware(transform)).AddMicroEndpoint("micro", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.UseContext(JQMiddleware(transform)).AddContextEndpoint("context", func(req *natsmicromw.Request) error {
+		return req.Respond(req.Data())
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, subject := range []string{"micro", "context"} {
+		t.Run(subject, func(t *testing.T) {
+			reply, err := nc.Request(subject, []byte(`{"orderId":"o-1","items":[{"price":2},{"price":3}]}`), time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(reply.Data) != `{"id":"o-1","total":5}` {
+				t.Errorf("unexpected reply: %s", reply.Data)
+			}
+
+			reply, err = nc.Request(subject, []byte("not json"), time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if handlerErr := natsmicromw.HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != natsmicromw.CodeInternal {
+				t.Errorf("expected internal error, received %v", handlerErr)
+			}
+		})
+	}
+
+}
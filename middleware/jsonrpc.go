@@ -0,0 +1,187 @@
+// Example JSON-RPC 2.0 adapter for natsmicromw
+
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// Standard JSON-RPC 2.0 error codes
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+	JSONRPCServerError    = -32000
+)
+
+type jsonRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// JSONRPCError is the standard JSON-RPC 2.0 error object.
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// JSONRPC exposes a set of handlers as methods of a single JSON-RPC 2.0
+// endpoint. Every method call runs through the given middleware chain, with
+// the method parameters as the request data.
+type JSONRPC struct {
+	methods map[string]natsmicromw.MicroHandlerFunc
+	mws     []natsmicromw.MicroMiddlewareFunc
+}
+
+// NewJSONRPC creates a new JSON-RPC adapter with per-method middleware.
+func NewJSONRPC(mws ...natsmicromw.MicroMiddlewareFunc) *JSONRPC {
+	return &JSONRPC{
+		methods: make(map[string]natsmicromw.MicroHandlerFunc),
+		mws:     mws,
+	}
+}
+
+// Register adds a handler for the named method. Must not be called after the
+// endpoint starts serving requests.
+func (j *JSONRPC) Register(method string, handler natsmicromw.MicroHandlerFunc) {
+	j.methods[method] = handler
+}
+
+// Handler returns the handler serving the JSON-RPC endpoint, including batch
+// requests.
+func (j *JSONRPC) Handler() natsmicromw.MicroHandlerFunc {
+	return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		payload, err := req.ReadData()
+		if err != nil {
+			return nil, err
+		}
+		data := bytes.TrimSpace(payload)
+
+		var out interface{}
+		if len(data) > 0 && data[0] == '[' {
+			var batch []json.RawMessage
+			if err := json.Unmarshal(data, &batch); err != nil {
+				out = jsonRPCErrorResponse(nil, JSONRPCParseError, "Parse error")
+			} else if len(batch) == 0 {
+				out = jsonRPCErrorResponse(nil, JSONRPCInvalidRequest, "Invalid Request")
+			} else {
+				responses := make([]*jsonRPCResponse, 0, len(batch))
+				for _, raw := range batch {
+					if res := j.call(req, raw); res != nil {
+						responses = append(responses, res)
+					}
+				}
+				// A batch of notifications gets no response at all
+				if len(responses) == 0 {
+					return natsmicromw.NewMicroReply(nil), nil
+				}
+				out = responses
+			}
+		} else {
+			res := j.call(req, data)
+			if res == nil {
+				return natsmicromw.NewMicroReply(nil), nil
+			}
+			out = res
+		}
+
+		encoded, err := json.Marshal(out)
+		if err != nil {
+			return nil, err
+		}
+		reply := natsmicromw.NewMicroReply(encoded)
+		reply.HeaderSet(natsmicromw.HeaderContentType, "application/json")
+		return reply, nil
+	}
+}
+
+func jsonRPCErrorResponse(id json.RawMessage, code int, message string) *jsonRPCResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &jsonRPCResponse{
+		JSONRPC: "2.0",
+		Error:   &JSONRPCError{Code: code, Message: message},
+		ID:      id,
+	}
+}
+
+// Call a single method, returns nil for notifications
+func (j *JSONRPC) call(req *natsmicromw.MicroRequest, raw json.RawMessage) *jsonRPCResponse {
+	var rpcReq jsonRPCRequest
+	if err := json.Unmarshal(raw, &rpcReq); err != nil {
+		return jsonRPCErrorResponse(nil, JSONRPCParseError, "Parse error")
+	}
+	if rpcReq.JSONRPC != "2.0" || rpcReq.Method == "" {
+		return jsonRPCErrorResponse(rpcReq.ID, JSONRPCInvalidRequest, "Invalid Request")
+	}
+	notification := rpcReq.ID == nil
+
+	handler, ok := j.methods[rpcReq.Method]
+	if !ok {
+		if notification {
+			return nil
+		}
+		return jsonRPCErrorResponse(rpcReq.ID, JSONRPCMethodNotFound, "Method not found")
+	}
+
+	// Wrap handler in middleware calls
+	for i := len(j.mws) - 1; i >= 0; i-- {
+		handler = j.mws[i](handler)
+	}
+
+	methodReq := (&natsmicromw.MicroRequest{
+		Subject: req.Subject,
+		Reply:   req.Reply,
+		Headers: req.Headers,
+		Data:    rpcReq.Params,
+	}).WithContext(req.Context())
+	reply, err := handler(methodReq)
+	if notification {
+		return nil
+	}
+
+	if err != nil {
+		res := jsonRPCErrorResponse(rpcReq.ID, JSONRPCInternalError, err.Error())
+		var handlerErr *natsmicromw.HandlerError
+		if errors.As(err, &handlerErr) {
+			res.Error.Code = JSONRPCServerError
+			if handlerErr.Code == natsmicromw.CodeBadRequest {
+				res.Error.Code = JSONRPCInvalidParams
+			}
+			res.Error.Data = handlerErr
+		}
+		return res
+	}
+
+	// Results that are not JSON are sent as strings
+	result := json.RawMessage("null")
+	if reply != nil && len(reply.Data) > 0 {
+		if json.Valid(reply.Data) {
+			result = reply.Data
+		} else {
+			result, _ = json.Marshal(string(reply.Data))
+		}
+	}
+	return &jsonRPCResponse{
+		JSONRPC: "2.0",
+		Result:  result,
+		ID:      rpcReq.ID,
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestJSONRPC(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	rpc := NewJSONRPC()
+	rpc.Register("echo", microEcho)
+	rpc.Register("fail", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return nil, natsmicromw.NewValidationError("invalid params")
+	})
+	if err := nm.AddMicroEndpoint("rpc", rpc.Handler()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		request  string
+		expected string
+	}{
+		{
+			"single call",
+			`{"jsonrpc":"2.0","method":"echo","params":[1,2],"id":1}`,
+			`{"jsonrpc":"2.0","result":[1,2],"id":1}`,
+		},
+		{
+			"method not found",
+			`{"jsonrpc":"2.0","method":"missing","id":"a"}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":"a"}`,
+		},
+		{
+			"parse error",
+			`{"jsonrpc":`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`,
+		},
+		{
+			"handler error",
+			`{"jsonrpc":"2.0","method":"fail","id":2}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid params","data":{"description":"invalid params","code":"400"}},"id":2}`,
+		},
+		{
+			"batch with notification",
+			`[{"jsonrpc":"2.0","method":"echo","params":{"a":1},"id":1},{"jsonrpc":"2.0","method":"echo"}]`,
+			`[{"jsonrpc":"2.0","result":{"a":1},"id":1}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := nc.Request("rpc", []byte(tt.request), 1*time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(reply.Data) != tt.expected {
+				t.Errorf("responses do not match, expected %s, received %s", tt.expected, string(reply.Data))
+			}
+		})
+	}
+}
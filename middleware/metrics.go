@@ -1,129 +1,169 @@
-// Example Prometheus metrics middleware for natsmicromw
+// Example metrics middleware for natsmicromw
 
 package middleware
 
 import (
+	"context"
+	"sync"
 	"time"
 
-	"github.com/nats-io/nats.go/micro"
-
 	"github.com/Karimerto/natsmicromw"
-
-	// For prometheus metrics
-	"github.com/prometheus/client_golang/prometheus"
 )
 
-// Define new metrics for the middleware
-var (
-	prometheusMessageCount = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "nats_messages_total",
-			Help: "Total number of NATS messages received.",
-		},
-		[]string{"subject"})
-
-	prometheusRequestDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "nats_request_duration_seconds",
-			Help:    "Duration of NATS requests in seconds.",
-			Buckets: []float64{0.001, .005, .01, .025, .05, .075, .1, .25, .5, .75, 1.0, 2.5, 5.0, 7.5, 10.0},
-		}, []string{"subject"})
-
-	prometheusPayloadSize = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "nats_payload_size_bytes",
-			Help:    "Size of NATS payloads in bytes.",
-			Buckets: []float64{128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536, 131072, 262144, 524288, 1048576},
-			// In case bigger sizes are needed, likely not
-			// , 2097152, 4194304, 8388608, 16777216, 33554432, 67108864, 134217728, 268435456, 536870912, 1073741824, 2147483648
-		}, []string{"subject"})
-)
+// Label used for subjects beyond the cardinality limit
+const MetricsOtherSubject = "other"
 
-func init() {
-	// Register the metric with Prometheus
-	prometheus.MustRegister(prometheusMessageCount)
-	prometheus.MustRegister(prometheusRequestDuration)
-	prometheus.MustRegister(prometheusPayloadSize)
+// CardinalityGuard limits the number of distinct subject labels. Subjects
+// seen after the limit was reached are reported as "other".
+type CardinalityGuard struct {
+	mu   sync.Mutex
+	max  int
+	seen map[string]struct{}
 }
 
-// Middleware that increments the message count metric
-func MetricsMiddleware(next micro.Handler) micro.Handler {
-	return micro.HandlerFunc(func(req micro.Request) {
-		// Increment the message count for the subject
-		prometheusMessageCount.With(prometheus.Labels{"subject": req.Subject()}).Inc()
-
-		// Record start time
-		start := time.Now()
-
-		// Call the next middleware or handler function
-		next.Handle(req)
-
-		// Record elapsed time and payload size
-		elapsed := time.Since(start)
-		payloadSize := len(req.Data())
-
-		// Report metrics to Prometheus or other monitoring system
-		prometheusRequestDuration.
-			With(prometheus.Labels{"subject": req.Subject()}).
-			Observe(float64(elapsed.Seconds()))
-		prometheusPayloadSize.
-			With(prometheus.Labels{"subject": req.Subject()}).
-			Observe(float64(payloadSize))
-	})
+// NewCardinalityGuard creates a guard allowing up to max distinct subjects.
+func NewCardinalityGuard(max int) *CardinalityGuard {
+	return &CardinalityGuard{
+		max:  max,
+		seen: make(map[string]struct{}),
+	}
 }
 
-// Same middleware but with context support
-func MetricsContextMiddleware(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
-	return func(req *natsmicromw.Request) error {
-		// Increment the message count for the subject
-		prometheusMessageCount.With(prometheus.Labels{"subject": req.Subject()}).Inc()
+// SetMax changes the limit, already seen subjects are kept.
+func (g *CardinalityGuard) SetMax(max int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.max = max
+}
 
-		// Record start time
-		start := time.Now()
+// Label returns the subject if it is known or still fits within the limit.
+func (g *CardinalityGuard) Label(subject string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.seen[subject]; ok {
+		return subject
+	}
+	if len(g.seen) >= g.max {
+		return MetricsOtherSubject
+	}
+	g.seen[subject] = struct{}{}
+	return subject
+}
+
+var metricsGuard = NewCardinalityGuard(1000)
+
+// Set the maximum number of distinct subjects labeled by the metrics middleware
+func SetMetricsMaxSubjects(max int) {
+	metricsGuard.SetMax(max)
+}
 
-		// Call the next middleware or handler function
-		err := next(req)
+// Label requests by the registered endpoint subject, which may contain
+// wildcards, and fall back to the guarded request subject. Requests received
+// on an alias subject are labeled with the alias.
+func metricsSubject(ctx context.Context, subject string) string {
+	if alias := natsmicromw.EndpointAliasFromContext(ctx); alias != "" {
+		return alias
+	}
+	if endpoint := natsmicromw.EndpointSubjectFromContext(ctx); endpoint != "" {
+		return endpoint
+	}
+	return metricsGuard.Label(subject)
+}
 
-		// Record elapsed time and payload size
-		elapsed := time.Since(start)
-		payloadSize := len(req.Data())
+// MetricsRecorder receives the request metrics, so that any metrics backend
+// can be plugged into `MetricsRecorderMiddleware`.
+type MetricsRecorder interface {
+	IncRequests(subject string)
+	ObserveDuration(subject string, duration time.Duration)
+	ObserveSize(subject string, size int)
+	IncErrors(subject string, code string)
+}
 
-		// Report metrics to Prometheus or other monitoring system
-		prometheusRequestDuration.
-			With(prometheus.Labels{"subject": req.Subject()}).
-			Observe(float64(elapsed.Seconds()))
-		prometheusPayloadSize.
-			With(prometheus.Labels{"subject": req.Subject()}).
-			Observe(float64(payloadSize))
+// MetricsRecorderFuncs is a recorder built from functions, e.g. to record
+// with OpenTelemetry instruments:
+//
+//	middleware.MetricsRecorderFuncs{
+//		OnRequest: func(subject string) {
+//			requests.Add(ctx, 1, metric.WithAttributes(attribute.String("subject", subject)))
+//		},
+//	}
+//
+// Nil functions are skipped.
+type MetricsRecorderFuncs struct {
+	OnRequest  func(subject string)
+	OnDuration func(subject string, duration time.Duration)
+	OnSize     func(subject string, size int)
+	OnError    func(subject string, code string)
+}
 
-		return err
+func (f MetricsRecorderFuncs) IncRequests(subject string) {
+	if f.OnRequest != nil {
+		f.OnRequest(subject)
 	}
 }
 
-// One more version for `MicroRequest` and `MicroReply`
-func MetricsMicroMiddleware(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
-	return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
-		// Increment the message count for the subject
-		prometheusMessageCount.With(prometheus.Labels{"subject": req.Subject}).Inc()
+func (f MetricsRecorderFuncs) ObserveDuration(subject string, duration time.Duration) {
+	if f.OnDuration != nil {
+		f.OnDuration(subject, duration)
+	}
+}
 
-		// Record start time
-		start := time.Now()
+func (f MetricsRecorderFuncs) ObserveSize(subject string, size int) {
+	if f.OnSize != nil {
+		f.OnSize(subject, size)
+	}
+}
 
-		// Call the next middleware or handler function
-		res, err := next(req)
+func (f MetricsRecorderFuncs) IncErrors(subject string, code string) {
+	if f.OnError != nil {
+		f.OnError(subject, code)
+	}
+}
 
-		// Record elapsed time and payload size
-		elapsed := time.Since(start)
-		payloadSize := len(req.Data)
+// Report the metrics of a handled request
+func recordMetrics(ctx context.Context, r MetricsRecorder, subject string, size int, elapsed time.Duration, err error) {
+	r.ObserveDuration(subject, elapsed)
+	r.ObserveSize(subject, size)
+	if code := natsmicromw.ContextErrorCode(ctx, err); code != "" {
+		r.IncErrors(subject, code)
+	}
+}
 
-		// Report metrics to Prometheus or other monitoring system
-		prometheusRequestDuration.
-			With(prometheus.Labels{"subject": req.Subject}).
-			Observe(float64(elapsed.Seconds()))
-		prometheusPayloadSize.
-			With(prometheus.Labels{"subject": req.Subject}).
-			Observe(float64(payloadSize))
+// Metrics middleware that reports to the given recorder
+func MetricsRecorderMiddleware(r MetricsRecorder) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			subject := metricsSubject(req.Context(), req.Subject())
+			r.IncRequests(subject)
+			start := time.Now()
+			err := next(req)
+			recordMetrics(req.Context(), r, subject, len(req.Data()), time.Since(start), replyError(req.Context(), nil, err))
+			return err
+		}
+	}
+}
 
-		return res, err
+// Same middleware with `MicroRequest` and `MicroReply`
+func MetricsRecorderMicroMiddleware(r MetricsRecorder) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			subject := metricsSubject(req.Context(), req.Subject)
+			r.IncRequests(subject)
+			start := time.Now()
+			data, err := req.ReadData()
+			if err != nil {
+				recordMetrics(req.Context(), r, subject, 0, time.Since(start), err)
+				return nil, err
+			}
+			res, err := next(req)
+			recordMetrics(req.Context(), r, subject, len(data), time.Since(start), replyError(req.Context(), res, err))
+			return res, err
+		}
 	}
 }
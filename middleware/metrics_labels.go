@@ -0,0 +1,126 @@
+// Example context-based metrics labels for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// LabeledMetricsRecorder is a recorder that supports extra labels besides
+// the subject.
+type LabeledMetricsRecorder interface {
+	MetricsRecorder
+	WithLabels(labels map[string]string) MetricsRecorder
+}
+
+// MetricsLabelExtractor derives a label value from the request context,
+// e.g. populated by an earlier authentication middleware.
+type MetricsLabelExtractor func(ctx context.Context) string
+
+// APIKeyTierLabel extracts the tier of the API key.
+func APIKeyTierLabel(ctx context.Context) string {
+	if apiKey := APIKeyFromContext(ctx); apiKey != nil {
+		return apiKey.Tier
+	}
+	return ""
+}
+
+// StateLabel extracts a fact of the chain state published by an earlier
+// middleware, e.g. `StateIdentity`.
+func StateLabel[T any](key *natsmicromw.StateKey[T]) MetricsLabelExtractor {
+	return func(ctx context.Context) string {
+		value, ok := key.Get(ctx)
+		if !ok {
+			return ""
+		}
+		return fmt.Sprint(value)
+	}
+}
+
+type MetricsLabelOptions struct {
+	// Labels is the allowlist of extra label names, it must match the labels
+	// of the recorder. Extractors for other names are ignored.
+	Labels     []string
+	Extractors map[string]MetricsLabelExtractor
+	// Maximum number of distinct values per label, further values are
+	// reported as "other". Defaults to 100.
+	MaxValues int
+}
+
+type metricsLabeler struct {
+	opts   MetricsLabelOptions
+	guards map[string]*CardinalityGuard
+}
+
+func newMetricsLabeler(opts MetricsLabelOptions) *metricsLabeler {
+	if opts.MaxValues <= 0 {
+		opts.MaxValues = 100
+	}
+	guards := make(map[string]*CardinalityGuard, len(opts.Labels))
+	for _, name := range opts.Labels {
+		guards[name] = NewCardinalityGuard(opts.MaxValues)
+	}
+	return &metricsLabeler{opts: opts, guards: guards}
+}
+
+func (l *metricsLabeler) labels(ctx context.Context) map[string]string {
+	labels := make(map[string]string, len(l.opts.Labels))
+	for _, name := range l.opts.Labels {
+		var value string
+		if extract, ok := l.opts.Extractors[name]; ok {
+			value = extract(ctx)
+		}
+		if value != "" {
+			value = l.guards[name].Label(value)
+		}
+		labels[name] = value
+	}
+	return labels
+}
+
+// Metrics middleware that adds labels derived from the request context
+func MetricsLabelsMiddleware(r LabeledMetricsRecorder, opts MetricsLabelOptions) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	l := newMetricsLabeler(opts)
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			subject := metricsSubject(req.Context(), req.Subject())
+			rr := r.WithLabels(l.labels(req.Context()))
+			rr.IncRequests(subject)
+			start := time.Now()
+			err := next(req)
+			recordMetrics(req.Context(), rr, subject, len(req.Data()), time.Since(start), replyError(req.Context(), nil, err))
+			return err
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func MetricsLabelsMicroMiddleware(r LabeledMetricsRecorder, opts MetricsLabelOptions) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	l := newMetricsLabeler(opts)
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			subject := metricsSubject(req.Context(), req.Subject)
+			rr := r.WithLabels(l.labels(req.Context()))
+			rr.IncRequests(subject)
+			start := time.Now()
+			data, err := req.ReadData()
+			if err != nil {
+				recordMetrics(req.Context(), rr, subject, 0, time.Since(start), err)
+				return nil, err
+			}
+			res, err := next(req)
+			recordMetrics(req.Context(), rr, subject, len(data), time.Since(start), replyError(req.Context(), res, err))
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,93 @@
+//go:build !natsmicromw_noprometheus
+
+// Example Prometheus recorder with context-based labels for natsmicromw
+
+package middleware
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusLabelRecorder is a Prometheus recorder with extra labels. Since
+// label names are fixed per metric, its metrics are prefixed with a
+// namespace, e.g. "tenants_nats_messages_total".
+type PrometheusLabelRecorder struct {
+	names    []string
+	labels   map[string]string
+	messages *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	size     *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// NewPrometheusLabelRecorder creates and registers the labeled metrics.
+func NewPrometheusLabelRecorder(reg prometheus.Registerer, namespace string, labels ...string) (*PrometheusLabelRecorder, error) {
+	names := append([]string{"subject"}, labels...)
+	r := &PrometheusLabelRecorder{
+		names: labels,
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "nats_messages_total",
+			Help:      "Total number of NATS messages received.",
+		}, names),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "nats_request_duration_seconds",
+			Help:      "Duration of NATS requests in seconds.",
+			Buckets:   []float64{0.001, .005, .01, .025, .05, .075, .1, .25, .5, .75, 1.0, 2.5, 5.0, 7.5, 10.0},
+		}, names),
+		size: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "nats_payload_size_bytes",
+			Help:      "Size of NATS payloads in bytes.",
+			Buckets:   []float64{128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536, 131072, 262144, 524288, 1048576},
+		}, names),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "nats_errors_total",
+			Help:      "Total number of NATS requests that failed.",
+		}, append(append([]string{}, names...), "code")),
+	}
+
+	for _, c := range []prometheus.Collector{r.messages, r.duration, r.size, r.errors} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// WithLabels returns a recorder for the label values, missing labels are empty.
+func (r *PrometheusLabelRecorder) WithLabels(labels map[string]string) MetricsRecorder {
+	nr := *r
+	nr.labels = labels
+	return &nr
+}
+
+func (r *PrometheusLabelRecorder) with(subject string) prometheus.Labels {
+	labels := prometheus.Labels{"subject": subject}
+	for _, name := range r.names {
+		labels[name] = r.labels[name]
+	}
+	return labels
+}
+
+func (r *PrometheusLabelRecorder) IncRequests(subject string) {
+	r.messages.With(r.with(subject)).Inc()
+}
+
+func (r *PrometheusLabelRecorder) ObserveDuration(subject string, duration time.Duration) {
+	r.duration.With(r.with(subject)).Observe(duration.Seconds())
+}
+
+func (r *PrometheusLabelRecorder) ObserveSize(subject string, size int) {
+	r.size.With(r.with(subject)).Observe(float64(size))
+}
+
+func (r *PrometheusLabelRecorder) IncErrors(subject string, code string) {
+	labels := r.with(subject)
+	labels["code"] = code
+	r.errors.With(labels).Inc()
+}
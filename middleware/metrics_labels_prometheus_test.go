@@ -0,0 +1,47 @@
+//go:build !natsmicromw_noprometheus
+
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsLabelsMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	recorder, err := NewPrometheusLabelRecorder(prometheus.NewRegistry(), "test", "tier")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nm = nm.UseMicro(
+		APIKeyMicroMiddleware(StaticAPIKeys{"key": {ID: "1", Tier: "gold"}}),
+		MetricsLabelsMicroMiddleware(recorder, MetricsLabelOptions{
+			Labels: []string{"tier"},
+			Extractors: map[string]MetricsLabelExtractor{
+				"tier":   APIKeyTierLabel,
+				"secret": func(ctx context.Context) string { return "not allowed" },
+			},
+		}))
+	if err := nm.AddMicroEndpoint("echo", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg := nats.NewMsg("echo")
+	msg.Header.Set(HeaderAPIKey, "key")
+	if _, err := nc.RequestMsg(msg, 1*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	count := testutil.ToFloat64(recorder.messages.With(prometheus.Labels{"subject": "echo", "tier": "gold"}))
+	if count != 1 {
+		t.Errorf("message count does not match, expected %d, received %f", 1, count)
+	}
+}
@@ -0,0 +1,111 @@
+//go:build !natsmicromw_noprometheus
+
+// Example Prometheus metrics middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+
+	// For prometheus metrics
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Define new metrics for the middleware
+var (
+	prometheusMessageCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_messages_total",
+			Help: "Total number of NATS messages received.",
+		},
+		[]string{"subject"})
+
+	prometheusRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "nats_request_duration_seconds",
+			Help:    "Duration of NATS requests in seconds.",
+			Buckets: []float64{0.001, .005, .01, .025, .05, .075, .1, .25, .5, .75, 1.0, 2.5, 5.0, 7.5, 10.0},
+		}, []string{"subject"})
+
+	prometheusErrorCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_errors_total",
+			Help: "Total number of NATS requests that failed.",
+		},
+		[]string{"subject", "code"})
+
+	prometheusPayloadSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "nats_payload_size_bytes",
+			Help:    "Size of NATS payloads in bytes.",
+			Buckets: []float64{128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536, 131072, 262144, 524288, 1048576},
+			// In case bigger sizes are needed, likely not
+			// , 2097152, 4194304, 8388608, 16777216, 33554432, 67108864, 134217728, 268435456, 536870912, 1073741824, 2147483648
+		}, []string{"subject"})
+)
+
+func init() {
+	// Register the metric with Prometheus
+	prometheus.MustRegister(prometheusMessageCount)
+	prometheus.MustRegister(prometheusRequestDuration)
+	prometheus.MustRegister(prometheusPayloadSize)
+	prometheus.MustRegister(prometheusErrorCount)
+}
+
+// PrometheusRecorder reports request metrics to the default Prometheus
+// registry. It is the recorder used by the metrics middleware.
+type PrometheusRecorder struct{}
+
+func (PrometheusRecorder) IncRequests(subject string) {
+	prometheusMessageCount.With(prometheus.Labels{"subject": subject}).Inc()
+}
+
+func (PrometheusRecorder) ObserveDuration(subject string, duration time.Duration) {
+	prometheusRequestDuration.
+		With(prometheus.Labels{"subject": subject}).
+		Observe(duration.Seconds())
+}
+
+func (PrometheusRecorder) ObserveSize(subject string, size int) {
+	prometheusPayloadSize.
+		With(prometheus.Labels{"subject": subject}).
+		Observe(float64(size))
+}
+
+func (PrometheusRecorder) IncErrors(subject string, code string) {
+	prometheusErrorCount.With(prometheus.Labels{"subject": subject, "code": code}).Inc()
+}
+
+// Middleware that increments the message count metric
+func MetricsMiddleware(next micro.Handler) micro.Handler {
+	var r PrometheusRecorder
+	return micro.HandlerFunc(func(req micro.Request) {
+		// Plain handlers do not know their endpoint, only limit the subjects
+		subject := metricsGuard.Label(req.Subject())
+		r.IncRequests(subject)
+
+		// Record start time
+		start := time.Now()
+
+		// Call the next middleware or handler function
+		next.Handle(req)
+
+		// Record elapsed time and payload size, errors are not known here
+		recordMetrics(context.Background(), r, subject, len(req.Data()), time.Since(start), nil)
+	})
+}
+
+// Same middleware but with context support
+func MetricsContextMiddleware(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return MetricsRecorderMiddleware(PrometheusRecorder{})(next)
+}
+
+// One more version for `MicroRequest` and `MicroReply`
+func MetricsMicroMiddleware(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return MetricsRecorderMicroMiddleware(PrometheusRecorder{})(next)
+}
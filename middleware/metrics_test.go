@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+type fakeRecorder struct {
+	mu       sync.Mutex
+	requests map[string]int
+	errors   map[string]string
+}
+
+func (f *fakeRecorder) IncRequests(subject string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests[subject]++
+}
+
+func (f *fakeRecorder) ObserveDuration(subject string, duration time.Duration) {}
+func (f *fakeRecorder) ObserveSize(subject string, size int)                   {}
+
+func (f *fakeRecorder) IncErrors(subject string, code string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors[subject] = code
+}
+
+func TestMetricsRecorderMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	recorder := &fakeRecorder{requests: map[string]int{}, errors: map[string]string{}}
+	nm = nm.UseMicro(MetricsRecorderMicroMiddleware(recorder))
+	err := nm.AddMicroEndpoint("get", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		if req.Subject == "orders.404" {
+			return nil, &natsmicromw.HandlerError{Description: "not found", Code: "404"}
+		}
+		return natsmicromw.NewMicroReply(req.Data), nil
+	}, micro.WithEndpointSubject("orders.*"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, subject := range []string{"orders.1", "orders.2", "orders.404"} {
+		if _, err := nc.Request(subject, nil, 1*time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	// All requests are labeled by the endpoint subject
+	if recorder.requests["orders.*"] != 3 || len(recorder.requests) != 1 {
+		t.Errorf("unexpected requests: %v", recorder.requests)
+	}
+	if recorder.errors["orders.*"] != "404" {
+		t.Errorf("unexpected errors: %v", recorder.errors)
+	}
+}
+
+func TestCardinalityGuard(t *testing.T) {
+	g := NewCardinalityGuard(2)
+	for _, subject := range []string{"orders.1", "orders.2", "orders.1"} {
+		if label := g.Label(subject); label != subject {
+			t.Errorf("label does not match, expected %s, received %s", subject, label)
+		}
+	}
+	if label := g.Label("orders.3"); label != MetricsOtherSubject {
+		t.Errorf("label does not match, expected %s, received %s", MetricsOtherSubject, label)
+	}
+}
+
+func TestMetricsErrorReply(t *testing.T) {
+	recorder := &fakeRecorder{requests: map[string]int{}, errors: map[string]string{}}
+	handler := MetricsRecorderMicroMiddleware(recorder)(func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return natsmicromw.NewMicroError("503", "unavailable", nil, nil), nil
+	})
+	req := (&natsmicromw.MicroRequest{Subject: "failing"}).WithContext(context.Background())
+	if _, err := handler(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recorder.errors["failing"] != "503" {
+		t.Errorf("expected the error reply to be counted, received %v", recorder.errors)
+	}
+}
+
+func TestMetricsHandlerTimeout(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	recorder := &fakeRecorder{requests: map[string]int{}, errors: map[string]string{}}
+	release, returned := make(chan struct{}), make(chan struct{})
+	nm = nm.UseContext(MetricsRecorderMiddleware(recorder)).WithHandlerTimeout(20 * time.Millisecond)
+	err := nm.AddContextEndpoint("slow", func(req *natsmicromw.Request) error {
+		defer close(returned)
+		<-release
+		// The late reply is discarded, but still counted as a timeout
+		return req.Respond([]byte("late"))
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg, err := nc.Request("slow", nil, 1*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code := msg.Header.Get(micro.ErrorCodeHeader); code != natsmicromw.CodeTimeout {
+		t.Errorf("expected %s, received %s", natsmicromw.CodeTimeout, code)
+	}
+	close(release)
+	<-returned
+
+	// The middleware records the request after the handler has returned
+	for i := 0; i < 10; i++ {
+		recorder.mu.Lock()
+		code := recorder.errors["slow"]
+		recorder.mu.Unlock()
+		if code == natsmicromw.CodeTimeout {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected the timeout to be counted, received %v", recorder.errors)
+}
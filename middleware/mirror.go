@@ -0,0 +1,220 @@
+// Example request mirroring middleware for natsmicromw
+
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// Headers removed from mirrored requests unless `MirrorOptions.ScrubHeaders`
+// is set
+var DefaultMirrorScrubHeaders = []string{HeaderAuthorization, HeaderAPIKey, HeaderIdentityAssertion}
+
+type MirrorOptions struct {
+	// Conn is used to publish the mirrored requests
+	Conn *nats.Conn
+	// Requests are mirrored to `<SubjectPrefix>.<request subject>`. Defaults
+	// to "analytics".
+	SubjectPrefix string
+	// Fraction of requests mirrored, between 0 and 1 (default)
+	SampleRate float64
+	// Headers removed from mirrored requests, defaults to
+	// `DefaultMirrorScrubHeaders`
+	ScrubHeaders []string
+	// Dotted paths of fields removed from JSON payloads, e.g. "user.email".
+	// Payloads that are not JSON are mirrored without data when set.
+	ScrubFields []string
+	// Scrub is called on every mirrored message after the headers and fields
+	// are removed, e.g. to mask values. It runs in the background.
+	Scrub func(msg *nats.Msg)
+	// Number of requests queued for publishing, defaults to 1024. When the
+	// queue is full, requests are dropped instead of blocking handlers.
+	BufferSize int
+}
+
+// Mirror publishes a sample of the handled requests to an analytics subject
+// in the background. Unlike the audit middleware, which records every
+// request for compliance, it feeds product analytics with the scrubbed
+// requests themselves.
+type Mirror struct {
+	opts    MirrorOptions
+	fields  fieldTree
+	queue   chan *nats.Msg
+	dropped atomic.Int64
+	failed  atomic.Int64
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewMirror creates a new mirror and starts publishing, use it with
+// `MirrorMiddleware` or `MirrorMicroMiddleware`.
+func NewMirror(opts MirrorOptions) *Mirror {
+	if opts.SubjectPrefix == "" {
+		opts.SubjectPrefix = "analytics"
+	}
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	if opts.ScrubHeaders == nil {
+		opts.ScrubHeaders = DefaultMirrorScrubHeaders
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 1024
+	}
+
+	m := &Mirror{
+		opts:  opts,
+		queue: make(chan *nats.Msg, opts.BufferSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if len(opts.ScrubFields) > 0 {
+		m.fields = parseFields(strings.Join(opts.ScrubFields, ","))
+	}
+	go m.run()
+	return m
+}
+
+func (m *Mirror) run() {
+	defer close(m.done)
+	for {
+		select {
+		case msg := <-m.queue:
+			m.publish(msg)
+		case <-m.stop:
+			// Flush what was queued before stopping
+			for {
+				select {
+				case msg := <-m.queue:
+					m.publish(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (m *Mirror) publish(msg *nats.Msg) {
+	m.scrub(msg)
+	if err := m.opts.Conn.PublishMsg(msg); err != nil {
+		m.failed.Add(1)
+	}
+}
+
+// Remove the scrubbed headers and fields from the message. Header keys are
+// case sensitive in NATS, so every spelling of a scrubbed header is removed.
+func (m *Mirror) scrub(msg *nats.Msg) {
+	for key := range msg.Header {
+		for _, scrubbed := range m.opts.ScrubHeaders {
+			if strings.EqualFold(key, scrubbed) {
+				delete(msg.Header, key)
+				break
+			}
+		}
+	}
+	if m.fields != nil && len(msg.Data) > 0 {
+		msg.Data = m.scrubFields(msg.Data)
+	}
+	if m.opts.Scrub != nil {
+		m.opts.Scrub(msg)
+	}
+}
+
+// Remove the fields from the JSON payload, or the whole payload if it is not
+// JSON, as it cannot be scrubbed
+func (m *Mirror) scrubFields(data []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// Keep numbers as they were sent
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil
+	}
+	scrubbed, err := json.Marshal(m.fields.remove(v))
+	if err != nil {
+		return nil
+	}
+	return scrubbed
+}
+
+// Record queues a copy of the request for mirroring if it is sampled,
+// dropping it if the queue is full.
+func (m *Mirror) Record(subject string, headers nats.Header, data []byte) {
+	if rand.Float64() >= m.opts.SampleRate {
+		return
+	}
+
+	// Copy the request, which is scrubbed in the background while later
+	// middleware and the handler may change it
+	msg := nats.NewMsg(m.opts.SubjectPrefix + "." + subject)
+	for k, v := range headers {
+		msg.Header[k] = append([]string(nil), v...)
+	}
+	msg.Data = append([]byte(nil), data...)
+
+	select {
+	case m.queue <- msg:
+	default:
+		m.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of requests dropped because the queue was full.
+func (m *Mirror) Dropped() int64 {
+	return m.dropped.Load()
+}
+
+// Failed returns the number of requests that could not be published.
+func (m *Mirror) Failed() int64 {
+	return m.failed.Load()
+}
+
+// Stop publishes the queued requests and stops the mirror. Requests recorded
+// after Stop are never published.
+func (m *Mirror) Stop() {
+	m.once.Do(func() { close(m.stop) })
+	<-m.done
+}
+
+// MirrorMiddleware mirrors a sample of the requests before they are handled,
+// without delaying them.
+func MirrorMiddleware(m *Mirror) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			m.Record(req.Subject(), nats.Header(req.Headers()), req.Data())
+			return next(req)
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func MirrorMicroMiddleware(m *Mirror) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			data, err := req.ReadData()
+			if err != nil {
+				return nil, err
+			}
+			m.Record(req.Subject, nats.Header(req.Headers), data)
+			return next(req)
+		}
+	}
+}
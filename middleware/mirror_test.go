@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestMirrorMicroMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	sub, err := nc.SubscribeSync("analytics.>")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mirror := NewMirror(MirrorOptions{
+		Conn:        nc,
+		ScrubFields: []string{"user.email", "password"},
+		Scrub: func(msg *nats.Msg) {
+			msg.Header.Set("mirrored", "true")
+		},
+	})
+	defer mirror.Stop()
+
+	if err := nm.UseMicro(MirrorMicroMiddleware(mirror)).AddMicroEndpoint("echo", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data := []byte(`{"user":{"name":"alice","email":"alice@example.com"},"password":"secret","amount":12.50}`)
+	msg := nats.NewMsg("echo")
+	msg.Data = data
+	msg.Header.Set(HeaderAPIKey, "key")
+	msg.Header["Authorization"] = []string{"Bearer token"}
+	msg.Header.Set("tenant", "acme")
+	reply, err := nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(reply.Data) != string(data) {
+		t.Errorf("expected the request to be handled unchanged, received %s", reply.Data)
+	}
+
+	mirrored, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("expected a mirrored request: %v", err)
+	}
+	if mirrored.Subject != "analytics.echo" {
+		t.Errorf("unexpected subject %s", mirrored.Subject)
+	}
+	if mirrored.Header.Get(HeaderAPIKey) != "" || mirrored.Header.Get("Authorization") != "" || mirrored.Header.Get("tenant") != "acme" || mirrored.Header.Get("mirrored") != "true" {
+		t.Errorf("unexpected headers %v", mirrored.Header)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(mirrored.Data, &payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	user, _ := payload["user"].(map[string]any)
+	if _, ok := payload["password"]; ok || user["email"] != nil || user["name"] != "alice" || payload["amount"] != 12.5 {
+		t.Errorf("unexpected scrubbed payload %s", mirrored.Data)
+	}
+}
+
+func TestMirrorSampling(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	sub, err := nc.SubscribeSync("sampled.>")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mirror := NewMirror(MirrorOptions{Conn: nc, SubjectPrefix: "sampled", SampleRate: 0.5, ScrubFields: []string{"id"}})
+	if err := nm.UseMicro(MirrorMicroMiddleware(mirror)).AddMicroEndpoint("echo", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	const requests = 200
+	for i := 0; i < requests; i++ {
+		if _, err := nc.Request("echo", []byte("not json"), time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	mirror.Stop()
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mirrored, _, err := sub.Pending()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mirrored == 0 || mirrored == requests {
+		t.Errorf("expected a sample of the requests, mirrored %d of %d", mirrored, requests)
+	}
+	if msg, err := sub.NextMsg(time.Second); err != nil || len(msg.Data) != 0 {
+		t.Errorf("expected payloads that are not JSON to be dropped, received %q: %v", msg.Data, err)
+	}
+}
@@ -0,0 +1,129 @@
+// Example New Relic middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// Distributed tracing headers accepted and propagated by New Relic agents
+var newRelicHeaders = []string{"newrelic", "traceparent", "tracestate"}
+
+// NewRelicTransaction is the transaction used by the middleware. The agent
+// transaction differs only in taking the transport type when accepting
+// headers, so an adapter embedding `*newrelic.Transaction` passes
+// `newrelic.TransportOther`:
+//
+//	type nrTxn struct{ *newrelic.Transaction }
+//
+//	func (t nrTxn) AcceptDistributedTraceHeaders(headers http.Header) {
+//		t.Transaction.AcceptDistributedTraceHeaders(newrelic.TransportOther, headers)
+//	}
+type NewRelicTransaction interface {
+	AcceptDistributedTraceHeaders(headers http.Header)
+	InsertDistributedTraceHeaders(headers http.Header)
+	AddAttribute(key string, value any)
+	NoticeError(err error)
+	End()
+}
+
+// NewRelicApplication starts transactions. The agent application returns
+// its own transaction type, so it is wrapped in an adapter as well:
+//
+//	type nrApp struct{ *newrelic.Application }
+//
+//	func (a nrApp) StartTransaction(name string) middleware.NewRelicTransaction {
+//		return nrTxn{a.Application.StartTransaction(name)}
+//	}
+type NewRelicApplication interface {
+	StartTransaction(name string) NewRelicTransaction
+}
+
+type newRelicContextKey struct{}
+
+// NewRelicTransactionFromContext returns the transaction of the request.
+func NewRelicTransactionFromContext(ctx context.Context) NewRelicTransaction {
+	txn, _ := ctx.Value(newRelicContextKey{}).(NewRelicTransaction)
+	return txn
+}
+
+// InjectNewRelicHeaders writes the distributed tracing headers of the
+// request transaction into an outgoing message.
+func InjectNewRelicHeaders(ctx context.Context, headers nats.Header) {
+	txn := NewRelicTransactionFromContext(ctx)
+	if txn == nil {
+		return
+	}
+	hdrs := http.Header{}
+	txn.InsertDistributedTraceHeaders(hdrs)
+	for key, values := range hdrs {
+		headers[strings.ToLower(key)] = values
+	}
+}
+
+// Start a transaction named after the endpoint and accept the caller's trace
+func startNewRelicTransaction(ctx context.Context, app NewRelicApplication, subject string, headers micro.Headers) (context.Context, NewRelicTransaction) {
+	txn := app.StartTransaction(metricsSubject(ctx, subject))
+
+	hdrs := http.Header{}
+	for _, key := range newRelicHeaders {
+		for _, value := range headers.Values(key) {
+			hdrs.Add(key, value)
+		}
+	}
+	if len(hdrs) > 0 {
+		txn.AcceptDistributedTraceHeaders(hdrs)
+	}
+
+	txn.AddAttribute("nats.subject", subject)
+	return context.WithValue(ctx, newRelicContextKey{}, txn), txn
+}
+
+func endNewRelicTransaction(ctx context.Context, txn NewRelicTransaction, err error) {
+	if err != nil {
+		txn.AddAttribute("error.code", natsmicromw.ContextErrorCode(ctx, err))
+		txn.NoticeError(err)
+	}
+	txn.End()
+}
+
+// NewRelicMiddleware starts a New Relic transaction for each request, named
+// after the subject the endpoint was registered on, e.g. "orders.*", to
+// keep the number of transaction names low, with the subject of the request
+// as the `nats.subject` attribute. Handler errors and error replies are
+// noticed with their code.
+func NewRelicMiddleware(app NewRelicApplication) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			ctx, txn := startNewRelicTransaction(req.Context(), app, req.Subject(), req.Headers())
+			err := next(req.WithContext(ctx))
+			endNewRelicTransaction(ctx, txn, replyError(ctx, nil, err))
+			return err
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func NewRelicMicroMiddleware(app NewRelicApplication) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			ctx, txn := startNewRelicTransaction(req.Context(), app, req.Subject, req.Headers)
+			reply, err := next(req.WithContext(ctx))
+			endNewRelicTransaction(ctx, txn, replyError(ctx, reply, err))
+			return reply, err
+		}
+	}
+}
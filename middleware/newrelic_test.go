@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Transaction recording what the middleware does with it
+type fakeNewRelicTransaction struct {
+	name       string
+	accepted   http.Header
+	attributes map[string]any
+	errors     []error
+	ended      bool
+}
+
+func (t *fakeNewRelicTransaction) AcceptDistributedTraceHeaders(headers http.Header) {
+	t.accepted = headers
+}
+
+func (t *fakeNewRelicTransaction) InsertDistributedTraceHeaders(headers http.Header) {
+	headers.Set("traceparent", "00-"+t.name)
+}
+
+func (t *fakeNewRelicTransaction) AddAttribute(key string, value any) {
+	t.attributes[key] = value
+}
+
+func (t *fakeNewRelicTransaction) NoticeError(err error) {
+	t.errors = append(t.errors, err)
+}
+
+func (t *fakeNewRelicTransaction) End() {
+	t.ended = true
+}
+
+type fakeNewRelicApplication struct {
+	mu   sync.Mutex
+	txns []*fakeNewRelicTransaction
+}
+
+func (a *fakeNewRelicApplication) StartTransaction(name string) NewRelicTransaction {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	txn := &fakeNewRelicTransaction{name: name, attributes: make(map[string]any)}
+	a.txns = append(a.txns, txn)
+	return txn
+}
+
+func (a *fakeNewRelicApplication) last() *fakeNewRelicTransaction {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.txns[len(a.txns)-1]
+}
+
+func TestNewRelicMicroMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	app := &fakeNewRelicApplication{}
+	var injected nats.Header
+	err := nm.UseMicro(NewRelicMicroMiddleware(app)).AddMicroEndpoint("orders", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		injected = nats.Header{}
+		InjectNewRelicHeaders(req.Context(), injected)
+		if string(req.Data) == "fail" {
+			return natsmicromw.NewMicroError(natsmicromw.CodeUnavailable, "unavailable", nil, nil), nil
+		}
+		return natsmicromw.NewMicroReply(nil), nil
+	}, micro.WithEndpointSubject("orders.*"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("transaction", func(t *testing.T) {
+		msg := nats.NewMsg("orders.42")
+		msg.Header.Set("traceparent", "00-caller")
+		if _, err := nc.RequestMsg(msg, time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		txn := app.last()
+		if txn.name != "orders.*" {
+			t.Errorf("expected the transaction to be named after the endpoint, received %s", txn.name)
+		}
+		if subject := txn.attributes["nats.subject"]; subject != "orders.42" {
+			t.Errorf("expected the subject attribute orders.42, received %v", subject)
+		}
+		if traceparent := txn.accepted.Get("traceparent"); traceparent != "00-caller" {
+			t.Errorf("expected the trace of the caller to be accepted, received %s", traceparent)
+		}
+		if traceparent := injected.Get("traceparent"); traceparent != "00-orders.*" {
+			t.Errorf("expected the trace headers to be injected, received %s", traceparent)
+		}
+		if !txn.ended || len(txn.errors) != 0 {
+			t.Errorf("expected an ended transaction without errors, received %+v", txn)
+		}
+	})
+
+	t.Run("error reply", func(t *testing.T) {
+		if _, err := nc.Request("orders.42", []byte("fail"), time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		txn := app.last()
+		if len(txn.errors) != 1 || txn.attributes["error.code"] != natsmicromw.CodeUnavailable {
+			t.Errorf("expected the error reply to be noticed, received %+v", txn)
+		}
+	})
+}
+
+func TestNewRelicMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	app := &fakeNewRelicApplication{}
+	err := nm.UseContext(NewRelicMiddleware(app)).AddContextEndpoint("orders", func(req *natsmicromw.Request) error {
+		if NewRelicTransactionFromContext(req.Context()) == nil {
+			t.Errorf("expected the transaction in the context")
+		}
+		return natsmicromw.Errorf(natsmicromw.CodeNotFound, "not found")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := nc.Request("orders", nil, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	txn := app.last()
+	if txn.name != "orders" || !txn.ended || len(txn.errors) != 1 || txn.attributes["error.code"] != natsmicromw.CodeNotFound {
+		t.Errorf("unexpected transaction: %+v", txn)
+	}
+}
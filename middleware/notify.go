@@ -0,0 +1,272 @@
+// Example push notification helper for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// Request header with the inbox the caller receives notifications on
+	HeaderNotifyInbox string = "notify-inbox"
+	// Notification header with the topic of the notification
+	HeaderNotifyTopic string = "notify-topic"
+)
+
+var (
+	ErrNoNotifyInbox      = errors.New("request has no notify-inbox header")
+	ErrInvalidNotifyInbox = errors.New("notify-inbox is not an inbox subject")
+	ErrNoNotifyIdentity   = errors.New("notification registration requires an identity")
+	ErrTooManyNotifyRegs  = errors.New("too many notification registrations")
+)
+
+type NotifierOptions struct {
+	// How long a registration lasts unless renewed by registering again,
+	// defaults to 5 minutes
+	TTL time.Duration
+	// Maximum registrations per identity, defaults to 100
+	MaxPerIdentity int
+	// Prefix every notify inbox must start with, defaults to "_INBOX.". The
+	// service publishes to the inboxes with its own permissions, so callers
+	// must not be able to pick arbitrary subjects.
+	InboxPrefix string
+	// Identity the registration is bound to. Defaults to the API key ID,
+	// OAuth2 token subject or auth callout principal, in that order.
+	// Callers without an identity cannot register.
+	Identity func(ctx context.Context) string
+	// Called for registrations removed after they expired
+	OnExpire func(reg NotifyRegistration)
+}
+
+// NotifyRegistration is a caller registered for notifications on a topic.
+type NotifyRegistration struct {
+	Identity string
+	Inbox    string
+	Topic    string
+	Expires  time.Time
+}
+
+type notifyKey struct {
+	topic string
+	inbox string
+}
+
+// Notifier keeps the inboxes of callers registered for notifications, so
+// that the service can publish to them later, e.g. when a resource they
+// asked about changes. This gives subscription-like patterns on top of
+// request/reply, with the registrations bound to the identity of the caller.
+type Notifier struct {
+	nc   *nats.Conn
+	opts NotifierOptions
+
+	mu   sync.Mutex
+	regs map[notifyKey]NotifyRegistration
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewNotifier creates a new notifier publishing on the connection and starts
+// removing expired registrations in the background.
+func NewNotifier(nc *nats.Conn, opts NotifierOptions) *Notifier {
+	if opts.TTL <= 0 {
+		opts.TTL = 5 * time.Minute
+	}
+	if opts.MaxPerIdentity <= 0 {
+		opts.MaxPerIdentity = 100
+	}
+	if opts.Identity == nil {
+		opts.Identity = identityKey
+	}
+	if opts.InboxPrefix == "" {
+		opts.InboxPrefix = "_INBOX."
+	}
+
+	n := &Notifier{
+		nc:   nc,
+		opts: opts,
+		regs: make(map[notifyKey]NotifyRegistration),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	ticker := time.NewTicker(n.opts.TTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+			n.expire()
+		}
+	}
+}
+
+// Remove the expired registrations
+func (n *Notifier) expire() {
+	now := time.Now()
+	var expired []NotifyRegistration
+	n.mu.Lock()
+	for key, reg := range n.regs {
+		if now.After(reg.Expires) {
+			delete(n.regs, key)
+			expired = append(expired, reg)
+		}
+	}
+	n.mu.Unlock()
+
+	if n.opts.OnExpire != nil {
+		for _, reg := range expired {
+			n.opts.OnExpire(reg)
+		}
+	}
+}
+
+// Check that the inbox is a literal subject under the inbox prefix
+func (n *Notifier) validInbox(inbox string) bool {
+	if !strings.HasPrefix(inbox, n.opts.InboxPrefix) || len(inbox) == len(n.opts.InboxPrefix) {
+		return false
+	}
+	for _, token := range strings.Split(inbox, ".") {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// Register registers the caller of the request for notifications on the
+// topic, using the inbox in its `notify-inbox` header. The inbox must start
+// with the inbox prefix and the caller must have an identity. Registering
+// again renews the registration.
+func (n *Notifier) Register(ctx context.Context, headers micro.Headers, topic string) (NotifyRegistration, error) {
+	inbox := nats.Header(headers).Get(HeaderNotifyInbox)
+	if inbox == "" {
+		return NotifyRegistration{}, ErrNoNotifyInbox
+	}
+	if !n.validInbox(inbox) {
+		return NotifyRegistration{}, ErrInvalidNotifyInbox
+	}
+	identity := n.opts.Identity(ctx)
+	if identity == "" {
+		return NotifyRegistration{}, ErrNoNotifyIdentity
+	}
+
+	reg := NotifyRegistration{
+		Identity: identity,
+		Inbox:    inbox,
+		Topic:    topic,
+		Expires:  time.Now().Add(n.opts.TTL),
+	}
+	key := notifyKey{topic, inbox}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	old, ok := n.regs[key]
+	if ok && old.Identity != identity {
+		// The inbox is registered by another caller
+		return NotifyRegistration{}, ErrInvalidNotifyInbox
+	}
+	if !ok && n.count(identity) >= n.opts.MaxPerIdentity {
+		return NotifyRegistration{}, ErrTooManyNotifyRegs
+	}
+	n.regs[key] = reg
+	return reg, nil
+}
+
+// Number of registrations of the identity
+func (n *Notifier) count(identity string) int {
+	count := 0
+	for _, reg := range n.regs {
+		if reg.Identity == identity {
+			count++
+		}
+	}
+	return count
+}
+
+// Unregister removes the registration of the inbox for the topic, if it
+// belongs to the identity of the caller. Returns whether it was removed.
+func (n *Notifier) Unregister(ctx context.Context, inbox, topic string) bool {
+	identity := n.opts.Identity(ctx)
+	key := notifyKey{topic, inbox}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if reg, ok := n.regs[key]; !ok || identity == "" || reg.Identity != identity {
+		return false
+	}
+	delete(n.regs, key)
+	return true
+}
+
+// Registrations returns the registrations that have not expired.
+func (n *Notifier) Registrations() []NotifyRegistration {
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	regs := make([]NotifyRegistration, 0, len(n.regs))
+	for _, reg := range n.regs {
+		if !now.After(reg.Expires) {
+			regs = append(regs, reg)
+		}
+	}
+	return regs
+}
+
+// Publish the notification to the registrations of the topic accepted by
+// the filter, returns the number of notifications sent
+func (n *Notifier) publish(topic string, data []byte, accept func(NotifyRegistration) bool) (int, error) {
+	now := time.Now()
+	var inboxes []string
+	n.mu.Lock()
+	for key, reg := range n.regs {
+		if key.topic == topic && !now.After(reg.Expires) && accept(reg) {
+			inboxes = append(inboxes, reg.Inbox)
+		}
+	}
+	n.mu.Unlock()
+
+	var errs []error
+	sent := 0
+	for _, inbox := range inboxes {
+		msg := nats.NewMsg(inbox)
+		msg.Header.Set(HeaderNotifyTopic, topic)
+		msg.Data = data
+		if err := n.nc.PublishMsg(msg); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
+}
+
+// Notify publishes the data to all callers registered for the topic.
+func (n *Notifier) Notify(topic string, data []byte) (int, error) {
+	return n.publish(topic, data, func(NotifyRegistration) bool { return true })
+}
+
+// NotifyIdentity publishes the data to the callers with the identity that
+// are registered for the topic.
+func (n *Notifier) NotifyIdentity(identity, topic string, data []byte) (int, error) {
+	return n.publish(topic, data, func(reg NotifyRegistration) bool { return reg.Identity == identity })
+}
+
+// Stop stops removing expired registrations in the background.
+func (n *Notifier) Stop() {
+	n.once.Do(func() { close(n.stop) })
+	<-n.done
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+type notifyUserKey struct{}
+
+func TestNotifier(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	notifier := NewNotifier(nc, NotifierOptions{
+		MaxPerIdentity: 2,
+		Identity: func(ctx context.Context) string {
+			user, _ := ctx.Value(notifyUserKey{}).(string)
+			return user
+		},
+	})
+	defer notifier.Stop()
+
+	err := nm.AddMicroEndpoint("subscribe", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		ctx := context.WithValue(req.Context(), notifyUserKey{}, req.HeaderGet("user"))
+		if _, err := notifier.Register(ctx, req.Headers, string(req.Data)); err != nil {
+			return nil, natsmicromw.Errorf(natsmicromw.CodeBadRequest, "%w", err)
+		}
+		return natsmicromw.NewMicroReply(nil), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Register an inbox of the user for the topic
+	register := func(t *testing.T, user, topic string) *nats.Subscription {
+		inbox := nats.NewInbox()
+		sub, err := nc.SubscribeSync(inbox)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msg := nats.NewMsg("subscribe")
+		msg.Header.Set(HeaderNotifyInbox, inbox)
+		msg.Header.Set("user", user)
+		msg.Data = []byte(topic)
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if handlerErr := natsmicromw.HandlerErrorFromMsg(reply); handlerErr != nil {
+			t.Fatalf("unexpected error: %v", handlerErr)
+		}
+		return sub
+	}
+
+	alice := register(t, "alice", "orders")
+	bob := register(t, "bob", "orders")
+	defer alice.Unsubscribe()
+	defer bob.Unsubscribe()
+
+	t.Run("notify topic", func(t *testing.T) {
+		if sent, err := notifier.Notify("orders", []byte("changed")); err != nil || sent != 2 {
+			t.Fatalf("expected 2 notifications, sent %d: %v", sent, err)
+		}
+		for _, sub := range []*nats.Subscription{alice, bob} {
+			msg, err := sub.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(msg.Data) != "changed" || msg.Header.Get(HeaderNotifyTopic) != "orders" {
+				t.Errorf("unexpected notification: %q %v", msg.Data, msg.Header)
+			}
+		}
+	})
+
+	t.Run("notify identity", func(t *testing.T) {
+		if sent, _ := notifier.NotifyIdentity("bob", "orders", []byte("yours")); sent != 1 {
+			t.Fatalf("expected 1 notification, sent %d", sent)
+		}
+		if msg, err := bob.NextMsg(time.Second); err != nil || string(msg.Data) != "yours" {
+			t.Errorf("notification not received: %v", err)
+		}
+		if _, err := alice.NextMsg(50 * time.Millisecond); err == nil {
+			t.Errorf("notification sent to another identity")
+		}
+	})
+
+	t.Run("limit per identity", func(t *testing.T) {
+		register(t, "alice", "users").Unsubscribe()
+		msg := nats.NewMsg("subscribe")
+		msg.Header.Set(HeaderNotifyInbox, nats.NewInbox())
+		msg.Header.Set("user", "alice")
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if natsmicromw.HandlerErrorFromMsg(reply) == nil {
+			t.Errorf("expected registration to be rejected")
+		}
+	})
+
+	t.Run("foreign inbox", func(t *testing.T) {
+		for _, inbox := range []string{"orders.delete", "_INBOX.>", "_INBOX.*.x", "_INBOX."} {
+			msg := nats.NewMsg("subscribe")
+			msg.Header.Set(HeaderNotifyInbox, inbox)
+			msg.Header.Set("user", "carol")
+			reply, err := nc.RequestMsg(msg, time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if natsmicromw.HandlerErrorFromMsg(reply) == nil {
+				t.Errorf("expected inbox %q to be rejected", inbox)
+			}
+		}
+	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		headers := map[string][]string{HeaderNotifyInbox: {nats.NewInbox()}}
+		if _, err := notifier.Register(context.Background(), headers, "orders"); err != ErrNoNotifyIdentity {
+			t.Errorf("expected missing identity error, got %v", err)
+		}
+	})
+
+	t.Run("unregister", func(t *testing.T) {
+		bobCtx := context.WithValue(context.Background(), notifyUserKey{}, "bob")
+		if notifier.Unregister(bobCtx, alice.Subject, "orders") {
+			t.Errorf("registration removed by another identity")
+		}
+		aliceCtx := context.WithValue(context.Background(), notifyUserKey{}, "alice")
+		if !notifier.Unregister(aliceCtx, alice.Subject, "orders") {
+			t.Errorf("registration not removed")
+		}
+		if sent, _ := notifier.Notify("orders", nil); sent != 1 {
+			t.Errorf("expected 1 notification, sent %d", sent)
+		}
+	})
+}
+
+func TestNotifierExpiry(t *testing.T) {
+	expired := make(chan NotifyRegistration, 1)
+	notifier := NewNotifier(nil, NotifierOptions{
+		TTL:      20 * time.Millisecond,
+		Identity: func(ctx context.Context) string { return "alice" },
+		OnExpire: func(reg NotifyRegistration) { expired <- reg },
+	})
+	defer notifier.Stop()
+
+	headers := map[string][]string{HeaderNotifyInbox: {"_INBOX.test"}}
+	if _, err := notifier.Register(context.Background(), headers, "orders"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := notifier.Register(context.Background(), nil, "orders"); err != ErrNoNotifyInbox {
+		t.Errorf("expected missing inbox error, got %v", err)
+	}
+
+	select {
+	case reg := <-expired:
+		if reg.Inbox != "_INBOX.test" || reg.Topic != "orders" {
+			t.Errorf("unexpected registration expired: %+v", reg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("registration did not expire")
+	}
+	if regs := notifier.Registrations(); len(regs) != 0 {
+		t.Errorf("expected no registrations, got %v", regs)
+	}
+}
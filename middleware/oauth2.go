@@ -0,0 +1,322 @@
+// Example OAuth2 token introspection middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+const (
+	HeaderAuthorization string = "authorization"
+)
+
+var (
+	ErrIntrospectionFailed = errors.New("token introspection failed")
+)
+
+// TokenInfo is the result of an RFC 7662 token introspection.
+type TokenInfo struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// Scopes returns the space-separated scopes as a slice.
+func (t *TokenInfo) Scopes() []string {
+	return strings.Fields(t.Scope)
+}
+
+// HasScope checks whether the token was granted the scope.
+func (t *TokenInfo) HasScope(scope string) bool {
+	for _, s := range t.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenCacheEntry struct {
+	info    *TokenInfo
+	expires time.Time
+}
+
+// Introspector validates opaque tokens against an OAuth2 introspection
+// endpoint. Results are cached for `TTL`, and inactive tokens for `NegativeTTL`,
+// but never beyond the expiry of the token. The cache is keyed by a hash of
+// the token, so it does not hold the credentials themselves.
+type Introspector struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	TTL          time.Duration
+	NegativeTTL  time.Duration
+	HTTPClient   *http.Client
+	// Maximum number of cached tokens, defaults to 10000
+	MaxCacheEntries int
+
+	mu    sync.Mutex
+	cache map[string]tokenCacheEntry
+}
+
+// NewIntrospector creates a new Introspector with default cache durations.
+func NewIntrospector(introspectionURL, clientID, clientSecret string) *Introspector {
+	return &Introspector{
+		URL:          introspectionURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TTL:          time.Minute,
+		NegativeTTL:  10 * time.Second,
+		HTTPClient:   http.DefaultClient,
+	}
+}
+
+// SetClientSecret replaces the client secret, e.g. from a `RotatingSecret`:
+//
+//	secret.OnRotate(func(value []byte) { in.SetClientSecret(string(value)) })
+func (in *Introspector) SetClientSecret(secret string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.ClientSecret = secret
+}
+
+// Key of the cached token info, a hash of the token
+func tokenCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Make room for a new token, dropping expired tokens first and an arbitrary
+// one if none have expired
+func (in *Introspector) evict(now time.Time) {
+	max := in.MaxCacheEntries
+	if max <= 0 {
+		max = 10000
+	}
+	if len(in.cache) < max {
+		return
+	}
+	for key, entry := range in.cache {
+		if now.After(entry.expires) {
+			delete(in.cache, key)
+		}
+	}
+	for key := range in.cache {
+		if len(in.cache) < max {
+			break
+		}
+		delete(in.cache, key)
+	}
+}
+
+// Introspect returns the token info, using the cache when possible.
+func (in *Introspector) Introspect(ctx context.Context, token string) (*TokenInfo, error) {
+	key := tokenCacheKey(token)
+	now := time.Now()
+	in.mu.Lock()
+	entry, ok := in.cache[key]
+	if ok && now.After(entry.expires) {
+		delete(in.cache, key)
+		ok = false
+	}
+	in.mu.Unlock()
+	if ok {
+		return entry.info, nil
+	}
+
+	info, err := in.introspect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := in.NegativeTTL
+	if info.Active {
+		ttl = in.TTL
+	}
+	expires := now.Add(ttl)
+	// Never cache a token beyond its own expiry
+	if info.ExpiresAt > 0 && time.Unix(info.ExpiresAt, 0).Before(expires) {
+		expires = time.Unix(info.ExpiresAt, 0)
+	}
+
+	in.mu.Lock()
+	if in.cache == nil {
+		in.cache = make(map[string]tokenCacheEntry)
+	}
+	if _, ok := in.cache[key]; !ok {
+		in.evict(now)
+	}
+	in.cache[key] = tokenCacheEntry{info, expires}
+	in.mu.Unlock()
+
+	return info, nil
+}
+
+func (in *Introspector) introspect(ctx context.Context, token string) (*TokenInfo, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, in.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+	in.mu.Lock()
+	clientSecret := in.ClientSecret
+	in.mu.Unlock()
+	if in.ClientID != "" {
+		httpReq.SetBasicAuth(in.ClientID, clientSecret)
+	}
+
+	client := in.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrIntrospectionFailed, res.StatusCode)
+	}
+
+	var info TokenInfo
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+type tokenInfoContextKey struct{}
+
+// TokenInfoFromContext returns the introspected token of the request.
+func TokenInfoFromContext(ctx context.Context) *TokenInfo {
+	info, _ := ctx.Value(tokenInfoContextKey{}).(*TokenInfo)
+	return info
+}
+
+// ScopesFromContext returns the scopes granted to the request token.
+func ScopesFromContext(ctx context.Context) []string {
+	info := TokenInfoFromContext(ctx)
+	if info == nil {
+		return nil
+	}
+	return info.Scopes()
+}
+
+// Token of a bearer authorization header, the scheme is case-insensitive
+func bearerToken(header string) string {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Validate the bearer token and return a context with the token info
+func authenticate(ctx context.Context, in *Introspector, header string) (context.Context, error) {
+	token := bearerToken(header)
+	if token == "" {
+		return nil, &natsmicromw.HandlerError{
+			Description: "missing bearer token",
+			Code:        natsmicromw.CodeUnauthorized,
+		}
+	}
+
+	info, err := in.Introspect(ctx, token)
+	if err != nil {
+		return nil, natsmicromw.WrapError(natsmicromw.CodeUnavailable, "token introspection unavailable", err)
+	}
+	if !info.Active {
+		return nil, &natsmicromw.HandlerError{
+			Description: "invalid token",
+			Code:        natsmicromw.CodeUnauthorized,
+		}
+	}
+
+	StateIdentity.Set(ctx, info.Subject)
+	return context.WithValue(ctx, tokenInfoContextKey{}, info), nil
+}
+
+// OAuth2Middleware validates the bearer token in the `authorization` header
+// and stores the token info in the request context.
+func OAuth2Middleware(in *Introspector) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			ctx, err := authenticate(req.Context(), in, req.Headers().Get(HeaderAuthorization))
+			if err != nil {
+				return err
+			}
+			return next(req.WithContext(ctx))
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func OAuth2MicroMiddleware(in *Introspector) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			ctx, err := authenticate(req.Context(), in, req.HeaderGet(HeaderAuthorization))
+			if err != nil {
+				return nil, err
+			}
+			return next(req.WithContext(ctx))
+		}
+	}
+}
+
+// Check that all required scopes were granted
+func checkScopes(ctx context.Context, scopes []string) error {
+	info := TokenInfoFromContext(ctx)
+	for _, scope := range scopes {
+		if info == nil || !info.HasScope(scope) {
+			return &natsmicromw.HandlerError{
+				Description: "missing scope: " + scope,
+				Code:        natsmicromw.CodeForbidden,
+			}
+		}
+	}
+	return nil
+}
+
+// RequireScopes rejects requests whose token lacks any of the scopes. Use it
+// on the endpoints that need them, after the OAuth2 middleware:
+//
+//	svc.UseContext(middleware.RequireScopes("orders:write")).AddContextEndpoint(...)
+func RequireScopes(scopes ...string) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if err := checkScopes(req.Context(), scopes); err != nil {
+				return err
+			}
+			return next(req)
+		}
+	}
+}
+
+// Same as `RequireScopes` with `MicroRequest` and `MicroReply`
+func RequireScopesMicro(scopes ...string) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if err := checkScopes(req.Context(), scopes); err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	}
+}
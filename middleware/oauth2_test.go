@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestOAuth2Middleware(t *testing.T) {
+	var calls int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.FormValue("token") == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		info := TokenInfo{Active: r.FormValue("token") == "good", Scope: "orders:read"}
+		json.NewEncoder(w).Encode(info)
+	}))
+	defer idp.Close()
+
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	nm = nm.UseMicro(OAuth2MicroMiddleware(NewIntrospector(idp.URL, "client", "secret")))
+	if err := nm.AddMicroEndpoint("read", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.UseMicro(RequireScopesMicro("orders:write")).AddMicroEndpoint("write", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := func(subject, token string) *natsmicromw.HandlerError {
+		msg := nats.NewMsg(subject)
+		msg.Data = []byte("data")
+		if token != "" {
+			msg.Header.Set(HeaderAuthorization, "Bearer "+token)
+		}
+		reply, err := nc.RequestMsg(msg, 1*time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return natsmicromw.HandlerErrorFromMsg(reply)
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		if err := request("read", "good"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// The second request is served from the cache
+		if err := request("read", "good"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if c := atomic.LoadInt32(&calls); c != 1 {
+			t.Errorf("expected a single introspection call, got %d", c)
+		}
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		if err := request("read", ""); err == nil || err.Code != "401" {
+			t.Errorf("expected a 401 error, got %v", err)
+		}
+	})
+
+	t.Run("inactive token", func(t *testing.T) {
+		if err := request("read", "bad"); err == nil || err.Code != "401" {
+			t.Errorf("expected a 401 error, got %v", err)
+		}
+	})
+
+	t.Run("introspection failure", func(t *testing.T) {
+		err := request("read", "fail")
+		if err == nil || err.Code != "503" {
+			t.Fatalf("expected a 503 error, got %v", err)
+		}
+		// The underlying error is not sent to the client
+		if strings.Contains(err.Description, ErrIntrospectionFailed.Error()) {
+			t.Errorf("unexpected error description: %q", err.Description)
+		}
+	})
+
+	t.Run("missing scope", func(t *testing.T) {
+		if err := request("write", "good"); err == nil || err.Code != "403" {
+			t.Errorf("expected a 403 error, got %v", err)
+		}
+	})
+}
+
+func TestIntrospectorCache(t *testing.T) {
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(TokenInfo{Active: true, Subject: r.FormValue("token")})
+	}))
+	defer idp.Close()
+
+	// A struct literal works without the constructor
+	in := &Introspector{URL: idp.URL, TTL: time.Minute, MaxCacheEntries: 2}
+	for _, token := range []string{"a", "b", "c"} {
+		info, err := in.Introspect(context.Background(), token)
+		if err != nil || info.Subject != token {
+			t.Fatalf("unexpected token info: %v %v", info, err)
+		}
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if len(in.cache) != 2 {
+		t.Errorf("expected 2 cached tokens, got %d", len(in.cache))
+	}
+	for key := range in.cache {
+		if key == "a" || key == "b" || key == "c" {
+			t.Errorf("raw token used as cache key")
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := map[string]string{
+		"Bearer abc":  "abc",
+		"bearer abc":  "abc",
+		"BEARER  abc": "abc",
+		"Basic abc":   "",
+		"abc":         "",
+	}
+	for header, expected := range tests {
+		if token := bearerToken(header); token != expected {
+			t.Errorf("token of %q does not match, expected %q, received %q", header, expected, token)
+		}
+	}
+}
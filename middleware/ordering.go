@@ -0,0 +1,205 @@
+// Example per-key ordering middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// Request header with the key whose requests are handled in order
+	HeaderOrderingKey string = "ordering-key"
+)
+
+// Longest a request waits for the turn of its key by default
+const DefaultOrderingMaxWait = 10 * time.Second
+
+var (
+	ErrOrderingQueueFull = errors.New("too many pending requests for key")
+	ErrOrderingWait      = errors.New("timed out waiting for the turn of the key")
+)
+
+type KeyOrderingOptions struct {
+	// Key returns the key of the request, the `ordering-key` header by
+	// default. Requests without one are not ordered.
+	Key func(ctx context.Context, headers micro.Headers, data []byte) string
+	// MaxPending limits the requests waiting per key, rejecting others with a
+	// 429 error. Unlimited if zero.
+	MaxPending int
+	// MaxWait limits how long a request waits for the turn of its key,
+	// rejecting it with a 503 error after that, `DefaultOrderingMaxWait` if
+	// zero. A shorter deadline of the request's context applies instead.
+	MaxWait time.Duration
+}
+
+// Requests of a key, the first one running and the others waiting in order
+type orderingQueue struct {
+	waiting []chan struct{}
+}
+
+// KeyOrdering serializes the handling of requests per key, keeping the
+// order in which they arrived, while requests of different keys run in
+// parallel.
+type KeyOrdering struct {
+	opts KeyOrderingOptions
+
+	mu     sync.Mutex
+	queues map[string]*orderingQueue
+}
+
+// NewKeyOrdering creates a new ordering with the given options.
+func NewKeyOrdering(opts KeyOrderingOptions) *KeyOrdering {
+	if opts.Key == nil {
+		opts.Key = func(ctx context.Context, headers micro.Headers, data []byte) string {
+			return headers.Get(HeaderOrderingKey)
+		}
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = DefaultOrderingMaxWait
+	}
+	return &KeyOrdering{
+		opts:   opts,
+		queues: make(map[string]*orderingQueue),
+	}
+}
+
+// Pending returns the number of requests of the key being handled or
+// waiting.
+func (o *KeyOrdering) Pending(key string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	q, ok := o.queues[key]
+	if !ok {
+		return 0
+	}
+	return 1 + len(q.waiting)
+}
+
+// Wait for the turn of the request, until the context is done or the
+// maximum wait has passed
+func (o *KeyOrdering) acquire(ctx context.Context, key string) error {
+	o.mu.Lock()
+	q, ok := o.queues[key]
+	if !ok {
+		o.queues[key] = &orderingQueue{}
+		o.mu.Unlock()
+		return nil
+	}
+	if o.opts.MaxPending > 0 && len(q.waiting) >= o.opts.MaxPending {
+		o.mu.Unlock()
+		return &natsmicromw.HandlerError{
+			Description: ErrOrderingQueueFull.Error(),
+			Code:        natsmicromw.CodeTooManyRequests,
+		}
+	}
+	turn := make(chan struct{})
+	q.waiting = append(q.waiting, turn)
+	timer := time.NewTimer(o.opts.MaxWait)
+	o.mu.Unlock()
+	defer timer.Stop()
+	var err error
+	select {
+	case <-turn:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = &natsmicromw.HandlerError{
+			Description: ErrOrderingWait.Error(),
+			Code:        natsmicromw.CodeUnavailable,
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, ch := range q.waiting {
+		if ch == turn {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return err
+		}
+	}
+	// The turn was handed over meanwhile, pass it on
+	o.releaseLocked(key)
+	return err
+}
+
+// Hand the turn over to the next request of the key
+func (o *KeyOrdering) release(key string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.releaseLocked(key)
+}
+
+func (o *KeyOrdering) releaseLocked(key string) {
+	q := o.queues[key]
+	if len(q.waiting) == 0 {
+		delete(o.queues, key)
+		return
+	}
+	close(q.waiting[0])
+	q.waiting = q.waiting[1:]
+}
+
+// Run the handler in the turn of the request's key
+func (o *KeyOrdering) run(ctx context.Context, headers micro.Headers, data []byte, fn func() error) error {
+	key := o.opts.Key(ctx, headers, data)
+	if key == "" {
+		return fn()
+	}
+	if err := o.acquire(ctx, key); err != nil {
+		return err
+	}
+	defer o.release(key)
+	return fn()
+}
+
+// KeyOrderingMiddleware handles the requests of a key one at a time, in
+// the order they reach the middleware, e.g. for commands on the same
+// entity, while requests of other keys are not held up. Share the ordering
+// between the endpoints of the entity, e.g. create, update and delete, to
+// order them with each other. Requests wait for their turn until their
+// context is done or `MaxWait` has passed.
+//
+// Micro calls the handler of an endpoint for one request at a time, so a
+// request waiting for its key would also hold up the requests of other keys
+// to the same endpoint. Register the endpoints on a worker pool, e.g. with
+// a single unnamed priority tier, which keeps their subjects, so that
+// waiting requests only take up a worker of their own:
+//
+//	ordered := srv.WithPriorityTiers(natsmicromw.PriorityTier{Workers: 16, Queue: 100})
+//	ordered.UseContext(middleware.KeyOrderingMiddleware(ordering)).AddContextEndpoint("update", handler)
+func KeyOrderingMiddleware(ordering *KeyOrdering) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			return ordering.run(req.Context(), req.Headers(), req.Data(), func() error {
+				return next(req)
+			})
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func KeyOrderingMicroMiddleware(ordering *KeyOrdering) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			data, err := req.ReadData()
+			if err != nil {
+				return nil, err
+			}
+			var reply *natsmicromw.MicroReply
+			err = ordering.run(req.Context(), req.Headers, data, func() error {
+				var err error
+				reply, err = next(req)
+				return err
+			})
+			return reply, err
+		}
+	}
+}
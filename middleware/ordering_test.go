@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestKeyOrderingMicroMiddleware(t *testing.T) {
+	ordering := NewKeyOrdering(KeyOrderingOptions{MaxPending: 2})
+
+	var mu sync.Mutex
+	var handled []string
+	release := make(chan struct{})
+	handler := KeyOrderingMicroMiddleware(ordering)(func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		if string(req.Data) == "first" {
+			<-release
+		}
+		mu.Lock()
+		handled = append(handled, string(req.Data))
+		mu.Unlock()
+		return natsmicromw.NewMicroReply(nil), nil
+	})
+	request := func(ctx context.Context, key, data string) *natsmicromw.MicroRequest {
+		return (&natsmicromw.MicroRequest{
+			Headers: micro.Headers{HeaderOrderingKey: {key}},
+			Data:    []byte(data),
+		}).WithContext(ctx)
+	}
+	waitPending := func(key string, n int) {
+		for i := 0; ordering.Pending(key) != n; i++ {
+			if i > 100 {
+				t.Fatalf("expected %d pending requests, found %d", n, ordering.Pending(key))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Queue requests behind the blocked first one, in order
+	var wg sync.WaitGroup
+	for i, data := range []string{"first", "second", "third"} {
+		wg.Add(1)
+		go func(data string) {
+			defer wg.Done()
+			if _, err := handler(request(context.Background(), "a", data)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(data)
+		waitPending("a", i+1)
+	}
+
+	t.Run("full", func(t *testing.T) {
+		_, err := handler(request(context.Background(), "a", "fourth"))
+		var handlerErr *natsmicromw.HandlerError
+		if !errors.As(err, &handlerErr) || handlerErr.Code != natsmicromw.CodeTooManyRequests {
+			t.Errorf("expected a 429 error, received %v", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		ordering.opts.MaxPending = 0
+		if _, err := handler(request(ctx, "a", "canceled")); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the deadline to be exceeded, received %v", err)
+		}
+		waitPending("a", 3)
+	})
+
+	t.Run("max wait", func(t *testing.T) {
+		ordering.opts.MaxWait = 10 * time.Millisecond
+		defer func() { ordering.opts.MaxWait = DefaultOrderingMaxWait }()
+		_, err := handler(request(context.Background(), "a", "late"))
+		var handlerErr *natsmicromw.HandlerError
+		if !errors.As(err, &handlerErr) || handlerErr.Code != natsmicromw.CodeUnavailable {
+			t.Errorf("expected a 503 error, received %v", err)
+		}
+		waitPending("a", 3)
+	})
+
+	t.Run("other keys", func(t *testing.T) {
+		if _, err := handler(request(context.Background(), "b", "other")); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	close(release)
+	wg.Wait()
+	if expected := []string{"other", "first", "second", "third"}; !reflect.DeepEqual(handled, expected) {
+		t.Errorf("expected %v, handled %v", expected, handled)
+	}
+	if n := ordering.Pending("a"); n != 0 {
+		t.Errorf("expected no pending requests, found %d", n)
+	}
+}
+
+func TestKeyOrderingConcurrentKeys(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	ordering := NewKeyOrdering(KeyOrderingOptions{})
+	release := make(chan struct{})
+	ordered := nm.WithPriorityTiers(natsmicromw.PriorityTier{Workers: 4}).UseMicro(KeyOrderingMicroMiddleware(ordering))
+	err := ordered.AddMicroEndpoint("ordered", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		if string(req.Data) == "blocked" {
+			<-release
+		}
+		return natsmicromw.NewMicroReply(req.Data), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	request := func(key, data string) (*nats.Msg, error) {
+		msg := nats.NewMsg("ordered")
+		msg.Header.Set(HeaderOrderingKey, key)
+		msg.Data = []byte(data)
+		return nc.RequestMsg(msg, 2*time.Second)
+	}
+	waitPending := func(key string, n int) {
+		for i := 0; ordering.Pending(key) != n; i++ {
+			if i > 100 {
+				t.Fatalf("expected %d pending requests, found %d", n, ordering.Pending(key))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Hold key "a" with a running and a waiting request
+	var wg sync.WaitGroup
+	for i, data := range []string{"blocked", "waiting"} {
+		wg.Add(1)
+		go func(data string) {
+			defer wg.Done()
+			if _, err := request("a", data); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(data)
+		waitPending("a", i+1)
+	}
+
+	// Key "b" is not held up by the requests of key "a"
+	reply, err := request("b", "other")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if string(reply.Data) != "other" {
+		t.Errorf("expected other, received %s", reply.Data)
+	}
+
+	close(release)
+	wg.Wait()
+}
@@ -0,0 +1,71 @@
+// Example profiling labels middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"runtime/pprof"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+const (
+	HeaderTenant string = "tenant"
+)
+
+var profilingLabelsDisabled atomic.Bool
+
+// SetProfilingLabels enables or disables the profiling labels at runtime.
+// When disabled, the middleware only adds a single atomic load per request.
+func SetProfilingLabels(enabled bool) {
+	profilingLabelsDisabled.Store(!enabled)
+}
+
+// Labels for the endpoint, tenant and request ID, when known
+func profilingLabels(ctx context.Context, subject string, headers micro.Headers) pprof.LabelSet {
+	labels := []string{"endpoint", subject}
+	if tenant := headers.Get(HeaderTenant); tenant != "" {
+		labels = append(labels, "tenant", tenant)
+	}
+	if requestId := RequestIdFromContext(ctx); requestId != "" {
+		labels = append(labels, "request_id", requestId)
+	}
+	return pprof.Labels(labels...)
+}
+
+// ProfilingLabelsMiddleware runs the handler with pprof labels, so that
+// continuous profilers like Pyroscope or Parca can break down CPU usage by
+// endpoint and tenant. Add it after `RequestIdMiddleware` to also label
+// the request ID.
+func ProfilingLabelsMiddleware(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(req *natsmicromw.Request) error {
+		if profilingLabelsDisabled.Load() {
+			return next(req)
+		}
+
+		var err error
+		pprof.Do(req.Context(), profilingLabels(req.Context(), req.Subject(), req.Headers()), func(ctx context.Context) {
+			err = next(req.WithContext(ctx))
+		})
+		return err
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func ProfilingLabelsMicroMiddleware(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		if profilingLabelsDisabled.Load() {
+			return next(req)
+		}
+
+		var reply *natsmicromw.MicroReply
+		var err error
+		pprof.Do(req.Context(), profilingLabels(req.Context(), req.Subject, req.Headers), func(ctx context.Context) {
+			reply, err = next(req.WithContext(ctx))
+		})
+		return reply, err
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestProfilingLabelsMiddleware(t *testing.T) {
+	var endpoint, tenant string
+	handler := ProfilingLabelsMicroMiddleware(func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		endpoint, _ = pprof.Label(req.Context(), "endpoint")
+		tenant, _ = pprof.Label(req.Context(), "tenant")
+		return nil, nil
+	})
+
+	req := (&natsmicromw.MicroRequest{
+		Subject: "orders.create",
+		Headers: micro.Headers{HeaderTenant: {"acme"}},
+	}).WithContext(context.Background())
+
+	handler(req)
+	if endpoint != "orders.create" || tenant != "acme" {
+		t.Errorf("unexpected labels: endpoint %q, tenant %q", endpoint, tenant)
+	}
+
+	SetProfilingLabels(false)
+	defer SetProfilingLabels(true)
+	endpoint = ""
+	handler(req)
+	if endpoint != "" {
+		t.Errorf("expected no labels when disabled, got endpoint %q", endpoint)
+	}
+}
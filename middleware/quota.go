@@ -0,0 +1,285 @@
+// Example per-identity quota middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+const (
+	HeaderQuotaLimit     string = "quota-limit"
+	HeaderQuotaRemaining string = "quota-remaining"
+	// Unix time in seconds when the oldest counted request leaves the window
+	HeaderQuotaReset string = "quota-reset"
+)
+
+// Quota limit and window by default
+const (
+	DefaultQuotaLimit  int64 = 10000
+	DefaultQuotaWindow       = 24 * time.Hour
+)
+
+// QuotaUsage is the state of a quota after a request was accounted.
+type QuotaUsage struct {
+	Used  int64
+	Limit int64
+	Reset time.Time
+}
+
+// QuotaStore keeps the request counts of each identity. `Consume` counts a
+// request if the usage within the window is below the limit, and reports
+// whether it was allowed.
+type QuotaStore interface {
+	Consume(ctx context.Context, key string, limit int64, window time.Duration) (QuotaUsage, bool, error)
+}
+
+// MemoryQuotaStore is an in-memory sliding window store. The window is
+// divided into buckets, so memory use per key does not depend on the limit.
+// Keys without requests in the window are removed once per bucket, so a
+// store must only be used with one window.
+type MemoryQuotaStore struct {
+	buckets int64
+
+	mu     sync.Mutex
+	counts map[string]map[int64]int64
+	// Bucket of the last removal of expired keys
+	swept int64
+}
+
+// NewMemoryQuotaStore creates a new store, splitting each window into the
+// given number of buckets (60 if zero).
+func NewMemoryQuotaStore(buckets int) *MemoryQuotaStore {
+	if buckets <= 0 {
+		buckets = 60
+	}
+	return &MemoryQuotaStore{
+		buckets: int64(buckets),
+		counts:  make(map[string]map[int64]int64),
+	}
+}
+
+func (m *MemoryQuotaStore) Consume(ctx context.Context, key string, limit int64, window time.Duration) (QuotaUsage, bool, error) {
+	size := int64(window) / m.buckets
+	if size <= 0 {
+		size = 1
+	}
+	current := time.Now().UnixNano() / size
+	first := current - m.buckets + 1
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current != m.swept {
+		m.sweep(first)
+		m.swept = current
+	}
+
+	// Drop buckets that left the window and sum the rest
+	counts := m.counts[key]
+	var used int64
+	oldest := current
+	for bucket, count := range counts {
+		if bucket < first {
+			delete(counts, bucket)
+			continue
+		}
+		used += count
+		if bucket < oldest {
+			oldest = bucket
+		}
+	}
+
+	allowed := used < limit
+	if allowed {
+		if counts == nil {
+			counts = make(map[int64]int64)
+			m.counts[key] = counts
+		}
+		counts[current]++
+		used++
+	} else if len(counts) == 0 {
+		delete(m.counts, key)
+	}
+
+	return QuotaUsage{
+		Used:  used,
+		Limit: limit,
+		Reset: time.Unix(0, (oldest+m.buckets)*size),
+	}, allowed, nil
+}
+
+// Remove the buckets before first, and the keys left without buckets
+func (m *MemoryQuotaStore) sweep(first int64) {
+	for key, counts := range m.counts {
+		for bucket := range counts {
+			if bucket < first {
+				delete(counts, bucket)
+			}
+		}
+		if len(counts) == 0 {
+			delete(m.counts, key)
+		}
+	}
+}
+
+// SnapshotState returns the request counts of the store as JSON, see
+// `PersistState`.
+func (m *MemoryQuotaStore) SnapshotState() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.Marshal(m.counts)
+}
+
+// RestoreState replaces the request counts of the store with a snapshot.
+// The buckets are only meaningful with the same number of buckets and
+// windows as when the snapshot was taken.
+func (m *MemoryQuotaStore) RestoreState(data []byte) error {
+	counts := make(map[string]map[int64]int64)
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts = counts
+	return nil
+}
+
+type QuotaOptions struct {
+	Store QuotaStore
+	// Limit is the number of requests allowed within the window,
+	// `DefaultQuotaLimit` if not positive
+	Limit int64
+	// Window is the duration the requests are counted for,
+	// `DefaultQuotaWindow` if not positive
+	Window time.Duration
+	// Key returns the identity to account the request to. Defaults to the
+	// API key ID, OAuth2 token subject or auth callout principal, in that
+	// order. Requests without an identity are not limited.
+	Key func(ctx context.Context) string
+	// TierLimits overrides the limit per API key tier. A tenant's
+	// `TenantConfig.QuotaLimit` takes precedence over both limits.
+	TierLimits map[string]int64
+}
+
+// Identity of the request from the authentication middleware, if any
+func identityKey(ctx context.Context) string {
+	if apiKey := APIKeyFromContext(ctx); apiKey != nil {
+		return apiKey.ID
+	}
+	if info := TokenInfoFromContext(ctx); info != nil {
+		return info.Subject
+	}
+	if p := PrincipalFromContext(ctx); p != nil {
+		return p.Subject
+	}
+	return ""
+}
+
+func setQuotaHeaders(set func(key, value string), usage QuotaUsage) {
+	remaining := usage.Limit - usage.Used
+	if remaining < 0 {
+		remaining = 0
+	}
+	set(HeaderQuotaLimit, strconv.FormatInt(usage.Limit, 10))
+	set(HeaderQuotaRemaining, strconv.FormatInt(remaining, 10))
+	set(HeaderQuotaReset, strconv.FormatInt(usage.Reset.Unix(), 10))
+}
+
+// Account the request, returns nil usage if the request has no identity
+func consumeQuota(ctx context.Context, opts QuotaOptions) (*QuotaUsage, error) {
+	key := opts.Key(ctx)
+	if key == "" {
+		return nil, nil
+	}
+
+	limit := opts.Limit
+	if apiKey := APIKeyFromContext(ctx); apiKey != nil {
+		if tierLimit, ok := opts.TierLimits[apiKey.Tier]; ok {
+			limit = tierLimit
+		}
+	}
+	if config := TenantConfigFromContext(ctx); config != nil && config.QuotaLimit > 0 {
+		limit = config.QuotaLimit
+	}
+
+	usage, allowed, err := opts.Store.Consume(ctx, key, limit, opts.Window)
+	if err != nil {
+		return nil, natsmicromw.WrapError(natsmicromw.CodeUnavailable, "quota store unavailable", err)
+	}
+	if !allowed {
+		herr := &natsmicromw.HandlerError{
+			Description: "quota exhausted",
+			Code:        natsmicromw.CodeTooManyRequests,
+		}
+		setQuotaHeaders(func(k, v string) { herr.WithHeader(k, v) }, usage)
+		herr.WithRetryAfter(time.Until(usage.Reset))
+		return nil, herr
+	}
+	return &usage, nil
+}
+
+func defaultQuotaOptions(opts QuotaOptions) QuotaOptions {
+	if opts.Key == nil {
+		opts.Key = identityKey
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemoryQuotaStore(0)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultQuotaLimit
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultQuotaWindow
+	}
+	return opts
+}
+
+// QuotaMiddleware limits the number of requests per identity within a long
+// sliding window, e.g. 100k requests per day per API key. Exhausted quotas
+// are rejected with a 429 error that includes the quota headers and a
+// `retry-after-ms` header with the time until a request leaves the window.
+// The quota is not reported as load of the service, as it only applies to
+// one caller, who can pace itself with the quota headers.
+func QuotaMiddleware(opts QuotaOptions) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	opts = defaultQuotaOptions(opts)
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			if _, err := consumeQuota(req.Context(), opts); err != nil {
+				return err
+			}
+			return next(req)
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`, which also adds the
+// quota headers to successful replies.
+func QuotaMicroMiddleware(opts QuotaOptions) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	opts = defaultQuotaOptions(opts)
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			usage, err := consumeQuota(req.Context(), opts)
+			if err != nil {
+				return nil, err
+			}
+
+			reply, err := next(req)
+			if err == nil && reply != nil && usage != nil {
+				setQuotaHeaders(reply.HeaderSet, *usage)
+			}
+			return reply, err
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestQuotaMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	// Quota usage of one caller is not load of the service
+	nm.SetBackpressure(0.1)
+	store := StaticAPIKeys{
+		"basic": {ID: "1", Tier: "basic"},
+		"gold":  {ID: "2", Tier: "gold"},
+	}
+	nm = nm.UseMicro(APIKeyMicroMiddleware(store), QuotaMicroMiddleware(QuotaOptions{
+		Limit:      2,
+		Window:     time.Hour,
+		TierLimits: map[string]int64{"gold": 3},
+	}))
+	if err := nm.AddMicroEndpoint("echo", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Chain benchmarks do not consume quota
+	bench, err := nm.EnableChainBenchmark(func(req micro.Request) bool { return true })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	benchReq, _ := json.Marshal(natsmicromw.ChainBenchmarkRequest{
+		Iterations: 5,
+		Pipeline:   natsmicromw.EndpointMicro,
+		Headers:    map[string][]string{HeaderAPIKey: {"basic"}},
+	})
+	if _, err := nc.Request(bench, benchReq, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := func(key string) *nats.Msg {
+		msg := nats.NewMsg("echo")
+		msg.Header.Set(HeaderAPIKey, key)
+		reply, err := nc.RequestMsg(msg, 1*time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return reply
+	}
+
+	for i := 0; i < 2; i++ {
+		reply := request("basic")
+		if herr := natsmicromw.HandlerErrorFromMsg(reply); herr != nil {
+			t.Fatalf("unexpected error: %v", herr)
+		}
+		if remaining := reply.Header.Get(HeaderQuotaRemaining); remaining != []string{"1", "0"}[i] {
+			t.Errorf("unexpected remaining quota: %s", remaining)
+		}
+		if load := reply.Header.Get(natsmicromw.HeaderBackpressure); load != "" {
+			t.Errorf("quota usage was reported as backpressure: %s", load)
+		}
+	}
+
+	herr := natsmicromw.HandlerErrorFromMsg(request("basic"))
+	if herr == nil || herr.Code != "429" {
+		t.Fatalf("expected a 429 error, got %v", herr)
+	}
+	if nats.Header(herr.Headers).Get(HeaderQuotaLimit) != "2" {
+		t.Errorf("quota limit header does not match, expected %s, received %s", "2", nats.Header(herr.Headers).Get(HeaderQuotaLimit))
+	}
+	if retryAfter, ok := herr.RetryAfter(); !ok || retryAfter <= 0 {
+		t.Errorf("expected a retry-after header, received %v", retryAfter)
+	}
+
+	// Higher tier limit
+	for i := 0; i < 3; i++ {
+		if herr := natsmicromw.HandlerErrorFromMsg(request("gold")); herr != nil {
+			t.Errorf("unexpected error: %v", herr)
+		}
+	}
+}
+
+func TestQuotaNilReply(t *testing.T) {
+	ctx := context.WithValue(context.Background(), apiKeyContextKey{}, &APIKey{ID: "1"})
+	handler := QuotaMicroMiddleware(QuotaOptions{Limit: 1, Window: time.Hour})(func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return nil, nil
+	})
+	req := (&natsmicromw.MicroRequest{Subject: "echo"}).WithContext(ctx)
+	if reply, err := handler(req); reply != nil || err != nil {
+		t.Errorf("unexpected reply: %v %v", reply, err)
+	}
+}
+
+func TestMemoryQuotaStorePrunes(t *testing.T) {
+	store := NewMemoryQuotaStore(2)
+	window := 20 * time.Millisecond
+	for _, key := range []string{"a", "b", "c"} {
+		if _, allowed, _ := store.Consume(context.Background(), key, 1, window); !allowed {
+			t.Fatalf("request of %s not allowed", key)
+		}
+	}
+	time.Sleep(2 * window)
+	if _, allowed, _ := store.Consume(context.Background(), "d", 0, window); allowed {
+		t.Errorf("request above the limit allowed")
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.counts) != 0 {
+		t.Errorf("expired keys not removed: %v", store.counts)
+	}
+}
+
+// Store recording the limit and window it is called with
+type quotaStoreFunc func(ctx context.Context, key string, limit int64, window time.Duration) (QuotaUsage, bool, error)
+
+func (f quotaStoreFunc) Consume(ctx context.Context, key string, limit int64, window time.Duration) (QuotaUsage, bool, error) {
+	return f(ctx, key, limit, window)
+}
+
+func TestQuotaDefaults(t *testing.T) {
+	ctx := context.WithValue(context.Background(), apiKeyContextKey{}, &APIKey{ID: "1"})
+	var limit int64
+	var window time.Duration
+	store := quotaStoreFunc(func(ctx context.Context, key string, l int64, w time.Duration) (QuotaUsage, bool, error) {
+		limit, window = l, w
+		return QuotaUsage{Used: 1, Limit: l}, true, nil
+	})
+
+	// Zero and negative values both fall back to the defaults
+	for _, opts := range []QuotaOptions{{Store: store}, {Store: store, Limit: -1, Window: -time.Hour}} {
+		handler := QuotaMicroMiddleware(opts)(microEcho)
+		req := (&natsmicromw.MicroRequest{Subject: "echo"}).WithContext(ctx)
+		if _, err := handler(req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if limit != DefaultQuotaLimit {
+			t.Errorf("expected %d, received %d", DefaultQuotaLimit, limit)
+		}
+		if window != DefaultQuotaWindow {
+			t.Errorf("expected %v, received %v", DefaultQuotaWindow, window)
+		}
+	}
+}
+
+func TestQuotaStoreError(t *testing.T) {
+	ctx := context.WithValue(context.Background(), apiKeyContextKey{}, &APIKey{ID: "1"})
+	store := quotaStoreFunc(func(context.Context, string, int64, time.Duration) (QuotaUsage, bool, error) {
+		return QuotaUsage{}, false, errors.New("dial tcp 10.0.0.1:6379: connection refused")
+	})
+	handler := QuotaMicroMiddleware(QuotaOptions{Store: store})(microEcho)
+	req := (&natsmicromw.MicroRequest{Subject: "echo"}).WithContext(ctx)
+
+	_, err := handler(req)
+	var handlerErr *natsmicromw.HandlerError
+	if !errors.As(err, &handlerErr) || handlerErr.Code != natsmicromw.CodeUnavailable {
+		t.Fatalf("expected a 503 error, received %v", err)
+	}
+	// The store error is kept as the cause, but not sent to the client
+	if strings.Contains(handlerErr.Description, "10.0.0.1") {
+		t.Errorf("unexpected error description: %q", handlerErr.Description)
+	}
+}
@@ -7,72 +7,98 @@ import (
 
 	"github.com/Karimerto/natsmicromw"
 
-	// For generating request ID
-	"github.com/rs/xid"
+	"github.com/nats-io/nats.go"
 )
 
 type requestIdContextKey struct{}
 
-// An example request id middleware
-func RequestIdMiddleware(tags ...string) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
-	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
-		// If no tags are defined, then assume "request_id"
-		// Try a few variants since NATS headers are case-sensitive
-		if len(tags) == 0 {
-			tags = []string{"request_id", "Request_id", "Request_Id", "REQUEST_ID"}
+// RequestIdGenerator returns a new unique request id.
+type RequestIdGenerator func() string
+
+// RequestIds configures the request id middleware, for a different format of
+// generated ids. Downstream systems often require a specific format, e.g.
+// UUIDs, to correlate requests.
+type RequestIds struct {
+	tags     []string
+	generate RequestIdGenerator
+}
+
+// NewRequestIds creates a new request id configuration reading the request
+// id from the given header tags. The request id is included in error
+// replies, see `natsmicromw.SetErrorMeta`, and in requests made through
+// `natsmicromw.ConnFromContext`. Ids are generated with the default
+// generator, see `WithGenerator` for others.
+func NewRequestIds(tags ...string) *RequestIds {
+	// If no tags are defined, then assume "request_id"
+	// Try a few variants since NATS headers are case-sensitive, unless the
+	// service normalizes them with `SetHeaderNormalizer`
+	if len(tags) == 0 {
+		tags = []string{"request_id", "Request_id", "Request_Id", "REQUEST_ID"}
+	}
+	return &RequestIds{tags: tags, generate: newRequestID}
+}
+
+// WithGenerator returns a copy of the configuration generating ids with the
+// given function, e.g. `UUIDv7RequestId` or a `NewSnowflakeRequestIds`
+// generator. It must be safe for concurrent use.
+func (r *RequestIds) WithGenerator(generate RequestIdGenerator) *RequestIds {
+	return &RequestIds{tags: r.tags, generate: generate}
+}
+
+// Return the request id in the headers, or generate one
+func (r *RequestIds) requestId(get func(string) string) string {
+	// Try all possible tags until something is found
+	for _, tag := range r.tags {
+		if requestId := get(tag); requestId != "" {
+			return requestId
 		}
+	}
+	// If nothing is found, generate one
+	return r.generate()
+}
 
+// Context with the request id, which is also added to the requests made
+// through `natsmicromw.Conn` under the first tag
+func (r *RequestIds) context(ctx context.Context, requestId string) context.Context {
+	natsmicromw.SetErrorMeta(ctx, natsmicromw.ErrorMetaRequestID, requestId)
+	ctx = natsmicromw.WithOutgoingHeaders(ctx, func(headers nats.Header) {
+		if headers.Get(r.tags[0]) == "" {
+			headers.Set(r.tags[0], requestId)
+		}
+	})
+	return context.WithValue(ctx, requestIdContextKey{}, requestId)
+}
+
+// Middleware returns the request id middleware with this configuration.
+func (r *RequestIds) Middleware() func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
 		return func(req *natsmicromw.Request) error {
-			var requestId string
-			// Try all possible tags until something is found
-			for _, tag := range tags {
-				requestId = req.Headers().Get(tag)
-				if requestId != "" {
-					break
-				}
-			}
-
-			// If nothing is found, generate one
-			if len(requestId) == 0 {
-				requestId = xid.New().String()
-			}
-
-			ctx := context.WithValue(req.Context(), requestIdContextKey{}, requestId)
+			ctx := r.context(req.Context(), r.requestId(req.Headers().Get))
 			return next(req.WithContext(ctx))
 		}
 	}
 }
 
-// Same example with `MicroRequest` and `MicroReply`
-func RequestIdMicroMiddleware(tags ...string) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+// Same middleware with `MicroRequest` and `MicroReply`
+func (r *RequestIds) MicroMiddleware() func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
 	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
-		// If no tags are defined, then assume "request_id"
-		// Try a few variants since NATS headers are case-sensitive
-		if len(tags) == 0 {
-			tags = []string{"request_id", "Request_id", "Request_Id", "REQUEST_ID"}
-		}
-
 		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
-			var requestId string
-			// Try all possible tags until something is found
-			for _, tag := range tags {
-				requestId = req.HeaderGet(tag)
-				if requestId != "" {
-					break
-				}
-			}
-
-			// If nothing is found, generate one
-			if len(requestId) == 0 {
-				requestId = xid.New().String()
-			}
-
-			ctx := context.WithValue(req.Context(), requestIdContextKey{}, requestId)
+			ctx := r.context(req.Context(), r.requestId(req.HeaderGet))
 			return next(req.WithContext(ctx))
 		}
 	}
 }
 
+// An example request id middleware
+func RequestIdMiddleware(tags ...string) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return NewRequestIds(tags...).Middleware()
+}
+
+// Same example with `MicroRequest` and `MicroReply`
+func RequestIdMicroMiddleware(tags ...string) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return NewRequestIds(tags...).MicroMiddleware()
+}
+
 // Get current request Id from the context
 func RequestIdFromContext(ctx context.Context) string {
 	requestId, ok := ctx.Value(requestIdContextKey{}).(string)
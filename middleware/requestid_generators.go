@@ -0,0 +1,134 @@
+// Example request id generators for natsmicromw
+
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// UUIDv4RequestId generates a random UUID (RFC 9562 version 4).
+func UUIDv4RequestId() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return formatUUID(id, 4)
+}
+
+// UUIDv7RequestId generates a time-ordered UUID (RFC 9562 version 7), with
+// the current Unix time in milliseconds followed by random bits.
+func UUIDv7RequestId() string {
+	var id [16]byte
+	_, _ = rand.Read(id[6:])
+	putMillis(id[:6], time.Now())
+	return formatUUID(id, 7)
+}
+
+// Set the version and variant bits and format the UUID
+func formatUUID(id [16]byte, version byte) string {
+	id[6] = id[6]&0x0f | version<<4
+	id[8] = id[8]&0x3f | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], id[10:])
+	return string(buf[:])
+}
+
+// Write the Unix time in milliseconds as a 48-bit big-endian integer
+func putMillis(b []byte, t time.Time) {
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(b, ms[2:])
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDRequestId generates a lexicographically sortable ULID, with the
+// current Unix time in milliseconds followed by random bits. Ids generated
+// in the same millisecond are not ordered.
+func ULIDRequestId() string {
+	var id [16]byte
+	_, _ = rand.Read(id[6:])
+	putMillis(id[:6], time.Now())
+
+	// 128 bits as 26 Crockford base32 characters, 5 bits each from the
+	// most significant end with 2 bits of padding
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	var buf [26]byte
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
+
+// Snowflake id layout: 41 bits of milliseconds since the epoch, 10 bits of
+// node id and 12 bits of sequence
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	snowflakeMaxNode      = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSequence  = 1<<snowflakeSequenceBits - 1
+)
+
+// Epoch of snowflake ids, 2010-11-04T01:42:54.657Z as used by Twitter
+var snowflakeEpoch = time.UnixMilli(1288834974657)
+
+// ErrInvalidSnowflakeNode is returned for a node id outside 0-1023.
+var ErrInvalidSnowflakeNode = errors.New("snowflake node id must be between 0 and 1023")
+
+// NewSnowflakeRequestIds returns a generator of snowflake ids, 63-bit
+// integers ordered by time that are unique as long as every instance uses
+// a different node id. Up to 4096 ids are generated per millisecond, after
+// which the generator waits for the next one.
+func NewSnowflakeRequestIds(node int64) (RequestIdGenerator, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, ErrInvalidSnowflakeNode
+	}
+
+	var (
+		mu       sync.Mutex
+		last     int64
+		sequence int64
+	)
+	return func() string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now := time.Since(snowflakeEpoch).Milliseconds()
+		// Do not go back in time if the clock does
+		if now < last {
+			now = last
+		}
+		if now == last {
+			sequence = (sequence + 1) & snowflakeMaxSequence
+			if sequence == 0 {
+				// Sequence exhausted, wait for the next millisecond
+				for now <= last {
+					time.Sleep(100 * time.Microsecond)
+					now = time.Since(snowflakeEpoch).Milliseconds()
+				}
+			}
+		} else {
+			sequence = 0
+		}
+		last = now
+
+		id := now<<(snowflakeNodeBits+snowflakeSequenceBits) | node<<snowflakeSequenceBits | sequence
+		return strconv.FormatInt(id, 10)
+	}, nil
+}
@@ -0,0 +1,15 @@
+//go:build natsmicromw_noxid
+
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Generate a new random request ID, used when built without xid
+func newRequestID() string {
+	var id [12]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"bytes"
+	"regexp"
+	"strconv"
 	"testing"
 	"time"
 
@@ -175,3 +177,109 @@ func TestRequestIdMiddleware(t *testing.T) {
 		}
 	})
 }
+
+func TestRequestIdGenerator(t *testing.T) {
+	s := getServer(t)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	nm, err := natsmicromw.AddMicroService(nc, micro.Config{
+		Name:    "TestService",
+		Version: "1.0.0",
+	})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+	ids := NewRequestIds().WithGenerator(func() string { return "generated" })
+	nm = nm.UseMicro(ids.MicroMiddleware())
+
+	err = nm.AddMicroEndpoint("foo", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return &natsmicromw.MicroReply{Data: []byte(RequestIdFromContext(req.Context()))}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reply, err := nc.Request("foo", nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(reply.Data) != "generated" {
+		t.Errorf("expected generated request id, received %q", reply.Data)
+	}
+
+	// Ids in the headers are kept
+	msg := nats.NewMsg("foo")
+	msg.Header.Set("request_id", "req-1")
+	reply, err = nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(reply.Data) != "req-1" {
+		t.Errorf("expected request id from header, received %q", reply.Data)
+	}
+}
+
+func TestRequestIdFormats(t *testing.T) {
+	snowflake, err := NewSnowflakeRequestIds(42)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	formats := map[string]struct {
+		generate RequestIdGenerator
+		pattern  string
+	}{
+		"uuidv4":    {UUIDv4RequestId, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		"uuidv7":    {UUIDv7RequestId, `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		"ulid":      {ULIDRequestId, `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`},
+		"snowflake": {snowflake, `^[0-9]+$`},
+	}
+	for name, format := range formats {
+		t.Run(name, func(t *testing.T) {
+			re := regexp.MustCompile(format.pattern)
+			seen := make(map[string]bool)
+			for i := 0; i < 1000; i++ {
+				id := format.generate()
+				if !re.MatchString(id) {
+					t.Fatalf("id %q does not match %s", id, format.pattern)
+				}
+				if seen[id] {
+					t.Fatalf("duplicate id %q", id)
+				}
+				seen[id] = true
+			}
+		})
+	}
+
+	t.Run("time ordered", func(t *testing.T) {
+		for name, generate := range map[string]RequestIdGenerator{"uuidv7": UUIDv7RequestId, "ulid": ULIDRequestId} {
+			first := generate()
+			time.Sleep(2 * time.Millisecond)
+			if second := generate(); second <= first {
+				t.Errorf("%s ids not ordered: %s then %s", name, first, second)
+			}
+		}
+
+		prev := int64(0)
+		for i := 0; i < 10000; i++ {
+			id, _ := strconv.ParseInt(snowflake(), 10, 64)
+			if id <= prev {
+				t.Fatalf("snowflake ids not increasing: %d then %d", prev, id)
+			}
+			if node := id >> 12 & 0x3ff; node != 42 {
+				t.Fatalf("expected node 42, got %d", node)
+			}
+			prev = id
+		}
+	})
+
+	if _, err := NewSnowflakeRequestIds(1024); err != ErrInvalidSnowflakeNode {
+		t.Errorf("expected invalid node error, got %v", err)
+	}
+}
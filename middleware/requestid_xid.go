@@ -0,0 +1,19 @@
+//go:build !natsmicromw_noxid
+
+package middleware
+
+import (
+	// For generating request ID
+	"github.com/rs/xid"
+)
+
+// Generate a new sortable request ID
+func newRequestID() string {
+	return XidRequestId()
+}
+
+// XidRequestId generates a sortable xid, the default request id format. It
+// is not available when built with the `natsmicromw_noxid` tag.
+func XidRequestId() string {
+	return xid.New().String()
+}
@@ -0,0 +1,86 @@
+// Example subject rewriting middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// SubjectRewriter returns the subject seen by later middleware and the handler.
+type SubjectRewriter func(subject string) string
+
+// StripSubjectPrefix removes the first matching prefix, e.g. an environment
+// prefix like "prod.".
+func StripSubjectPrefix(prefixes ...string) SubjectRewriter {
+	return func(subject string) string {
+		for _, prefix := range prefixes {
+			if rest, ok := strings.CutPrefix(subject, prefix); ok {
+				return rest
+			}
+		}
+		return subject
+	}
+}
+
+// MapSubjects maps legacy subjects to new ones, other subjects are kept.
+func MapSubjects(subjects map[string]string) SubjectRewriter {
+	return func(subject string) string {
+		if mapped, ok := subjects[subject]; ok {
+			return mapped
+		}
+		return subject
+	}
+}
+
+type originalSubjectContextKey struct{}
+
+// OriginalSubjectFromContext returns the subject the request was received on
+// before it was rewritten, empty if it was not.
+func OriginalSubjectFromContext(ctx context.Context) string {
+	subject, _ := ctx.Value(originalSubjectContextKey{}).(string)
+	return subject
+}
+
+// Apply the rewriters in order
+func rewriteSubject(subject string, rewriters []SubjectRewriter) string {
+	for _, rewrite := range rewriters {
+		subject = rewrite(subject)
+	}
+	return subject
+}
+
+// Middleware that rewrites the subject seen by the later middleware and the
+// handler, so that a service can accept multiple subject layouts during
+// migrations. The original subject is kept in the context.
+func SubjectRewriteMiddleware(rewriters ...SubjectRewriter) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			original := req.Subject()
+			subject := rewriteSubject(original, rewriters)
+			if subject == original {
+				return next(req)
+			}
+			ctx := context.WithValue(req.Context(), originalSubjectContextKey{}, original)
+			return next(req.WithSubject(subject).WithContext(ctx))
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func SubjectRewriteMicroMiddleware(rewriters ...SubjectRewriter) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			original := req.Subject
+			subject := rewriteSubject(original, rewriters)
+			if subject == original {
+				return next(req)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), originalSubjectContextKey{}, original))
+			req.Subject = subject
+			return next(req)
+		}
+	}
+}
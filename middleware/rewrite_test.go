@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestSubjectRewriteMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	nm = nm.UseMicro(SubjectRewriteMicroMiddleware(
+		StripSubjectPrefix("prod.", "staging."),
+		MapSubjects(map[string]string{"legacy.orders": "orders.list"}),
+	))
+	handler := func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return natsmicromw.NewMicroReply([]byte(req.Subject + " " + OriginalSubjectFromContext(req.Context()))), nil
+	}
+	for i, subject := range []string{"prod.orders.list", "legacy.orders", "orders.list"} {
+		if err := nm.AddMicroEndpoint(fmt.Sprintf("ep%d", i), handler, micro.WithEndpointSubject(subject)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	ctxHandler := func(req *natsmicromw.Request) error {
+		return req.Respond([]byte(req.Subject() + " " + OriginalSubjectFromContext(req.Context())))
+	}
+	err := nm.UseContext(SubjectRewriteMiddleware(StripSubjectPrefix("prod."))).
+		AddContextEndpoint("ctx", ctxHandler, micro.WithEndpointSubject("prod.ctx.list"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[string]string{
+		"prod.orders.list": "orders.list prod.orders.list",
+		"legacy.orders":    "orders.list legacy.orders",
+		"orders.list":      "orders.list ",
+		"prod.ctx.list":    "ctx.list prod.ctx.list",
+	}
+	for subject, expected := range tests {
+		msg, err := nc.Request(subject, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg.Data) != expected {
+			t.Errorf("subject does not match, expected %q, received %q", expected, string(msg.Data))
+		}
+	}
+}
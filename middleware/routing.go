@@ -0,0 +1,257 @@
+// Example KV routing table middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// Request header with the key the request is routed by
+	HeaderRoutingKey string = "routing-key"
+)
+
+type RoutingOptions struct {
+	// KV is the routing table, mapping routing keys to the instance ID of
+	// their owner
+	KV nats.KeyValue
+	// Service is this instance, whose keys are handled locally
+	Service *natsmicromw.Service
+	// TTL of cached owners, 5 seconds if zero. Keys missing from the
+	// routing table are not cached.
+	TTL time.Duration
+	// Maximum number of cached owners, defaults to 10000
+	MaxCacheEntries int
+	// Key returns the routing key of the request, the `routing-key` header by
+	// default. Requests without one are handled locally.
+	Key func(ctx context.Context, headers micro.Headers, data []byte) string
+	// OnError is called with the errors looking up the routing table or
+	// forwarding requests, e.g. to log them, as clients only receive a
+	// generic 503 error
+	OnError func(key string, err error)
+}
+
+type routingCacheEntry struct {
+	owner   string
+	expires time.Time
+}
+
+// Router looks up and caches the owners of routing keys.
+type Router struct {
+	opts RoutingOptions
+	self string
+
+	mu    sync.Mutex
+	cache map[string]routingCacheEntry
+}
+
+// NewRouter creates a new router with the given options.
+func NewRouter(opts RoutingOptions) *Router {
+	if opts.TTL <= 0 {
+		opts.TTL = 5 * time.Second
+	}
+	if opts.MaxCacheEntries <= 0 {
+		opts.MaxCacheEntries = 10000
+	}
+	if opts.Key == nil {
+		opts.Key = func(ctx context.Context, headers micro.Headers, data []byte) string {
+			return headers.Get(HeaderRoutingKey)
+		}
+	}
+	return &Router{
+		opts:  opts,
+		self:  opts.Service.Info().ID,
+		cache: make(map[string]routingCacheEntry),
+	}
+}
+
+// Owner returns the instance ID owning the key, using the cache when
+// possible. Keys missing from the routing table have no owner.
+func (r *Router) Owner(key string) (string, error) {
+	now := time.Now()
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.owner, nil
+	}
+
+	kve, err := r.opts.KV.Get(key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		// Misses are not cached, as the keys come from the clients
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	owner := string(kve.Value())
+
+	r.mu.Lock()
+	r.evict(now)
+	r.cache[key] = routingCacheEntry{owner, now.Add(r.opts.TTL)}
+	r.mu.Unlock()
+	return owner, nil
+}
+
+// Make room for a new owner, dropping expired owners first and an
+// arbitrary one if none have expired
+func (r *Router) evict(now time.Time) {
+	if len(r.cache) < r.opts.MaxCacheEntries {
+		return
+	}
+	for key, entry := range r.cache {
+		if now.After(entry.expires) {
+			delete(r.cache, key)
+		}
+	}
+	for key := range r.cache {
+		if len(r.cache) < r.opts.MaxCacheEntries {
+			break
+		}
+		delete(r.cache, key)
+	}
+}
+
+// Report an error to the error callback, if any
+func (r *Router) report(key string, err error) {
+	if r.opts.OnError != nil {
+		r.opts.OnError(key, err)
+	}
+}
+
+// Invalidate drops the cached owner of the keys, e.g. when they were moved,
+// or of all keys if none are given.
+func (r *Router) Invalidate(keys ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(keys) == 0 {
+		r.cache = make(map[string]routingCacheEntry)
+		return
+	}
+	for _, key := range keys {
+		delete(r.cache, key)
+	}
+}
+
+// Return the instance subject to forward the request to, or an empty
+// subject if it is handled locally
+func (r *Router) route(ctx context.Context, subject string, headers micro.Headers, data []byte) (string, error) {
+	// Requests already forwarded to this instance are never forwarded again,
+	// even if the routing table changed meanwhile
+	if strings.HasPrefix(subject, natsmicromw.InstanceSubject(r.self, "")) {
+		return "", nil
+	}
+	key := r.opts.Key(ctx, headers, data)
+	if key == "" {
+		return "", nil
+	}
+	owner, err := r.Owner(key)
+	if err != nil {
+		r.report(key, err)
+		return "", &natsmicromw.HandlerError{
+			Description: "routing table unavailable",
+			Code:        natsmicromw.CodeUnavailable,
+		}
+	}
+	if owner == "" || owner == r.self {
+		return "", nil
+	}
+	return natsmicromw.InstanceSubject(owner, subject), nil
+}
+
+// Forward the request to the owner and return its reply, with the error
+// code and description of error replies split from the other headers
+func (r *Router) forward(ctx context.Context, subject string, headers micro.Headers, data []byte) (*nats.Msg, string, string, error) {
+	msg := &nats.Msg{Subject: subject, Header: nats.Header(headers), Data: data}
+	reply, err := natsmicromw.ConnFromContext(ctx).RequestMsg(msg)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+		return nil, "", "", &natsmicromw.HandlerError{Description: "owner timed out", Code: natsmicromw.CodeTimeout}
+	} else if err != nil {
+		r.report(r.opts.Key(ctx, headers, data), err)
+		return nil, "", "", &natsmicromw.HandlerError{Description: "owner unavailable", Code: natsmicromw.CodeUnavailable}
+	}
+
+	code, description := reply.Header.Get(micro.ErrorCodeHeader), reply.Header.Get(micro.ErrorHeader)
+	replyHeaders := nats.Header{}
+	for k, v := range reply.Header {
+		if k != micro.ErrorCodeHeader && k != micro.ErrorHeader {
+			replyHeaders[k] = v
+		}
+	}
+	reply.Header = replyHeaders
+	return reply, code, description, nil
+}
+
+// RoutingMiddleware forwards requests to the service instance owning their
+// routing key, as recorded in a JetStream KV bucket, and relays its reply,
+// so sharded stateful services can be served behind stable subjects. The
+// instances must enable `SetInstanceSubjects`, as requests are forwarded
+// to the instance subject of their owner. Requests whose key is owned by
+// this instance or not in the routing table are handled locally. Forwarded
+// requests are bounded by the deadline of the request, and fail with a 503
+// error if the owner is unavailable. Owners are cached for the `TTL`, keys
+// missing from the routing table are looked up again for every request.
+func RoutingMiddleware(router *Router) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			ctx := req.Context()
+			subject, err := router.route(ctx, req.Subject(), req.Headers(), req.Data())
+			if err != nil {
+				return err
+			} else if subject == "" {
+				return next(req)
+			}
+
+			reply, code, description, err := router.forward(ctx, subject, req.Headers(), req.Data())
+			if err != nil {
+				return err
+			}
+			if code != "" {
+				return req.Error(code, description, reply.Data, micro.WithHeaders(micro.Headers(reply.Header)))
+			}
+			return req.Respond(reply.Data, micro.WithHeaders(micro.Headers(reply.Header)))
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func RoutingMicroMiddleware(router *Router) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			data, err := req.ReadData()
+			if err != nil {
+				return nil, err
+			}
+			ctx := req.Context()
+			subject, err := router.route(ctx, req.Subject, req.Headers, data)
+			if err != nil {
+				return nil, err
+			} else if subject == "" {
+				return next(req)
+			}
+
+			reply, code, description, err := router.forward(ctx, subject, req.Headers, data)
+			if err != nil {
+				return nil, err
+			}
+			if code != "" {
+				return natsmicromw.NewMicroError(code, description, reply.Data, micro.Headers(reply.Header)), nil
+			}
+			return &natsmicromw.MicroReply{Headers: micro.Headers(reply.Header), Data: reply.Data}, nil
+		}
+	}
+}
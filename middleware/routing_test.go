@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestRoutingMicroMiddleware(t *testing.T) {
+	opts := &server.Options{Host: "localhost", Port: server.RANDOM_PORT, NoSigs: true, JetStream: true, StoreDir: t.TempDir()}
+	s, err := runServer(opts)
+	if err != nil {
+		t.Fatalf("Could not start NATS server: %v", err)
+	}
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "routes"})
+	if err != nil {
+		t.Fatalf("Could not create bucket: %v", err)
+	}
+
+	// Two instances of the same service, replying with their ID
+	var ids []string
+	var routers []*Router
+	for i := 0; i < 2; i++ {
+		nm, err := natsmicromw.AddMicroService(nc, micro.Config{Name: "TestService", Version: "1.0.0"})
+		if err != nil {
+			t.Fatalf("Could not create micro service: %v", err)
+		}
+		defer nm.Stop()
+		nm.SetInstanceSubjects(true)
+
+		id := nm.Info().ID
+		ids = append(ids, id)
+		router := NewRouter(RoutingOptions{KV: kv, Service: nm, MaxCacheEntries: 2})
+		routers = append(routers, router)
+		err = nm.UseMicro(RoutingMicroMiddleware(router)).AddMicroEndpoint("owner", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if string(req.Data) == "fail" {
+				return nil, natsmicromw.Errorf(natsmicromw.CodeConflict, "failed on %s", id)
+			}
+			return natsmicromw.NewMicroReply([]byte(id)), nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := kv.PutString("acme", ids[1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := func(key, data string) *nats.Msg {
+		msg := nats.NewMsg("owner")
+		msg.Header.Set(HeaderRoutingKey, key)
+		msg.Data = []byte(data)
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return reply
+	}
+
+	t.Run("routed", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			if reply := request("acme", ""); string(reply.Data) != ids[1] {
+				t.Fatalf("expected the owner to handle the request, received %q", reply.Data)
+			}
+		}
+	})
+
+	t.Run("unowned", func(t *testing.T) {
+		reply := request("other", "")
+		if string(reply.Data) != ids[0] && string(reply.Data) != ids[1] {
+			t.Errorf("unexpected reply %q", reply.Data)
+		}
+	})
+
+	t.Run("error relayed", func(t *testing.T) {
+		reply := request("acme", "fail")
+		handlerErr := natsmicromw.HandlerErrorFromMsg(reply)
+		if handlerErr == nil || handlerErr.Code != natsmicromw.CodeConflict || handlerErr.Description != "failed on "+ids[1] {
+			t.Errorf("expected the error of the owner, received %v", handlerErr)
+		}
+	})
+
+	t.Run("owner unavailable", func(t *testing.T) {
+		if _, err := kv.PutString("gone", "unknown"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		reply := request("gone", "")
+		if handlerErr := natsmicromw.HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != natsmicromw.CodeUnavailable {
+			t.Errorf("expected a 503 error, received %v", handlerErr)
+		}
+	})
+	t.Run("misses not cached", func(t *testing.T) {
+		if owner, err := routers[0].Owner("late"); err != nil || owner != "" {
+			t.Fatalf("expected no owner, received %q, %v", owner, err)
+		}
+		if _, err := kv.PutString("late", ids[1]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if owner, err := routers[0].Owner("late"); err != nil || owner != ids[1] {
+			t.Errorf("expected owner %s, received %q, %v", ids[1], owner, err)
+		}
+	})
+
+	t.Run("cache bounded", func(t *testing.T) {
+		for _, key := range []string{"acme", "gone", "late"} {
+			if _, err := routers[0].Owner(key); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if n := len(routers[0].cache); n > 2 {
+			t.Errorf("expected at most 2 cached owners, found %d", n)
+		}
+	})
+
+	t.Run("table unavailable", func(t *testing.T) {
+		var reported error
+		router := NewRouter(RoutingOptions{
+			KV:      failingKV{kv},
+			Service: routers[0].opts.Service,
+			OnError: func(key string, err error) { reported = err },
+		})
+		headers := micro.Headers{HeaderRoutingKey: {"acme"}}
+		_, err := router.route(context.Background(), "owner", headers, nil)
+		var handlerErr *natsmicromw.HandlerError
+		if !errors.As(err, &handlerErr) || handlerErr.Code != natsmicromw.CodeUnavailable || handlerErr.Description != "routing table unavailable" {
+			t.Errorf("expected a generic 503 error, received %v", err)
+		}
+		if !errors.Is(reported, errKVDown) {
+			t.Errorf("expected the KV error to be reported, received %v", reported)
+		}
+	})
+}
+
+var errKVDown = errors.New("kv down")
+
+// Routing table whose lookups fail
+type failingKV struct {
+	nats.KeyValue
+}
+
+func (failingKV) Get(key string) (nats.KeyValueEntry, error) {
+	return nil, errKVDown
+}
@@ -0,0 +1,296 @@
+// Example secrets providers for natsmicromw middleware configuration
+
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrSecretNotFound = errors.New("secret not found")
+	ErrNoVaultReader  = errors.New("vault secrets without a read function")
+)
+
+// SecretsProvider fetches named secrets (keys, client secrets etc.) at
+// runtime, so that middleware can be re-keyed without a restart.
+type SecretsProvider interface {
+	GetSecret(ctx context.Context, name string) ([]byte, error)
+}
+
+// SecretsProviderFunc is an adapter to use ordinary functions as providers.
+type SecretsProviderFunc func(ctx context.Context, name string) ([]byte, error)
+
+func (f SecretsProviderFunc) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	return f(ctx, name)
+}
+
+// EnvSecrets reads secrets from environment variables. The name is upper
+// cased, with dashes and dots replaced by underscores, e.g. `Prefix` "APP_"
+// and name "signing-key" reads `APP_SIGNING_KEY`.
+type EnvSecrets struct {
+	Prefix string
+}
+
+func (e EnvSecrets) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	key := e.Prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, key)
+	}
+	return []byte(value), nil
+}
+
+// FileSecrets reads secrets from files in a directory, e.g. mounted
+// Kubernetes or Docker secrets. Trailing newlines are trimmed.
+type FileSecrets struct {
+	Dir string
+}
+
+func (f FileSecrets) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(f.Dir, filepath.Base(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	} else if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(data, "\r\n"), nil
+}
+
+// VaultSecrets reads secrets from a HashiCorp Vault KV engine. `Read` is
+// typically `client.Logical().ReadWithContext` wrapped to return the secret
+// data. The name is "<path>#<field>", or just "<path>" to use the field
+// "value". KV v2 responses with nested "data" are unwrapped.
+type VaultSecrets struct {
+	Mount string
+	Read  func(ctx context.Context, path string) (map[string]any, error)
+}
+
+// NewVaultSecrets creates a Vault provider, the read function is required.
+func NewVaultSecrets(mount string, read func(ctx context.Context, path string) (map[string]any, error)) (VaultSecrets, error) {
+	if read == nil {
+		return VaultSecrets{}, ErrNoVaultReader
+	}
+	return VaultSecrets{Mount: mount, Read: read}, nil
+}
+
+func (v VaultSecrets) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	if v.Read == nil {
+		return nil, ErrNoVaultReader
+	}
+	path, field, ok := strings.Cut(name, "#")
+	if !ok {
+		field = "value"
+	}
+	if v.Mount != "" {
+		path = strings.TrimSuffix(v.Mount, "/") + "/" + path
+	}
+
+	data, err := v.Read(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if nested, ok := data["data"].(map[string]any); ok {
+		data = nested
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return []byte(value), nil
+}
+
+// RotatingSecret keeps the latest value of a secret, polling the provider at
+// the given interval. Registered hooks are called whenever the value changes.
+type RotatingSecret struct {
+	provider SecretsProvider
+	name     string
+
+	mu    sync.RWMutex
+	value []byte
+	hooks []func(value []byte)
+	stop  chan struct{}
+	once  sync.Once
+
+	// Serializes the rotations, so hooks see the values in the order they
+	// were fetched, and values fetched before the last rotation are dropped
+	rotating sync.Mutex
+	fetches  atomic.Uint64
+	rotated  uint64
+}
+
+// NewRotatingSecret fetches the initial value of the secret and starts
+// polling for changes. A zero interval disables polling, use `Refresh`
+// to rotate manually.
+func NewRotatingSecret(ctx context.Context, provider SecretsProvider, name string, interval time.Duration) (*RotatingSecret, error) {
+	value, err := provider.GetSecret(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &RotatingSecret{
+		provider: provider,
+		name:     name,
+		value:    value,
+		stop:     make(chan struct{}),
+	}
+	if interval > 0 {
+		go rs.poll(interval)
+	}
+	return rs, nil
+}
+
+func (rs *RotatingSecret) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rs.stop:
+			return
+		case <-ticker.C:
+			// Keep the previous value if the provider is temporarily unavailable
+			_ = rs.Refresh(context.Background())
+		}
+	}
+}
+
+// Get returns the current value of the secret.
+func (rs *RotatingSecret) Get() []byte {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.value
+}
+
+// OnRotate registers a hook that is called with the new value whenever the
+// secret changes. Hooks are called one rotation at a time, in order, and
+// must not call `Refresh`.
+func (rs *RotatingSecret) OnRotate(hook func(value []byte)) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.hooks = append(rs.hooks, hook)
+}
+
+// Refresh fetches the secret and calls the rotation hooks if it changed.
+func (rs *RotatingSecret) Refresh(ctx context.Context) error {
+	fetch := rs.fetches.Add(1)
+	value, err := rs.provider.GetSecret(ctx, rs.name)
+	if err != nil {
+		return err
+	}
+
+	rs.rotating.Lock()
+	defer rs.rotating.Unlock()
+	// A concurrent refresh fetched a newer value meanwhile
+	if fetch < rs.rotated {
+		return nil
+	}
+	rs.rotated = fetch
+
+	rs.mu.Lock()
+	if bytes.Equal(value, rs.value) {
+		rs.mu.Unlock()
+		return nil
+	}
+	rs.value = value
+	hooks := append([]func([]byte){}, rs.hooks...)
+	rs.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(value)
+	}
+	return nil
+}
+
+// Stop polling for changes.
+func (rs *RotatingSecret) Stop() {
+	rs.once.Do(func() { close(rs.stop) })
+}
+
+// JSON value of a rotating secret, parsed once per rotation. Invalid new
+// values are ignored and the previous value is kept.
+type secretJSON[T any] struct {
+	mu    sync.RWMutex
+	value T
+}
+
+func newSecretJSON[T any](rs *RotatingSecret) (*secretJSON[T], error) {
+	sj := &secretJSON[T]{}
+	if err := json.Unmarshal(rs.Get(), &sj.value); err != nil {
+		return nil, err
+	}
+	rs.OnRotate(func(data []byte) {
+		var value T
+		if err := json.Unmarshal(data, &value); err != nil {
+			return
+		}
+		sj.mu.Lock()
+		sj.value = value
+		sj.mu.Unlock()
+	})
+	return sj, nil
+}
+
+func (sj *secretJSON[T]) get() T {
+	sj.mu.RLock()
+	defer sj.mu.RUnlock()
+	return sj.value
+}
+
+// SecretAPIKeys is an `APIKeyStore` backed by a secret containing a JSON
+// object of keys and their metadata, e.g.
+//
+//	{"k-123": {"id": "orders-client", "owner": "acme", "tier": "gold"}}
+//
+// Keys are added and revoked by rotating the secret.
+type SecretAPIKeys struct {
+	keys *secretJSON[StaticAPIKeys]
+}
+
+// NewSecretAPIKeys parses the current value of the secret and follows its
+// rotations.
+func NewSecretAPIKeys(rs *RotatingSecret) (*SecretAPIKeys, error) {
+	keys, err := newSecretJSON[StaticAPIKeys](rs)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretAPIKeys{keys: keys}, nil
+}
+
+func (s *SecretAPIKeys) Lookup(ctx context.Context, key string) (*APIKey, error) {
+	return s.keys.get().Lookup(ctx, key)
+}
+
+// SecretIdentityKeys are the accepted identity assertion keys, read from a
+// secret containing a JSON object of key IDs and public keys, e.g.
+//
+//	{"2024-06": "UD...", "2024-07": "UC..."}
+//
+// Set it as `IdentityOptions.KeySecret` to rotate the keys without a
+// restart.
+type SecretIdentityKeys struct {
+	keys *secretJSON[map[string]string]
+}
+
+// NewSecretIdentityKeys parses the current value of the secret and follows
+// its rotations.
+func NewSecretIdentityKeys(rs *RotatingSecret) (*SecretIdentityKeys, error) {
+	keys, err := newSecretJSON[map[string]string](rs)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretIdentityKeys{keys: keys}, nil
+}
+
+// Keys returns the current keys by key ID.
+func (s *SecretIdentityKeys) Keys() map[string]string {
+	return s.keys.get()
+}
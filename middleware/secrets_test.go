@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nkeys"
+)
+
+func TestRotatingSecret(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "signing-key")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rs, err := NewRotatingSecret(context.Background(), FileSecrets{Dir: dir}, "signing-key", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rs.Stop()
+
+	if string(rs.Get()) != "first" {
+		t.Errorf("secret does not match, expected %s, received %s", "first", rs.Get())
+	}
+
+	var rotated []string
+	rs.OnRotate(func(value []byte) {
+		rotated = append(rotated, string(value))
+	})
+
+	// Unchanged value does not trigger the hooks
+	if err := rs.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("second\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := rs.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(rs.Get()) != "second" {
+		t.Errorf("secret does not match, expected %s, received %s", "second", rs.Get())
+	}
+	if len(rotated) != 1 || rotated[0] != "second" {
+		t.Errorf("unexpected rotations: %v", rotated)
+	}
+}
+
+func TestRotatingSecretOrder(t *testing.T) {
+	// The first refresh is slower than the second one, so it fetches an
+	// older value that arrives last
+	var calls atomic.Int32
+	release := make(chan struct{})
+	provider := SecretsProviderFunc(func(ctx context.Context, name string) ([]byte, error) {
+		switch calls.Add(1) {
+		case 1:
+			return []byte("first"), nil
+		case 2:
+			<-release
+			return []byte("second"), nil
+		}
+		return []byte("third"), nil
+	})
+	rs, err := NewRotatingSecret(context.Background(), provider, "signing-key", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rs.Stop()
+
+	var rotated []string
+	rs.OnRotate(func(value []byte) {
+		rotated = append(rotated, string(value))
+	})
+
+	slow := make(chan error)
+	go func() { slow <- rs.Refresh(context.Background()) }()
+	for i := 0; calls.Load() != 2; i++ {
+		if i > 100 {
+			t.Fatalf("refresh did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := rs.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The older value is dropped
+	if string(rs.Get()) != "third" {
+		t.Errorf("secret does not match, expected %s, received %s", "third", rs.Get())
+	}
+	if len(rotated) != 1 || rotated[0] != "third" {
+		t.Errorf("unexpected rotations: %v", rotated)
+	}
+}
+
+func TestSecretsProviders(t *testing.T) {
+	t.Setenv("APP_SIGNING_KEY", "env-secret")
+	value, err := EnvSecrets{Prefix: "APP_"}.GetSecret(context.Background(), "signing-key")
+	if err != nil || string(value) != "env-secret" {
+		t.Errorf("unexpected env secret: %s, %v", value, err)
+	}
+
+	if _, err := (FileSecrets{Dir: t.TempDir()}).GetSecret(context.Background(), "missing"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("expected ErrSecretNotFound, got %v", err)
+	}
+
+	vault := VaultSecrets{
+		Mount: "secret/data",
+		Read: func(ctx context.Context, path string) (map[string]any, error) {
+			if path != "secret/data/orders" {
+				t.Errorf("unexpected vault path: %s", path)
+			}
+			return map[string]any{"data": map[string]any{"client_secret": "vault-secret"}}, nil
+		},
+	}
+	value, err = vault.GetSecret(context.Background(), "orders#client_secret")
+	if err != nil || string(value) != "vault-secret" {
+		t.Errorf("unexpected vault secret: %s, %v", value, err)
+	}
+
+	if _, err := NewVaultSecrets("secret/data", nil); !errors.Is(err, ErrNoVaultReader) {
+		t.Errorf("expected ErrNoVaultReader, got %v", err)
+	}
+	if _, err := (VaultSecrets{}).GetSecret(context.Background(), "orders"); !errors.Is(err, ErrNoVaultReader) {
+		t.Errorf("expected ErrNoVaultReader, got %v", err)
+	}
+}
+
+func TestSecretAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api-keys")
+	if err := os.WriteFile(path, []byte(`{"k-1":{"id":"first","owner":"acme"}}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rs, err := NewRotatingSecret(context.Background(), FileSecrets{Dir: dir}, "api-keys", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rs.Stop()
+
+	store, err := NewSecretAPIKeys(rs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if apiKey, err := store.Lookup(context.Background(), "k-1"); err != nil || apiKey.ID != "first" {
+		t.Errorf("unexpected api key: %v, %v", apiKey, err)
+	}
+
+	// Rotation revokes the old key and adds the new one
+	if err := os.WriteFile(path, []byte(`{"k-2":{"id":"second","owner":"acme"}}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := rs.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Lookup(context.Background(), "k-1"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+	if apiKey, err := store.Lookup(context.Background(), "k-2"); err != nil || apiKey.ID != "second" {
+		t.Errorf("unexpected api key: %v, %v", apiKey, err)
+	}
+
+	// An invalid rotation keeps the previous keys
+	if err := os.WriteFile(path, []byte(`{"k-3":`), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := rs.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Lookup(context.Background(), "k-2"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSecretIdentityKeys(t *testing.T) {
+	first, _ := nkeys.CreateAccount()
+	second, _ := nkeys.CreateAccount()
+	firstPub, _ := first.PublicKey()
+	secondPub, _ := second.PublicKey()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "identity-keys")
+	if err := os.WriteFile(path, []byte(`{"2024":"`+firstPub+`"}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rs, err := NewRotatingSecret(context.Background(), FileSecrets{Dir: dir}, "identity-keys", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rs.Stop()
+
+	keys, err := NewSecretIdentityKeys(rs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts := IdentityOptions{KeySecret: keys}
+	principal := func(keyID string) Principal {
+		return Principal{Subject: "CN=orders", KeyID: keyID, ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	}
+
+	firstValue, _ := SignIdentityAssertion(first, principal("2024"))
+	secondValue, _ := SignIdentityAssertion(second, principal("2025"))
+	if _, err := VerifyIdentityAssertion(firstValue, opts); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := VerifyIdentityAssertion(secondValue, opts); !errors.Is(err, ErrUntrustedIssuer) {
+		t.Errorf("expected ErrUntrustedIssuer, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"2025":"`+secondPub+`"}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := rs.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := VerifyIdentityAssertion(firstValue, opts); !errors.Is(err, ErrUntrustedIssuer) {
+		t.Errorf("expected ErrUntrustedIssuer, got %v", err)
+	}
+	if _, err := VerifyIdentityAssertion(secondValue, opts); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Example server identity headers middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+const (
+	// Reply headers identifying the service instance that handled the request
+	HeaderServiceName     string = "service-name"
+	HeaderServiceVersion  string = "service-version"
+	HeaderServiceInstance string = "service-instance"
+	HeaderServiceHost     string = "service-host"
+	// Reply header with the time spent handling the request, in microseconds
+	HeaderHandlingTime string = "handling-time-us"
+)
+
+type ServerHeadersOptions struct {
+	// Service whose name, version and instance ID are added, none if nil
+	Service *natsmicromw.Service
+	// Add the hostname of the node
+	Hostname bool
+	// Add the time spent in the middleware and handler
+	Duration bool
+}
+
+// Headers that are the same for every reply
+func serverHeaders(opts ServerHeadersOptions) nats.Header {
+	headers := nats.Header{}
+	if opts.Service != nil {
+		info := opts.Service.Info()
+		headers.Set(HeaderServiceName, info.Name)
+		headers.Set(HeaderServiceVersion, info.Version)
+		headers.Set(HeaderServiceInstance, info.ID)
+	}
+	if opts.Hostname {
+		if hostname, err := os.Hostname(); err == nil {
+			headers.Set(HeaderServiceHost, hostname)
+		}
+	}
+	return headers
+}
+
+func handlingTime(start time.Time) string {
+	return strconv.FormatInt(time.Since(start).Microseconds(), 10)
+}
+
+// Add the headers to the error reply of a request whose handler failed
+func setErrorServerHeaders(ctx context.Context, static nats.Header, opts ServerHeadersOptions, start time.Time) {
+	for k, v := range static {
+		natsmicromw.SetErrorMeta(ctx, k, v[0])
+	}
+	if opts.Duration {
+		natsmicromw.SetErrorMeta(ctx, HeaderHandlingTime, handlingTime(start))
+	}
+}
+
+// ServerHeadersMiddleware adds headers identifying the service instance, and
+// optionally the node and the handling time, to the replies sent by the
+// handler, so clients can tell which instance served a request. Error
+// replies to errors returned by the handler get them too, in their headers
+// and metadata, see `natsmicromw.SetErrorMeta`.
+func ServerHeadersMiddleware(opts ServerHeadersOptions) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	static := serverHeaders(opts)
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			start := time.Now()
+			stamp := func(m *nats.Msg) {
+				// Copy the headers passed by the handler before changing them
+				headers := nats.Header{}
+				for k, v := range m.Header {
+					headers[k] = v
+				}
+				for k, v := range static {
+					headers[k] = v
+				}
+				if opts.Duration {
+					headers.Set(HeaderHandlingTime, handlingTime(start))
+				}
+				m.Header = headers
+			}
+			err := next(req.WithRespondOpts(micro.RespondOpt(stamp)))
+			if err != nil {
+				setErrorServerHeaders(req.Context(), static, opts, start)
+			}
+			return err
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func ServerHeadersMicroMiddleware(opts ServerHeadersOptions) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	static := serverHeaders(opts)
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			start := time.Now()
+			reply, err := next(req)
+			if err != nil {
+				setErrorServerHeaders(req.Context(), static, opts, start)
+				return reply, err
+			} else if reply == nil {
+				return reply, err
+			}
+			for k, v := range static {
+				reply.HeaderSet(k, v[0])
+			}
+			if opts.Duration {
+				reply.HeaderSet(HeaderHandlingTime, handlingTime(start))
+			}
+			return reply, nil
+		}
+	}
+}
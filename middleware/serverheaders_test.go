@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestServerHeadersMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	opts := ServerHeadersOptions{Service: nm, Hostname: true, Duration: true}
+	nm = nm.UseContext(ServerHeadersMiddleware(opts)).UseMicro(ServerHeadersMicroMiddleware(opts))
+
+	if err := nm.AddMicroEndpoint("micro", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddContextEndpoint("context", func(req *natsmicromw.Request) error {
+		return req.Respond(req.Data(), micro.WithHeaders(micro.Headers{"custom": []string{"value"}}))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddMicroEndpoint("micro-error", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return nil, natsmicromw.Errorf(natsmicromw.CodeConflict, "conflict")
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddContextEndpoint("context-error", func(req *natsmicromw.Request) error {
+		return errors.New("failed")
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info := nm.Info()
+	hostname, _ := os.Hostname()
+	for _, subject := range []string{"micro", "context", "micro-error", "context-error"} {
+		t.Run(subject, func(t *testing.T) {
+			reply, err := nc.Request(subject, []byte("data"), time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := map[string]string{
+				HeaderServiceName:     info.Name,
+				HeaderServiceVersion:  info.Version,
+				HeaderServiceInstance: info.ID,
+				HeaderServiceHost:     hostname,
+			}
+			for k, v := range expected {
+				if reply.Header.Get(k) != v {
+					t.Errorf("expected header %s to be %q, received %q", k, v, reply.Header.Get(k))
+				}
+			}
+			if _, err := strconv.ParseInt(reply.Header.Get(HeaderHandlingTime), 10, 64); err != nil {
+				t.Errorf("invalid handling time: %v", err)
+			}
+			if failed := natsmicromw.HandlerErrorFromMsg(reply) != nil; failed != strings.HasSuffix(subject, "-error") {
+				t.Errorf("unexpected error reply: %v", failed)
+			}
+			if subject == "context" && reply.Header.Get("custom") != "value" {
+				t.Errorf("headers of the handler lost: %v", reply.Header)
+			}
+		})
+	}
+}
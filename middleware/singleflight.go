@@ -0,0 +1,63 @@
+//go:build !natsmicromw_nosingleflight
+
+// Example singleflight middleware for natsmicromw
+
+package middleware
+
+import (
+	"github.com/Karimerto/natsmicromw"
+
+	// For collapsing identical concurrent requests
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// Requests carrying this header always execute the handler
+	HeaderSingleflightBypass string = "singleflight-bypass"
+)
+
+// Requests of different callers are never merged, so the key is the same as
+// the one of the reply cache
+func singleflightKey(req *natsmicromw.MicroRequest) (string, error) {
+	data, err := req.ReadData()
+	if err != nil {
+		return "", err
+	}
+	return CacheKey(req.Context(), req.Subject, req.Headers, data), nil
+}
+
+// SingleflightMicroMiddleware executes the handler only once for identical
+// concurrent requests (same subject, payload and caller, see `CacheKey`)
+// and shares the reply.
+// This is meant for read-only endpoints, since the other callers never reach
+// the handler.
+func SingleflightMicroMiddleware() func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	// The group must outlive a single request, so it is created here
+	var group singleflight.Group
+
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if req.HeaderGet(HeaderSingleflightBypass) != "" {
+				return next(req)
+			}
+
+			key, err := singleflightKey(req)
+			if err != nil {
+				return nil, err
+			}
+			res, err, _ := group.Do(key, func() (interface{}, error) {
+				return next(req)
+			})
+			if err != nil {
+				return nil, err
+			}
+			reply, ok := res.(*natsmicromw.MicroReply)
+			if !ok || reply == nil {
+				return nil, nil
+			}
+
+			// Every caller gets its own copy, since later middleware may modify it
+			return reply.Clone(), nil
+		}
+	}
+}
@@ -0,0 +1,73 @@
+//go:build !natsmicromw_nosingleflight
+
+package middleware
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestSingleflightMicroMiddleware(t *testing.T) {
+	var calls atomic.Int32
+	handler := SingleflightMicroMiddleware()(func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		calls.Add(1)
+		// Long enough for the concurrent requests to arrive
+		time.Sleep(50 * time.Millisecond)
+		return natsmicromw.NewMicroReply([]byte(req.HeaderGet(HeaderAPIKey))), nil
+	})
+
+	request := func(keys ...string) []string {
+		replies := make([]string, len(keys))
+		var wg sync.WaitGroup
+		for i, key := range keys {
+			wg.Add(1)
+			go func(i int, key string) {
+				defer wg.Done()
+				req := &natsmicromw.MicroRequest{
+					Subject: "me",
+					Headers: micro.Headers{HeaderAPIKey: []string{key}},
+				}
+				reply, err := handler(req.WithContext(context.Background()))
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				replies[i] = string(reply.Data)
+			}(i, key)
+		}
+		wg.Wait()
+		return replies
+	}
+
+	t.Run("same caller", func(t *testing.T) {
+		calls.Store(0)
+		for _, reply := range request("alice", "alice", "alice") {
+			if reply != "alice" {
+				t.Errorf("unexpected reply %q", reply)
+			}
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("expected one handler call, got %d", n)
+		}
+	})
+
+	t.Run("different callers", func(t *testing.T) {
+		calls.Store(0)
+		keys := []string{"alice", "bob"}
+		for i, reply := range request(keys...) {
+			if reply != keys[i] {
+				t.Errorf("expected the reply of %s, received %q", keys[i], reply)
+			}
+		}
+		if n := calls.Load(); n != 2 {
+			t.Errorf("expected a handler call per caller, got %d", n)
+		}
+	})
+}
@@ -0,0 +1,299 @@
+// Example SLO tracking middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// SLOTarget defines the objectives of an endpoint. Zero values disable the
+// corresponding objective.
+type SLOTarget struct {
+	// Fraction of requests that must succeed, e.g. 0.999
+	Availability float64
+	// Fraction of requests that must complete within `Latency`, e.g. 0.99
+	LatencyTarget float64
+	Latency       time.Duration
+}
+
+type SLOKind string
+
+const (
+	SLOAvailability SLOKind = "availability"
+	SLOLatency      SLOKind = "latency"
+)
+
+// SLOAlert is passed to the alert callback when an error budget burns too fast.
+type SLOAlert struct {
+	Subject       string
+	Kind          SLOKind
+	ShortBurnRate float64
+	LongBurnRate  float64
+}
+
+// SLOStatus is the current state of an endpoint.
+type SLOStatus struct {
+	Requests            int64   `json:"requests"`
+	Availability        float64 `json:"availability"`
+	AvailabilityBurn    float64 `json:"availability_burn_rate"`
+	LatencyCompliance   float64 `json:"latency_compliance"`
+	LatencyBurn         float64 `json:"latency_burn_rate"`
+	AvailabilityBurning bool    `json:"availability_burning,omitempty"`
+	LatencyBurning      bool    `json:"latency_burning,omitempty"`
+}
+
+type SLOOptions struct {
+	Default SLOTarget
+	// Targets by endpoint subject, as registered, e.g. "orders.*"
+	Endpoints map[string]SLOTarget
+	// Maximum number of tracked endpoints, defaults to 1000. Requests of
+	// further endpoints are tracked together as "other".
+	MaxEndpoints int
+	// Burn rates are computed over a short and a long window, an alert
+	// fires when both exceed the threshold. Defaults to 5 minutes, 1 hour
+	// and 14.4, i.e. 2% of a 30 day budget consumed in an hour.
+	ShortWindow   time.Duration
+	LongWindow    time.Duration
+	BurnThreshold float64
+	// OnBurn is called once when an endpoint starts burning its budget
+	OnBurn func(alert SLOAlert)
+}
+
+type sloBucket struct {
+	total, errors, slow int64
+}
+
+type sloEndpoint struct {
+	target  SLOTarget
+	buckets map[int64]*sloBucket
+	burning map[SLOKind]bool
+}
+
+// SLOTracker records request outcomes per endpoint subject and computes
+// rolling error budget burn rates.
+type SLOTracker struct {
+	opts       SLOOptions
+	bucketSize time.Duration
+	guard      *CardinalityGuard
+
+	mu        sync.Mutex
+	endpoints map[string]*sloEndpoint
+}
+
+// NewSLOTracker creates a new tracker, use it with `SLOMiddleware` or
+// `SLOMicroMiddleware`. The tracker also implements `prometheus.Collector`,
+// unless built with the `natsmicromw_noprometheus` tag.
+func NewSLOTracker(opts SLOOptions) *SLOTracker {
+	if opts.ShortWindow <= 0 {
+		opts.ShortWindow = 5 * time.Minute
+	}
+	if opts.LongWindow <= 0 {
+		opts.LongWindow = time.Hour
+	}
+	if opts.BurnThreshold <= 0 {
+		opts.BurnThreshold = 14.4
+	}
+	if opts.MaxEndpoints <= 0 {
+		opts.MaxEndpoints = 1000
+	}
+
+	bucketSize := opts.ShortWindow / 5
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+
+	return &SLOTracker{
+		opts:       opts,
+		bucketSize: bucketSize,
+		guard:      NewCardinalityGuard(opts.MaxEndpoints),
+		endpoints:  make(map[string]*sloEndpoint),
+	}
+}
+
+// Target of the endpoint
+func (t *SLOTracker) target(subject string) SLOTarget {
+	if target, ok := t.opts.Endpoints[subject]; ok {
+		return target
+	}
+	return t.opts.Default
+}
+
+// Get or create the endpoint state, must be called with the lock held
+func (t *SLOTracker) endpoint(subject string) *sloEndpoint {
+	ep, ok := t.endpoints[subject]
+	if !ok {
+		ep = &sloEndpoint{
+			target:  t.target(subject),
+			buckets: make(map[int64]*sloBucket),
+			burning: make(map[SLOKind]bool),
+		}
+		t.endpoints[subject] = ep
+	}
+	return ep
+}
+
+// Sum the buckets within the window, must be called with the lock held
+func (t *SLOTracker) sum(ep *sloEndpoint, now int64, window time.Duration) sloBucket {
+	first := now - int64(window/t.bucketSize) + 1
+	var total sloBucket
+	for idx, b := range ep.buckets {
+		if idx >= first {
+			total.total += b.total
+			total.errors += b.errors
+			total.slow += b.slow
+		}
+	}
+	return total
+}
+
+func burnRate(bad, total int64, target float64) float64 {
+	if total == 0 || target <= 0 || target >= 1 {
+		return 0
+	}
+	return float64(bad) / float64(total) / (1 - target)
+}
+
+// Record the outcome of a request to the endpoint with the subject, as
+// registered. Endpoints beyond `MaxEndpoints` are recorded as "other".
+func (t *SLOTracker) Record(subject string, duration time.Duration, failed bool) {
+	now := time.Now().UnixNano() / int64(t.bucketSize)
+	subject = t.guard.Label(subject)
+
+	t.mu.Lock()
+	ep := t.endpoint(subject)
+
+	// Drop buckets that left the long window
+	first := now - int64(t.opts.LongWindow/t.bucketSize) + 1
+	for idx := range ep.buckets {
+		if idx < first {
+			delete(ep.buckets, idx)
+		}
+	}
+
+	b, ok := ep.buckets[now]
+	if !ok {
+		b = &sloBucket{}
+		ep.buckets[now] = b
+	}
+	b.total++
+	if failed {
+		b.errors++
+	}
+	if ep.target.Latency > 0 && duration > ep.target.Latency {
+		b.slow++
+	}
+
+	short := t.sum(ep, now, t.opts.ShortWindow)
+	long := t.sum(ep, now, t.opts.LongWindow)
+
+	var alerts []SLOAlert
+	check := func(kind SLOKind, shortBad, longBad int64, target float64) {
+		shortBurn := burnRate(shortBad, short.total, target)
+		longBurn := burnRate(longBad, long.total, target)
+		burning := shortBurn > t.opts.BurnThreshold && longBurn > t.opts.BurnThreshold
+		if burning && !ep.burning[kind] {
+			alerts = append(alerts, SLOAlert{subject, kind, shortBurn, longBurn})
+		}
+		ep.burning[kind] = burning
+	}
+	check(SLOAvailability, short.errors, long.errors, ep.target.Availability)
+	check(SLOLatency, short.slow, long.slow, ep.target.LatencyTarget)
+	t.mu.Unlock()
+
+	if t.opts.OnBurn != nil {
+		for _, alert := range alerts {
+			t.opts.OnBurn(alert)
+		}
+	}
+}
+
+// Status returns the state of an endpoint over the long window.
+func (t *SLOTracker) Status(subject string) SLOStatus {
+	now := time.Now().UnixNano() / int64(t.bucketSize)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ep, ok := t.endpoints[subject]
+	if !ok {
+		// Endpoints without requests are not tracked
+		ep = &sloEndpoint{target: t.target(subject)}
+	}
+	long := t.sum(ep, now, t.opts.LongWindow)
+
+	status := SLOStatus{
+		Requests:            long.total,
+		Availability:        1,
+		LatencyCompliance:   1,
+		AvailabilityBurn:    burnRate(long.errors, long.total, ep.target.Availability),
+		LatencyBurn:         burnRate(long.slow, long.total, ep.target.LatencyTarget),
+		AvailabilityBurning: ep.burning[SLOAvailability],
+		LatencyBurning:      ep.burning[SLOLatency],
+	}
+	if long.total > 0 {
+		status.Availability = 1 - float64(long.errors)/float64(long.total)
+		status.LatencyCompliance = 1 - float64(long.slow)/float64(long.total)
+	}
+	return status
+}
+
+// StatsHandler can be used as `micro.Config.StatsHandler` to include the
+// SLO status in the endpoint stats, looked up by the endpoint subject.
+func (t *SLOTracker) StatsHandler(e *micro.Endpoint) any {
+	return t.Status(e.Subject)
+}
+
+// Only server errors consume the availability budget
+func isServerError(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	code, convErr := strconv.Atoi(natsmicromw.ContextErrorCode(ctx, err))
+	return convErr != nil || code >= 500
+}
+
+// Track requests by the registered endpoint subject, matching the stats of
+// the endpoint, or by the subject of the request without one
+func sloSubject(ctx context.Context, subject string) string {
+	if endpoint := natsmicromw.EndpointSubjectFromContext(ctx); endpoint != "" {
+		return endpoint
+	}
+	return subject
+}
+
+// Middleware that records each request in the SLO tracker, by the subject
+// the endpoint was registered on
+func SLOMiddleware(t *SLOTracker) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			start := time.Now()
+			err := next(req)
+			t.Record(sloSubject(req.Context(), req.Subject()), time.Since(start), isServerError(req.Context(), replyError(req.Context(), nil, err)))
+			return err
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func SLOMicroMiddleware(t *SLOTracker) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			start := time.Now()
+			reply, err := next(req)
+			t.Record(sloSubject(req.Context(), req.Subject), time.Since(start), isServerError(req.Context(), replyError(req.Context(), reply, err)))
+			return reply, err
+		}
+	}
+}
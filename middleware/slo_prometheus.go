@@ -0,0 +1,38 @@
+//go:build !natsmicromw_noprometheus
+
+// Prometheus collector of the SLO tracker
+
+package middleware
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var sloBurnRate = prometheus.NewDesc(
+	"nats_slo_burn_rate",
+	"Error budget burn rate of NATS endpoints.",
+	[]string{"subject", "slo", "window"}, nil)
+
+// Describe implements `prometheus.Collector`.
+func (t *SLOTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sloBurnRate
+}
+
+// Collect implements `prometheus.Collector`.
+func (t *SLOTracker) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now().UnixNano() / int64(t.bucketSize)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for subject, ep := range t.endpoints {
+		for _, window := range []time.Duration{t.opts.ShortWindow, t.opts.LongWindow} {
+			sum := t.sum(ep, now, window)
+			ch <- prometheus.MustNewConstMetric(sloBurnRate, prometheus.GaugeValue,
+				burnRate(sum.errors, sum.total, ep.target.Availability), subject, string(SLOAvailability), window.String())
+			ch <- prometheus.MustNewConstMetric(sloBurnRate, prometheus.GaugeValue,
+				burnRate(sum.slow, sum.total, ep.target.LatencyTarget), subject, string(SLOLatency), window.String())
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestSLOTracker(t *testing.T) {
+	var alerts []SLOAlert
+	tracker := NewSLOTracker(SLOOptions{
+		Default: SLOTarget{Availability: 0.99, LatencyTarget: 0.9, Latency: 100 * time.Millisecond},
+		OnBurn: func(alert SLOAlert) {
+			alerts = append(alerts, alert)
+		},
+	})
+
+	for i := 0; i < 100; i++ {
+		tracker.Record("orders", time.Millisecond, false)
+	}
+	status := tracker.Status("orders")
+	if status.Requests != 100 || status.Availability != 1 || status.AvailabilityBurn != 0 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	// 20% errors burn a 1% budget 20 times faster than allowed
+	for i := 0; i < 25; i++ {
+		tracker.Record("orders", time.Millisecond, true)
+	}
+	status = tracker.Status("orders")
+	if !status.AvailabilityBurning || status.LatencyBurning {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if len(alerts) != 1 || alerts[0].Kind != SLOAvailability || alerts[0].Subject != "orders" {
+		t.Errorf("unexpected alerts: %+v", alerts)
+	}
+
+	// Other endpoints are tracked separately, looking them up does not
+	// track them
+	if status := tracker.Status("billing"); status.Requests != 0 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if n := len(tracker.endpoints); n != 1 {
+		t.Errorf("expected 1 tracked endpoint, found %d", n)
+	}
+}
+
+func TestSLOTrackerMaxEndpoints(t *testing.T) {
+	tracker := NewSLOTracker(SLOOptions{MaxEndpoints: 2})
+	for _, subject := range []string{"a", "b", "c", "d"} {
+		tracker.Record(subject, time.Millisecond, false)
+	}
+	if n := len(tracker.endpoints); n != 3 {
+		t.Errorf("expected 2 endpoints and other, found %d", n)
+	}
+	if status := tracker.Status(MetricsOtherSubject); status.Requests != 2 {
+		t.Errorf("expected 2 requests of other endpoints, received %+v", status)
+	}
+}
+
+func TestSLOEndpointSubject(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	tracker := NewSLOTracker(SLOOptions{})
+	err := nm.UseMicro(SLOMicroMiddleware(tracker)).AddMicroEndpoint("orders", microEcho, micro.WithEndpointSubject("orders.*"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, subject := range []string{"orders.1", "orders.2"} {
+		if _, err := nc.Request(subject, nil, time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Requests are tracked by the endpoint, as in the endpoint stats
+	status, ok := tracker.StatsHandler(&micro.Endpoint{EndpointConfig: micro.EndpointConfig{Subject: "orders.*"}}).(SLOStatus)
+	if !ok || status.Requests != 2 {
+		t.Errorf("expected 2 requests of the endpoint, received %+v", status)
+	}
+}
+
+func TestSLOErrorReply(t *testing.T) {
+	tracker := NewSLOTracker(SLOOptions{
+		Default: SLOTarget{Availability: 0.99, LatencyTarget: 0.9, Latency: 100 * time.Millisecond},
+	})
+	handler := SLOMicroMiddleware(tracker)(func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		if string(req.Data) == "fail" {
+			return natsmicromw.NewMicroError("503", "unavailable", nil, nil), nil
+		}
+		return natsmicromw.NewMicroError("404", "not found", nil, nil), nil
+	})
+	for _, data := range []string{"fail", "missing"} {
+		req := (&natsmicromw.MicroRequest{Subject: "orders", Data: []byte(data)}).WithContext(context.Background())
+		if _, err := handler(req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// Only the server error consumes the budget
+	if status := tracker.Status("orders"); status.Requests != 2 || status.Availability != 0.5 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestSLOHandlerTimeout(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	tracker := NewSLOTracker(SLOOptions{})
+	release := make(chan struct{})
+	nm = nm.UseMicro(SLOMicroMiddleware(tracker)).WithHandlerTimeout(20 * time.Millisecond)
+	err := nm.AddMicroEndpoint("slow", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		<-release
+		return natsmicromw.NewMicroReply([]byte("late")), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg, err := nc.Request("slow", nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code := msg.Header.Get(micro.ErrorCodeHeader); code != natsmicromw.CodeTimeout {
+		t.Errorf("expected %s, received %s", natsmicromw.CodeTimeout, code)
+	}
+	close(release)
+
+	// The late successful reply is recorded as the 504 sent instead
+	for i := 0; i < 10; i++ {
+		if status := tracker.Status("slow"); status.Requests == 1 {
+			if status.Availability != 0 {
+				t.Errorf("expected the timeout to be a failure, received %+v", status)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected the request to be recorded")
+}
@@ -0,0 +1,138 @@
+// Example middleware state persistence for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+)
+
+// StateSnapshotter is implemented by middleware state that can be persisted
+// across restarts. In this package only `MemoryQuotaStore` implements it.
+type StateSnapshotter interface {
+	// SnapshotState returns the encoded state
+	SnapshotState() ([]byte, error)
+	// RestoreState replaces the state with a snapshot
+	RestoreState(data []byte) error
+}
+
+type StatePersistenceOptions struct {
+	// KV stores the snapshots
+	KV nats.KeyValue
+	// Key of the snapshots in the bucket, e.g. "quota.<service name>"
+	Key string
+	// Instance is a stable name of the instance, e.g. its pod name, which
+	// keeps the snapshots of the instances apart under "<Key>.<Instance>",
+	// so that each restores its own. Defaults to the host name.
+	Instance string
+	// Interval between snapshots, 30 seconds if zero
+	Interval time.Duration
+	// OnError is called with the errors of periodic snapshots, e.g. to log
+	// them. Failed snapshots are retried at the next interval.
+	OnError func(err error)
+}
+
+// StatePersister saves snapshots of middleware state to a KV bucket and
+// restores them.
+type StatePersister struct {
+	opts  StatePersistenceOptions
+	state StateSnapshotter
+	key   string
+}
+
+// NewStatePersister creates a new persister for the state with the given
+// options.
+func NewStatePersister(state StateSnapshotter, opts StatePersistenceOptions) *StatePersister {
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	if opts.Instance == "" {
+		opts.Instance, _ = os.Hostname()
+	}
+	key := opts.Key
+	if opts.Instance != "" {
+		key += "." + stateKeyToken(opts.Instance)
+	}
+	return &StatePersister{opts: opts, state: state, key: key}
+}
+
+// Replace the characters not allowed in KV keys
+func stateKeyToken(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '=':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Key returns the key of the snapshots of this instance.
+func (p *StatePersister) Key() string {
+	return p.key
+}
+
+// Save stores a snapshot of the state.
+func (p *StatePersister) Save() error {
+	data, err := p.state.SnapshotState()
+	if err != nil {
+		return err
+	}
+	_, err = p.opts.KV.Put(p.key, data)
+	return err
+}
+
+// Restore replaces the state with the stored snapshot, if there is one.
+func (p *StatePersister) Restore() error {
+	kve, err := p.opts.KV.Get(p.key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return p.state.RestoreState(kve.Value())
+}
+
+// Run saves a snapshot at every interval until the context is done, and a
+// last one then. Failed periodic snapshots are passed to `OnError`, the
+// error of the last one is returned.
+func (p *StatePersister) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := p.Save(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			if err := p.Save(); err != nil && p.opts.OnError != nil {
+				p.opts.OnError(err)
+			}
+		}
+	}
+}
+
+// PersistState restores the state from its last snapshot and saves
+// snapshots periodically as a background task of the service, so that
+// protective state, e.g. the quota usage, survives instance restarts. Call
+// it before adding the endpoints using the state. The last snapshot is
+// saved when the service stops, use `Service.Wait` after `Service.Stop` to
+// wait for it, and its failure is reported to the `ErrorHandler` of the
+// service. Failed periodic snapshots are passed to `OnError` and retried.
+func PersistState(svc *natsmicromw.Service, state StateSnapshotter, opts StatePersistenceOptions) (*StatePersister, error) {
+	p := NewStatePersister(state, opts)
+	if err := p.Restore(); err != nil {
+		return nil, err
+	}
+	svc.Go(p.Run)
+	return p, nil
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestPersistState(t *testing.T) {
+	opts := &server.Options{Host: "localhost", Port: server.RANDOM_PORT, NoSigs: true, JetStream: true, StoreDir: t.TempDir()}
+	s, err := runServer(opts)
+	if err != nil {
+		t.Fatalf("Could not start NATS server: %v", err)
+	}
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "state"})
+	if err != nil {
+		t.Fatalf("Could not create bucket: %v", err)
+	}
+	persistence := StatePersistenceOptions{KV: kv, Key: "quota.TestService", Instance: "pod-0", Interval: time.Hour}
+	ctx := context.Background()
+
+	// Nothing to restore on the first start
+	nm, err := natsmicromw.AddMicroService(nc, micro.Config{Name: "TestService", Version: "1.0.0"})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+	store := NewMemoryQuotaStore(0)
+	if _, err := PersistState(nm, store, persistence); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, allowed, _ := store.Consume(ctx, "alice", 3, time.Hour); !allowed {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+	}
+	// The last snapshot is saved on stop
+	nm.Stop()
+	nm.Wait()
+
+	nm, err = natsmicromw.AddMicroService(nc, micro.Config{Name: "TestService", Version: "1.0.0"})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+	defer nm.Stop()
+	restored := NewMemoryQuotaStore(0)
+	if _, err := PersistState(nm, restored, persistence); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	usage, allowed, _ := restored.Consume(ctx, "alice", 3, time.Hour)
+	if !allowed || usage.Used != 3 {
+		t.Errorf("expected the restored usage, received %+v", usage)
+	}
+	if _, allowed, _ := restored.Consume(ctx, "alice", 3, time.Hour); allowed {
+		t.Errorf("expected the quota to be exhausted after the restart")
+	}
+
+	// Other instances keep their own snapshots
+	other := NewMemoryQuotaStore(0)
+	otherPersistence := persistence
+	otherPersistence.Instance = "pod-1"
+	persister, err := PersistState(nm, other, otherPersistence)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if persister.Key() != "quota.TestService.pod-1" {
+		t.Errorf("expected the key of the instance, received %s", persister.Key())
+	}
+	if _, allowed, _ := other.Consume(ctx, "alice", 3, time.Hour); !allowed {
+		t.Errorf("expected the snapshot of another instance not to be restored")
+	}
+	if err := persister.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	restored = NewMemoryQuotaStore(0)
+	if err := NewStatePersister(restored, persistence).Restore(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usage, allowed, _ := restored.Consume(ctx, "alice", 3, time.Hour); !allowed || usage.Used != 3 {
+		t.Errorf("expected the snapshot of the instance to be kept, received %+v", usage)
+	}
+
+	if _, err := kv.PutString("quota.TestService.pod-0", "invalid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := PersistState(nm, NewMemoryQuotaStore(0), persistence); err == nil {
+		t.Errorf("expected an error restoring an invalid snapshot")
+	}
+}
+
+// State whose first snapshots fail
+type flakyState struct {
+	failures atomic.Int32
+	saved    atomic.Int32
+}
+
+func (f *flakyState) SnapshotState() ([]byte, error) {
+	if f.failures.Add(-1) >= 0 {
+		return nil, errors.New("snapshot failed")
+	}
+	f.saved.Add(1)
+	return []byte("{}"), nil
+}
+
+func (f *flakyState) RestoreState(data []byte) error {
+	return nil
+}
+
+func TestStatePersisterRetries(t *testing.T) {
+	opts := &server.Options{Host: "localhost", Port: server.RANDOM_PORT, NoSigs: true, JetStream: true, StoreDir: t.TempDir()}
+	s, err := runServer(opts)
+	if err != nil {
+		t.Fatalf("Could not start NATS server: %v", err)
+	}
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "state"})
+	if err != nil {
+		t.Fatalf("Could not create bucket: %v", err)
+	}
+
+	state := &flakyState{}
+	state.failures.Store(2)
+	var reported atomic.Int32
+	persister := NewStatePersister(state, StatePersistenceOptions{
+		KV:       kv,
+		Key:      "flaky",
+		Interval: time.Millisecond,
+		OnError:  func(err error) { reported.Add(1) },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- persister.Run(ctx) }()
+	for i := 0; state.saved.Load() == 0; i++ {
+		if i > 1000 {
+			t.Fatalf("expected a snapshot to be saved after the failures")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancellation error, received %v", err)
+	}
+	if n := reported.Load(); n != 2 {
+		t.Errorf("expected 2 reported errors, received %d", n)
+	}
+}
@@ -0,0 +1,143 @@
+// Example StatsD / DogStatsD metrics recorder for natsmicromw
+
+package middleware
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// StatsDClient is the subset of a StatsD client used by the recorder. The
+// DogStatsD client (`github.com/DataDog/datadog-go/v5/statsd`) implements it,
+// as does the built-in `UDPStatsDClient`.
+type StatsDClient interface {
+	Count(name string, value int64, tags []string, rate float64) error
+	Timing(name string, value time.Duration, tags []string, rate float64) error
+	Histogram(name string, value float64, tags []string, rate float64) error
+}
+
+// UDPStatsDClient sends metrics over UDP. With `Tags` enabled it uses the
+// DogStatsD tag extension, otherwise tag values are appended to the metric
+// name, since plain StatsD has no tags. With a rate below 1, metrics are
+// sampled at the rate and sent with it, so the server scales them back up.
+type UDPStatsDClient struct {
+	Prefix string
+	Tags   bool
+
+	conn net.Conn
+}
+
+// NewUDPStatsDClient creates a client sending to the address, e.g. "127.0.0.1:8125".
+func NewUDPStatsDClient(addr, prefix string, tags bool) (*UDPStatsDClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPStatsDClient{Prefix: prefix, Tags: tags, conn: conn}, nil
+}
+
+// Replace characters that have a meaning in the StatsD line format
+var (
+	statsdReplacer    = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_")
+	statsdTagReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_")
+)
+
+func (c *UDPStatsDClient) send(name, value, kind string, tags []string, rate float64) error {
+	if rate < 1 && rand.Float64() >= rate {
+		return nil
+	}
+	if !c.Tags {
+		for _, tag := range tags {
+			if _, v, ok := strings.Cut(tag, ":"); ok {
+				tag = v
+			}
+			name += "." + strings.ReplaceAll(tag, ".", "_")
+		}
+		tags = nil
+	}
+
+	line := statsdReplacer.Replace(c.Prefix+name) + ":" + value + "|" + kind
+	if rate < 1 {
+		line += fmt.Sprintf("|@%g", rate)
+	}
+	if len(tags) > 0 {
+		sanitized := make([]string, len(tags))
+		for i, tag := range tags {
+			sanitized[i] = statsdTagReplacer.Replace(tag)
+		}
+		line += "|#" + strings.Join(sanitized, ",")
+	}
+
+	_, err := c.conn.Write([]byte(line))
+	return err
+}
+
+func (c *UDPStatsDClient) Count(name string, value int64, tags []string, rate float64) error {
+	return c.send(name, fmt.Sprint(value), "c", tags, rate)
+}
+
+func (c *UDPStatsDClient) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	return c.send(name, fmt.Sprintf("%g", float64(value)/float64(time.Millisecond)), "ms", tags, rate)
+}
+
+func (c *UDPStatsDClient) Histogram(name string, value float64, tags []string, rate float64) error {
+	return c.send(name, fmt.Sprintf("%g", value), "h", tags, rate)
+}
+
+// Close the underlying connection.
+func (c *UDPStatsDClient) Close() error {
+	return c.conn.Close()
+}
+
+// StatsDRecorder reports request metrics to a StatsD client, use it with
+// `MetricsRecorderMiddleware`. Emission errors are ignored, as with UDP
+// they are not observable anyway.
+type StatsDRecorder struct {
+	Client StatsDClient
+	// Sample rate of all metrics, defaults to 1
+	Rate float64
+	// Extra tags added to all metrics
+	Tags []string
+}
+
+// WithLabels returns a recorder that adds the labels as tags.
+func (r StatsDRecorder) WithLabels(labels map[string]string) MetricsRecorder {
+	tags := append([]string{}, r.Tags...)
+	for k, v := range labels {
+		if v != "" {
+			tags = append(tags, k+":"+v)
+		}
+	}
+	r.Tags = tags
+	return r
+}
+
+func (r StatsDRecorder) tags(tags ...string) []string {
+	return append(tags, r.Tags...)
+}
+
+func (r StatsDRecorder) rate() float64 {
+	if r.Rate <= 0 {
+		return 1
+	}
+	return r.Rate
+}
+
+func (r StatsDRecorder) IncRequests(subject string) {
+	_ = r.Client.Count("nats.messages", 1, r.tags("subject:"+subject), r.rate())
+}
+
+func (r StatsDRecorder) ObserveDuration(subject string, duration time.Duration) {
+	_ = r.Client.Timing("nats.request.duration", duration, r.tags("subject:"+subject), r.rate())
+}
+
+func (r StatsDRecorder) ObserveSize(subject string, size int) {
+	_ = r.Client.Histogram("nats.payload.size", float64(size), r.tags("subject:"+subject), r.rate())
+}
+
+func (r StatsDRecorder) IncErrors(subject string, code string) {
+	_ = r.Client.Count("nats.errors", 1, r.tags("subject:"+subject, "code:"+code), r.rate())
+}
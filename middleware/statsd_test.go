@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+func TestStatsDRecorder(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer pc.Close()
+
+	client, err := NewUDPStatsDClient(pc.LocalAddr().String(), "svc.", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer client.Close()
+
+	handler := MetricsRecorderMicroMiddleware(StatsDRecorder{Client: client})(
+		func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			return nil, errors.New("failed")
+		})
+	handler((&natsmicromw.MicroRequest{Subject: "orders.create", Data: []byte("data")}).WithContext(context.Background()))
+
+	expected := []string{
+		"svc.nats.messages:1|c|#subject:orders.create",
+		"svc.nats.request.duration:",
+		"svc.nats.payload.size:4|h|#subject:orders.create",
+		"svc.nats.errors:1|c|#subject:orders.create,code:500",
+	}
+	buf := make([]byte, 1024)
+	for _, line := range expected {
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if received := string(buf[:n]); len(received) < len(line) || received[:len(line)] != line {
+			t.Errorf("metric does not match, expected %s, received %s", line, received)
+		}
+	}
+}
+
+func TestUDPStatsDClientSampling(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer pc.Close()
+
+	client, err := NewUDPStatsDClient(pc.LocalAddr().String(), "", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer client.Close()
+
+	// Nothing is sent at a zero rate, and everything sent carries the rate
+	for i := 0; i < 100; i++ {
+		_ = client.Count("dropped", 1, nil, 0)
+	}
+	for i := 0; i < 1000; i++ {
+		_ = client.Count("sampled", 1, nil, 0.1)
+	}
+	_ = client.Count("done", 1, nil, 1)
+
+	sampled := 0
+	buf := make([]byte, 1024)
+	for {
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		line := string(buf[:n])
+		if line == "done:1|c" {
+			break
+		} else if line != "sampled:1|c|@0.1" {
+			t.Fatalf("unexpected metric %s", line)
+		}
+		sampled++
+	}
+	if sampled == 0 || sampled > 300 {
+		t.Errorf("expected about 100 sampled metrics, received %d", sampled)
+	}
+}
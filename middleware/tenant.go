@@ -0,0 +1,282 @@
+// Example per-tenant configuration middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+)
+
+var (
+	// Chain state fact with the tenant of the request, for trusted
+	// middleware authenticating it by other means than the identity and
+	// API key middleware
+	StateTenant = natsmicromw.NewStateKey[string]("tenant")
+)
+
+// TenantConfig holds the middleware parameters of a tenant. Zero values
+// leave the parameters of the middleware unchanged.
+type TenantConfig struct {
+	// QuotaLimit overrides the limit of the quota middleware
+	QuotaLimit int64 `json:"quota_limit,omitempty"`
+	// AllowedEncodings restricts the reply compression encodings
+	AllowedEncodings []CompressionType `json:"allowed_encodings,omitempty"`
+	// Features are the feature flags of the tenant
+	Features map[string]bool `json:"features,omitempty"`
+	// Values holds other parameters, e.g. for custom middleware
+	Values map[string]string `json:"values,omitempty"`
+}
+
+// Feature reports whether the feature flag is enabled for the tenant.
+func (c *TenantConfig) Feature(name string) bool {
+	return c != nil && c.Features[name]
+}
+
+// Encoding returns the encoding if allowed for the tenant, otherwise no
+// compression.
+func (c *TenantConfig) Encoding(encoding CompressionType) CompressionType {
+	if c == nil || len(c.AllowedEncodings) == 0 {
+		return encoding
+	}
+	for _, allowed := range c.AllowedEncodings {
+		if allowed == encoding {
+			return encoding
+		}
+	}
+	return CompressionNone
+}
+
+// TenantConfigProvider looks up the configuration of a tenant.
+// Implementations return `ErrTenantNotFound` for unknown tenants.
+type TenantConfigProvider interface {
+	TenantConfig(ctx context.Context, tenant string) (*TenantConfig, error)
+}
+
+// TenantConfigProviderFunc is an adapter to use ordinary functions as
+// configuration providers.
+type TenantConfigProviderFunc func(ctx context.Context, tenant string) (*TenantConfig, error)
+
+func (f TenantConfigProviderFunc) TenantConfig(ctx context.Context, tenant string) (*TenantConfig, error) {
+	return f(ctx, tenant)
+}
+
+// StaticTenantConfigs is a fixed set of configurations.
+type StaticTenantConfigs map[string]TenantConfig
+
+func (s StaticTenantConfigs) TenantConfig(ctx context.Context, tenant string) (*TenantConfig, error) {
+	config, ok := s[tenant]
+	if !ok {
+		return nil, ErrTenantNotFound
+	}
+	return &config, nil
+}
+
+// KVTenantConfigs looks up configurations from a JetStream key-value bucket,
+// with JSON encoded `TenantConfig` values stored under the tenant ID.
+type KVTenantConfigs struct {
+	KV nats.KeyValue
+}
+
+func (s KVTenantConfigs) TenantConfig(ctx context.Context, tenant string) (*TenantConfig, error) {
+	entry, err := s.KV.Get(tenant)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, ErrTenantNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var config TenantConfig
+	if err := json.Unmarshal(entry.Value(), &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+type TenantConfigOptions struct {
+	Provider TenantConfigProvider
+	// TTL of cached configurations, 1 minute if zero
+	TTL time.Duration
+	// Default is used for requests without a tenant and for unknown tenants.
+	// If nil, requests of unknown tenants are rejected.
+	Default *TenantConfig
+	// Tenant returns the tenant of the request, `AuthenticatedTenant` by
+	// default. Tenants get their own quota limits, so do not trust headers
+	// set by clients, e.g. `tenant`.
+	Tenant func(ctx context.Context, headers micro.Headers) string
+}
+
+// AuthenticatedTenant returns the tenant of the authenticated request, the
+// `StateTenant` fact if set by trusted middleware, otherwise the account of
+// the principal verified by `IdentityMiddleware` or the owner of the key
+// validated by `APIKeyMiddleware`. Returns an empty string for anonymous
+// requests.
+func AuthenticatedTenant(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if tenant, ok := StateTenant.Get(ctx); ok && tenant != "" {
+		return tenant
+	}
+	if p := PrincipalFromContext(ctx); p != nil && p.Account != "" {
+		return p.Account
+	}
+	if apiKey := APIKeyFromContext(ctx); apiKey != nil {
+		return apiKey.Owner
+	}
+	return ""
+}
+
+type tenantCacheEntry struct {
+	config  *TenantConfig
+	expires time.Time
+}
+
+// TenantConfigs resolves and caches the configurations of tenants.
+type TenantConfigs struct {
+	opts TenantConfigOptions
+
+	mu    sync.Mutex
+	cache map[string]tenantCacheEntry
+}
+
+// NewTenantConfigs creates a new resolver with the given options.
+func NewTenantConfigs(opts TenantConfigOptions) *TenantConfigs {
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+	}
+	if opts.Tenant == nil {
+		opts.Tenant = func(ctx context.Context, headers micro.Headers) string {
+			return AuthenticatedTenant(ctx)
+		}
+	}
+	return &TenantConfigs{
+		opts:  opts,
+		cache: make(map[string]tenantCacheEntry),
+	}
+}
+
+// Resolve returns the configuration of the tenant, using the cache when
+// possible. Unknown tenants get the default configuration, which is not
+// cached, or `ErrTenantNotFound` without one.
+func (t *TenantConfigs) Resolve(ctx context.Context, tenant string) (*TenantConfig, error) {
+	if tenant == "" {
+		return t.opts.Default, nil
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	entry, ok := t.cache[tenant]
+	if ok && now.After(entry.expires) {
+		delete(t.cache, tenant)
+		ok = false
+	}
+	t.mu.Unlock()
+	if ok {
+		return entry.config, nil
+	}
+
+	config, err := t.opts.Provider.TenantConfig(ctx, tenant)
+	if errors.Is(err, ErrTenantNotFound) && t.opts.Default != nil {
+		// Not cached, there is no bound on the number of unknown tenants
+		return t.opts.Default, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.cache[tenant] = tenantCacheEntry{config, now.Add(t.opts.TTL)}
+	t.mu.Unlock()
+
+	return config, nil
+}
+
+// Invalidate drops the cached configuration of the tenant, e.g. when it was
+// changed, or of all tenants if none is given.
+func (t *TenantConfigs) Invalidate(tenants ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(tenants) == 0 {
+		t.cache = make(map[string]tenantCacheEntry)
+		return
+	}
+	for _, tenant := range tenants {
+		delete(t.cache, tenant)
+	}
+}
+
+type tenantConfigContextKey struct{}
+
+// TenantConfigFromContext returns the configuration of the request's tenant,
+// nil if none was resolved.
+func TenantConfigFromContext(ctx context.Context) *TenantConfig {
+	if ctx == nil {
+		return nil
+	}
+	config, _ := ctx.Value(tenantConfigContextKey{}).(*TenantConfig)
+	return config
+}
+
+// Resolve the configuration of the request and return a context with it
+func (t *TenantConfigs) resolve(ctx context.Context, headers micro.Headers) (context.Context, error) {
+	config, err := t.Resolve(ctx, t.opts.Tenant(ctx, headers))
+	if errors.Is(err, ErrTenantNotFound) {
+		return nil, &natsmicromw.HandlerError{
+			Description: err.Error(),
+			Code:        natsmicromw.CodeForbidden,
+		}
+	} else if err != nil {
+		return nil, &natsmicromw.HandlerError{
+			Description: err.Error(),
+			Code:        natsmicromw.CodeUnavailable,
+		}
+	}
+	if config == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, tenantConfigContextKey{}, config), nil
+}
+
+// TenantConfigMiddleware resolves the configuration of the request's tenant,
+// see `TenantConfigOptions.Tenant`, and stores it in the context, where later middleware uses it instead of
+// its own parameters: the quota middleware uses the tenant's limit and the
+// compression middleware only its allowed encodings. Add it after the
+// authentication middleware and before them.
+// Requests of unknown tenants are rejected with a 403 error, unless a
+// default configuration is set.
+func TenantConfigMiddleware(configs *TenantConfigs) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			ctx, err := configs.resolve(req.Context(), req.Headers())
+			if err != nil {
+				return err
+			}
+			return next(req.WithContext(ctx))
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func TenantConfigMicroMiddleware(configs *TenantConfigs) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			ctx, err := configs.resolve(req.Context(), req.Headers)
+			if err != nil {
+				return nil, err
+			}
+			return next(req.WithContext(ctx))
+		}
+	}
+}
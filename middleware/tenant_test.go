@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestTenantConfigMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	lookups := map[string]int{}
+	provider := TenantConfigProviderFunc(func(ctx context.Context, tenant string) (*TenantConfig, error) {
+		lookups[tenant]++
+		return StaticTenantConfigs{
+			"acme": {QuotaLimit: 1, AllowedEncodings: []CompressionType{CompressionDeflate}},
+			"big":  {QuotaLimit: 3, Features: map[string]bool{"beta": true}},
+		}.TenantConfig(ctx, tenant)
+	})
+	configs := NewTenantConfigs(TenantConfigOptions{Provider: provider})
+
+	// Trusted middleware, e.g. behind a gateway, publishing the tenant
+	trusted := func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			StateTenant.Set(req.Context(), req.HeaderGet("x-gateway-tenant"))
+			return next(req)
+		}
+	}
+	if err := nm.UseMicro(TenantConfigMicroMiddleware(configs)).AddMicroEndpoint("untrusted", microEcho); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	nm = nm.UseMicro(
+		trusted,
+		TenantConfigMicroMiddleware(configs),
+		QuotaMicroMiddleware(QuotaOptions{
+			Limit:  2,
+			Window: time.Hour,
+			Key: func(ctx context.Context) string {
+				return "all"
+			},
+		}),
+		CompressionMiddleware,
+	)
+	if err := nm.AddMicroEndpoint("echo", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		if TenantConfigFromContext(req.Context()).Feature("beta") {
+			return natsmicromw.NewMicroReply([]byte("beta")), nil
+		}
+		return microEcho(req)
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("a"), GetCompressMin()+1)
+	request := func(tenant string, encoding CompressionType) *nats.Msg {
+		msg := nats.NewMsg("echo")
+		msg.Data = data
+		msg.Header.Set("x-gateway-tenant", tenant)
+		msg.Header.Set(HeaderAcceptEncoding, string(encoding))
+		reply, err := nc.RequestMsg(msg, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return reply
+	}
+
+	// Gzip is not allowed for acme, so the reply is not compressed
+	reply := request("acme", CompressionGzip)
+	if herr := natsmicromw.HandlerErrorFromMsg(reply); herr != nil {
+		t.Fatalf("unexpected error: %v", herr)
+	}
+	if reply.Header.Get(HeaderEncoding) != "" || !bytes.Equal(reply.Data, data) {
+		t.Errorf("expected an uncompressed reply, received %q", reply.Header.Get(HeaderEncoding))
+	}
+	if herr := natsmicromw.HandlerErrorFromMsg(request("acme", CompressionDeflate)); herr == nil || herr.Code != natsmicromw.CodeTooManyRequests {
+		t.Errorf("expected the tenant quota to be exhausted, received %v", herr)
+	}
+
+	reply = request("big", CompressionGzip)
+	if string(reply.Data) != "beta" || reply.Header.Get(HeaderQuotaLimit) != "3" {
+		t.Errorf("expected the tenant feature and quota, received %q, limit %s", reply.Data, reply.Header.Get(HeaderQuotaLimit))
+	}
+
+	if herr := natsmicromw.HandlerErrorFromMsg(request("unknown", CompressionNone)); herr == nil || herr.Code != natsmicromw.CodeForbidden {
+		t.Errorf("expected a 403 error, received %v", herr)
+	}
+
+	if lookups["acme"] != 1 || lookups["big"] != 1 {
+		t.Errorf("expected cached configurations, received lookups %v", lookups)
+	}
+
+	// The tenant header of the client is not trusted
+	msg := nats.NewMsg("untrusted")
+	msg.Header.Set(HeaderTenant, "big")
+	reply, err := nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if herr := natsmicromw.HandlerErrorFromMsg(reply); herr != nil {
+		t.Errorf("unexpected error: %v", herr)
+	}
+	if lookups["big"] != 1 {
+		t.Errorf("expected the tenant header to be ignored, received lookups %v", lookups)
+	}
+}
+
+func TestAuthenticatedTenant(t *testing.T) {
+	ctx := context.Background()
+	if tenant := AuthenticatedTenant(ctx); tenant != "" {
+		t.Errorf("expected no tenant, received %q", tenant)
+	}
+	if tenant := AuthenticatedTenant(nil); tenant != "" {
+		t.Errorf("expected no tenant, received %q", tenant)
+	}
+
+	ctx = context.WithValue(ctx, apiKeyContextKey{}, &APIKey{ID: "key", Owner: "owner"})
+	if tenant := AuthenticatedTenant(ctx); tenant != "owner" {
+		t.Errorf("expected the key owner, received %q", tenant)
+	}
+	ctx = context.WithValue(ctx, principalContextKey{}, &Principal{Subject: "alice", Account: "account"})
+	if tenant := AuthenticatedTenant(ctx); tenant != "account" {
+		t.Errorf("expected the principal account, received %q", tenant)
+	}
+}
+
+func TestTenantConfigsResolve(t *testing.T) {
+	failing := errors.New("provider down")
+	var err error
+	configs := NewTenantConfigs(TenantConfigOptions{
+		Provider: TenantConfigProviderFunc(func(ctx context.Context, tenant string) (*TenantConfig, error) {
+			if err != nil {
+				return nil, err
+			}
+			return StaticTenantConfigs{"acme": {QuotaLimit: 5}}.TenantConfig(ctx, tenant)
+		}),
+		Default: &TenantConfig{QuotaLimit: 1},
+	})
+
+	ctx := context.Background()
+	if config, _ := configs.Resolve(ctx, ""); config == nil || config.QuotaLimit != 1 {
+		t.Errorf("expected the default configuration, received %v", config)
+	}
+	if config, _ := configs.Resolve(ctx, "unknown"); config == nil || config.QuotaLimit != 1 {
+		t.Errorf("expected the default configuration, received %v", config)
+	}
+	if len(configs.cache) != 0 {
+		t.Errorf("expected the default configuration not to be cached, received %v", configs.cache)
+	}
+	if config, _ := configs.Resolve(ctx, "acme"); config == nil || config.QuotaLimit != 5 {
+		t.Errorf("expected the tenant configuration, received %v", config)
+	}
+
+	// Cached configurations are used until invalidated
+	err = failing
+	if config, resolveErr := configs.Resolve(ctx, "acme"); resolveErr != nil || config.QuotaLimit != 5 {
+		t.Errorf("expected the cached configuration, received %v: %v", config, resolveErr)
+	}
+	configs.Invalidate("acme")
+	if _, resolveErr := configs.Resolve(ctx, "acme"); !errors.Is(resolveErr, failing) {
+		t.Errorf("expected the provider error, received %v", resolveErr)
+	}
+}
+
+func TestCompressionWithoutContext(t *testing.T) {
+	// Requests built without a context, e.g. in unit tests of handlers
+	data := bytes.Repeat([]byte("a"), GetCompressMin()+1)
+	req := &natsmicromw.MicroRequest{
+		Data:    data,
+		Headers: micro.Headers{HeaderAcceptEncoding: []string{string(CompressionGzip)}},
+	}
+	reply, err := CompressionMiddleware(microEcho)(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reply.HeaderGet(HeaderEncoding) != string(CompressionGzip) {
+		t.Errorf("expected a compressed reply, received %v", reply.Headers)
+	}
+}
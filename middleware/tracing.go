@@ -0,0 +1,138 @@
+// Example distributed tracing middleware for natsmicromw
+
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"github.com/Karimerto/natsmicromw"
+)
+
+// SpanContext identifies a span across process boundaries. IDs are lower
+// case hex strings, 32 characters for trace IDs and 16 for span IDs.
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	// Sampling decision of the caller, nil if not decided yet
+	Sampled *bool
+}
+
+// Span is a single traced operation.
+type Span interface {
+	SetTag(key string, value any)
+	SetError(err error)
+	Finish()
+	Context() SpanContext
+}
+
+// Tracer creates spans, typically by adapting an APM library. The parent is
+// nil if the request did not carry a trace.
+type Tracer interface {
+	StartSpan(ctx context.Context, operation string, parent *SpanContext) (context.Context, Span)
+}
+
+// Propagator reads and writes span contexts from and to message headers.
+type Propagator interface {
+	Extract(headers micro.Headers) (SpanContext, bool)
+	Inject(sc SpanContext, headers nats.Header)
+}
+
+type TracingOptions struct {
+	Tracer     Tracer
+	Propagator Propagator
+	// Operation name of the spans, defaults to "nats.request"
+	Operation string
+}
+
+type spanContextKey struct{}
+
+// SpanFromContext returns the span of the request, if any.
+func SpanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanContextKey{}).(Span)
+	return span
+}
+
+// InjectTrace writes the span context of the request into the headers of an
+// outgoing message, so that downstream services continue the same trace.
+func InjectTrace(ctx context.Context, p Propagator, headers nats.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		p.Inject(span.Context(), headers)
+	}
+}
+
+// Start a span for the request, continuing the trace of the caller
+func startSpan(ctx context.Context, opts TracingOptions, subject string, headers micro.Headers) (context.Context, Span) {
+	var parent *SpanContext
+	if sc, ok := opts.Propagator.Extract(headers); ok {
+		parent = &sc
+	}
+
+	operation := opts.Operation
+	if operation == "" {
+		operation = "nats.request"
+	}
+
+	ctx, span := opts.Tracer.StartSpan(ctx, operation, parent)
+	span.SetTag("nats.subject", subject)
+	if traceID := span.Context().TraceID; traceID != "" {
+		natsmicromw.SetErrorMeta(ctx, natsmicromw.ErrorMetaTraceID, traceID)
+	}
+	// Requests made through `natsmicromw.Conn` continue the trace
+	ctx = natsmicromw.WithOutgoingHeaders(ctx, func(headers nats.Header) {
+		opts.Propagator.Inject(span.Context(), headers)
+	})
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// Tag the span with the request tags and the error, including the code of
+// handler errors
+func finishSpan(ctx context.Context, span Span, start time.Time, err error) {
+	for key, value := range natsmicromw.Tags(ctx) {
+		span.SetTag(key, value)
+	}
+	span.SetTag("duration_ms", time.Since(start).Milliseconds())
+	if err != nil {
+		span.SetTag("error.code", natsmicromw.ContextErrorCode(ctx, err))
+		span.SetError(err)
+	}
+	span.Finish()
+}
+
+// TracingMiddleware creates a span for each request, continuing the trace
+// propagated in the request headers. The trace ID is included in error
+// replies, see `natsmicromw.SetErrorMeta`, and the span is tagged with the
+// tags of the request, see `natsmicromw.Tag`.
+func TracingMiddleware(opts TracingOptions) func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+	return func(next natsmicromw.ContextHandlerFunc) natsmicromw.ContextHandlerFunc {
+		return func(req *natsmicromw.Request) error {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			start := time.Now()
+			ctx, span := startSpan(req.Context(), opts, req.Subject(), req.Headers())
+			err := next(req.WithContext(ctx))
+			finishSpan(ctx, span, start, replyError(ctx, nil, err))
+			return err
+		}
+	}
+}
+
+// Same middleware with `MicroRequest` and `MicroReply`
+func TracingMicroMiddleware(opts TracingOptions) func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+	return func(next natsmicromw.MicroHandlerFunc) natsmicromw.MicroHandlerFunc {
+		return func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+			if natsmicromw.IsChainBenchmark(req.Context()) {
+				return next(req)
+			}
+			start := time.Now()
+			ctx, span := startSpan(req.Context(), opts, req.Subject, req.Headers)
+			reply, err := next(req.WithContext(ctx))
+			finishSpan(ctx, span, start, replyError(ctx, reply, err))
+			return reply, err
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Karimerto/natsmicromw"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+type testSpan struct {
+	parent *SpanContext
+	sc     SpanContext
+	tags   map[string]any
+	err    error
+}
+
+func (s *testSpan) SetTag(key string, value any) { s.tags[key] = value }
+func (s *testSpan) SetError(err error)           { s.err = err }
+func (s *testSpan) Finish()                      {}
+func (s *testSpan) Context() SpanContext         { return s.sc }
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, operation string, parent *SpanContext) (context.Context, Span) {
+	span := &testSpan{
+		parent: parent,
+		sc:     SpanContext{TraceID: "0000000000000000000000000000002a", SpanID: "0000000000000007"},
+		tags:   map[string]any{},
+	}
+	if parent != nil {
+		span.sc.TraceID = parent.TraceID
+	}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return ctx, span
+}
+
+func TestDatadogPropagator(t *testing.T) {
+	sampled := true
+	sc := SpanContext{
+		TraceID: "00000000000000ff000000000000002a",
+		SpanID:  "0000000000000007",
+		Sampled: &sampled,
+	}
+
+	headers := nats.Header{}
+	DatadogPropagator{}.Inject(sc, headers)
+	if headers.Get(HeaderDatadogTraceID) != "42" || headers.Get(HeaderDatadogParentID) != "7" {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+
+	extracted, ok := DatadogPropagator{}.Extract(micro.Headers(headers))
+	if !ok {
+		t.Fatalf("expected a span context")
+	}
+	if extracted.TraceID != sc.TraceID || extracted.SpanID != sc.SpanID || extracted.Sampled == nil || !*extracted.Sampled {
+		t.Errorf("unexpected span context: %+v", extracted)
+	}
+
+	if _, ok := (DatadogPropagator{}).Extract(micro.Headers{}); ok {
+		t.Errorf("expected no span context without headers")
+	}
+}
+
+func TestDatadogMiddleware(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	tracer := &testTracer{}
+	nm = nm.UseMicro(DatadogMicroMiddleware(tracer))
+	err := nm.AddMicroEndpoint("fail", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		if SpanFromContext(req.Context()) == nil {
+			return nil, errors.New("missing span")
+		}
+		return nil, &natsmicromw.HandlerError{Description: "not found", Code: "404"}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg := nats.NewMsg("fail")
+	msg.Header.Set(HeaderDatadogTraceID, "42")
+	msg.Header.Set(HeaderDatadogParentID, "7")
+	if _, err := nc.RequestMsg(msg, 1*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if len(tracer.spans) != 1 {
+		t.Fatalf("expected a single span, got %d", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span.parent == nil || span.parent.TraceID != "0000000000000000000000000000002a" {
+		t.Errorf("unexpected parent: %+v", span.parent)
+	}
+	if span.tags["error.code"] != "404" || span.tags["nats.subject"] != "fail" || span.err == nil {
+		t.Errorf("unexpected span: %+v", span)
+	}
+}
+
+func TestB3Propagator(t *testing.T) {
+	sampled := false
+	sc := SpanContext{
+		TraceID: "463ac35c9f6413ad48485a3953bb6124",
+		SpanID:  "a2fb4a1d1a96d312",
+		Sampled: &sampled,
+	}
+
+	for _, single := range []bool{false, true} {
+		p := B3Propagator{SingleHeader: single}
+		headers := nats.Header{}
+		p.Inject(sc, headers)
+
+		if single && headers.Get(HeaderB3) != "463ac35c9f6413ad48485a3953bb6124-a2fb4a1d1a96d312-0" {
+			t.Errorf("unexpected single header: %s", headers.Get(HeaderB3))
+		}
+
+		extracted, ok := p.Extract(micro.Headers(headers))
+		if !ok {
+			t.Fatalf("expected a span context")
+		}
+		if extracted.TraceID != sc.TraceID || extracted.SpanID != sc.SpanID || extracted.Sampled == nil || *extracted.Sampled {
+			t.Errorf("unexpected span context: %+v", extracted)
+		}
+	}
+
+	// 64-bit trace IDs are padded, a lone sampling state is not a trace
+	extracted, ok := B3Propagator{}.Extract(micro.Headers{HeaderB3: {"48485a3953bb6124-a2fb4a1d1a96d312-d"}})
+	if !ok || extracted.TraceID != "000000000000000048485a3953bb6124" || extracted.Sampled == nil || !*extracted.Sampled {
+		t.Errorf("unexpected span context: %+v", extracted)
+	}
+	if _, ok := (B3Propagator{}).Extract(micro.Headers{HeaderB3: {"0"}}); ok {
+		t.Errorf("expected no span context for sampling state only")
+	}
+}
+
+func TestTracingConnHeaders(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	err := nm.AddMicroEndpoint("back", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		return natsmicromw.NewMicroReply([]byte(req.HeaderGet("request_id") + " " + req.HeaderGet(HeaderDatadogTraceID))), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Requests made through the connection carry the request id and trace
+	err = nm.UseMicro(RequestIdMicroMiddleware(), DatadogMicroMiddleware(&testTracer{})).AddMicroEndpoint("front", func(req *natsmicromw.MicroRequest) (*natsmicromw.MicroReply, error) {
+		reply, err := natsmicromw.ConnFromContext(req.Context()).Request("back", nil)
+		if err != nil {
+			return nil, err
+		}
+		return natsmicromw.NewMicroReply(reply.Data), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg := nats.NewMsg("front")
+	msg.Header.Set("request_id", "req-1")
+	reply, err := nc.RequestMsg(msg, 1*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "req-1 42"; string(reply.Data) != expected {
+		t.Errorf("propagated headers do not match, expected %s, received %s", expected, reply.Data)
+	}
+}
@@ -0,0 +1,181 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+var (
+	ErrNoConnections = errors.New("natsmicromw: no connections")
+)
+
+// MultiService is the same service registered on several connections, e.g.
+// a main cluster and a leaf node, so it is reachable from isolated domains.
+// Endpoints and middleware are added to every registration, sharing the
+// same handler and middleware values, and the registrations are stopped
+// together.
+type MultiService struct {
+	services []*Service
+}
+
+// MultiGroup is a group of a [MultiService], on every registration.
+type MultiGroup struct {
+	groups []*Group
+}
+
+// AddMultiService creates the service on each of the connections. If any
+// of them fails, the ones already created are stopped.
+func AddMultiService(conns []*nats.Conn, config micro.Config, fns ...MiddlewareFunc) (*MultiService, error) {
+	if len(conns) == 0 {
+		return nil, ErrNoConnections
+	}
+
+	m := &MultiService{}
+	for _, nc := range conns {
+		s, err := AddService(nc, config, fns...)
+		if err != nil {
+			_ = m.Stop()
+			return nil, err
+		}
+		m.services = append(m.services, s)
+	}
+	return m, nil
+}
+
+// Services returns the registrations, in the order of the connections.
+func (m *MultiService) Services() []*Service {
+	return append([]*Service{}, m.services...)
+}
+
+// With returns a multi service whose registrations are scoped by the
+// function, e.g. to use any of the `With` options of [Service]:
+//
+//	m.With(func(s *Service) *Service { return s.WithHandlerTimeout(time.Second) })
+func (m *MultiService) With(fn func(s *Service) *Service) *MultiService {
+	nm := &MultiService{services: make([]*Service, len(m.services))}
+	for i, s := range m.services {
+		nm.services[i] = fn(s)
+	}
+	return nm
+}
+
+// Use returns a multi service with the middleware added to every registration.
+func (m *MultiService) Use(fns ...MiddlewareFunc) *MultiService {
+	return m.With(func(s *Service) *Service { return s.Use(fns...) })
+}
+
+// UseContext returns a multi service with the context middleware added to
+// every registration.
+func (m *MultiService) UseContext(fns ...ContextMiddlewareFunc) *MultiService {
+	return m.With(func(s *Service) *Service { return s.UseContext(fns...) })
+}
+
+// UseMicro returns a multi service with the Micro middleware added to every
+// registration.
+func (m *MultiService) UseMicro(fns ...MicroMiddlewareFunc) *MultiService {
+	return m.With(func(s *Service) *Service { return s.UseMicro(fns...) })
+}
+
+// AddEndpoint registers the endpoint on every registration, stopping at the
+// first one that fails.
+func (m *MultiService) AddEndpoint(name string, handler micro.Handler, opts ...micro.EndpointOpt) error {
+	for _, s := range m.services {
+		if err := s.AddEndpoint(name, handler, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddContextEndpoint registers the endpoint on every registration.
+func (m *MultiService) AddContextEndpoint(name string, handler ContextHandlerFunc, opts ...micro.EndpointOpt) error {
+	for _, s := range m.services {
+		if err := s.AddContextEndpoint(name, handler, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddMicroEndpoint registers the endpoint on every registration.
+func (m *MultiService) AddMicroEndpoint(name string, handler MicroHandlerFunc, opts ...micro.EndpointOpt) error {
+	for _, s := range m.services {
+		if err := s.AddMicroEndpoint(name, handler, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddGroup adds the group to every registration.
+func (m *MultiService) AddGroup(name string, opts ...micro.GroupOpt) *MultiGroup {
+	mg := &MultiGroup{groups: make([]*Group, len(m.services))}
+	for i, s := range m.services {
+		mg.groups[i] = s.AddGroup(name, opts...)
+	}
+	return mg
+}
+
+// Stats returns the statistics of every registration, with the totals over
+// all of them.
+func (m *MultiService) Stats() RegistryStats {
+	return aggregateStats(m.services)
+}
+
+// Stop stops every registration, returning the errors of those that failed.
+func (m *MultiService) Stop() error {
+	return stopServices(m.services)
+}
+
+// Shutdown stops every registration and waits for their background tasks to
+// return, or until the context is done.
+func (m *MultiService) Shutdown(ctx context.Context) error {
+	return shutdownServices(ctx, m.services)
+}
+
+// With returns a multi group whose groups are scoped by the function.
+func (mg *MultiGroup) With(fn func(g *Group) *Group) *MultiGroup {
+	ng := &MultiGroup{groups: make([]*Group, len(mg.groups))}
+	for i, g := range mg.groups {
+		ng.groups[i] = fn(g)
+	}
+	return ng
+}
+
+// AddGroup adds the nested group to every registration.
+func (mg *MultiGroup) AddGroup(name string, opts ...micro.GroupOpt) *MultiGroup {
+	return mg.With(func(g *Group) *Group { return g.AddGroup(name, opts...) })
+}
+
+// AddEndpoint registers the endpoint in the group of every registration.
+func (mg *MultiGroup) AddEndpoint(name string, handler micro.Handler, opts ...micro.EndpointOpt) error {
+	for _, g := range mg.groups {
+		if err := g.AddEndpoint(name, handler, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddContextEndpoint registers the endpoint in the group of every registration.
+func (mg *MultiGroup) AddContextEndpoint(name string, handler ContextHandlerFunc, opts ...micro.EndpointOpt) error {
+	for _, g := range mg.groups {
+		if err := g.AddContextEndpoint(name, handler, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddMicroEndpoint registers the endpoint in the group of every registration.
+func (mg *MultiGroup) AddMicroEndpoint(name string, handler MicroHandlerFunc, opts ...micro.EndpointOpt) error {
+	for _, g := range mg.groups {
+		if err := g.AddMicroEndpoint(name, handler, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
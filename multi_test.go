@@ -0,0 +1,71 @@
+package natsmicromw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestMultiService(t *testing.T) {
+	// Two servers without routes, as isolated domains
+	var conns []*nats.Conn
+	for i := 0; i < 2; i++ {
+		s := getServer(t)
+		defer s.Shutdown()
+		nc, err := nats.Connect(s.Addr().String())
+		if err != nil {
+			t.Fatalf("Could not connect to NATS server: %v", err)
+		}
+		defer nc.Close()
+		conns = append(conns, nc)
+	}
+
+	if _, err := AddMultiService(nil, micro.Config{Name: "Multi", Version: "1.0.0"}); err != ErrNoConnections {
+		t.Fatalf("expected ErrNoConnections, received %v", err)
+	}
+	m, err := AddMultiService(conns, micro.Config{Name: "Multi", Version: "1.0.0"})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+
+	err = m.UseMicro(rejectingMiddleware).AddGroup("orders").AddMicroEndpoint("echo", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply(req.Data), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, nc := range conns {
+		if _, err := nc.Request("orders.echo", []byte("data"), time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	msg := nats.NewMsg("orders.echo")
+	msg.Header.Set("reject", "1")
+	reply, err := conns[1].RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != CodeForbidden {
+		t.Errorf("shared middleware was not applied, received %v", handlerErr)
+	}
+
+	stats := m.Stats()
+	if len(stats.Services) != 2 || stats.NumRequests != 3 || stats.NumErrors != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, nm := range m.Services() {
+		if !nm.Stopped() {
+			t.Errorf("service was not stopped")
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package natsmicromw
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ErrNotMultipart is returned when parsing the parts of a payload whose
+// content type is not multipart.
+var ErrNotMultipart = errors.New("natsmicromw: payload is not multipart")
+
+// Part is a named part of a multipart payload, e.g. JSON metadata and a
+// binary blob sent together.
+type Part struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Parts of a multipart payload, in order.
+type Parts []Part
+
+// Get returns the first part with the name, nil if there is none.
+func (p Parts) Get(name string) *Part {
+	for i := range p {
+		if p[i].Name == name {
+			return &p[i]
+		}
+	}
+	return nil
+}
+
+// EncodeParts encodes the parts into the message data as a MIME multipart
+// payload with named parts, as browsers send forms, and sets the content
+// type header with the boundary. Parts without a content type are sent as
+// "application/octet-stream".
+func EncodeParts(msg *nats.Msg, parts ...Part) error {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, part := range parts {
+		contentType := part.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": part.Name}))
+		header.Set("Content-Type", contentType)
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := pw.Write(part.Data); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Header.Set(HeaderContentType, w.FormDataContentType())
+	msg.Data = buf.Bytes()
+	return nil
+}
+
+// Parse the multipart payload with the boundary of the content type
+func parseParts(headers micro.Headers, body io.Reader) (Parts, error) {
+	mediaType, params, err := mime.ParseMediaType(headers.Get(HeaderContentType))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, ErrNotMultipart
+	}
+
+	var parts Parts
+	r := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return parts, nil
+		} else if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{
+			Name:        p.FormName(),
+			ContentType: p.Header.Get("Content-Type"),
+			Data:        data,
+		})
+	}
+}
+
+// Parts parses the payload of a multipart request, see [EncodeParts], and
+// returns its parts. A streamed payload is consumed. Returns
+// [ErrNotMultipart] if the content type header is not multipart.
+func (r *MicroRequest) Parts() (Parts, error) {
+	return parseParts(r.Headers, r.Body())
+}
+
+// Parts parses the payload of a multipart request, see
+// [MicroRequest.Parts].
+func (r *Request) Parts() (Parts, error) {
+	return parseParts(r.Headers(), bytes.NewReader(r.Data()))
+}
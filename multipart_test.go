@@ -0,0 +1,81 @@
+package natsmicromw
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestParts(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	err := nm.AddMicroEndpoint("upload", func(req *MicroRequest) (*MicroReply, error) {
+		parts, err := req.Parts()
+		if errors.Is(err, ErrNotMultipart) {
+			return nil, Errorf(CodeBadRequest, "expected a multipart payload")
+		} else if err != nil {
+			return nil, err
+		}
+		meta, blob := parts.Get("meta"), parts.Get("blob")
+		if len(parts) != 2 || meta == nil || blob == nil {
+			return nil, Errorf(CodeBadRequest, "missing parts")
+		}
+		if meta.ContentType != "application/json" || blob.ContentType != "application/octet-stream" {
+			return nil, Errorf(CodeBadRequest, "unexpected content types")
+		}
+		return NewMicroReply(append(meta.Data, blob.Data...)), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = nm.AddContextEndpoint("count", func(req *Request) error {
+		parts, err := req.Parts()
+		if err != nil {
+			return err
+		}
+		return req.Respond([]byte{byte(len(parts))})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg := nats.NewMsg("upload")
+	err = EncodeParts(msg,
+		Part{Name: "meta", ContentType: "application/json", Data: []byte(`{"name":"a.bin"}`)},
+		Part{Name: "blob", Data: []byte{0, 1, 2, '\r', '\n', '-', '-'}},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reply, err := nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handlerErr := HandlerErrorFromMsg(reply); handlerErr != nil {
+		t.Fatalf("unexpected error reply: %v", handlerErr)
+	}
+	if expected := "{\"name\":\"a.bin\"}\x00\x01\x02\r\n--"; string(reply.Data) != expected {
+		t.Errorf("expected %q, received %q", expected, reply.Data)
+	}
+
+	msg.Subject = "count"
+	reply, err = nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reply.Data) != 1 || reply.Data[0] != 2 {
+		t.Errorf("expected 2 parts, received %v", reply.Data)
+	}
+
+	reply, err = nc.Request("upload", []byte("plain"), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != CodeBadRequest {
+		t.Errorf("expected a 400 error, received %v", handlerErr)
+	}
+}
@@ -11,25 +11,83 @@ package natsmicromw
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
 )
 
-// Service represents a Microservice with middleware support.
+// Service represents a Microservice with middleware support. A Service is
+// safe for concurrent use, including registering endpoints and adding
+// middleware from multiple goroutines.
 type Service struct {
+	// Guards the settings changed by the setters, shared by all clones
+	mu *sync.RWMutex
+
+	nc         *nats.Conn
 	svc        micro.Service
 	mw         []MiddlewareFunc
 	cmw        []ContextMiddlewareFunc
 	mmw        []MicroMiddlewareFunc
 	defaultCtx context.Context
+	codec      Codec
+
+	instanceSubjects  bool
+	stamping          ReplyStamping
+	errorCodes        []ErrorCodeMapping
+	errorEncoder      ErrorEncoder
+	errorTransforms   []ErrorTransformer
+	defaultErrorCode  string
+	recoverPanics     bool
+	onPanic           PanicHandler
+	handlerTimeout    time.Duration
+	replyDeadline     time.Duration
+	warmup            Warmup
+	maxWatches        int
+	subjectAliases    map[string][]string
+	priorityPools     []*priorityPool
+	shard             *shardFilter
+	debugChain        DebugChainAuthorizer
+	echoHeaders       []string
+	tagHeaders        string
+	backpressure      float64
+	headerNormalizer  HeaderNormalizer
+	version           string
+	metadata          map[string]string
+	versionPrecedence VersionPrecedence
+	unanswered        unansweredPolicy
+	onRespondError    func(micro.Request, error)
+
+	tasks       *tasks
+	startup     *startup
+	guard       *chainGuard
+	described   *descriptions
+	maintenance *maintenance
+	inFlight    *inFlight
+	pools       *workerPools
+
+	respondErrors *atomic.Uint64
+	abandoned     *atomic.Uint64
+}
+
+// Configuration checks shared by all clones of a service
+type chainGuard struct {
+	frozen     atomic.Bool
+	strict     atomic.Bool
+	registered atomic.Bool
+	// Serializes the registration of endpoints, so that the endpoints added
+	// by one registration can be found in the service info
+	registering sync.Mutex
 }
 
 // Group represents a Microservice group with middleware support.
 type Group struct {
-	svc *Service
-	grp micro.Group
+	svc    *Service
+	grp    micro.Group
+	prefix string
 }
 
 // MiddlewareFunc defines the type for middleware functions.
@@ -44,6 +102,13 @@ type MicroHandlerFunc func(*MicroRequest) (*MicroReply, error)
 
 type MicroMiddlewareFunc func(MicroHandlerFunc) MicroHandlerFunc
 
+const (
+	// Endpoint metadata key pointing from an instance subject to the shared subject
+	MetadataInstanceOf string = "instance_of"
+
+	instanceSubjectPrefix = "_INSTANCE"
+)
+
 func wrapHandler(handler micro.Handler, mws ...MiddlewareFunc) micro.Handler {
 	// Create a chain of middleware handlers
 	var wrappedHandler micro.Handler = handler
@@ -62,66 +127,166 @@ func AddService(nc *nats.Conn, config micro.Config, fns ...MiddlewareFunc) (*Ser
 		config.Endpoint.Handler = wrapHandler(config.Endpoint.Handler, fns...)
 	}
 
+	tasks := newTasks(&config)
 	svc, err := micro.AddService(nc, config)
 	if err != nil {
+		tasks.cancel()
 		return nil, err
 	}
+	tasks.setErrorHandler(svc, config.ErrorHandler)
 
-	s := &Service{svc: svc, mw: fns}
+	s := newService(nc, svc, tasks)
+	s.mw = fns
 	return s, nil
 }
 
-// WithMiddleware adds middleware functions to the Microservice.
-func (s *Service) WithMiddleware(fns ...MiddlewareFunc) *Service {
+func newService(nc *nats.Conn, svc micro.Service, tasks *tasks) *Service {
 	return &Service{
-		svc:        s.svc,
-		mw:         append(s.mw, fns...),
-		cmw:        s.cmw,
-		mmw:        s.mmw,
-		defaultCtx: s.defaultCtx,
+		mu:          new(sync.RWMutex),
+		nc:          nc,
+		svc:         svc,
+		tasks:       tasks,
+		startup:     newStartup(),
+		guard:       new(chainGuard),
+		described:   new(descriptions),
+		maintenance: new(maintenance),
+		inFlight:    new(inFlight),
+		pools:       new(workerPools),
+
+		respondErrors: new(atomic.Uint64),
+		abandoned:     new(atomic.Uint64),
+
+		errorCodes:   DefaultErrorCodes,
+		errorEncoder: JSONErrorEncoder,
 	}
 }
 
+// Create a shallow copy of the service, sharing the underlying micro.Service
+func (s *Service) clone() *Service {
+	s.checkFrozen()
+	return s.snapshot()
+}
+
+// Copy the service under the lock, for reading the settings consistently
+func (s *Service) snapshot() *Service {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ns := *s
+	return &ns
+}
+
+// Freeze rejects any later changes to the middleware and settings of the
+// service, its clones and groups, which then panic. Call it once the service
+// is configured to make sure every endpoint sees the same configuration.
+func (s *Service) Freeze() {
+	s.guard.frozen.Store(true)
+}
+
+func (s *Service) checkFrozen() {
+	if s.guard.frozen.Load() {
+		panic("natsmicromw: service is frozen")
+	}
+}
+
+// WithStrictChain enables the strict mode for the service, its clones and
+// groups, in which adding middleware after any endpoint has been registered
+// panics. Without it, such middleware silently only applies to endpoints
+// registered later.
+func (s *Service) WithStrictChain() *Service {
+	s.guard.strict.Store(true)
+	return s
+}
+
+// Clone the service for adding middleware
+func (s *Service) cloneChain() *Service {
+	if s.guard.strict.Load() && s.guard.registered.Load() {
+		panic("natsmicromw: middleware added after endpoints were registered")
+	}
+	return s.clone()
+}
+
+// Append to a copy of the slice, so that clones never share a backing array
+func extend[T any](s []T, v ...T) []T {
+	return append(s[:len(s):len(s)], v...)
+}
+
+// WithMiddleware adds middleware functions to the Microservice.
+func (s *Service) WithMiddleware(fns ...MiddlewareFunc) *Service {
+	ns := s.cloneChain()
+	ns.mw = extend(s.mw, fns...)
+	return ns
+}
+
 // Use is an alias for WithMiddleware, adding middleware functions to the Microservice.
 func (s *Service) Use(fns ...MiddlewareFunc) *Service {
 	return s.WithMiddleware(fns...)
 }
 
-func wrapContextHandler(s *Service, handler ContextHandlerFunc) micro.HandlerFunc {
-	return micro.HandlerFunc(func(req micro.Request) {
-		// Use the default context if available, otherwise use background context
-		var ctx context.Context
-		if s.defaultCtx != nil {
-			ctx = s.defaultCtx
-		} else {
-			ctx = context.Background()
+// Default context of the service, or the background context if not set
+func (s *Service) baseContext() context.Context {
+	ctx := context.Background()
+	if s.defaultCtx != nil {
+		ctx = s.defaultCtx
+	}
+	if s.codec != nil {
+		ctx = withCodec(ctx, s.codec)
+	}
+	return ctx
+}
+
+// The middleware chain and the default context are snapshotted when the
+// endpoint is registered, later changes to the service do not affect it.
+func wrapContextHandler(s *Service, ep *endpointRef, handler ContextHandlerFunc) micro.Handler {
+	s = s.snapshot()
+	baseCtx := withErrorConverter(withConn(s.baseContext(), s.nc), s.errorConverter())
+	encodeError := s.errorReply()
+	recoverPanics, onPanic := s.recoverPanics, s.onPanic
+
+	// Wrap handler in middleware calls, recording each layer in the trace
+	// of debugged requests if enabled
+	var wrappedCtxHandler ContextHandlerFunc = handler
+	if s.debugChain != nil {
+		wrappedCtxHandler = tracedContextChain(s.cmw, handler)
+	} else {
+		for i := len(s.cmw) - 1; i >= 0; i-- {
+			wrappedCtxHandler = s.cmw[i](wrappedCtxHandler)
 		}
+	}
 
-		ctxReq := &Request{req, ctx}
+	unanswered, tasks, timeout := s.unanswered, s.tasks, s.handlerTimeout
 
-		// Wrap handler in middleware calls
-		var wrappedCtxHandler ContextHandlerFunc = handler
-		for i := len(s.cmw) - 1; i >= 0; i-- {
-			wrappedCtxHandler = s.cmw[i](wrappedCtxHandler)
+	return micro.HandlerFunc(func(req micro.Request) {
+		ctx, meta, cancel := inFlightContext(endpointContext(baseCtx, ep, req), req)
+		defer cancel()
+		inner := micro.Request(req)
+		if trace := s.debugTrace(req); trace != nil {
+			ctx = withChainTrace(ctx, trace)
+			inner = &debugRequest{req, trace}
 		}
 
+		// Guard against replying more than once
+		tracked := &trackedRequest{Request: inner, onDuplicate: tasks.report}
+
 		// Call the top-level handler
-		err := wrappedCtxHandler(ctxReq)
+		_, err := callWithTimeout(ctx, timeout, func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, callRecovered(recoverPanics, onPanic, req, func() error {
+				return wrappedCtxHandler(&Request{tracked, ctx})
+			})
+		})
+		if errors.Is(err, ErrHandlerTimeout) {
+			tasks.report(req.Subject(), ErrHandlerTimeout)
+			// The handler may have replied just before the deadline
+			if tracked.responded.Load() {
+				return
+			}
+		}
 
 		// If an error is encountered, respond with it automatically
 		if err != nil {
-			handlerErr, ok := err.(*HandlerError)
-			if !ok {
-				handlerErr = &HandlerError{
-					Description: err.Error(),
-					Code:        "500",
-				}
-			}
-
-			// Send the entire error in the body as well
-			errData, _ := json.Marshal(handlerErr)
-
-			req.Error(handlerErr.Code, handlerErr.Description, errData)
+			code, description, data, headers := encodeError(err, meta)
+			tracked.Error(code, description, data, micro.WithHeaders(headers))
+		} else if !tracked.responded.Load() && unanswered.enabled() {
+			unanswered.handle(req)
 		}
 	})
 }
@@ -130,24 +295,24 @@ func wrapContextHandler(s *Service, handler ContextHandlerFunc) micro.HandlerFun
 // Note that this version does not support defining an endpoint in the initial config.
 // If any is defined, it will not use any of the context-based middlewares.
 func AddContextService(nc *nats.Conn, config micro.Config, fns ...ContextMiddlewareFunc) (*Service, error) {
+	tasks := newTasks(&config)
 	svc, err := micro.AddService(nc, config)
 	if err != nil {
+		tasks.cancel()
 		return nil, err
 	}
+	tasks.setErrorHandler(svc, config.ErrorHandler)
 
-	s := &Service{svc: svc, cmw: fns}
+	s := newService(nc, svc, tasks)
+	s.cmw = fns
 	return s, nil
 }
 
 // WithContextMiddleware adds middleware functions to the Microservice.
 func (s *Service) WithContextMiddleware(fns ...ContextMiddlewareFunc) *Service {
-	return &Service{
-		svc:        s.svc,
-		mw:         s.mw,
-		cmw:        append(s.cmw, fns...),
-		mmw:        s.mmw,
-		defaultCtx: s.defaultCtx,
-	}
+	ns := s.cloneChain()
+	ns.cmw = extend(s.cmw, fns...)
+	return ns
 }
 
 // UseContext is an alias for WithContextMiddleware, adding middleware functions to the Microservice.
@@ -155,44 +320,60 @@ func (s *Service) UseContext(fns ...ContextMiddlewareFunc) *Service {
 	return s.WithContextMiddleware(fns...)
 }
 
-func wrapMicroHandler(s *Service, handler MicroHandlerFunc) micro.HandlerFunc {
-	return micro.HandlerFunc(func(req micro.Request) {
-		// Use the default context if available, otherwise use background context
-		var ctx context.Context
-		if s.defaultCtx != nil {
-			ctx = s.defaultCtx
-		} else {
-			ctx = context.Background()
-		}
-
-		// ctxReq := &Request{req, ctx}
-		microReq := newMicroRequest(req, ctx)
-
-		// Wrap handler in middleware calls
-		var wrappedMicroHandler MicroHandlerFunc = handler
+// Like the context handler, the chain, default context and reply stamping
+// are snapshotted when the endpoint is registered.
+func wrapMicroHandler(s *Service, ep *endpointRef, handler MicroHandlerFunc) micro.HandlerFunc {
+	s = s.snapshot()
+	baseCtx := withErrorConverter(withConn(s.baseContext(), s.nc), s.errorConverter())
+	encodeError := s.errorReply()
+	recoverPanics, onPanic := s.recoverPanics, s.onPanic
+	stamping, unanswered := s.stamping, s.unanswered
+	tasks, timeout := s.tasks, s.handlerTimeout
+
+	// Wrap handler in middleware calls, recording each layer in the trace
+	// of debugged requests if enabled
+	var wrappedMicroHandler MicroHandlerFunc = handler
+	if s.debugChain != nil {
+		wrappedMicroHandler = tracedMicroChain(s.mmw, handler)
+	} else {
 		for i := len(s.mmw) - 1; i >= 0; i-- {
 			wrappedMicroHandler = s.mmw[i](wrappedMicroHandler)
 		}
+	}
+
+	return micro.HandlerFunc(func(req micro.Request) {
+		ctx, meta, cancel := inFlightContext(endpointContext(baseCtx, ep, req), req)
+		defer cancel()
+		out := micro.Request(req)
+		if trace := s.debugTrace(req); trace != nil {
+			ctx = withChainTrace(ctx, trace)
+			out = &debugRequest{req, trace}
+		}
+		microReq := newMicroRequest(req, ctx)
 
 		// Call the top-level handler
-		reply, err := wrappedMicroHandler(microReq)
+		reply, err := callWithTimeout(ctx, timeout, func(ctx context.Context) (reply *MicroReply, err error) {
+			err = callRecovered(recoverPanics, onPanic, req, func() error {
+				reply, err = wrappedMicroHandler(microReq.WithContext(ctx))
+				return err
+			})
+			return reply, err
+		})
+		if errors.Is(err, ErrHandlerTimeout) {
+			tasks.report(req.Subject(), ErrHandlerTimeout)
+		}
 
 		// If an error is encountered, respond with it automatically
 		if err != nil {
-			handlerErr, ok := err.(*HandlerError)
-			if !ok {
-				handlerErr = &HandlerError{
-					Description: err.Error(),
-					Code:        "500",
-				}
-			}
-
-			// Send the entire error in the body as well
-			errData, _ := json.Marshal(handlerErr)
-
-			req.Error(handlerErr.Code, handlerErr.Description, errData)
+			code, description, data, headers := encodeError(err, meta)
+			out.Error(code, description, data, micro.WithHeaders(headers))
+		} else if reply == nil {
+			unanswered.handle(req)
+		} else if code, description, ok := reply.ServiceError(); ok {
+			out.Error(code, description, reply.Data, micro.WithHeaders(reply.Headers))
 		} else {
-			req.Respond(reply.Data, micro.WithHeaders(reply.Headers))
+			reply.stamp(stamping)
+			out.Respond(reply.Data, micro.WithHeaders(reply.Headers))
 		}
 	})
 }
@@ -201,24 +382,24 @@ func wrapMicroHandler(s *Service, handler MicroHandlerFunc) micro.HandlerFunc {
 // Note that this version does not support defining an endpoint in the initial config.
 // If any is defined, it will not use any of the context-based middlewares.
 func AddMicroService(nc *nats.Conn, config micro.Config, fns ...MicroMiddlewareFunc) (*Service, error) {
+	tasks := newTasks(&config)
 	svc, err := micro.AddService(nc, config)
 	if err != nil {
+		tasks.cancel()
 		return nil, err
 	}
+	tasks.setErrorHandler(svc, config.ErrorHandler)
 
-	s := &Service{svc: svc, mmw: fns}
+	s := newService(nc, svc, tasks)
+	s.mmw = fns
 	return s, nil
 }
 
 // WithMicroMiddleware adds middleware functions to the Microservice.
 func (s *Service) WithMicroMiddleware(fns ...MicroMiddlewareFunc) *Service {
-	return &Service{
-		svc:        s.svc,
-		mw:         s.mw,
-		cmw:        s.cmw,
-		mmw:        append(s.mmw, fns...),
-		defaultCtx: s.defaultCtx,
-	}
+	ns := s.cloneChain()
+	ns.mmw = extend(s.mmw, fns...)
+	return ns
 }
 
 // UseMicro is an alias for WithMicroMiddleware, adding middleware functions to the Microservice.
@@ -229,29 +410,187 @@ func (s *Service) UseMicro(fns ...MicroMiddlewareFunc) *Service {
 // SetDefaultContext sets the default context to be used by the service.
 // This context will be used if no custom context is provided during endpoint registration.
 func (s *Service) SetDefaultContext(ctx context.Context) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.defaultCtx = ctx
 }
 
+// SetDefaultCodec sets the codec used by `Request.RespondValue` and
+// returned by `CodecFromContext`, e.g. a JSON codec with different
+// marshaling options. Defaults to `JSONCodec`.
+func (s *Service) SetDefaultCodec(codec Codec) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codec = codec
+}
+
+// SetInstanceSubjects enables registering every endpoint also on a subject
+// unique to this service instance. The instance subjects are advertised in the
+// endpoint metadata, allowing clients to route requests to a specific instance.
+func (s *Service) SetInstanceSubjects(enabled bool) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instanceSubjects = enabled
+}
+
+// InstanceSubject returns the subject unique to the service instance with
+// the ID for the endpoint subject, see [Service.SetInstanceSubjects].
+func InstanceSubject(id, subject string) string {
+	return instanceSubjectPrefix + "." + id + "." + subject
+}
+
+// SetErrorCodes sets the mapping of plain errors returned by handlers to
+// error codes. Errors without a match are sent as code "500".
+func (s *Service) SetErrorCodes(mappings ...ErrorCodeMapping) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorCodes = mappings
+}
+
+// WithErrorTransformer adds a transformer applied to handler errors before
+// they are encoded, e.g. to sanitize them.
+func (s *Service) WithErrorTransformer(fns ...ErrorTransformer) *Service {
+	ns := s.clone()
+	ns.errorTransforms = extend(s.errorTransforms, fns...)
+	return ns
+}
+
+// Build the conversion of handler errors with the error codes, default code
+// and transformers of the service
+func (s *Service) errorConverter() func(err error) *HandlerError {
+	mappings, transforms := s.errorCodes, s.errorTransforms
+	defaultCode := s.defaultErrorCode
+	if defaultCode == "" {
+		defaultCode = CodeInternal
+	}
+	return func(err error) *HandlerError {
+		handlerErr := toHandlerError(err, mappings, defaultCode)
+		for _, transform := range transforms {
+			handlerErr = transform(handlerErr)
+		}
+		return handlerErr
+	}
+}
+
+// Build the conversion of handler errors into the error reply
+func (s *Service) errorReply() func(err error, meta *errorMeta) (string, string, []byte, micro.Headers) {
+	convert, encoder := s.errorConverter(), s.errorEncoder
+	return func(err error, meta *errorMeta) (string, string, []byte, micro.Headers) {
+		// Added after the transformers, so sanitized errors keep the IDs
+		return encoder(meta.annotate(convert(err)))
+	}
+}
+
+// SetErrorEncoder sets how handler errors are serialized into error
+// replies, by default `JSONErrorEncoder`.
+func (s *Service) SetErrorEncoder(encoder ErrorEncoder) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorEncoder = encoder
+}
+
+// SetReplyStamping sets the extra headers added to every Micro reply.
+func (s *Service) SetReplyStamping(stamping ReplyStamping) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stamping = stamping
+}
+
+// Register the endpoint, or defer it until the service is started if there
+// are start hooks.
+func (s *Service) addEndpoint(add func(string, micro.Handler, ...micro.EndpointOpt) error, ref *endpointRef, handler micro.Handler, opts ...micro.EndpointOpt) error {
+	s.guard.registered.Store(true)
+	cfg := s.snapshot()
+	handler = echoHandler(cfg.echoHeaders, cfg.warmupHandler(cfg.maintenanceHandler(ref.name, handler)))
+	handler = normalizeHandler(cfg.headerNormalizer, handler)
+	handler = cfg.deadlineHandler(handler)
+	handler = cfg.shard.handler(handler, cfg.errorReply())
+	handler = respondErrorHandler(cfg.onRespondError, cfg.respondErrors, handler)
+	handler = cfg.inFlight.handler(ref.name, replyHints{cfg.tagHeaders, cfg.backpressure}, handler)
+	if ref.kind == EndpointWatch {
+		handler = goHandler(handler, cfg.maxWatches, cfg.errorReply())
+	}
+	deferred := s.startup.deferEndpoint(func() error {
+		return s.registerEndpoint(add, ref, handler, opts...)
+	})
+	if deferred {
+		return nil
+	}
+	return s.registerEndpoint(add, ref, handler, opts...)
+}
+
+// Register the endpoint and record the subject it was registered on. With
+// instance subjects enabled, also register it on a subject unique to this
+// service instance.
+func (s *Service) registerEndpoint(add func(string, micro.Handler, ...micro.EndpointOpt) error, ref *endpointRef, handler micro.Handler, opts ...micro.EndpointOpt) error {
+	s.guard.registering.Lock()
+	defer s.guard.registering.Unlock()
+
+	name := ref.name
+	known := len(s.svc.Info().Endpoints)
+	if err := add(name, handler, opts...); err != nil {
+		return err
+	}
+
+	// Look up the subject among the endpoints just registered, the variants
+	// of other priority tiers or versions have names of their own
+	info := s.svc.Info()
+	for i := len(info.Endpoints) - 1; i >= known; i-- {
+		ep := info.Endpoints[i]
+		if ep.Name != name {
+			continue
+		}
+
+		subject := ep.Subject
+		ref.subject.Store(&subject)
+
+		cfg := s.snapshot()
+		s.described.add(cfg.describeEndpoint(ref, ep), cfg.endpointWiring(ref))
+		if err := s.registerAliases(name, handler, ep, cfg.subjectAliases[ref.name]); err != nil {
+			return err
+		}
+		if !cfg.instanceSubjects {
+			return nil
+		}
+
+		metadata := copyMetadata(ep.Metadata)
+		metadata[MetadataInstanceOf] = ep.Subject
+
+		return s.svc.AddEndpoint(name+"-instance", handler,
+			micro.WithEndpointSubject(InstanceSubject(info.ID, ep.Subject)),
+			micro.WithEndpointMetadata(metadata))
+	}
+	return nil
+}
+
 // AddEndpoint registers an endpoint with the given name on a specific subject.
 func (s *Service) AddEndpoint(name string, handler micro.Handler, opts ...micro.EndpointOpt) error {
-	return s.svc.AddEndpoint(name, wrapHandler(handler, s.mw...), opts...)
+	return s.addEndpoint(s.endpointAdder(s.svc), &endpointRef{name: name, kind: EndpointRaw, handler: handlerName(handler), fn: handler}, s.snapshot().unanswered.track(wrapHandler(handler, s.mw...)), opts...)
 }
 
 // AddContextEndpoint registers an endpoint with the given name on a specific subject.
 func (s *Service) AddContextEndpoint(name string, handler ContextHandlerFunc, opts ...micro.EndpointOpt) error {
-	return s.svc.AddEndpoint(name, wrapContextHandler(s, handler), opts...)
+	ep := &endpointRef{name: name, kind: EndpointContext, handler: handlerName(handler), fn: handler}
+	return s.addEndpoint(s.endpointAdder(s.svc), ep, wrapContextHandler(s, ep, handler), opts...)
 }
 
 // AddMicroEndpoint registers an endpoint with the given name on a specific subject.
 func (s *Service) AddMicroEndpoint(name string, handler MicroHandlerFunc, opts ...micro.EndpointOpt) error {
-	return s.svc.AddEndpoint(name, wrapMicroHandler(s, handler), opts...)
+	ep := &endpointRef{name: name, kind: EndpointMicro, handler: handlerName(handler), fn: handler}
+	return s.addEndpoint(s.endpointAdder(s.svc), ep, wrapMicroHandler(s, ep, handler), opts...)
 }
 
 // AddGroup returns a Group interface, allowing for more complex endpoint topologies.
 // A group can be used to register endpoints with a given prefix.
 func (s *Service) AddGroup(name string, opts ...micro.GroupOpt) *Group {
 	grp := s.svc.AddGroup(name, opts...)
-	return &Group{s, grp}
+	return &Group{s, grp, name}
 }
 
 // Info returns the service info.
@@ -269,8 +608,10 @@ func (s *Service) Reset() {
 	s.svc.Reset()
 }
 
-// Stop drains the endpoint subscriptions and marks the service as stopped.
+// Stop drains the endpoint subscriptions, marks the service as stopped and
+// cancels the background tasks.
 func (s *Service) Stop() error {
+	defer s.tasks.cancel()
 	return s.svc.Stop()
 }
 
@@ -282,37 +623,64 @@ func (s *Service) Stopped() bool {
 // AddGroup creates a new group, prefixed by this group's prefix.
 func (g *Group) AddGroup(name string, opts ...micro.GroupOpt) *Group {
 	grp := g.grp.AddGroup(name, opts...)
-	return &Group{g.svc, grp}
+	prefix := name
+	if g.prefix != "" && name != "" {
+		prefix = g.prefix + "." + name
+	} else if name == "" {
+		prefix = g.prefix
+	}
+	return &Group{g.svc, grp, prefix}
 }
 
 // AddEndpoint registers new endpoints on a service.
 // The endpoint's subject will be prefixed with the group prefix.
 func (g *Group) AddEndpoint(name string, handler micro.Handler, opts ...micro.EndpointOpt) error {
-	return g.grp.AddEndpoint(name, wrapHandler(handler, g.svc.mw...), opts...)
+	ep := &endpointRef{name: name, kind: EndpointRaw, group: g.prefix, handler: handlerName(handler), fn: handler}
+	return g.svc.addEndpoint(g.svc.endpointAdder(g.grp), ep, g.svc.snapshot().unanswered.track(wrapHandler(handler, g.svc.mw...)), opts...)
 }
 
 // AddContextEndpoint registers an endpoint with the given name on a specific subject within a group.
 func (g *Group) AddContextEndpoint(name string, handler ContextHandlerFunc, opts ...micro.EndpointOpt) error {
-	return g.grp.AddEndpoint(name, wrapContextHandler(g.svc, handler), opts...)
+	ep := &endpointRef{name: name, kind: EndpointContext, group: g.prefix, handler: handlerName(handler), fn: handler}
+	return g.svc.addEndpoint(g.svc.endpointAdder(g.grp), ep, wrapContextHandler(g.svc, ep, handler), opts...)
 }
 
 // AddMicroEndpoint registers an endpoint with the given name on a specific subject within a group.
 func (g *Group) AddMicroEndpoint(name string, handler MicroHandlerFunc, opts ...micro.EndpointOpt) error {
-	return g.grp.AddEndpoint(name, wrapMicroHandler(g.svc, handler), opts...)
+	ep := &endpointRef{name: name, kind: EndpointMicro, group: g.prefix, handler: handlerName(handler), fn: handler}
+	return g.svc.addEndpoint(g.svc.endpointAdder(g.grp), ep, wrapMicroHandler(g.svc, ep, handler), opts...)
+}
+
+// WithDefaultContext returns a group whose endpoints use the given default
+// context instead of the one of the service, e.g. to carry tenant or
+// dependency specific values.
+func (g *Group) WithDefaultContext(ctx context.Context) *Group {
+	svc := g.svc.clone()
+	svc.defaultCtx = ctx
+	return &Group{svc, g.grp, g.prefix}
+}
+
+// WithErrorTransformer returns a group whose handler errors are also
+// transformed by the given functions, after those of the service.
+func (g *Group) WithErrorTransformer(fns ...ErrorTransformer) *Group {
+	svc := g.svc.clone()
+	svc.errorTransforms = extend(g.svc.errorTransforms, fns...)
+	return &Group{svc, g.grp, g.prefix}
+}
+
+// WithDefaultErrorCode returns a group that sends plain errors without a
+// mapped code with the given code instead of "500".
+func (g *Group) WithDefaultErrorCode(code string) *Group {
+	svc := g.svc.clone()
+	svc.defaultErrorCode = code
+	return &Group{svc, g.grp, g.prefix}
 }
 
 // WithMiddleware adds middleware functions to the Microservice group.
 func (g *Group) WithMiddleware(fns ...MiddlewareFunc) *Group {
-	return &Group{
-		svc: &Service{
-			svc:        g.svc.svc,
-			mw:         append(g.svc.mw, fns...),
-			cmw:        g.svc.cmw,
-			mmw:        g.svc.mmw,
-			defaultCtx: g.svc.defaultCtx,
-		},
-		grp: g.grp,
-	}
+	svc := g.svc.cloneChain()
+	svc.mw = extend(g.svc.mw, fns...)
+	return &Group{svc, g.grp, g.prefix}
 }
 
 // Use is an alias for WithMiddleware, adding middleware functions to the Microservice group.
@@ -322,16 +690,9 @@ func (g *Group) Use(fns ...MiddlewareFunc) *Group {
 
 // WithContextMiddleware adds context middleware functions to the Microservice group.
 func (g *Group) WithContextMiddleware(fns ...ContextMiddlewareFunc) *Group {
-	return &Group{
-		svc: &Service{
-			svc:        g.svc.svc,
-			mw:         g.svc.mw,
-			cmw:        append(g.svc.cmw, fns...),
-			mmw:        g.svc.mmw,
-			defaultCtx: g.svc.defaultCtx,
-		},
-		grp: g.grp,
-	}
+	svc := g.svc.cloneChain()
+	svc.cmw = extend(g.svc.cmw, fns...)
+	return &Group{svc, g.grp, g.prefix}
 }
 
 // UseContext is an alias for WithContextMiddleware, adding context middleware functions to the Microservice group.
@@ -341,16 +702,9 @@ func (g *Group) UseContext(fns ...ContextMiddlewareFunc) *Group {
 
 // WithMicroMiddleware adds Micro middleware functions to the Microservice group.
 func (g *Group) WithMicroMiddleware(fns ...MicroMiddlewareFunc) *Group {
-	return &Group{
-		svc: &Service{
-			svc:        g.svc.svc,
-			mw:         g.svc.mw,
-			cmw:        g.svc.cmw,
-			mmw:        append(g.svc.mmw, fns...),
-			defaultCtx: g.svc.defaultCtx,
-		},
-		grp: g.grp,
-	}
+	svc := g.svc.cloneChain()
+	svc.mmw = extend(g.svc.mmw, fns...)
+	return &Group{svc, g.grp, g.prefix}
 }
 
 // UseMicro is an alias for WithMicroMiddleware, adding Micro middleware functions to the Microservice group.
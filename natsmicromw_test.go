@@ -3,7 +3,12 @@ package natsmicromw
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -398,3 +403,687 @@ func TestError(t *testing.T) {
 		}
 	})
 }
+
+func TestMicroError(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	err := nm.AddMicroEndpoint("foo", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroError("422", "order rejected", []byte("raw details"), micro.Headers{"order-id": []string{"42"}}), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reply, err := nc.Request("foo", nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reply.Header.Get(micro.ErrorCodeHeader) != "422" || reply.Header.Get(micro.ErrorHeader) != "order rejected" {
+		t.Errorf("unexpected error headers %v", reply.Header)
+	}
+	if reply.Header.Get("order-id") != "42" || string(reply.Data) != "raw details" {
+		t.Errorf("expected the headers and data as they are, received %v %q", reply.Header, reply.Data)
+	}
+
+	clone := NewMicroError("500", "", nil, nil).Clone()
+	if code, description, ok := clone.ServiceError(); !ok || code != "500" || description != "500" {
+		t.Errorf("unexpected service error %s %s %v", code, description, ok)
+	}
+	if _, _, ok := NewMicroReply(nil).ServiceError(); ok {
+		t.Errorf("expected no service error")
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	// Create test server and client
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	err := nm.AddContextEndpoint("foo", func(req *Request) error {
+		return NewValidationError("invalid request").
+			WithField("name", "must not be empty").
+			WithMeta("rule", "required")
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	reply, err := nc.Request("foo", []byte("data"), 1*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	handlerErr := HandlerErrorFromMsg(reply)
+	if handlerErr == nil {
+		t.Fatalf("expected an error reply")
+	}
+	if handlerErr.Code != "400" {
+		t.Errorf("error code does not match, expected %s, got %s", "400", handlerErr.Code)
+	}
+	if len(handlerErr.Fields) != 1 || handlerErr.Fields[0].Field != "name" {
+		t.Errorf("unexpected field errors: %v", handlerErr.Fields)
+	}
+	if handlerErr.Meta["rule"] != "required" {
+		t.Errorf("unexpected error metadata: %v", handlerErr.Meta)
+	}
+}
+
+func TestTypedHandlerAndStamping(t *testing.T) {
+	type sumRequest struct {
+		A int `json:"a"`
+		B int `json:"b"`
+	}
+	type sumReply struct {
+		Sum int `json:"sum"`
+	}
+
+	// Create test server and client
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	nm.SetReplyStamping(ReplyStamping{ContentLength: true, Checksum: true})
+	err := nm.AddMicroEndpoint("sum", TypedHandler(JSONCodec{}, func(ctx context.Context, req sumRequest) (sumReply, error) {
+		return sumReply{Sum: req.A + req.B}, nil
+	}))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	t.Run("typed reply", func(t *testing.T) {
+		reply, err := nc.Request("sum", []byte(`{"a":1,"b":2}`), 1*time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []byte(`{"sum":3}`)
+		if !bytes.Equal(expected, reply.Data) {
+			t.Errorf("responses do not match, expected %s, received %s", string(expected), string(reply.Data))
+		}
+		if ct := reply.Header.Get(HeaderContentType); ct != "application/json" {
+			t.Errorf("content type does not match, expected %s, got %s", "application/json", ct)
+		}
+		if cl := reply.Header.Get(HeaderContentLength); cl != "9" {
+			t.Errorf("content length does not match, expected %s, got %s", "9", cl)
+		}
+		if reply.Header.Get(HeaderContentSHA256) == "" {
+			t.Errorf("checksum header missing")
+		}
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		reply, err := nc.Request("sum", []byte(`not json`), 1*time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != "400" {
+			t.Errorf("expected a 400 error, got %v", handlerErr)
+		}
+	})
+}
+
+// JSON codec with indented output
+type indentCodec struct {
+	JSONCodec
+}
+
+func (indentCodec) Encode(v any) ([]byte, error) {
+	return json.MarshalIndent(v, "", "  ")
+}
+
+func (indentCodec) ContentType() string {
+	return "application/json; indent=2"
+}
+
+func TestRespondWithCodec(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	value := map[string]int{"a": 1}
+	nm.SetDefaultCodec(indentCodec{})
+	if err := nm.AddContextEndpoint("default", func(req *Request) error {
+		return req.RespondValue(value)
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddContextEndpoint("explicit", func(req *Request) error {
+		return req.RespondWith(JSONCodec{}, value)
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[string]string{
+		"default":  "{\n  \"a\": 1\n}",
+		"explicit": `{"a":1}`,
+	}
+	for subject, expected := range tests {
+		reply, err := nc.Request(subject, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(reply.Data) != expected {
+			t.Errorf("reply to %s does not match, expected %q, received %q", subject, expected, string(reply.Data))
+		}
+		if reply.Header.Get(HeaderContentType) == "" {
+			t.Errorf("reply to %s has no content type", subject)
+		}
+	}
+}
+
+func TestRequestWithRespondOpts(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	nm = nm.UseContext(func(next ContextHandlerFunc) ContextHandlerFunc {
+		return func(req *Request) error {
+			return next(req.WithRespondOpts(func(m *nats.Msg) {
+				m.Header.Set("stamped", "true")
+			}))
+		}
+	})
+	if err := nm.AddContextEndpoint("foo", func(req *Request) error {
+		return req.Respond(nil, micro.WithHeaders(micro.Headers{"own": []string{"true"}}))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reply, err := nc.Request("foo", nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reply.Header.Get("stamped") != "true" || reply.Header.Get("own") != "true" {
+		t.Errorf("unexpected headers: %v", reply.Header)
+	}
+}
+
+func TestEndpointFromContext(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	grp := nm.AddGroup("orders")
+	err := grp.AddMicroEndpoint("get", func(req *MicroRequest) (*MicroReply, error) {
+		ctx := req.Context()
+		return NewMicroReply([]byte(EndpointNameFromContext(ctx) + " " + EndpointSubjectFromContext(ctx))), nil
+	}, micro.WithEndpointSubject("get.*"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reply, err := nc.Request("orders.get.42", nil, 1*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "get orders.get.*"
+	if string(reply.Data) != expected {
+		t.Errorf("endpoint does not match, expected %s, received %s", expected, string(reply.Data))
+	}
+}
+
+func TestEndpointSubjectConcurrentRegistration(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer nc.Close()
+	defer s.Shutdown()
+
+	// Endpoints with the same name in different groups, registered at once
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := nm.AddGroup(fmt.Sprintf("g%d", i)).AddMicroEndpoint("get", func(req *MicroRequest) (*MicroReply, error) {
+				return NewMicroReply([]byte(EndpointSubjectFromContext(req.Context()))), nil
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 20; i++ {
+		expected := fmt.Sprintf("g%d.get", i)
+		reply, err := nc.Request(expected, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(reply.Data) != expected {
+			t.Errorf("endpoint subject does not match, expected %s, received %s", expected, reply.Data)
+		}
+	}
+}
+
+func TestBackgroundTasks(t *testing.T) {
+	s := getServer(t)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	errs := make(chan string, 1)
+	nm, err := AddService(nc, micro.Config{
+		Name:    "TestService",
+		Version: "1.0.0",
+		ErrorHandler: func(svc micro.Service, natsErr *micro.NATSError) {
+			errs <- natsErr.Description
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+
+	nm.Go(func(ctx context.Context) error {
+		return errors.New("refresh failed")
+	})
+	select {
+	case desc := <-errs:
+		if desc != "refresh failed" {
+			t.Errorf("error does not match, expected %s, received %s", "refresh failed", desc)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task error was not reported")
+	}
+
+	// Tasks are cancelled on stop, without reporting the cancellation
+	nm.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := nm.Stop(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nm.Wait()
+	select {
+	case desc := <-errs:
+		t.Errorf("unexpected error reported: %s", desc)
+	default:
+	}
+}
+
+func TestGroupDefaultContext(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	type tenantKey struct{}
+	nm.SetDefaultContext(context.WithValue(context.Background(), tenantKey{}, "default"))
+	handler := func(req *Request) error {
+		tenant, _ := req.Context().Value(tenantKey{}).(string)
+		return req.Respond([]byte(tenant))
+	}
+
+	grp := nm.AddGroup("acme").WithDefaultContext(context.WithValue(context.Background(), tenantKey{}, "acme"))
+	if err := grp.AddContextEndpoint("tenant", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddContextEndpoint("tenant", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for subject, expected := range map[string]string{"acme.tenant": "acme", "tenant": "default"} {
+		msg, err := nc.Request(subject, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg.Data) != expected {
+			t.Errorf("context does not match, expected %s, received %s", expected, string(msg.Data))
+		}
+	}
+}
+
+func TestChainSnapshot(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	type tagKey string
+	tag := func(name string) ContextMiddlewareFunc {
+		return func(next ContextHandlerFunc) ContextHandlerFunc {
+			return func(req *Request) error {
+				return next(req.WithContext(context.WithValue(req.Context(), tagKey(name), true)))
+			}
+		}
+	}
+	handler := func(req *Request) error {
+		var tags string
+		for _, name := range []string{"base", "a", "b", "late"} {
+			if req.Context().Value(tagKey(name)) != nil {
+				tags += name + " "
+			}
+		}
+		return req.Respond([]byte(tags))
+	}
+
+	// Sibling clones must not share the chain of their parent
+	base := nm.UseContext(tag("base"), tag("base"), tag("base"))
+	base = base.UseContext(tag("base"))
+	a := base.UseContext(tag("a"))
+	b := base.UseContext(tag("b"))
+	if err := a.AddContextEndpoint("a", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.AddContextEndpoint("b", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Later changes do not affect registered endpoints
+	a.SetDefaultContext(context.WithValue(context.Background(), tagKey("late"), true))
+
+	for subject, expected := range map[string]string{"a": "base a ", "b": "base b "} {
+		msg, err := nc.Request(subject, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg.Data) != expected {
+			t.Errorf("chain does not match, expected %q, received %q", expected, string(msg.Data))
+		}
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	s, nm := getServerAndService(t)
+	defer s.Shutdown()
+
+	grp := nm.AddGroup("grp")
+	nm.Freeze()
+
+	for name, fn := range map[string]func(){
+		"Use":                func() { nm.Use() },
+		"UseContext":         func() { nm.UseContext() },
+		"SetDefaultContext":  func() { nm.SetDefaultContext(context.Background()) },
+		"Group.UseMicro":     func() { grp.UseMicro() },
+		"WithDefaultContext": func() { grp.WithDefaultContext(context.Background()) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic on a frozen service", name)
+				}
+			}()
+			fn()
+		}()
+	}
+
+	// Endpoints can still be registered
+	if err := grp.AddContextEndpoint("ok", emptyRequestHandler); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConcurrentConfiguration(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	noop := func(next ContextHandlerFunc) ContextHandlerFunc {
+		return next
+	}
+	grp := nm.AddGroup("grp")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("ep%d", i)
+			nm.SetDefaultContext(context.Background())
+			nm.SetReplyStamping(ReplyStamping{ContentLength: true})
+			if err := nm.UseContext(noop).AddContextEndpoint(name, emptyRequestHandler); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err := grp.UseContext(noop).AddContextEndpoint(name, emptyRequestHandler); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err := nm.AddMicroEndpoint(name+"m", func(req *MicroRequest) (*MicroReply, error) {
+				return NewMicroReply(nil), nil
+			}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if endpoints := len(nm.Info().Endpoints); endpoints != 30 {
+		t.Errorf("endpoint count does not match, expected %d, received %d", 30, endpoints)
+	}
+}
+
+func TestStrictChain(t *testing.T) {
+	s, nm := getServerAndService(t)
+	defer s.Shutdown()
+
+	noop := func(next ContextHandlerFunc) ContextHandlerFunc {
+		return next
+	}
+	nm = nm.WithStrictChain().UseContext(noop)
+	grp := nm.AddGroup("grp").UseContext(noop)
+	if err := grp.AddContextEndpoint("ep", emptyRequestHandler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, fn := range map[string]func(){
+		"UseContext":       func() { nm.UseContext(noop) },
+		"Group.UseContext": func() { grp.UseContext(noop) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic after endpoints were registered", name)
+				}
+			}()
+			fn()
+		}()
+	}
+
+	// Other settings are still allowed
+	nm.SetDefaultContext(context.Background())
+}
+
+func TestMicroRequestBody(t *testing.T) {
+	req := (&MicroRequest{Data: []byte("data")}).WithContext(context.Background())
+	if data, _ := io.ReadAll(req.Body()); string(data) != "data" {
+		t.Errorf("body does not match, expected %s, received %s", "data", string(data))
+	}
+
+	streamed := req.WithBody(strings.NewReader("streamed"))
+	if !streamed.Streamed() || streamed.Data != nil {
+		t.Fatalf("payload is not streamed")
+	}
+	data, err := streamed.ReadData()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "streamed" || string(streamed.Data) != "streamed" || streamed.Streamed() {
+		t.Errorf("payload was not read into data: %s", string(streamed.Data))
+	}
+	if string(req.Data) != "data" {
+		t.Errorf("original request was modified: %s", string(req.Data))
+	}
+}
+
+func TestRequestToMsg(t *testing.T) {
+	req := &MicroRequest{Subject: "foo", Reply: "_INBOX.1", Headers: micro.Headers{"key": []string{"value"}}, Data: []byte("data")}
+	msg := req.ToMsg()
+	if msg.Subject != "foo" || msg.Reply != "_INBOX.1" || msg.Header.Get("key") != "value" || string(msg.Data) != "data" {
+		t.Fatalf("message does not match the request: %+v", msg)
+	}
+
+	msg.Header.Set("key", "changed")
+	msg.Data[0] = 'D'
+	if req.HeaderGet("key") != "value" || string(req.Data) != "data" {
+		t.Errorf("request was modified through the message")
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	errNotFound := errors.New("not found")
+	nm.SetErrorCodes(append(DefaultErrorCodes, MapError(errNotFound, CodeNotFound))...)
+
+	handlers := map[string]ContextHandlerFunc{
+		"timeout": func(req *Request) error {
+			return fmt.Errorf("query failed: %w", context.DeadlineExceeded)
+		},
+		"missing": func(req *Request) error {
+			return errNotFound
+		},
+		"wrapped": func(req *Request) error {
+			return fmt.Errorf("lookup: %w", Errorf(CodeConflict, "order %d exists", 42))
+		},
+		"plain": func(req *Request) error {
+			return errors.New("failed")
+		},
+	}
+	expected := map[string]string{"timeout": "504", "missing": "404", "wrapped": "409", "plain": "500"}
+	// Middleware sees the codes of the service mapping
+	var mu sync.Mutex
+	recorded := make(map[string]string)
+	recorder := func(next ContextHandlerFunc) ContextHandlerFunc {
+		return func(req *Request) error {
+			err := next(req)
+			mu.Lock()
+			recorded[req.Subject()] = ContextErrorCode(req.Context(), err)
+			mu.Unlock()
+			return err
+		}
+	}
+	for name, handler := range handlers {
+		if err := nm.UseContext(recorder).AddContextEndpoint(name, handler); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for name, code := range expected {
+		msg, err := nc.Request(name, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if received := msg.Header.Get(micro.ErrorCodeHeader); received != code {
+			t.Errorf("%s error code does not match, expected %s, received %s", name, code, received)
+		}
+		mu.Lock()
+		if received := recorded[name]; received != code {
+			t.Errorf("%s middleware error code does not match, expected %s, received %s", name, code, received)
+		}
+		mu.Unlock()
+	}
+
+	err := Errorf(CodeTimeout, "query failed: %w", context.DeadlineExceeded)
+	if !errors.Is(err, context.DeadlineExceeded) || err.Description != "query failed: context deadline exceeded" {
+		t.Errorf("error does not wrap the cause: %v", err)
+	}
+	// All wrapped errors are kept
+	err = Errorf(CodeUnavailable, "%w: %w", ErrHandlerTimeout, context.Canceled)
+	if !errors.Is(err, ErrHandlerTimeout) || !errors.Is(err, context.Canceled) {
+		t.Errorf("error does not wrap both causes: %v", err)
+	}
+	if err := Errorf(CodeBadRequest, "invalid %s", "order"); err.Unwrap() != nil {
+		t.Errorf("unexpected cause: %v", err.Unwrap())
+	}
+	if code := ErrorCode(fmt.Errorf("query: %w", context.DeadlineExceeded)); code != CodeTimeout {
+		t.Errorf("error code does not match, expected %s, received %s", CodeTimeout, code)
+	}
+}
+
+func TestErrorEncoder(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	failing := func(req *Request) error {
+		return NewValidationError("invalid order").WithField("id", "required")
+	}
+	empty := nm.WithContextMiddleware()
+	empty.SetErrorEncoder(EmptyErrorEncoder)
+	if err := empty.AddContextEndpoint("empty", failing); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	problem := nm.WithContextMiddleware()
+	problem.SetErrorEncoder(ProblemJSONErrorEncoder)
+	if err := problem.AddContextEndpoint("problem", failing); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg, err := nc.Request("empty", nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(msg.Data) != 0 || msg.Header.Get(micro.ErrorCodeHeader) != CodeBadRequest {
+		t.Errorf("unexpected error reply: %v %s", msg.Header, string(msg.Data))
+	}
+
+	msg, err = nc.Request("problem", nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if contentType := msg.Header.Get(HeaderContentType); contentType != "application/problem+json" {
+		t.Errorf("content type does not match, expected %s, received %s", "application/problem+json", contentType)
+	}
+	expected := `{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid order","fields":[{"field":"id","message":"required"}]}`
+	if string(msg.Data) != expected {
+		t.Errorf("body does not match, expected %s, received %s", expected, string(msg.Data))
+	}
+}
+
+func TestGroupErrorTransformer(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	failing := func(req *Request) error {
+		return errors.New("database password expired")
+	}
+	invalid := func(req *Request) error {
+		return NewValidationError("invalid order")
+	}
+
+	nm = nm.WithErrorTransformer(func(err *HandlerError) *HandlerError {
+		out := *err
+		out.Meta = map[string]string{"service": "orders"}
+		return &out
+	})
+	public := nm.AddGroup("public").WithErrorTransformer(SanitizeErrors)
+	admin := nm.AddGroup("admin").WithDefaultErrorCode(CodeUnavailable)
+	for _, err := range []error{
+		public.AddContextEndpoint("fail", failing),
+		public.AddContextEndpoint("invalid", invalid),
+		admin.AddContextEndpoint("fail", failing),
+	} {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		subject     string
+		code        string
+		description string
+	}{
+		{"public.fail", CodeInternal, "internal error"},
+		{"public.invalid", CodeBadRequest, "invalid order"},
+		{"admin.fail", CodeUnavailable, "database password expired"},
+	}
+	for _, test := range tests {
+		msg, err := nc.Request(test.subject, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		handlerErr := HandlerErrorFromMsg(msg)
+		if handlerErr.Code != test.code || handlerErr.Description != test.description {
+			t.Errorf("%s error does not match, expected %s %s, received %s %s", test.subject, test.code, test.description, handlerErr.Code, handlerErr.Description)
+		}
+		if test.subject != "public.fail" && handlerErr.Meta["service"] != "orders" {
+			t.Errorf("%s error was not transformed by the service", test.subject)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package natsmicromw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ErrOpsAuthorizer is returned when enabling an operations endpoint, such
+// as the chain benchmark, without an authorizer.
+var ErrOpsAuthorizer = errors.New("natsmicromw: operations endpoint requires an authorizer")
+
+// Subscribe a hidden operations endpoint on "<prefix>.<service name>.<instance ID>",
+// not listed in the service info, serving the requests passing the
+// authorizer until the service stops. Returns the subject.
+func (s *Service) subscribeOps(prefix string, authorize DebugChainAuthorizer, handle func(req *msgRequest)) (string, error) {
+	if authorize == nil {
+		return "", ErrOpsAuthorizer
+	}
+	info := s.svc.Info()
+	subject := prefix + "." + info.Name + "." + info.ID
+
+	sub, err := s.nc.Subscribe(subject, func(msg *nats.Msg) {
+		req := &msgRequest{msg}
+		if !authorize(req) {
+			_ = req.Error(CodeForbidden, "not authorized", nil)
+			return
+		}
+		handle(req)
+	})
+	if err != nil {
+		return "", err
+	}
+	s.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		_ = sub.Unsubscribe()
+		return nil
+	})
+	return subject, nil
+}
+
+// Request received on a plain subscription
+type msgRequest struct {
+	msg *nats.Msg
+}
+
+func (r *msgRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	reply := &nats.Msg{Data: data}
+	for _, opt := range opts {
+		opt(reply)
+	}
+	return r.msg.RespondMsg(reply)
+}
+
+func (r *msgRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return micro.ErrMarshalResponse
+	}
+	return r.Respond(data, opts...)
+}
+
+func (r *msgRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	reply := &nats.Msg{Header: nats.Header{}}
+	for _, opt := range opts {
+		opt(reply)
+	}
+	reply.Header.Set(micro.ErrorCodeHeader, code)
+	reply.Header.Set(micro.ErrorHeader, description)
+	reply.Data = data
+	return r.msg.RespondMsg(reply)
+}
+
+func (r *msgRequest) Data() []byte { return r.msg.Data }
+
+func (r *msgRequest) Headers() micro.Headers { return micro.Headers(r.msg.Header) }
+
+func (r *msgRequest) Subject() string { return r.msg.Subject }
+
+func (r *msgRequest) Reply() string { return r.msg.Reply }
@@ -0,0 +1,48 @@
+package natsmicromw
+
+import (
+	"errors"
+	"runtime/debug"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ErrPanic is the cause of the error sent when a handler panics.
+var ErrPanic = errors.New("handler panicked")
+
+// PanicHandler is called with the recovered value and the stack trace when
+// a handler panics, e.g. to log or report it.
+type PanicHandler func(req micro.Request, recovered any, stack []byte)
+
+// SetRecoverPanics enables recovering from panics in context and Micro
+// handlers, including their middleware. A panic is turned into a "500"
+// error reply instead of killing the subscription callback.
+func (s *Service) SetRecoverPanics(enabled bool) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recoverPanics = enabled
+}
+
+// OnPanic sets the hook called for recovered panics, see [SetRecoverPanics].
+func (s *Service) OnPanic(handler PanicHandler) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onPanic = handler
+}
+
+// Call the handler, converting a panic into an error if enabled
+func callRecovered(enabled bool, onPanic PanicHandler, req micro.Request, fn func() error) (err error) {
+	if enabled {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if onPanic != nil {
+					onPanic(req, recovered, debug.Stack())
+				}
+				err = Errorf(CodeInternal, "%w", ErrPanic)
+			}
+		}()
+	}
+	return fn()
+}
@@ -0,0 +1,49 @@
+package natsmicromw
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	panics := make(chan any, 2)
+	nm.SetRecoverPanics(true)
+	nm.OnPanic(func(req micro.Request, recovered any, stack []byte) {
+		if !bytes.Contains(stack, []byte("panic_test.go")) {
+			t.Errorf("stack does not contain the handler: %s", stack)
+		}
+		panics <- recovered
+	})
+
+	if err := nm.AddContextEndpoint("context", func(req *Request) error {
+		panic("context handler")
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddMicroEndpoint("micro", func(req *MicroRequest) (*MicroReply, error) {
+		panic("micro handler")
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, subject := range []string{"context", "micro"} {
+		msg, err := nc.Request(subject, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		handlerErr := HandlerErrorFromMsg(msg)
+		if handlerErr == nil || handlerErr.Code != CodeInternal || handlerErr.Description != ErrPanic.Error() {
+			t.Errorf("unexpected reply: %v", handlerErr)
+		}
+		if recovered := <-panics; recovered != subject+" handler" {
+			t.Errorf("recovered value does not match, expected %s, received %v", subject+" handler", recovered)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package natsmicromw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ErrPriorityTierBusy is the description of the reply to requests rejected
+// because all workers of their tier are busy and its queue is full.
+var ErrPriorityTierBusy = errors.New("priority tier busy")
+
+const workersSubjectPrefix = "_WORKERS"
+
+// PriorityTier is a subject tier an endpoint is registered on, with its own
+// pool of workers, see [Service.WithPriorityTiers].
+type PriorityTier struct {
+	// Name is the subject token of the tier, e.g. "high". The tier with an
+	// empty name is served on the subject of the endpoint itself.
+	Name string
+	// Workers is the number of requests of the tier handled concurrently,
+	// 1 if zero
+	Workers int
+	// WorkersPerCPU sizes the pool to this many workers per CPU available to
+	// the process, see [AvailableCPUs], instead of Workers, so containers
+	// with small CPU limits do not over-provision goroutines
+	WorkersPerCPU int
+	// Queue is the number of requests waiting for a free worker, beyond
+	// which requests are rejected with a 503 error. With zero, requests are
+	// rejected as soon as all workers are busy.
+	Queue int
+}
+
+// WorkerPoolStats is the sizing and load of the worker pool of a priority
+// tier, see [Service.WorkerPools].
+type WorkerPoolStats struct {
+	Tier    string `json:"tier"`
+	Workers int    `json:"workers"`
+	Queue   int    `json:"queue"`
+	// Requests being handled
+	Busy int `json:"busy"`
+	// Requests waiting for a free worker
+	Waiting int `json:"waiting"`
+}
+
+// Worker pool of a tier, shared by all endpoints registered on it
+type priorityPool struct {
+	tier PriorityTier
+
+	mu      sync.Mutex
+	free    *sync.Cond
+	busy    int
+	pending int
+}
+
+func newPriorityPool(tier PriorityTier) *priorityPool {
+	if tier.WorkersPerCPU > 0 {
+		tier.Workers = tier.WorkersPerCPU * AvailableCPUs()
+	}
+	if tier.Workers <= 0 {
+		tier.Workers = 1
+	}
+	if tier.Queue < 0 {
+		tier.Queue = 0
+	}
+	p := &priorityPool{tier: tier}
+	p.free = sync.NewCond(&p.mu)
+	return p
+}
+
+// Wrap the handler to run requests on the workers of the tier, rejecting
+// them when the queue is full
+func (p *priorityPool) handler(handler micro.Handler, encodeError func(error, *errorMeta) (string, string, []byte, micro.Headers)) micro.Handler {
+	return micro.HandlerFunc(func(req micro.Request) {
+		p.mu.Lock()
+		if p.pending >= p.tier.Workers+p.tier.Queue {
+			p.mu.Unlock()
+			code, description, data, headers := encodeError(&HandlerError{
+				Description: ErrPriorityTierBusy.Error(),
+				Code:        CodeUnavailable,
+				cause:       ErrPriorityTierBusy,
+			}, new(errorMeta))
+			_ = req.Error(code, description, data, micro.WithHeaders(headers))
+			return
+		}
+		p.pending++
+		p.mu.Unlock()
+
+		go func() {
+			p.acquire()
+			defer p.release()
+			handler.Handle(&tierRequest{req, p.tier.Name, p})
+		}()
+	})
+}
+
+// Run the handler on a worker of the tier the request was received on, if
+// any, for requests resumed after the worker that received them was freed
+func runOnWorker(req micro.Request, handler micro.Handler) {
+	for r := req; r != nil; {
+		switch tr := r.(type) {
+		case *tierRequest:
+			p := tr.pool
+			p.mu.Lock()
+			p.pending++
+			p.mu.Unlock()
+			p.acquire()
+			defer p.release()
+			r = nil
+		case wrappedRequest:
+			r = tr.unwrapRequest()
+		default:
+			r = nil
+		}
+	}
+	handler.Handle(req)
+}
+
+// Wait for a free worker
+func (p *priorityPool) acquire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.busy >= p.tier.Workers {
+		p.free.Wait()
+	}
+	p.busy++
+}
+
+func (p *priorityPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy--
+	p.pending--
+	p.free.Signal()
+}
+
+// Change the number of workers. Requests being handled beyond a smaller
+// size finish normally.
+func (p *priorityPool) resize(workers int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tier.Workers = workers
+	p.free.Broadcast()
+}
+
+// Share of the workers and queue of the pool in use
+func (p *priorityPool) load() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return float64(p.pending) / float64(p.tier.Workers+p.tier.Queue)
+}
+
+func (p *priorityPool) stats() WorkerPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return WorkerPoolStats{
+		Tier:    p.tier.Name,
+		Workers: p.tier.Workers,
+		Queue:   p.tier.Queue,
+		Busy:    p.busy,
+		Waiting: p.pending - p.busy,
+	}
+}
+
+// Worker pools of all priority tiers of a service and its clones
+type workerPools struct {
+	mu    sync.Mutex
+	pools []*priorityPool
+}
+
+func (w *workerPools) add(pools ...*priorityPool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pools = append(w.pools, pools...)
+}
+
+func (w *workerPools) list() []*priorityPool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]*priorityPool(nil), w.pools...)
+}
+
+// Request received on a priority tier
+type tierRequest struct {
+	micro.Request
+	tier string
+	pool *priorityPool
+}
+
+type priorityTierContextKey struct{}
+
+// PriorityTierFromContext returns the name of the priority tier the request
+// was received on, empty if it was not received on a named tier.
+func PriorityTierFromContext(ctx context.Context) string {
+	tier, _ := ctx.Value(priorityTierContextKey{}).(string)
+	return tier
+}
+
+// WithPriorityTiers returns a service whose endpoints are registered once per
+// tier, on the subject of the endpoint with the name of the tier added
+// before the endpoint token, e.g. "svc.high.orders" and "svc.low.orders" for
+// the endpoint "orders" of the group "svc". Each tier has its own pool of
+// workers, shared by all endpoints registered through the returned service,
+// so a flood of low priority requests does not delay high priority ones.
+// Clients choose the tier with `client.WithPriority`. The first tier is
+// registered under the name of the endpoint and the others under the name
+// followed by "-<tier>".
+//
+//	tiered := srv.WithPriorityTiers(
+//		natsmicromw.PriorityTier{Name: "high", Workers: 8, Queue: 100},
+//		natsmicromw.PriorityTier{Name: "low", Workers: 2, Queue: 10},
+//	)
+//	tiered.AddGroup("svc").AddMicroEndpoint("orders", handler)
+func (s *Service) WithPriorityTiers(tiers ...PriorityTier) *Service {
+	ns := s.clone()
+	ns.priorityPools = make([]*priorityPool, len(tiers))
+	for i, tier := range tiers {
+		ns.priorityPools[i] = newPriorityPool(tier)
+	}
+	s.pools.add(ns.priorityPools...)
+	return ns
+}
+
+// WithPriorityTiers returns a group whose endpoints are registered once per
+// tier, see [Service.WithPriorityTiers].
+func (g *Group) WithPriorityTiers(tiers ...PriorityTier) *Group {
+	return &Group{g.svc.WithPriorityTiers(tiers...), g.grp, g.prefix}
+}
+
+// Registration function registering the endpoint on every tier
+func (s *Service) tieredAdder(parent endpointParent) func(string, micro.Handler, ...micro.EndpointOpt) error {
+	pools, encodeError := s.priorityPools, s.errorReply()
+	return func(name string, handler micro.Handler, opts ...micro.EndpointOpt) error {
+		for i, pool := range pools {
+			tierParent, tierName, tierOpts := parent, name, opts
+			if pool.tier.Name != "" {
+				tierParent = parent.AddGroup(pool.tier.Name)
+			}
+			if i > 0 {
+				tierName = name + "-" + pool.tier.Name
+				if pool.tier.Name == "" {
+					tierName = name + "-default"
+				}
+				// Keep the subject of the endpoint, which defaults to its name
+				tierOpts = append([]micro.EndpointOpt{micro.WithEndpointSubject(name)}, opts...)
+			}
+			if err := tierParent.AddEndpoint(tierName, pool.handler(handler, encodeError), tierOpts...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// PrioritySubject returns the subject of the tier for an endpoint subject, by
+// adding the tier before its last token. Endpoints registered with a custom
+// subject of several tokens need the tier added in their own way.
+func PrioritySubject(subject, tier string) string {
+	if tier == "" {
+		return subject
+	}
+	i := strings.LastIndexByte(subject, '.')
+	if i < 0 {
+		return tier + "." + subject
+	}
+	return subject[:i+1] + tier + subject[i:]
+}
+
+// WorkerPools returns the sizing and load of the worker pools of the
+// priority tiers of the service and its clones, in the order they were
+// created.
+func (s *Service) WorkerPools() []WorkerPoolStats {
+	pools := s.pools.list()
+	stats := make([]WorkerPoolStats, len(pools))
+	for i, pool := range pools {
+		stats[i] = pool.stats()
+	}
+	return stats
+}
+
+// ResizeWorkers changes the number of workers of the pools of the tier, 1
+// if zero or less, while the service is running, e.g. after the CPU limits
+// of the container changed. Requests already being handled finish normally.
+// Returns the number of pools resized, which is more than one if several
+// sets of tiers use the same tier name.
+func (s *Service) ResizeWorkers(tier string, workers int) int {
+	if workers <= 0 {
+		workers = 1
+	}
+	var resized int
+	for _, pool := range s.pools.list() {
+		if pool.tier.Name == tier {
+			pool.resize(workers)
+			resized++
+		}
+	}
+	return resized
+}
+
+// WorkersRequest is the body of requests to the worker pools endpoint
+// resizing the pools of a tier, see [Service.EnableWorkersEndpoint].
+type WorkersRequest struct {
+	Tier    string `json:"tier"`
+	Workers int    `json:"workers"`
+}
+
+// EnableWorkersEndpoint subscribes a hidden endpoint for tuning the worker
+// pools at runtime. Requests without a body are replied with the pools as a
+// JSON list of [WorkerPoolStats], see [Service.WorkerPools], and requests
+// with a [WorkersRequest] resize the pools of the tier before replying, or
+// fail with a "404" error if the tier has no pool. Like the in-flight
+// endpoint, it only serves requests passing the authorizer and listens on
+// "_WORKERS.<service name>.<instance ID>", which is returned.
+func (s *Service) EnableWorkersEndpoint(authorize DebugChainAuthorizer) (string, error) {
+	return s.subscribeOps(workersSubjectPrefix, authorize, func(req *msgRequest) {
+		if len(req.Data()) > 0 {
+			var resize WorkersRequest
+			if err := json.Unmarshal(req.Data(), &resize); err != nil {
+				_ = req.Error(CodeBadRequest, "invalid request: "+err.Error(), nil)
+				return
+			}
+			if s.ResizeWorkers(resize.Tier, resize.Workers) == 0 {
+				_ = req.Error(CodeNotFound, "unknown tier", nil)
+				return
+			}
+		}
+		_ = req.RespondJSON(s.WorkerPools())
+	})
+}
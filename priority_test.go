@@ -0,0 +1,220 @@
+package natsmicromw
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestPriorityTiers(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	release := make(chan struct{})
+	tiered := nm.WithPriorityTiers(
+		PriorityTier{Name: "high", Workers: 2},
+		PriorityTier{Name: "low", Workers: 1},
+	)
+	if err := tiered.AddGroup("svc").AddMicroEndpoint("work", func(req *MicroRequest) (*MicroReply, error) {
+		if string(req.Data) == "block" {
+			<-release
+		}
+		return NewMicroReply([]byte(PriorityTierFromContext(req.Context()))), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tier := range []string{"high", "low"} {
+		msg, err := nc.Request("svc."+tier+".work", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg.Data) != tier {
+			t.Errorf("expected tier %q, received %q", tier, msg.Data)
+		}
+	}
+
+	// Occupy the only worker of the low tier
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := nc.Request("svc.low.work", []byte("block"), time.Second)
+		blocked <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	msg, err := nc.Request("svc.low.work", nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handlerErr := HandlerErrorFromMsg(msg); handlerErr == nil || handlerErr.Code != CodeUnavailable || handlerErr.Description != ErrPriorityTierBusy.Error() {
+		t.Errorf("expected busy tier error, received %v", handlerErr)
+	}
+	if msg, err := nc.Request("svc.high.work", nil, time.Second); err != nil || string(msg.Data) != "high" {
+		t.Errorf("expected the high tier to be available: %v", err)
+	}
+
+	close(release)
+	if err := <-blocked; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	names := map[string]string{}
+	for _, ep := range nm.svc.Info().Endpoints {
+		names[ep.Name] = ep.Subject
+	}
+	if names["work"] != "svc.high.work" || names["work-low"] != "svc.low.work" {
+		t.Errorf("unexpected endpoints %v", names)
+	}
+}
+
+func TestPriorityTiersWithVersion(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	tiered := nm.WithPriorityTiers(PriorityTier{Name: "high"}, PriorityTier{Name: "low"})
+	if err := tiered.AddGroup("svc").WithVersionPrefix("v2").AddMicroEndpoint("work", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply([]byte(PriorityTierFromContext(req.Context()))), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Clients add the tier to versioned and unversioned subjects alike
+	for _, subject := range []string{"svc.v2.work", "svc.work"} {
+		for _, tier := range []string{"high", "low"} {
+			msg, err := nc.Request(PrioritySubject(subject, tier), nil, time.Second)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", PrioritySubject(subject, tier), err)
+			}
+			if string(msg.Data) != tier {
+				t.Errorf("expected tier %q, received %q", tier, msg.Data)
+			}
+		}
+	}
+}
+
+func TestPrioritySubject(t *testing.T) {
+	tests := []struct {
+		subject, tier, expected string
+	}{
+		{"svc.orders", "high", "svc.high.orders"},
+		{"orders", "low", "low.orders"},
+		{"a.b.orders", "high", "a.b.high.orders"},
+		{"svc.orders", "", "svc.orders"},
+	}
+	for _, tt := range tests {
+		if subject := PrioritySubject(tt.subject, tt.tier); subject != tt.expected {
+			t.Errorf("%s/%s: expected %q, received %q", tt.subject, tt.tier, tt.expected, subject)
+		}
+	}
+}
+
+func TestWorkerPools(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	release := make(chan struct{})
+	tiered := nm.WithPriorityTiers(
+		PriorityTier{Name: "high", WorkersPerCPU: 2},
+		PriorityTier{Name: "low", Workers: 1, Queue: 1},
+	)
+	if err := tiered.AddGroup("svc").AddMicroEndpoint("work", func(req *MicroRequest) (*MicroReply, error) {
+		if string(req.Data) == "block" {
+			<-release
+		}
+		return NewMicroReply(nil), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	subject, err := nm.EnableWorkersEndpoint(func(req micro.Request) bool {
+		return req.Headers().Get("debug-token") == "secret"
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pools := nm.WorkerPools()
+	if len(pools) != 2 || pools[0].Workers != 2*AvailableCPUs() || pools[1].Workers != 1 || pools[1].Queue != 1 {
+		t.Fatalf("unexpected pools: %+v", pools)
+	}
+
+	// One request running and one waiting on the low tier
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := nc.Request("svc.low.work", []byte("block"), 5*time.Second)
+			done <- err
+		}()
+	}
+	for i := 0; nm.WorkerPools()[1].Waiting != 1; i++ {
+		if i > 100 {
+			t.Fatalf("expected a waiting request, found %+v", nm.WorkerPools()[1])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if low := nm.WorkerPools()[1]; low.Busy != 1 {
+		t.Errorf("unexpected low tier stats: %+v", low)
+	}
+
+	// Resizing the pool lets the waiting request run
+	msg := nats.NewMsg(subject)
+	msg.Header.Set("debug-token", "secret")
+	msg.Data = []byte(`{"tier":"low","workers":2}`)
+	reply, err := nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var listed []WorkerPoolStats
+	if err := json.Unmarshal(reply.Data, &listed); err != nil {
+		t.Fatalf("unexpected error: %v, %s", err, reply.Data)
+	}
+	if len(listed) != 2 || listed[1].Workers != 2 {
+		t.Errorf("unexpected listed pools: %+v", listed)
+	}
+	for i := 0; nm.WorkerPools()[1].Busy != 2; i++ {
+		if i > 100 {
+			t.Fatalf("expected two busy workers, found %+v", nm.WorkerPools()[1])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	msg.Data = []byte(`{"tier":"unknown","workers":2}`)
+	reply, err = nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != CodeNotFound {
+		t.Errorf("expected a 404 error, received %v", handlerErr)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestQuotaCPUs(t *testing.T) {
+	tests := []struct {
+		quota, period string
+		cpus          int
+		ok            bool
+	}{
+		{"200000", "100000", 2, true},
+		{"150000", "100000", 1, true},
+		{"50000", "100000", 1, true},
+		{"-1", "100000", 0, false},
+		{"max", "100000", 0, false},
+	}
+	for _, tt := range tests {
+		if cpus, ok := quotaCPUs(tt.quota, tt.period); cpus != tt.cpus || ok != tt.ok {
+			t.Errorf("%s/%s: expected %d %v, received %d %v", tt.quota, tt.period, tt.cpus, tt.ok, cpus, ok)
+		}
+	}
+}
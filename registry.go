@@ -0,0 +1,145 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Registry manages several services sharing a connection and default
+// middleware, for processes hosting many small services.
+type Registry struct {
+	nc *nats.Conn
+
+	mu       sync.Mutex
+	mw       []MiddlewareFunc
+	cmw      []ContextMiddlewareFunc
+	mmw      []MicroMiddlewareFunc
+	services []*Service
+}
+
+// RegistryStats aggregates the statistics of all services in a registry.
+type RegistryStats struct {
+	Services       []micro.Stats
+	NumRequests    int
+	NumErrors      int
+	ProcessingTime time.Duration
+}
+
+// NewRegistry creates a new registry for services on the connection.
+func NewRegistry(nc *nats.Conn) *Registry {
+	return &Registry{nc: nc}
+}
+
+// Use adds default middleware for the services added after it.
+func (r *Registry) Use(fns ...MiddlewareFunc) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mw = extend(r.mw, fns...)
+	return r
+}
+
+// UseContext adds default context middleware for the services added after it.
+func (r *Registry) UseContext(fns ...ContextMiddlewareFunc) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cmw = extend(r.cmw, fns...)
+	return r
+}
+
+// UseMicro adds default Micro middleware for the services added after it.
+func (r *Registry) UseMicro(fns ...MicroMiddlewareFunc) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mmw = extend(r.mmw, fns...)
+	return r
+}
+
+// AddService creates a new service on the shared connection, starting with
+// the default middleware of the registry. Further middleware can be added
+// to the service as usual.
+func (r *Registry) AddService(config micro.Config) (*Service, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := AddService(r.nc, config, r.mw...)
+	if err != nil {
+		return nil, err
+	}
+	s.cmw = r.cmw
+	s.mmw = r.mmw
+	r.services = append(r.services, s)
+	return s, nil
+}
+
+// Services returns the services added to the registry.
+func (r *Registry) Services() []*Service {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Service{}, r.services...)
+}
+
+// Stop stops all services, returning the errors of those that failed.
+func (r *Registry) Stop() error {
+	return stopServices(r.Services())
+}
+
+func stopServices(services []*Service) error {
+	var errs []error
+	for _, s := range services {
+		if err := s.Stop(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown stops all services and waits for their background tasks to
+// return, or until the context is done.
+func (r *Registry) Shutdown(ctx context.Context) error {
+	return shutdownServices(ctx, r.Services())
+}
+
+// Stop the services and wait for their background tasks
+func shutdownServices(ctx context.Context, services []*Service) error {
+	err := stopServices(services)
+
+	done := make(chan struct{})
+	go func() {
+		for _, s := range services {
+			s.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return errors.Join(err, ctx.Err())
+	}
+}
+
+// Stats returns the statistics of all services, with the totals over all
+// their endpoints.
+func (r *Registry) Stats() RegistryStats {
+	return aggregateStats(r.Services())
+}
+
+func aggregateStats(services []*Service) RegistryStats {
+	var stats RegistryStats
+	for _, s := range services {
+		svcStats := s.Stats()
+		stats.Services = append(stats.Services, svcStats)
+		for _, ep := range svcStats.Endpoints {
+			stats.NumRequests += ep.NumRequests
+			stats.NumErrors += ep.NumErrors
+			stats.ProcessingTime += ep.ProcessingTime
+		}
+	}
+	return stats
+}
@@ -0,0 +1,64 @@
+package natsmicromw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestRegistry(t *testing.T) {
+	s := getServer(t)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	reg := NewRegistry(nc).UseMicro(rejectingMiddleware)
+	for _, name := range []string{"First", "Second"} {
+		nm, err := reg.AddService(micro.Config{Name: name, Version: "1.0.0"})
+		if err != nil {
+			t.Fatalf("Could not create micro service: %v", err)
+		}
+		err = nm.AddMicroEndpoint("echo", func(req *MicroRequest) (*MicroReply, error) {
+			return NewMicroReply(req.Data), nil
+		}, micro.WithEndpointSubject(name+".echo"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	msg := nats.NewMsg("First.echo")
+	msg.Header.Set("reject", "1")
+	reply, err := nc.RequestMsg(msg, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != CodeForbidden {
+		t.Errorf("default middleware was not applied, received %v", handlerErr)
+	}
+	if _, err := nc.Request("Second.echo", []byte("data"), time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stats := reg.Stats()
+	if len(stats.Services) != 2 || stats.NumRequests != 2 || stats.NumErrors != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := reg.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, nm := range reg.Services() {
+		if !nm.Stopped() {
+			t.Errorf("service was not stopped")
+		}
+	}
+}
@@ -6,6 +6,7 @@ package natsmicromw
 import (
 	"context"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
 )
 
@@ -28,7 +29,102 @@ func (r *Request) WithContext(ctx context.Context) *Request {
 	}
 }
 
+// Request with a rewritten subject
+type subjectRequest struct {
+	micro.Request
+	subject string
+}
+
+func (r *subjectRequest) Subject() string {
+	return r.subject
+}
+
+// WithSubject returns a new Request reporting the given subject instead of
+// the one the message was received on, e.g. to normalize subjects.
+func (r *Request) WithSubject(subject string) *Request {
+	return &Request{
+		&subjectRequest{r.Request, subject},
+		r.ctx,
+	}
+}
+
+// Request adding options to all its replies
+type respondOptsRequest struct {
+	micro.Request
+	opts []micro.RespondOpt
+}
+
+func (r *respondOptsRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Respond(data, append(opts, r.opts...)...)
+}
+
+func (r *respondOptsRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	return r.Request.RespondJSON(v, append(opts, r.opts...)...)
+}
+
+func (r *respondOptsRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Error(code, description, data, append(opts, r.opts...)...)
+}
+
+// WithRespondOpts returns a new Request applying the options to every reply
+// sent by the handler, after those it passes, e.g. for middleware adding
+// headers to the replies. Errors returned by the handler are replied to by
+// the service and do not get the options.
+func (r *Request) WithRespondOpts(opts ...micro.RespondOpt) *Request {
+	return &Request{
+		&respondOptsRequest{r.Request, opts},
+		r.ctx,
+	}
+}
+
+// RespondWith encodes the value with the codec and responds with it, setting
+// the content type and any codec-specific headers. Additional headers can be
+// passed using the `micro.WithHeaders` option.
+func (r *Request) RespondWith(codec Codec, v any, opts ...micro.RespondOpt) error {
+	msg := nats.NewMsg("")
+	if err := EncodeMsg(codec, v, msg); err != nil {
+		return err
+	}
+	return r.Respond(msg.Data, append([]micro.RespondOpt{micro.WithHeaders(micro.Headers(msg.Header))}, opts...)...)
+}
+
+// RespondValue responds with the value encoded with the default codec of the
+// service, see `Service.SetDefaultCodec`.
+func (r *Request) RespondValue(v any, opts ...micro.RespondOpt) error {
+	return r.RespondWith(CodecFromContext(r.ctx), v, opts...)
+}
+
 func (r *Request) RespondWithOriginalHeaders(response []byte, opts ...micro.RespondOpt) error {
 	headers := r.Headers()
 	return r.Respond(response, append(opts, micro.WithHeaders(headers))...)
 }
+
+// Msg returns a copy of the request as a `*nats.Msg`, including the reply
+// subject and headers, e.g. for libraries expecting one. Changes to the
+// message do not affect the request.
+func (r *Request) Msg() *nats.Msg {
+	return copyMsg(&nats.Msg{
+		Subject: r.Subject(),
+		Reply:   r.Reply(),
+		Header:  nats.Header(r.Headers()),
+		Data:    r.Data(),
+	})
+}
+
+// Copy the headers and data of the message
+func copyMsg(msg *nats.Msg) *nats.Msg {
+	c := &nats.Msg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+	}
+	if msg.Header != nil {
+		c.Header = make(nats.Header, len(msg.Header))
+		for k, v := range msg.Header {
+			c.Header[k] = append([]string(nil), v...)
+		}
+	}
+	if msg.Data != nil {
+		c.Data = append([]byte(nil), msg.Data...)
+	}
+	return c
+}
@@ -0,0 +1,67 @@
+package natsmicromw
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// OnRespondError sets a hook called when sending a reply fails, e.g. because
+// the connection is closed or the reply exceeds the maximum payload. Such
+// failures are otherwise lost unless every handler checks the error returned
+// by Respond. The hook applies to all endpoints, including raw ones, and is
+// called in addition to counting the failure in [Service.RespondErrors].
+func (s *Service) OnRespondError(hook func(req micro.Request, err error)) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onRespondError = hook
+}
+
+// RespondErrors returns the number of replies of the service, its clones and
+// groups that could not be sent.
+func (s *Service) RespondErrors() uint64 {
+	return s.respondErrors.Load()
+}
+
+// Wrap the handler to report failed replies
+func respondErrorHandler(hook func(micro.Request, error), count *atomic.Uint64, handler micro.Handler) micro.Handler {
+	return micro.HandlerFunc(func(req micro.Request) {
+		handler.Handle(&reportingRequest{req, hook, count})
+	})
+}
+
+// Request reporting the errors returned when replying
+type reportingRequest struct {
+	micro.Request
+	hook  func(micro.Request, error)
+	count *atomic.Uint64
+}
+
+// Report the error, unless the reply was rejected as a duplicate, which is
+// reported separately
+func (r *reportingRequest) report(err error) error {
+	if err == nil || errors.Is(err, ErrAlreadyResponded) {
+		return err
+	}
+	r.count.Add(1)
+	if r.hook != nil {
+		r.hook(r.Request, err)
+	}
+	return err
+}
+
+func (r *reportingRequest) unwrapRequest() micro.Request { return r.Request }
+
+func (r *reportingRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	return r.report(r.Request.Respond(data, opts...))
+}
+
+func (r *reportingRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	return r.report(r.Request.RespondJSON(v, opts...))
+}
+
+func (r *reportingRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	return r.report(r.Request.Error(code, description, data, opts...))
+}
@@ -0,0 +1,64 @@
+package natsmicromw
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestOnRespondError(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	failed := make(chan error, 2)
+	nm.OnRespondError(func(req micro.Request, err error) {
+		failed <- err
+	})
+
+	tooLarge := make([]byte, nc.MaxPayload()+1)
+	if err := nm.AddEndpoint("raw", micro.HandlerFunc(func(req micro.Request) {
+		req.Respond(tooLarge)
+	})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddMicroEndpoint("micro", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply(tooLarge), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddMicroEndpoint("ok", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply(nil), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, subject := range []string{"raw", "micro"} {
+		if _, err := nc.Request(subject, nil, 100*time.Millisecond); !errors.Is(err, nats.ErrTimeout) {
+			t.Errorf("%s: expected timeout, got %v", subject, err)
+		}
+		select {
+		case err := <-failed:
+			if !errors.Is(err, micro.ErrRespond) {
+				t.Errorf("%s: expected respond error, got %v", subject, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: hook not called", subject)
+		}
+	}
+	if _, err := nc.Request("ok", nil, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if count := nm.RespondErrors(); count != 2 {
+		t.Errorf("expected 2 respond errors, got %d", count)
+	}
+	select {
+	case err := <-failed:
+		t.Errorf("unexpected hook call: %v", err)
+	default:
+	}
+}
@@ -0,0 +1,165 @@
+package natsmicromw
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ShardKeyFunc returns the key of a request, which determines its shard.
+type ShardKeyFunc func(req micro.Request) string
+
+// ShardedGroup registers endpoints on a subject per shard, e.g.
+// "orders.3.update" for shard 3 of the "orders" group, see
+// [Service.ShardedGroup].
+type ShardedGroup struct {
+	svc    *Service
+	name   string
+	shards int
+	serve  []int
+	key    ShardKeyFunc
+}
+
+// Shard returns the shard of the key among the number of shards, using the
+// FNV-1a hash of the key.
+func Shard(key string, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// ShardSubject returns the subject of the endpoint subject in the shard of
+// the group, e.g. "orders.3.update".
+func ShardSubject(group string, shard int, subject string) string {
+	return group + "." + strconv.Itoa(shard) + "." + subject
+}
+
+// ShardedGroup returns a group whose endpoints are registered on a subject
+// per shard, so partitioned workloads, e.g. with per-user ordering, need no
+// manual subject math. Clients send requests to the subject of the shard of
+// their key with [ShardSubject] and [Shard], or the sharded client. If the
+// key function is set, requests whose key belongs to another shard are
+// rejected with a "400" error, catching clients that disagree on the number
+// of shards. Handlers get the shard with [ShardFromContext].
+//
+// Every instance serves all shards by default, with the usual queue group,
+// so requests of a shard are only handled in order by a single instance
+// when the shards are divided among the instances with [ShardedGroup.Only].
+func (s *Service) ShardedGroup(name string, shards int, key ShardKeyFunc) *ShardedGroup {
+	if shards < 1 {
+		shards = 1
+	}
+	serve := make([]int, shards)
+	for i := range serve {
+		serve[i] = i
+	}
+	return &ShardedGroup{s, name, shards, serve, key}
+}
+
+// Only returns a sharded group serving only the given shards, e.g. those
+// assigned to this instance. Shards out of range are ignored.
+func (g *ShardedGroup) Only(shards ...int) *ShardedGroup {
+	ng := *g
+	ng.serve = nil
+	for _, shard := range shards {
+		if shard >= 0 && shard < g.shards {
+			ng.serve = append(ng.serve, shard)
+		}
+	}
+	return &ng
+}
+
+// Shards returns the number of shards of the group.
+func (g *ShardedGroup) Shards() int {
+	return g.shards
+}
+
+// AddEndpoint registers the endpoint on every served shard, see
+// [ShardedGroup.AddMicroEndpoint].
+func (g *ShardedGroup) AddEndpoint(name string, handler micro.Handler, opts ...micro.EndpointOpt) error {
+	return g.add(name, opts, func(grp *Group, name string, opts []micro.EndpointOpt) error {
+		return grp.AddEndpoint(name, handler, opts...)
+	})
+}
+
+// AddContextEndpoint registers the endpoint on every served shard, see
+// [ShardedGroup.AddMicroEndpoint].
+func (g *ShardedGroup) AddContextEndpoint(name string, handler ContextHandlerFunc, opts ...micro.EndpointOpt) error {
+	return g.add(name, opts, func(grp *Group, name string, opts []micro.EndpointOpt) error {
+		return grp.AddContextEndpoint(name, handler, opts...)
+	})
+}
+
+// AddMicroEndpoint registers the endpoint on every served shard, under the
+// name followed by "-shard<N>", e.g. "update-shard3", so the shards are
+// told apart in the service info and stats.
+func (g *ShardedGroup) AddMicroEndpoint(name string, handler MicroHandlerFunc, opts ...micro.EndpointOpt) error {
+	return g.add(name, opts, func(grp *Group, name string, opts []micro.EndpointOpt) error {
+		return grp.AddMicroEndpoint(name, handler, opts...)
+	})
+}
+
+// Register an endpoint in the group of every served shard
+func (g *ShardedGroup) add(name string, opts []micro.EndpointOpt, register func(*Group, string, []micro.EndpointOpt) error) error {
+	// Keep the subject of the endpoint, which defaults to its name
+	opts = append([]micro.EndpointOpt{micro.WithEndpointSubject(name)}, opts...)
+	for _, shard := range g.serve {
+		svc := g.svc.clone()
+		svc.shard = &shardFilter{g.name, shard, g.shards, g.key}
+		grp := svc.AddGroup(g.name + "." + strconv.Itoa(shard))
+		if err := register(grp, name+"-shard"+strconv.Itoa(shard), opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shard served by an endpoint
+type shardFilter struct {
+	group  string
+	shard  int
+	shards int
+	key    ShardKeyFunc
+}
+
+// Wrap the handler to reject requests of other shards and mark the others
+// with the shard
+func (f *shardFilter) handler(handler micro.Handler, encodeError func(error, *errorMeta) (string, string, []byte, micro.Headers)) micro.Handler {
+	if f == nil {
+		return handler
+	}
+	return micro.HandlerFunc(func(req micro.Request) {
+		if f.key != nil {
+			if shard := Shard(f.key(req), f.shards); shard != f.shard {
+				code, description, data, headers := encodeError(&HandlerError{
+					Description: fmt.Sprintf("request for shard %d of %s received on shard %d", shard, f.group, f.shard),
+					Code:        CodeBadRequest,
+				}, new(errorMeta))
+				_ = req.Error(code, description, data, micro.WithHeaders(headers))
+				return
+			}
+		}
+		handler.Handle(&shardRequest{req, f.shard})
+	})
+}
+
+// Request received on the subject of a shard
+type shardRequest struct {
+	micro.Request
+	shard int
+}
+
+type shardContextKey struct{}
+
+// ShardFromContext returns the shard of a [ShardedGroup] the request was
+// received on, false if it was not received on a sharded endpoint.
+func ShardFromContext(ctx context.Context) (int, bool) {
+	shard, ok := ctx.Value(shardContextKey{}).(int)
+	return shard, ok
+}
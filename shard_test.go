@@ -0,0 +1,72 @@
+package natsmicromw
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestShardedGroup(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	key := func(req micro.Request) string { return string(req.Data()) }
+	err := nm.ShardedGroup("users", 4, key).Only(1, 2, 7).AddContextEndpoint("shard", func(req *Request) error {
+		shard, ok := ShardFromContext(req.Context())
+		if !ok {
+			return Errorf(CodeInternal, "no shard")
+		}
+		return req.Respond([]byte(strconv.Itoa(shard)))
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info := nm.Info()
+	if len(info.Endpoints) != 2 || info.Endpoints[0].Subject != "users.1.shard" || info.Endpoints[1].Subject != "users.2.shard" {
+		t.Fatalf("unexpected endpoints: %+v", info.Endpoints)
+	}
+	if info.Endpoints[0].Name != "shard-shard1" || info.Endpoints[1].Name != "shard-shard2" {
+		t.Errorf("expected distinct endpoint names, received %s and %s", info.Endpoints[0].Name, info.Endpoints[1].Name)
+	}
+
+	// Find keys of a served and an unserved shard
+	keys := make(map[int]string)
+	for i := 0; len(keys) < 4; i++ {
+		k := "user" + strconv.Itoa(i)
+		if _, ok := keys[Shard(k, 4)]; !ok {
+			keys[Shard(k, 4)] = k
+		}
+	}
+
+	t.Run("served", func(t *testing.T) {
+		reply, err := nc.Request(ShardSubject("users", 2, "shard"), []byte(keys[2]), time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(reply.Data) != "2" {
+			t.Errorf("expected shard 2, received %q", reply.Data)
+		}
+	})
+
+	t.Run("misrouted", func(t *testing.T) {
+		reply, err := nc.Request(ShardSubject("users", 1, "shard"), []byte(keys[2]), time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != CodeBadRequest {
+			t.Errorf("expected a 400 error, received %v", handlerErr)
+		}
+	})
+
+	t.Run("unserved", func(t *testing.T) {
+		_, err := nc.Request(ShardSubject("users", 0, "shard"), []byte(keys[0]), time.Second)
+		if err != nats.ErrNoResponders {
+			t.Errorf("expected no responders, received %v", err)
+		}
+	})
+}
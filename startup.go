@@ -0,0 +1,150 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default time limit for running all start hooks
+const DefaultStartTimeout = 30 * time.Second
+
+// StartPolicy defines what happens when a start hook fails.
+type StartPolicy int
+
+const (
+	// Stop the service without registering the deferred endpoints
+	StartFailStop StartPolicy = iota
+	// Report the error to the `ErrorHandler` and register the endpoints anyway
+	StartContinue
+)
+
+// Start hooks and the endpoints waiting for them, shared by all clones of a service
+type startup struct {
+	mu      sync.Mutex
+	hooks   []func(ctx context.Context) error
+	pending []func() error
+	started bool
+	timeout time.Duration
+	policy  StartPolicy
+
+	// Runs the hooks of the first call to Start, and its result
+	once sync.Once
+	err  error
+}
+
+func newStartup() *startup {
+	return &startup{timeout: DefaultStartTimeout}
+}
+
+// Queue the endpoint registration if the service is waiting for its start
+// hooks, returns false if it should be registered right away.
+func (st *startup) deferEndpoint(register func() error) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.started || len(st.hooks) == 0 {
+		return false
+	}
+	st.pending = append(st.pending, register)
+	return true
+}
+
+// OnStart adds a hook that must complete before the endpoints are
+// subscribed, e.g. to warm a cache or ping a dependency. Endpoints added
+// after the first hook are only registered by [Start], so instances do not
+// receive traffic before they are ready. Whether an endpoint waits is
+// decided when it is added: endpoints added before the first hook are
+// already subscribed and receive traffic right away, so add the hooks
+// first, right after creating the service.
+func (s *Service) OnStart(fn func(ctx context.Context) error) {
+	s.startup.mu.Lock()
+	defer s.startup.mu.Unlock()
+	s.startup.hooks = append(s.startup.hooks, fn)
+}
+
+// SetStartTimeout sets the time limit for running all start hooks.
+// Zero means no limit.
+func (s *Service) SetStartTimeout(timeout time.Duration) {
+	s.checkFrozen()
+	s.startup.mu.Lock()
+	defer s.startup.mu.Unlock()
+	s.startup.timeout = timeout
+}
+
+// SetStartPolicy sets what happens when a start hook fails or times out.
+func (s *Service) SetStartPolicy(policy StartPolicy) {
+	s.checkFrozen()
+	s.startup.mu.Lock()
+	defer s.startup.mu.Unlock()
+	s.startup.policy = policy
+}
+
+// Start runs the start hooks in order and then registers the deferred
+// endpoints. With the `StartFailStop` policy a failing hook stops the
+// service and its error is returned. Endpoints added after Start are
+// registered right away. The hooks only run once, concurrent and later
+// calls wait for the first one and return its result.
+func (s *Service) Start(ctx context.Context) error {
+	st := s.startup
+	st.once.Do(func() {
+		st.err = s.start(ctx)
+	})
+	return st.err
+}
+
+func (s *Service) start(ctx context.Context) error {
+	st := s.startup
+	st.mu.Lock()
+	hooks, timeout, policy := st.hooks, st.timeout, st.policy
+	st.mu.Unlock()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	for _, hook := range hooks {
+		if err := runStartHook(ctx, hook); err != nil {
+			err = fmt.Errorf("start hook failed: %w", err)
+			if policy == StartFailStop {
+				_ = s.Stop()
+				return err
+			}
+			s.tasks.report("", err)
+		}
+	}
+
+	// Endpoints added while registering go through directly
+	st.mu.Lock()
+	pending := st.pending
+	st.pending = nil
+	st.started = true
+	st.mu.Unlock()
+
+	var errs []error
+	for _, register := range pending {
+		if err := register(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run the hook, giving up when the context is done even if the hook does
+// not respect it
+func runStartHook(ctx context.Context, hook func(ctx context.Context) error) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- hook(ctx)
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,75 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestStartHooks(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	ready := make(chan struct{})
+	var runs atomic.Int32
+	nm.OnStart(func(ctx context.Context) error {
+		runs.Add(1)
+		<-ready
+		return nil
+	})
+	if err := nm.AddContextEndpoint("echo", func(req *Request) error {
+		return req.Respond(req.Data())
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Not subscribed before the hooks have completed
+	if _, err := nc.Request("echo", []byte("data"), 100*time.Millisecond); !errors.Is(err, nats.ErrNoResponders) {
+		t.Errorf("expected no responders before start, received %v", err)
+	}
+
+	// Concurrent calls run the hooks once
+	errs := make(chan error, 2)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- nm.Start(context.Background()) }()
+	}
+	close(ready)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("expected the hook to run once, received %d", n)
+	}
+	if _, err := nc.Request("echo", []byte("data"), time.Second); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStartHookTimeout(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	nm.SetStartTimeout(50 * time.Millisecond)
+	nm.OnStart(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	if err := nm.AddContextEndpoint("echo", emptyRequestHandler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := nm.Start(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, received %v", err)
+	}
+	if !nm.Stopped() {
+		t.Errorf("service was not stopped after failed start")
+	}
+}
@@ -0,0 +1,102 @@
+package natsmicromw
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Tags of a request, added during its handling
+type requestTags struct {
+	mu     sync.Mutex
+	keys   []string
+	values map[string]string
+}
+
+type tagsContextKey struct{}
+
+func withTags(ctx context.Context, t *requestTags) context.Context {
+	return context.WithValue(ctx, tagsContextKey{}, t)
+}
+
+// Tag records a diagnostic value of the request being handled, e.g. the
+// customer, cache outcome or feature flag taken, in the single place read
+// by all observability outputs: the in-flight requests, see
+// [Service.InFlight], middleware such as the tracing and audit middleware,
+// which read them with [Tags], and the reply headers if enabled with
+// [Service.SetTagHeaders]. Tagging a key again replaces its value. This has
+// no effect outside context and Micro handlers.
+func Tag(ctx context.Context, key, value string) {
+	t, _ := ctx.Value(tagsContextKey{}).(*requestTags)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.values == nil {
+		t.values = make(map[string]string)
+	}
+	if _, ok := t.values[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.values[key] = value
+}
+
+// Tags returns a copy of the tags of the request recorded so far with
+// [Tag], nil if there are none.
+func Tags(ctx context.Context) map[string]string {
+	t, _ := ctx.Value(tagsContextKey{}).(*requestTags)
+	if t == nil {
+		return nil
+	}
+	return t.copy()
+}
+
+func (t *requestTags) copy() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.keys) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(t.keys))
+	for k, v := range t.values {
+		tags[k] = v
+	}
+	return tags
+}
+
+// SetTagHeaders includes the tags of each request in its reply, successful
+// or not, as headers named with the prefix and the key, e.g. "tag-cache"
+// with the "tag-" prefix, so callers can see how their request was handled.
+// Headers set by the handler are kept. An empty prefix, the default,
+// disables the tag headers.
+func (s *Service) SetTagHeaders(prefix string) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tagHeaders = prefix
+}
+
+// Add the tags to the reply headers, without changing the headers passed
+// by the handler
+func (t *requestTags) respondOpt(prefix string) micro.RespondOpt {
+	return func(m *nats.Msg) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if len(t.keys) == 0 {
+			return
+		}
+		headers := nats.Header{}
+		for k, v := range m.Header {
+			headers[k] = v
+		}
+		for _, key := range t.keys {
+			if headers.Get(prefix+key) == "" {
+				headers.Set(prefix+key, t.values[key])
+			}
+		}
+		m.Header = headers
+	}
+}
@@ -0,0 +1,124 @@
+package natsmicromw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestTag(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	nm.SetTagHeaders("tag-")
+	tagging := func(next ContextHandlerFunc) ContextHandlerFunc {
+		return func(req *Request) error {
+			Tag(req.Context(), "tenant", "acme")
+			return next(req)
+		}
+	}
+	tagged := make(chan struct{})
+	release := make(chan struct{})
+	err := nm.UseContext(tagging).AddContextEndpoint("tagged", func(req *Request) error {
+		Tag(req.Context(), "cache", "miss")
+		Tag(req.Context(), "cache", "hit")
+		if tags := Tags(req.Context()); tags["tenant"] != "acme" || tags["cache"] != "hit" {
+			t.Errorf("unexpected tags: %v", tags)
+		}
+		if string(req.Data()) == "wait" {
+			close(tagged)
+			<-release
+		}
+		if string(req.Data()) == "fail" {
+			return Errorf(CodeConflict, "failed")
+		}
+		return req.Respond(nil, micro.WithHeaders(micro.Headers{"tag-tenant": {"other"}}))
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = nm.AddMicroEndpoint("micro", func(req *MicroRequest) (*MicroReply, error) {
+		Tag(req.Context(), "path", "fast")
+		return NewMicroReply(nil), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("in flight", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = nc.Request("tagged", []byte("wait"), 5*time.Second)
+		}()
+		<-tagged
+		inFlight := nm.InFlight()
+		if len(inFlight) != 1 || inFlight[0].Tags["tenant"] != "acme" || inFlight[0].Tags["cache"] != "hit" {
+			t.Errorf("unexpected in-flight requests: %+v", inFlight)
+		}
+		close(release)
+		<-done
+	})
+
+	t.Run("reply headers", func(t *testing.T) {
+		reply, err := nc.Request("tagged", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if reply.Header.Get("tag-cache") != "hit" || reply.Header.Get("tag-tenant") != "other" {
+			t.Errorf("unexpected reply headers: %v", reply.Header)
+		}
+
+		reply, err = nc.Request("micro", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if reply.Header.Get("tag-path") != "fast" {
+			t.Errorf("unexpected reply headers: %v", reply.Header)
+		}
+	})
+
+	t.Run("error reply", func(t *testing.T) {
+		reply, err := nc.Request("tagged", []byte("fail"), time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if reply.Header.Get(micro.ErrorCodeHeader) != CodeConflict || reply.Header.Get("tag-tenant") != "acme" {
+			t.Errorf("unexpected reply headers: %v", reply.Header)
+		}
+	})
+}
+
+func TestTagOutsideHandler(t *testing.T) {
+	ctx := context.Background()
+	Tag(ctx, "key", "value")
+	if tags := Tags(ctx); tags != nil {
+		t.Errorf("expected no tags, received %v", tags)
+	}
+}
+
+// Tag headers are not added unless enabled
+func TestTagHeadersDisabled(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	err := nm.AddContextEndpoint("tagged", func(req *Request) error {
+		Tag(req.Context(), "cache", "hit")
+		return req.Respond(nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reply, err := nc.RequestMsg(nats.NewMsg("tagged"), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reply.Header) != 0 {
+		t.Errorf("unexpected reply headers: %v", reply.Header)
+	}
+}
@@ -0,0 +1,77 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Background tasks of a service, shared by all its clones
+type tasks struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	onError func(subject string, err error)
+	cronSeq atomic.Int64
+}
+
+// Create the task set for the config, cancelling the tasks also when the
+// service stops on its own, e.g. when the connection is closed.
+func newTasks(config *micro.Config) *tasks {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &tasks{ctx: ctx, cancel: cancel}
+
+	done := config.DoneHandler
+	config.DoneHandler = func(svc micro.Service) {
+		t.cancel()
+		if done != nil {
+			done(svc)
+		}
+	}
+	return t
+}
+
+// Report task errors to the error handler of the service config
+func (t *tasks) setErrorHandler(svc micro.Service, handler micro.ErrHandler) {
+	if handler == nil {
+		return
+	}
+	t.onError = func(subject string, err error) {
+		handler(svc, &micro.NATSError{Subject: subject, Description: err.Error()})
+	}
+}
+
+func (t *tasks) report(subject string, err error) {
+	if t.onError != nil {
+		t.onError(subject, err)
+	}
+}
+
+func (t *tasks) goTask(fn func(ctx context.Context) error) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		err := fn(t.ctx)
+		// Returning the cancellation error on stop is not a failure
+		if err == nil || (errors.Is(err, context.Canceled) && t.ctx.Err() != nil) {
+			return
+		}
+		t.report("", err)
+	}()
+}
+
+// Go runs the function in a background goroutine managed by the service,
+// e.g. a cache refresher or a KV watcher. Its context is cancelled when the
+// service is stopped, and a returned error is reported to the `ErrorHandler`
+// of the service config.
+func (s *Service) Go(fn func(ctx context.Context) error) {
+	s.tasks.goTask(fn)
+}
+
+// Wait blocks until all background tasks started with [Go] have returned.
+func (s *Service) Wait() {
+	s.tasks.wg.Wait()
+}
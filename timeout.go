@@ -0,0 +1,93 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrHandlerTimeout is the cause of the "504" error sent when a handler
+// does not return within its timeout.
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// WithHandlerTimeout returns a service whose endpoints are bounded by the
+// timeout, independent of any client timeout. The request context gets the
+// deadline, and if the handler has not returned by then, a "504" error is
+// sent and the timeout is reported to the `ErrorHandler` of the service
+// config. A later reply from the handler is discarded.
+func (s *Service) WithHandlerTimeout(timeout time.Duration) *Service {
+	ns := s.clone()
+	ns.handlerTimeout = timeout
+	return ns
+}
+
+// WithHandlerTimeout returns a group whose endpoints are bounded by the
+// timeout, see [Service.WithHandlerTimeout].
+func (g *Group) WithHandlerTimeout(timeout time.Duration) *Group {
+	svc := g.svc.clone()
+	svc.handlerTimeout = timeout
+	return &Group{svc, g.grp, g.prefix}
+}
+
+type handlerTimeoutContextKey struct{}
+
+// Deadline set by `WithHandlerTimeout`, told apart from a deadline or
+// cancellation inherited from the request context
+type handlerTimeout struct {
+	ctx            context.Context
+	deadline       time.Time
+	parentDeadline time.Time
+}
+
+func (t *handlerTimeout) expired() bool {
+	if t.ctx.Err() != context.DeadlineExceeded {
+		return false
+	}
+	return t.parentDeadline.IsZero() || !t.parentDeadline.Before(t.deadline)
+}
+
+// HandlerTimedOut reports whether the timeout set with `WithHandlerTimeout`
+// has expired for the request, e.g. for middleware recording the outcome of
+// a handler that returned after a "504" error was already sent. It is false
+// for endpoints without a timeout and for canceled requests.
+func HandlerTimedOut(ctx context.Context) bool {
+	t, ok := ctx.Value(handlerTimeoutContextKey{}).(*handlerTimeout)
+	return ok && t.expired()
+}
+
+// Run the handler with a deadline, returning a timeout error when it is
+// reached even if the handler is still running. If the request context is
+// canceled first, its cause is returned instead.
+func callWithTimeout[T any](ctx context.Context, timeout time.Duration, fn func(ctx context.Context) (T, error)) (T, error) {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+
+	t := &handlerTimeout{deadline: time.Now().Add(timeout)}
+	t.parentDeadline, _ = ctx.Deadline()
+	ctx, cancel := context.WithDeadline(ctx, t.deadline)
+	defer cancel()
+	t.ctx = ctx
+	ctx = context.WithValue(ctx, handlerTimeoutContextKey{}, t)
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn(ctx)
+		done <- result{value, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-ctx.Done():
+		var zero T
+		if t.expired() {
+			return zero, Errorf(CodeTimeout, "%w", ErrHandlerTimeout)
+		}
+		return zero, context.Cause(ctx)
+	}
+}
@@ -0,0 +1,128 @@
+package natsmicromw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestHandlerTimeout(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	deadlines := make(chan bool, 1)
+	grp := nm.AddGroup("slow").WithHandlerTimeout(50 * time.Millisecond)
+	if err := grp.AddContextEndpoint("context", func(req *Request) error {
+		_, ok := req.Context().Deadline()
+		deadlines <- ok
+		time.Sleep(200 * time.Millisecond)
+		return req.Respond([]byte("late"))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := grp.AddMicroEndpoint("micro", func(req *MicroRequest) (*MicroReply, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddContextEndpoint("fast", func(req *Request) error {
+		if _, ok := req.Context().Deadline(); ok {
+			t.Errorf("endpoint without timeout has a deadline")
+		}
+		return req.Respond([]byte("ok"))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, subject := range []string{"slow.context", "slow.micro"} {
+		start := time.Now()
+		msg, err := nc.Request(subject, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if code := msg.Header.Get(micro.ErrorCodeHeader); code != CodeTimeout {
+			t.Errorf("%s error code does not match, expected %s, received %s", subject, CodeTimeout, code)
+		}
+		if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+			t.Errorf("%s reply took too long: %v", subject, elapsed)
+		}
+	}
+	if !<-deadlines {
+		t.Errorf("request context has no deadline")
+	}
+
+	if _, err := nc.Request("fast", nil, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestHandlerTimedOut(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	timedOut := make(chan [2]bool, 1)
+	if err := nm.WithHandlerTimeout(20*time.Millisecond).AddContextEndpoint("slow", func(req *Request) error {
+		before := HandlerTimedOut(req.Context())
+		<-req.Context().Done()
+		timedOut <- [2]bool{before, HandlerTimedOut(req.Context())}
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := nc.Request("slow", nil, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res := <-timedOut; res[0] || !res[1] {
+		t.Errorf("expected the timeout to expire during the handler, received %v", res)
+	}
+	if HandlerTimedOut(context.Background()) {
+		t.Errorf("expected no timeout outside a handler")
+	}
+}
+
+func TestHandlerTimeoutCanceled(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	timedOut := make(chan bool, 1)
+	if err := nm.WithHandlerTimeout(time.Second).AddContextEndpoint("runaway", func(req *Request) error {
+		<-req.Context().Done()
+		timedOut <- HandlerTimedOut(req.Context())
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan *nats.Msg, 1)
+	go func() {
+		msg := nats.NewMsg("runaway")
+		msg.Header.Set(ErrorMetaRequestID, "req-1")
+		reply, err := nc.RequestMsg(msg, 2*time.Second)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		done <- reply
+	}()
+	for i := 0; nm.CancelRequest("req-1") == 0; i++ {
+		if i > 100 {
+			t.Fatalf("request was not tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	reply := <-done
+	if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code == CodeTimeout || handlerErr.Description != ErrRequestCanceled.Error() {
+		t.Errorf("expected the cancellation error, received %v", handlerErr)
+	}
+	if <-timedOut {
+		t.Errorf("expected a canceled request not to be timed out")
+	}
+}
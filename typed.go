@@ -0,0 +1,43 @@
+// The package introduces typed handlers that decode requests and encode
+// replies with a `Codec`.
+
+package natsmicromw
+
+import (
+	"context"
+)
+
+// TypedHandler adapts a function with typed request and reply values to a
+// MicroHandlerFunc. The request is decoded with the codec, and the reply is
+// encoded with it, carrying the codec's content type.
+func TypedHandler[Req, Res any](codec Codec, fn func(ctx context.Context, req Req) (Res, error)) MicroHandlerFunc {
+	return func(req *MicroRequest) (*MicroReply, error) {
+		var in Req
+		if err := decodeRequest(codec, req, &in); err != nil {
+			return nil, &HandlerError{
+				Description: "invalid request: " + err.Error(),
+				Code:        CodeBadRequest,
+			}
+		}
+
+		out, err := fn(req.Context(), in)
+		if err != nil {
+			return nil, err
+		}
+		return NewMicroReplyWithCodec(codec, out)
+	}
+}
+
+// Decode the request, incrementally if the payload is streamed and the codec
+// supports it without headers
+func decodeRequest(codec Codec, req *MicroRequest, v any) error {
+	_, hc := codec.(HeaderCodec)
+	_, raw := v.(*[]byte)
+	if sc, ok := codec.(StreamCodec); ok && !hc && !raw && req.Streamed() {
+		return sc.DecodeReader(req.Body(), v)
+	}
+	if _, err := req.ReadData(); err != nil {
+		return err
+	}
+	return DecodeMsg(codec, req.msg(), v)
+}
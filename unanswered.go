@@ -0,0 +1,109 @@
+package natsmicromw
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+var (
+	// ErrUnanswered is the description of the automatic reply to requests
+	// the handler returned from without answering.
+	ErrUnanswered = errors.New("handler did not respond")
+	// ErrAlreadyResponded is returned when replying to a request more than once.
+	ErrAlreadyResponded = errors.New("request already responded to")
+)
+
+// Request that records whether a reply was sent. With a duplicate hook set,
+// only the first reply is sent and later ones are reported to the hook.
+type trackedRequest struct {
+	micro.Request
+	responded   atomic.Bool
+	onDuplicate func(subject string, err error)
+}
+
+// Mark the request as responded, false if it is a duplicate to reject
+func (r *trackedRequest) respond() bool {
+	if r.responded.Swap(true) && r.onDuplicate != nil {
+		r.onDuplicate(r.Subject(), ErrAlreadyResponded)
+		return false
+	}
+	return true
+}
+
+func (r *trackedRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	if !r.respond() {
+		return ErrAlreadyResponded
+	}
+	return r.Request.Respond(data, opts...)
+}
+
+func (r *trackedRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	if !r.respond() {
+		return ErrAlreadyResponded
+	}
+	return r.Request.RespondJSON(v, opts...)
+}
+
+func (r *trackedRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	if !r.respond() {
+		return ErrAlreadyResponded
+	}
+	return r.Request.Error(code, description, data, opts...)
+}
+
+// What to do with unanswered requests
+type unansweredPolicy struct {
+	hook    func(req micro.Request)
+	respond bool
+}
+
+func (p unansweredPolicy) enabled() bool {
+	return p.hook != nil || p.respond
+}
+
+func (p unansweredPolicy) handle(req micro.Request) {
+	if p.hook != nil {
+		p.hook(req)
+	}
+	if p.respond {
+		_ = req.Error(CodeInternal, ErrUnanswered.Error(), nil)
+	}
+}
+
+// Wrap the handler to detect returning without a reply, if enabled
+func (p unansweredPolicy) track(handler micro.Handler) micro.Handler {
+	if !p.enabled() {
+		return handler
+	}
+	return micro.HandlerFunc(func(req micro.Request) {
+		tracked := &trackedRequest{Request: req}
+		handler.Handle(tracked)
+		if !tracked.responded.Load() {
+			p.handle(req)
+		}
+	})
+}
+
+// OnUnanswered sets a hook called when a handler returns without replying,
+// e.g. to log it or count it in a metric, as such requests otherwise only
+// show up as client timeouts. Handlers that reply asynchronously after
+// returning are reported as well, so only use it when all handlers reply
+// before returning. In Micro handlers this means returning a nil reply
+// without an error.
+func (s *Service) OnUnanswered(hook func(req micro.Request)) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unanswered.hook = hook
+}
+
+// SetRespondUnanswered enables replying with a "500" error to requests the
+// handler returned from without replying, see [OnUnanswered].
+func (s *Service) SetRespondUnanswered(enabled bool) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unanswered.respond = enabled
+}
@@ -0,0 +1,123 @@
+package natsmicromw
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestUnanswered(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	unanswered := make(chan string, 3)
+	nm.OnUnanswered(func(req micro.Request) {
+		unanswered <- req.Subject()
+	})
+	nm.SetRespondUnanswered(true)
+
+	if err := nm.AddEndpoint("raw", micro.HandlerFunc(emptyHandler)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddContextEndpoint("context", emptyRequestHandler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddMicroEndpoint("micro", func(req *MicroRequest) (*MicroReply, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddContextEndpoint("answered", func(req *Request) error {
+		return req.Respond([]byte("ok"))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, subject := range []string{"raw", "context", "micro"} {
+		msg, err := nc.Request(subject, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if code := msg.Header.Get(micro.ErrorCodeHeader); code != CodeInternal {
+			t.Errorf("%s error code does not match, expected %s, received %s", subject, CodeInternal, code)
+		}
+		if received := <-unanswered; received != subject {
+			t.Errorf("unanswered subject does not match, expected %s, received %s", subject, received)
+		}
+	}
+
+	if _, err := nc.Request("answered", nil, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case subject := <-unanswered:
+		t.Errorf("answered request reported as unanswered: %s", subject)
+	default:
+	}
+}
+
+func TestRespondOnce(t *testing.T) {
+	s := getServer(t)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to NATS server: %v", err)
+	}
+	defer nc.Close()
+
+	errs := make(chan *micro.NATSError, 2)
+	nm, err := AddContextService(nc, micro.Config{
+		Name:    "TestService",
+		Version: "1.0.0",
+		ErrorHandler: func(svc micro.Service, natsErr *micro.NATSError) {
+			errs <- natsErr
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not create micro service: %v", err)
+	}
+
+	duplicate := make(chan error, 1)
+	if err := nm.AddContextEndpoint("twice", func(req *Request) error {
+		req.Respond([]byte("first"))
+		duplicate <- req.Respond([]byte("second"))
+		return errors.New("failed after responding")
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sub, err := nc.SubscribeSync(nats.NewInbox())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nc.PublishRequest("twice", sub.Subject, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(msg.Data) != "first" {
+		t.Errorf("reply does not match, expected %s, received %s", "first", string(msg.Data))
+	}
+	if _, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Errorf("received more than one reply")
+	}
+	if err := <-duplicate; !errors.Is(err, ErrAlreadyResponded) {
+		t.Errorf("expected already responded error, received %v", err)
+	}
+
+	// Both the second reply and the error reply are reported
+	for i := 0; i < 2; i++ {
+		natsErr := <-errs
+		if natsErr.Subject != "twice" || natsErr.Description != ErrAlreadyResponded.Error() {
+			t.Errorf("unexpected error reported: %v", natsErr)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package natsmicromw
+
+import (
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// Endpoint metadata key recording the API version served by the endpoint
+	MetadataVersion string = "version"
+)
+
+// VersionPrecedence defines whether a versioned endpoint also takes the
+// requests on its unversioned subject.
+type VersionPrecedence int
+
+const (
+	// Serve both the versioned and the unversioned subject, for the version
+	// clients get by default
+	VersionCurrent VersionPrecedence = iota
+	// Serve only the versioned subject, e.g. for a version being rolled out
+	// or one kept for clients that have not migrated yet
+	VersionExplicit
+)
+
+// Either a micro.Service or a micro.Group
+type endpointParent interface {
+	AddEndpoint(string, micro.Handler, ...micro.EndpointOpt) error
+	AddGroup(string, ...micro.GroupOpt) micro.Group
+}
+
+// WithVersionPrefix returns a service whose endpoints are registered under
+// the version, e.g. "v2.echo" for the "echo" endpoint. By default they are
+// also registered on the unversioned subject, see [Service.WithVersionPrecedence].
+// The endpoints are advertised with the `version` metadata, merged with the
+// metadata set with [Service.WithEndpointMetadata]. Metadata passed in the
+// endpoint options with `micro.WithEndpointMetadata` replaces both, as the
+// options cannot be merged.
+func (s *Service) WithVersionPrefix(version string) *Service {
+	ns := s.clone()
+	ns.version = version
+	return ns
+}
+
+// WithEndpointMetadata returns a service whose endpoints are advertised
+// with the metadata, merged with the `version` metadata of versioned
+// endpoints. Use it instead of `micro.WithEndpointMetadata` on versioned
+// endpoints. Metadata of earlier calls is kept unless overwritten.
+func (s *Service) WithEndpointMetadata(metadata map[string]string) *Service {
+	ns := s.clone()
+	ns.metadata = mergeMetadata(s.metadata, metadata)
+	return ns
+}
+
+// WithEndpointMetadata returns a group whose endpoints are advertised with
+// the metadata, see [Service.WithEndpointMetadata].
+func (g *Group) WithEndpointMetadata(metadata map[string]string) *Group {
+	svc := g.svc.clone()
+	svc.metadata = mergeMetadata(g.svc.metadata, metadata)
+	return &Group{svc, g.grp, g.prefix}
+}
+
+// Copy of the metadata with the other metadata added, so that clones never
+// share the map
+func mergeMetadata(metadata, other map[string]string) map[string]string {
+	merged := copyMetadata(metadata)
+	for k, v := range other {
+		merged[k] = v
+	}
+	return merged
+}
+
+// WithVersionPrecedence returns a service whose versioned endpoints serve
+// the unversioned subject or not. During a rollout, the new version is
+// registered as `VersionExplicit` for clients opting in, and switched to
+// `VersionCurrent` once the old version is switched to `VersionExplicit`.
+func (s *Service) WithVersionPrecedence(precedence VersionPrecedence) *Service {
+	ns := s.clone()
+	ns.versionPrecedence = precedence
+	return ns
+}
+
+// WithVersionPrefix returns a group whose endpoints are registered under
+// the version, which follows the group prefix, e.g. "orders.v2.list". See
+// [Service.WithVersionPrefix].
+func (g *Group) WithVersionPrefix(version string) *Group {
+	svc := g.svc.clone()
+	svc.version = version
+	return &Group{svc, g.grp, g.prefix}
+}
+
+// WithVersionPrecedence returns a group whose versioned endpoints serve the
+// unversioned subject or not, see [Service.WithVersionPrecedence].
+func (g *Group) WithVersionPrecedence(precedence VersionPrecedence) *Group {
+	svc := g.svc.clone()
+	svc.versionPrecedence = precedence
+	return &Group{svc, g.grp, g.prefix}
+}
+
+// Versions returns the API versions served by the endpoints of the service.
+func (s *Service) Versions() []string {
+	var versions []string
+	seen := make(map[string]bool)
+	for _, ep := range s.svc.Info().Endpoints {
+		version := ep.Metadata[MetadataVersion]
+		if version != "" && !seen[version] {
+			seen[version] = true
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// Registration function for endpoints of the parent, on every priority tier
+// if configured. The tier follows the version, e.g. "svc.v2.high.orders",
+// so that `PrioritySubject` finds the tier of versioned subjects too.
+func (s *Service) endpointAdder(parent endpointParent) func(string, micro.Handler, ...micro.EndpointOpt) error {
+	cfg := s.snapshot()
+	add := func(p endpointParent) func(string, micro.Handler, ...micro.EndpointOpt) error {
+		return p.AddEndpoint
+	}
+	if len(cfg.priorityPools) > 0 {
+		add = cfg.tieredAdder
+	}
+	return cfg.versionedAdder(parent, add)
+}
+
+// Registration function for endpoints of the parent, adding the version
+// prefix and the unversioned subject if configured, registering them with
+// the function for their parent. Called on a snapshot.
+func (s *Service) versionedAdder(parent endpointParent, add func(endpointParent) func(string, micro.Handler, ...micro.EndpointOpt) error) func(string, micro.Handler, ...micro.EndpointOpt) error {
+	if s.version == "" {
+		if len(s.metadata) == 0 {
+			return add(parent)
+		}
+		addEndpoint, metadata := add(parent), copyMetadata(s.metadata)
+		return func(name string, handler micro.Handler, opts ...micro.EndpointOpt) error {
+			opts = append([]micro.EndpointOpt{micro.WithEndpointMetadata(metadata)}, opts...)
+			return addEndpoint(name, handler, opts...)
+		}
+	}
+
+	addVersioned, addUnversioned := add(parent.AddGroup(s.version)), add(parent)
+	return func(name string, handler micro.Handler, opts ...micro.EndpointOpt) error {
+		metadata := mergeMetadata(s.metadata, map[string]string{MetadataVersion: s.version})
+		opts = append([]micro.EndpointOpt{micro.WithEndpointMetadata(metadata)}, opts...)
+
+		if err := addVersioned(name, handler, opts...); err != nil {
+			return err
+		}
+		if s.versionPrecedence != VersionCurrent {
+			return nil
+		}
+		// Keep the subject of the endpoint, which defaults to its name
+		opts = append([]micro.EndpointOpt{micro.WithEndpointSubject(name)}, opts...)
+		return addUnversioned(name+"-unversioned", handler, opts...)
+	}
+}
@@ -0,0 +1,92 @@
+package natsmicromw
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVersionPrefix(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	reply := func(data string) ContextHandlerFunc {
+		return func(req *Request) error {
+			return req.Respond([]byte(data))
+		}
+	}
+
+	orders := nm.AddGroup("orders")
+	if err := orders.WithVersionPrefix("v1").AddContextEndpoint("list", reply("v1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := orders.WithVersionPrefix("v2").WithVersionPrecedence(VersionExplicit).AddContextEndpoint("list", reply("v2"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.WithVersionPrefix("v2").AddContextEndpoint("echo", reply("echo")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[string]string{
+		"orders.list":    "v1",
+		"orders.v1.list": "v1",
+		"orders.v2.list": "v2",
+		"echo":           "echo",
+		"v2.echo":        "echo",
+	}
+	for subject, expected := range tests {
+		msg, err := nc.Request(subject, nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", subject, err)
+		}
+		if string(msg.Data) != expected {
+			t.Errorf("reply to %q does not match, expected %q, received %q", subject, expected, string(msg.Data))
+		}
+	}
+
+	versions := nm.Versions()
+	if len(versions) != 2 || versions[0] != "v1" || versions[1] != "v2" {
+		t.Errorf("versions do not match, received %v", versions)
+	}
+}
+
+func TestEndpointMetadata(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	handler := func(req *Request) error {
+		return req.Respond(nil)
+	}
+	owned := nm.WithEndpointMetadata(map[string]string{"owner": "orders-team"})
+	if err := owned.WithVersionPrefix("v2").WithVersionPrecedence(VersionExplicit).AddContextEndpoint("versioned", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := owned.AddGroup("grp").WithEndpointMetadata(map[string]string{"tier": "gold"}).AddContextEndpoint("plain", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := nm.AddContextEndpoint("bare", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]map[string]string{
+		"versioned": {"owner": "orders-team", MetadataVersion: "v2"},
+		"plain":     {"owner": "orders-team", "tier": "gold"},
+		"bare":      {},
+	}
+	for _, ep := range nm.Info().Endpoints {
+		metadata, ok := expected[ep.Name]
+		if !ok {
+			continue
+		}
+		if len(ep.Metadata) != len(metadata) {
+			t.Errorf("%s metadata does not match, expected %v, received %v", ep.Name, metadata, ep.Metadata)
+		}
+		for k, v := range metadata {
+			if ep.Metadata[k] != v {
+				t.Errorf("%s metadata does not match, expected %v, received %v", ep.Name, metadata, ep.Metadata)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ErrWarmingUp is the description of the replies to requests turned away
+// while an endpoint warms up.
+var ErrWarmingUp = errors.New("service warming up")
+
+// MinWarmupShare is the lowest initial share of handled requests, so that
+// a warming up endpoint never turns away all of its requests.
+const MinWarmupShare = 0.05
+
+// Longest time a request is delayed during a warm-up by default
+const DefaultWarmupMaxWait = 500 * time.Millisecond
+
+// Warmup ramps up the share of requests an endpoint handles after it is
+// registered, see [Service.SetWarmup].
+type Warmup struct {
+	// Period during which the share of handled requests grows linearly to
+	// all of them. No warm-up if zero.
+	Period time.Duration
+	// InitialShare of the requests handled right after registration,
+	// between `MinWarmupShare` and 1
+	InitialShare float64
+	// MaxWait is the longest a request above the current share is delayed
+	// until the share has grown enough to handle it,
+	// `DefaultWarmupMaxWait` if zero. Keep it below the client timeouts.
+	MaxWait time.Duration
+	// RetryAfter is the delay suggested to clients in the rejections,
+	// 100 milliseconds if zero
+	RetryAfter time.Duration
+}
+
+// SetWarmup makes the endpoints registered afterwards handle a reduced share
+// of their requests for a period after their registration, ramping up to
+// full capacity, to protect cold caches and lazily initialized code paths
+// after deploys. Core NATS requests cannot be handed back to the queue
+// group, so the other requests are delayed until the share has grown
+// enough to handle them, spreading them over the ramp. Delayed requests stay
+// in flight, see [Service.InFlight], and run on a worker of their priority
+// tier when they resume. Requests that would wait longer than the maximum
+// wait are rejected with a "503" error with a retry-after header, which
+// clients retrying with `client.WithRetryAfter` most likely send to an
+// instance already warm.
+func (s *Service) SetWarmup(warmup Warmup) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if warmup.InitialShare < MinWarmupShare {
+		warmup.InitialShare = MinWarmupShare
+	} else if warmup.InitialShare > 1 {
+		warmup.InitialShare = 1
+	}
+	if warmup.MaxWait <= 0 {
+		warmup.MaxWait = DefaultWarmupMaxWait
+	}
+	if warmup.RetryAfter <= 0 {
+		warmup.RetryAfter = 100 * time.Millisecond
+	}
+	s.warmup = warmup
+}
+
+// Share of the requests handled at the time since registration
+func (w Warmup) share(elapsed time.Duration) float64 {
+	if elapsed >= w.Period {
+		return 1
+	}
+	return w.InitialShare + (1-w.InitialShare)*float64(elapsed)/float64(w.Period)
+}
+
+// Time since registration from which the share exceeds the draw
+func (w Warmup) admittedAfter(draw float64) time.Duration {
+	if draw <= w.InitialShare {
+		return 0
+	}
+	return time.Duration(float64(w.Period) * (draw - w.InitialShare) / (1 - w.InitialShare))
+}
+
+// Wrap the handler to delay or reject a decreasing share of the requests
+// during the warm-up period, starting now
+func (s *Service) warmupHandler(handler micro.Handler) micro.Handler {
+	warmup, encodeError, tasks := s.warmup, s.errorReply(), s.tasks
+	if warmup.Period <= 0 {
+		return handler
+	}
+	replyError := func(req micro.Request, err error) {
+		code, description, data, headers := encodeError(err, new(errorMeta))
+		_ = req.Error(code, description, data, micro.WithHeaders(headers))
+	}
+	reject := func(req micro.Request) {
+		handlerErr := &HandlerError{
+			Description: ErrWarmingUp.Error(),
+			Code:        CodeUnavailable,
+			cause:       ErrWarmingUp,
+		}
+		replyError(req, handlerErr.WithRetryAfter(warmup.RetryAfter))
+	}
+	start := time.Now()
+	return micro.HandlerFunc(func(req micro.Request) {
+		draw, elapsed := rand.Float64(), time.Since(start)
+		if draw < warmup.share(elapsed) {
+			handler.Handle(req)
+			return
+		}
+		wait := warmup.admittedAfter(draw) - elapsed
+		if wait > warmup.MaxWait {
+			reject(req)
+			return
+		}
+
+		// Wait without blocking the requests handled meanwhile. The request
+		// stays in flight and can be canceled, and is turned away if the
+		// service stops first.
+		ctx, resume, release := holdInFlight(tasks.ctx, req)
+		go func() {
+			defer release()
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+			}
+			err := context.Cause(ctx)
+			resume()
+			if errors.Is(err, context.Canceled) && tasks.ctx.Err() != nil {
+				reject(req)
+				return
+			} else if err != nil {
+				replyError(req, err)
+				return
+			}
+			runOnWorker(req, handler)
+		}()
+	})
+}
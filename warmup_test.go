@@ -0,0 +1,204 @@
+package natsmicromw
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestWarmup(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	handler := func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply([]byte("ok")), nil
+	}
+	// Practically nothing is handled in the first seconds of a long warm-up
+	nm.SetWarmup(Warmup{Period: time.Hour, RetryAfter: 50 * time.Millisecond})
+	if err := nm.AddMicroEndpoint("cold", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nm.SetWarmup(Warmup{Period: 100 * time.Millisecond})
+	if err := nm.AddMicroEndpoint("warm", handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The minimum share is handled right away
+	var handlerErr *HandlerError
+	for i := 0; i < 50 && handlerErr == nil; i++ {
+		msg, err := nc.Request("cold", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		handlerErr = HandlerErrorFromMsg(msg)
+	}
+	if handlerErr == nil || handlerErr.Code != CodeUnavailable || handlerErr.Description != ErrWarmingUp.Error() {
+		t.Fatalf("expected a warm-up error, received %v", handlerErr)
+	}
+	if delay, ok := handlerErr.RetryAfter(); !ok || delay != 50*time.Millisecond {
+		t.Errorf("expected a retry-after of 50ms, received %v", delay)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		msg, err := nc.Request("warm", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg.Data) != "ok" {
+			t.Fatalf("expected the request to be handled after the warm-up, received %q", msg.Data)
+		}
+	}
+}
+
+func TestWarmupDelay(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	// Requests above the share wait for the ramp instead of being rejected
+	nm.SetWarmup(Warmup{Period: 200 * time.Millisecond, MaxWait: time.Second})
+	if nm.warmup.InitialShare != MinWarmupShare {
+		t.Errorf("initial share does not match, expected %v, received %v", MinWarmupShare, nm.warmup.InitialShare)
+	}
+	if err := nm.AddMicroEndpoint("ramp", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply([]byte("ok")), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		msg, err := nc.Request("ramp", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg.Data) != "ok" {
+			t.Fatalf("expected the request to be handled, received %q %v", msg.Data, HandlerErrorFromMsg(msg))
+		}
+	}
+}
+
+func TestWarmupShare(t *testing.T) {
+	w := Warmup{Period: 10 * time.Second, InitialShare: 0.2}
+	tests := []struct {
+		elapsed time.Duration
+		share   float64
+	}{
+		{0, 0.2},
+		{5 * time.Second, 0.6},
+		{10 * time.Second, 1},
+		{time.Minute, 1},
+	}
+	for _, tt := range tests {
+		if share := w.share(tt.elapsed); share < tt.share-1e-9 || share > tt.share+1e-9 {
+			t.Errorf("%v: expected %v, received %v", tt.elapsed, tt.share, share)
+		}
+		if tt.share < 1 {
+			if elapsed := w.admittedAfter(tt.share); elapsed < tt.elapsed-time.Millisecond || elapsed > tt.elapsed+time.Millisecond {
+				t.Errorf("%v: expected %v to be admitted after %v, received %v", tt.elapsed, tt.share, tt.elapsed, elapsed)
+			}
+		}
+	}
+}
+
+func TestWarmupDelayInFlight(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	// Requests above the initial share wait for most of an hour
+	nm.SetWarmup(Warmup{Period: time.Hour, MaxWait: time.Hour})
+	if err := nm.AddMicroEndpoint("ramp", func(req *MicroRequest) (*MicroReply, error) {
+		return NewMicroReply([]byte("ok")), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	replies := make(chan *nats.Msg, 20)
+	sub, err := nc.ChanSubscribe("inbox.>", replies)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sub.Unsubscribe()
+	for i := 0; i < cap(replies); i++ {
+		msg := nats.NewMsg("ramp")
+		msg.Reply = fmt.Sprintf("inbox.%d", i)
+		msg.Header.Set(ErrorMetaRequestID, fmt.Sprintf("req-%d", i))
+		if err := nc.PublishMsg(msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Delayed requests are listed as in flight and can be canceled
+	var delayed []InFlightRequest
+	for i := 0; len(delayed) == 0; i++ {
+		if i > 100 {
+			t.Fatalf("no delayed request in flight")
+		}
+		time.Sleep(10 * time.Millisecond)
+		delayed = nm.InFlight()
+	}
+	if canceled := nm.CancelRequest(delayed[0].RequestID); canceled != 1 {
+		t.Fatalf("expected 1 canceled request, received %d", canceled)
+	}
+
+	// The others are turned away when the service stops
+	if err := nm.Stop(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var canceled, rejected int
+	for i := 0; i < cap(replies); i++ {
+		select {
+		case msg := <-replies:
+			if handlerErr := HandlerErrorFromMsg(msg); handlerErr == nil {
+				continue
+			} else if handlerErr.Description == ErrRequestCanceled.Error() {
+				canceled++
+			} else if handlerErr.Description == ErrWarmingUp.Error() {
+				rejected++
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("missing replies")
+		}
+	}
+	if canceled != 1 || rejected < len(delayed)-1 {
+		t.Errorf("expected 1 canceled and %d rejected requests, received %d and %d", len(delayed)-1, canceled, rejected)
+	}
+	for i := 0; len(nm.InFlight()) > 0; i++ {
+		if i > 100 {
+			t.Fatalf("delayed requests still in flight")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWarmupDelayWorkers(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	// Delayed requests run on a worker of their tier when they resume
+	nm.SetWarmup(Warmup{Period: 200 * time.Millisecond, MaxWait: time.Second})
+	tiered := nm.WithPriorityTiers(PriorityTier{Name: "low", Workers: 1, Queue: 10})
+	if err := tiered.AddMicroEndpoint("ramp", func(req *MicroRequest) (*MicroReply, error) {
+		if busy := nm.WorkerPools()[0].Busy; busy != 1 {
+			return nil, Errorf(CodeInternal, "%d busy workers", busy)
+		}
+		return NewMicroReply([]byte("ok")), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		msg, err := nc.Request("low.ramp", nil, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg.Data) != "ok" {
+			t.Fatalf("expected the request to be handled, received %q %v", msg.Data, HandlerErrorFromMsg(msg))
+		}
+	}
+}
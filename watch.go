@@ -0,0 +1,205 @@
+package natsmicromw
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// Request header with the version of the data the client has, and reply
+	// header with the version of the data sent
+	HeaderWatchVersion string = "watch-version"
+	// Request header with how long to wait for a change, in milliseconds
+	HeaderWatchTimeout string = "watch-timeout-ms"
+	// Request header asking for heartbeats at this interval, in milliseconds
+	HeaderWatchHeartbeat string = "watch-heartbeat-ms"
+	// Reply header telling why the watch reply was sent
+	HeaderWatchStatus string = "watch-status"
+
+	// Statuses of watch replies
+	WatchChanged   string = "changed"
+	WatchTimeout   string = "timeout"
+	WatchHeartbeat string = "heartbeat"
+)
+
+const (
+	// Wait for a change when the request does not set a timeout
+	DefaultWatchTimeout = 30 * time.Second
+	// Longest wait a request can ask for
+	MaxWatchTimeout = 5 * time.Minute
+	// Most watches held at once by an endpoint by default
+	DefaultMaxWatches = 10000
+)
+
+// ErrTooManyWatches is the description of the replies to watches rejected
+// because the endpoint holds the most watches it can.
+var ErrTooManyWatches = errors.New("too many watches")
+
+// Watcher is the source of a watch endpoint.
+type Watcher interface {
+	// Watch returns the data and its version as soon as its version differs
+	// from the given one, which is empty for clients without any data. It
+	// blocks until then or until the context is done, returning the error
+	// of the context.
+	Watch(ctx context.Context, version string) (data []byte, newVersion string, err error)
+}
+
+// WatcherFunc is a function implementing [Watcher].
+type WatcherFunc func(ctx context.Context, version string) ([]byte, string, error)
+
+func (f WatcherFunc) Watch(ctx context.Context, version string) ([]byte, string, error) {
+	return f(ctx, version)
+}
+
+// Parse a duration header in milliseconds, zero if not set or invalid
+func headerMillis(headers micro.Headers, key string) time.Duration {
+	ms, err := strconv.ParseInt(nats.Header(headers).Get(key), 10, 64)
+	if err != nil || ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Send heartbeats to the reply subject until stopped
+func watchHeartbeats(nc *nats.Conn, reply string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			msg := nats.NewMsg(reply)
+			msg.Header.Set(HeaderWatchStatus, WatchHeartbeat)
+			_ = nc.PublishMsg(msg)
+		}
+	}
+}
+
+// Handler holding the request until the source has a new version
+func watchHandler(s *Service, source Watcher) ContextHandlerFunc {
+	nc, stopped := s.nc, s.tasks.ctx
+	return func(req *Request) error {
+		timeout := headerMillis(req.Headers(), HeaderWatchTimeout)
+		if timeout == 0 {
+			timeout = DefaultWatchTimeout
+		} else if timeout > MaxWatchTimeout {
+			timeout = MaxWatchTimeout
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+
+		// Cancel the watch when the service stops
+		go func() {
+			select {
+			case <-stopped.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		// Heartbeats stop before the final reply, so they never follow it
+		var heartbeats sync.WaitGroup
+		stopHeartbeats := make(chan struct{})
+		if interval := headerMillis(req.Headers(), HeaderWatchHeartbeat); interval > 0 && req.Reply() != "" {
+			heartbeats.Add(1)
+			go func() {
+				defer heartbeats.Done()
+				watchHeartbeats(nc, req.Reply(), interval, stopHeartbeats)
+			}()
+		}
+
+		version := req.Headers().Get(HeaderWatchVersion)
+		data, newVersion, err := source.Watch(ctx, version)
+		close(stopHeartbeats)
+		heartbeats.Wait()
+
+		switch {
+		case err == nil:
+			headers := micro.Headers{}
+			nats.Header(headers).Set(HeaderWatchStatus, WatchChanged)
+			nats.Header(headers).Set(HeaderWatchVersion, newVersion)
+			return req.Respond(data, micro.WithHeaders(headers))
+		case stopped.Err() != nil:
+			return Errorf(CodeUnavailable, "service stopped")
+		case errors.Is(err, context.DeadlineExceeded) && req.Context().Err() == nil:
+			// No change within the timeout, the client watches again
+			headers := micro.Headers{}
+			nats.Header(headers).Set(HeaderWatchStatus, WatchTimeout)
+			nats.Header(headers).Set(HeaderWatchVersion, version)
+			return req.Respond(nil, micro.WithHeaders(headers))
+		default:
+			return err
+		}
+	}
+}
+
+// SetMaxWatches limits the number of watches each watch endpoint registered
+// afterwards holds at once, [DefaultMaxWatches] if zero. Further watches are
+// rejected with a "503" error until a held watch is answered, so that
+// clients cannot exhaust the memory of the service with goroutines.
+func (s *Service) SetMaxWatches(max int) {
+	s.checkFrozen()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxWatches = max
+}
+
+// Handle each request in its own goroutine, so that a held watch does not
+// block the delivery of the other requests to the endpoint, rejecting
+// requests above the limit
+func goHandler(handler micro.Handler, max int, encodeError func(error, *errorMeta) (string, string, []byte, micro.Headers)) micro.Handler {
+	if max <= 0 {
+		max = DefaultMaxWatches
+	}
+	held := make(chan struct{}, max)
+	return micro.HandlerFunc(func(req micro.Request) {
+		select {
+		case held <- struct{}{}:
+		default:
+			code, description, data, headers := encodeError(&HandlerError{
+				Description: ErrTooManyWatches.Error(),
+				Code:        CodeUnavailable,
+				cause:       ErrTooManyWatches,
+			}, new(errorMeta))
+			_ = req.Error(code, description, data, micro.WithHeaders(headers))
+			return
+		}
+		go func() {
+			defer func() { <-held }()
+			handler.Handle(req)
+		}()
+	})
+}
+
+// AddWatchEndpoint registers an endpoint holding each request until the
+// source has data with a version other than the one in the `watch-version`
+// header, for config-watch style APIs over request/reply. The reply carries
+// the data and its version, with the `watch-status: changed` header. If
+// nothing changes within the `watch-timeout-ms` header (30 seconds by
+// default, 5 minutes at most), the reply has no data and the
+// `watch-status: timeout` header, and the client watches again. Clients
+// reading the replies with a subscription can ask for empty heartbeat
+// messages with the `watch-heartbeat-ms` header. Each request is handled
+// in its own goroutine, so watches are held concurrently, up to the limit
+// set with [Service.SetMaxWatches]. The request goes
+// through the context middleware, and the watch is cancelled when the
+// service stops. A handler timeout of the service also applies to the watch, so
+// it should be longer than the watch timeouts.
+func (s *Service) AddWatchEndpoint(name string, source Watcher, opts ...micro.EndpointOpt) error {
+	ep := &endpointRef{name: name, kind: EndpointWatch}
+	return s.addEndpoint(s.endpointAdder(s.svc), ep, wrapContextHandler(s, ep, watchHandler(s, source)), opts...)
+}
+
+// AddWatchEndpoint registers a watch endpoint within a group, see
+// [Service.AddWatchEndpoint].
+func (g *Group) AddWatchEndpoint(name string, source Watcher, opts ...micro.EndpointOpt) error {
+	ep := &endpointRef{name: name, kind: EndpointWatch, group: g.prefix}
+	return g.svc.addEndpoint(g.svc.endpointAdder(g.grp), ep, wrapContextHandler(g.svc, ep, watchHandler(g.svc, source)), opts...)
+}
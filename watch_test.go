@@ -0,0 +1,223 @@
+package natsmicromw
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Versioned value notifying watchers of changes
+type watchedValue struct {
+	mu      sync.Mutex
+	version int
+	data    string
+	changed chan struct{}
+}
+
+func (v *watchedValue) set(data string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.version++
+	v.data = data
+	close(v.changed)
+	v.changed = make(chan struct{})
+}
+
+func (v *watchedValue) Watch(ctx context.Context, version string) ([]byte, string, error) {
+	for {
+		v.mu.Lock()
+		current, data, changed := strconv.Itoa(v.version), v.data, v.changed
+		v.mu.Unlock()
+		if current != version {
+			return []byte(data), current, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func TestWatchEndpoint(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	value := &watchedValue{data: "initial", changed: make(chan struct{})}
+	if err := nm.AddGroup("config").AddWatchEndpoint("watch", value); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	watch := func(t *testing.T, version, timeout string) *nats.Msg {
+		msg := nats.NewMsg("config.watch")
+		msg.Header.Set(HeaderWatchVersion, version)
+		msg.Header.Set(HeaderWatchTimeout, timeout)
+		reply, err := nc.RequestMsg(msg, 2*time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return reply
+	}
+
+	t.Run("current data", func(t *testing.T) {
+		reply := watch(t, "", "")
+		if string(reply.Data) != "initial" || reply.Header.Get(HeaderWatchVersion) != "0" || reply.Header.Get(HeaderWatchStatus) != WatchChanged {
+			t.Errorf("unexpected reply: %q %v", reply.Data, reply.Header)
+		}
+	})
+
+	t.Run("change", func(t *testing.T) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			value.set("updated")
+		}()
+		reply := watch(t, "0", "1000")
+		if string(reply.Data) != "updated" || reply.Header.Get(HeaderWatchVersion) != "1" {
+			t.Errorf("unexpected reply: %q %v", reply.Data, reply.Header)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		reply := watch(t, "1", "50")
+		if len(reply.Data) != 0 || reply.Header.Get(HeaderWatchStatus) != WatchTimeout || reply.Header.Get(HeaderWatchVersion) != "1" {
+			t.Errorf("unexpected reply: %q %v", reply.Data, reply.Header)
+		}
+	})
+
+	t.Run("heartbeats", func(t *testing.T) {
+		inbox := nats.NewInbox()
+		sub, err := nc.SubscribeSync(inbox)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		msg := nats.NewMsg("config.watch")
+		msg.Reply = inbox
+		msg.Header.Set(HeaderWatchVersion, "1")
+		msg.Header.Set(HeaderWatchTimeout, "200")
+		msg.Header.Set(HeaderWatchHeartbeat, "40")
+		if err := nc.PublishMsg(msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		heartbeats := 0
+		for {
+			reply, err := sub.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if reply.Header.Get(HeaderWatchStatus) != WatchHeartbeat {
+				if reply.Header.Get(HeaderWatchStatus) != WatchTimeout {
+					t.Errorf("unexpected reply: %v", reply.Header)
+				}
+				break
+			}
+			heartbeats++
+		}
+		if heartbeats < 2 {
+			t.Errorf("expected heartbeats, got %d", heartbeats)
+		}
+	})
+
+	t.Run("concurrent watchers", func(t *testing.T) {
+		held := make(chan *nats.Msg, 1)
+		go func() {
+			msg := nats.NewMsg("config.watch")
+			msg.Header.Set(HeaderWatchVersion, "1")
+			msg.Header.Set(HeaderWatchTimeout, "1000")
+			reply, err := nc.RequestMsg(msg, 2*time.Second)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			held <- reply
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		// Another watcher is answered while the first one is held
+		start := time.Now()
+		reply := watch(t, "", "")
+		if string(reply.Data) != "updated" || time.Since(start) > 500*time.Millisecond {
+			t.Errorf("second watcher blocked: %q after %v", reply.Data, time.Since(start))
+		}
+		select {
+		case reply := <-held:
+			t.Fatalf("first watcher answered before a change: %v", reply.Header)
+		default:
+		}
+
+		value.set("concurrent")
+		if reply := <-held; reply == nil || string(reply.Data) != "concurrent" {
+			t.Errorf("unexpected reply of the first watcher: %v", reply)
+		}
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			nm.tasks.cancel()
+		}()
+		reply := watch(t, "2", "1000")
+		if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != CodeUnavailable {
+			t.Errorf("expected unavailable error, received %v", handlerErr)
+		}
+	})
+}
+
+func TestWatchEndpointLimit(t *testing.T) {
+	s, nm, nc := getServerServiceAndConn(t)
+	defer s.Shutdown()
+	defer nc.Close()
+
+	value := &watchedValue{data: "initial", changed: make(chan struct{})}
+	nm.SetMaxWatches(1)
+	if err := nm.AddWatchEndpoint("watch", value); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	held := make(chan *nats.Msg, 1)
+	go func() {
+		msg := nats.NewMsg("watch")
+		msg.Header.Set(HeaderWatchVersion, "0")
+		msg.Header.Set(HeaderWatchTimeout, "1000")
+		reply, err := nc.RequestMsg(msg, 2*time.Second)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		held <- reply
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The second watch is over the limit
+	reply, err := nc.Request("watch", nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handlerErr := HandlerErrorFromMsg(reply); handlerErr == nil || handlerErr.Code != CodeUnavailable || handlerErr.Description != ErrTooManyWatches.Error() {
+		t.Errorf("expected a too many watches error, received %v", handlerErr)
+	}
+
+	// Answering the held watch frees its slot
+	value.set("updated")
+	if reply := <-held; reply == nil || string(reply.Data) != "updated" {
+		t.Fatalf("unexpected reply of the held watch: %v", reply)
+	}
+	// The slot is freed right after the reply is sent
+	for i := 0; i < 10; i++ {
+		if reply, err = nc.Request("watch", nil, time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if HandlerErrorFromMsg(reply) == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if string(reply.Data) != "updated" {
+		t.Errorf("unexpected reply: %q %v", reply.Data, HandlerErrorFromMsg(reply))
+	}
+}